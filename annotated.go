@@ -0,0 +1,69 @@
+package dsmrp1
+
+// JSON of telegrams with the unit and OBIS code of each value.
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// A value of a telegram with the line it was read from.
+type AnnotatedValue struct {
+	Value     interface{} `json:"value"`
+	Unit      string      `json:"unit,omitempty"`
+	Obis      string      `json:"obis"`
+	TimeStamp string      `json:"timestamp,omitempty"` // of gas records
+}
+
+// Returns the telegram as JSON like json.Marshal, except that each value
+// read from a line is an object with its unit and the OBIS code of the
+// line, eg. {"value": 233.1, "unit": "V", "obis": "1-0:32.7.0"}, so that
+// generic dashboards can render it without knowing the fields.
+func (t *Telegram) MarshalAnnotated() ([]byte, error) {
+	return json.Marshal(annotate(reflect.ValueOf(t).Elem()))
+}
+
+func annotate(sv reflect.Value) map[string]interface{} {
+	ret := make(map[string]interface{})
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		fieldType := st.Field(i)
+		if fieldType.Tag.Get("json") == "-" {
+			continue
+		}
+		field := sv.Field(i)
+		obis, ok := fieldType.Tag.Lookup("obis")
+		if !ok {
+			// The sections of a telegram.
+			if field.Kind() == reflect.Ptr &&
+				field.Type().Elem().Kind() == reflect.Struct {
+				if field.IsNil() {
+					ret[fieldType.Name] = nil
+				} else {
+					ret[fieldType.Name] = annotate(field.Elem())
+				}
+				continue
+			}
+			ret[fieldType.Name] = field.Interface()
+			continue
+		}
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				ret[fieldType.Name] = nil
+				continue
+			}
+			field = field.Elem()
+		}
+		v := AnnotatedValue{
+			Value: field.Interface(),
+			Unit:  fieldType.Tag.Get("unit"),
+			Obis:  obis,
+		}
+		if g, ok := v.Value.(GasRecord); ok {
+			v.Value = g.Value
+			v.TimeStamp = g.TimeStamp
+		}
+		ret[fieldType.Name] = v
+	}
+	return ret
+}
@@ -0,0 +1,264 @@
+package dsmrp1
+
+// Building raw telegrams, eg. for simulators and fixtures.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Builds a raw telegram of a version of the standard, with the values
+// formatted as it prescribes and a valid checksum, eg.
+//
+//	raw := dsmrp1.NewTelegramBuilder(dsmrp1.DSMR5).
+//		Timestamp(time.Now()).
+//		Import(1, 1234.5).
+//		Import(2, 2345.6).
+//		Power(1193, 0).
+//		Bytes()
+//
+// Only the lines that are set end up in the telegram, apart from the
+// version.  Setting a line again replaces it.
+type TelegramBuilder struct {
+	version Version
+	header  string
+	lines   []builtLine
+}
+
+type builtLine struct {
+	code obis.Code
+	text string
+}
+
+// Headers of well-known meters of each version.
+var defaultHeaders = map[Version]string{
+	DSMR22: "/ISk5\\2MT382-1004",
+	DSMR4:  "/KFM5KAIFA-METER",
+	DSMR5:  "/ISk5\\2MT382-1000",
+	EMUCS:  "/FLU5\\253769484_A",
+}
+
+// Returns a builder of telegrams of the given version.
+func NewTelegramBuilder(version Version) *TelegramBuilder {
+	b := &TelegramBuilder{version: version, header: defaultHeaders[version]}
+	switch version {
+	case DSMR4:
+		b.Line(obis.Version, "42")
+	case DSMR5:
+		b.Line(obis.Version, "50")
+	case EMUCS:
+		b.Line(obis.EMUCSVersion, "50217")
+	}
+	return b
+}
+
+// Formats a timestamp as meters do: YYMMDDhhmmss followed by S in summer
+// time and W otherwise.
+func FormatTimestamp(t time.Time) string {
+	_, offset := t.Zone()
+	_, winterOffset := time.Date(t.Year(), 1, 1, 0, 0, 0, 0,
+		t.Location()).Zone()
+	dst := "W"
+	if offset != winterOffset {
+		dst = "S"
+	}
+	return t.Format("060102150405") + dst
+}
+
+// Sets the line with the given code to the given arguments.
+func (b *TelegramBuilder) Line(code obis.Code, args ...string) *TelegramBuilder {
+	return b.set(code, string(code)+"("+strings.Join(args, ")(")+")")
+}
+
+func (b *TelegramBuilder) set(code obis.Code, text string) *TelegramBuilder {
+	for i := range b.lines {
+		if b.lines[i].code == code {
+			b.lines[i].text = text
+			return b
+		}
+	}
+	b.lines = append(b.lines, builtLine{code, text})
+	return b
+}
+
+// Sets the header, without the leading slash, eg. "ISk5\2MT382-1000".
+func (b *TelegramBuilder) Header(header string) *TelegramBuilder {
+	b.header = "/" + header
+	return b
+}
+
+func (b *TelegramBuilder) Timestamp(at time.Time) *TelegramBuilder {
+	return b.Line(obis.Timestamp, FormatTimestamp(at))
+}
+
+// Sets the serial number of the meter, which is hex encoded.
+func (b *TelegramBuilder) EquipmentID(id string) *TelegramBuilder {
+	return b.Line(obis.EquipmentID, strings.ToUpper(hex.EncodeToString(
+		[]byte(id))))
+}
+
+// Sets the register of the energy imported in the given tariff, eg.
+// 1-0:1.8.1 for tariff 1.
+func (b *TelegramBuilder) Import(tariff Tariff, kWh float64) *TelegramBuilder {
+	return b.Line(obis.ForTariff(obis.ImportTariff1, int(tariff)), b.kWh(kWh))
+}
+
+// Sets the register of the energy exported in the given tariff.
+func (b *TelegramBuilder) Export(tariff Tariff, kWh float64) *TelegramBuilder {
+	return b.Line(obis.ForTariff(obis.ExportTariff1, int(tariff)), b.kWh(kWh))
+}
+
+func (b *TelegramBuilder) kWh(v float64) string {
+	if b.version == DSMR22 {
+		return fmt.Sprintf("%09.3f*kWh", v)
+	}
+	return fmt.Sprintf("%010.3f*kWh", v)
+}
+
+func (b *TelegramBuilder) Tariff(tariff Tariff) *TelegramBuilder {
+	return b.Line(obis.Tariff, fmt.Sprintf("%04d", tariff))
+}
+
+// Sets the power imported and exported, in W.
+func (b *TelegramBuilder) Power(w, wOut float64) *TelegramBuilder {
+	return b.Line(obis.Power, b.kW(w)).Line(obis.PowerOut, b.kW(wOut))
+}
+
+func (b *TelegramBuilder) kW(w float64) string {
+	if b.version == DSMR22 {
+		return fmt.Sprintf("%07.2f*kW", w/1000)
+	}
+	return fmt.Sprintf("%06.3f*kW", w/1000)
+}
+
+// Sets the number of power failures and long power failures, with an
+// empty log of the latter.
+func (b *TelegramBuilder) PowerFailures(n, long int) *TelegramBuilder {
+	return b.Line(obis.PowerFailures, fmt.Sprintf("%05d", n)).
+		Line(obis.LongPowerFailures, fmt.Sprintf("%05d", long)).
+		Line(obis.PowerFailureLog, "0", "0-0:96.7.19")
+}
+
+// The OBIS codes of L1, L2 and L3 differ by 20 in the second group.
+func phaseCode(phase, c int) obis.Code {
+	return obis.Code(fmt.Sprintf("1-0:%d.7.0", c+20*(phase-1)))
+}
+
+// Sets the voltage in V, current in A and power imported and exported
+// in W of the given phase: 1, 2 or 3.  DSMR 4 and earlier do not report
+// the voltage.
+func (b *TelegramBuilder) Phase(phase int, voltage, current, w,
+	wOut float64) *TelegramBuilder {
+	if b.version == DSMR5 || b.version == EMUCS {
+		b.Line(phaseCode(phase, 32), fmt.Sprintf("%05.1f*V", voltage))
+	}
+	if b.version == EMUCS {
+		b.Line(phaseCode(phase, 31), fmt.Sprintf("%06.2f*A", current))
+	} else {
+		b.Line(phaseCode(phase, 31), fmt.Sprintf("%03.0f*A", current))
+	}
+	return b.Line(phaseCode(phase, 21), b.kW(w)).
+		Line(phaseCode(phase, 22), b.kW(wOut))
+}
+
+// Sets the number of voltage sags and swells of the given phase.
+func (b *TelegramBuilder) VoltageEvents(phase, sags,
+	swells int) *TelegramBuilder {
+	c := 32 + 20*(phase-1)
+	return b.Line(obis.Code(fmt.Sprintf("1-0:%d.32.0", c)),
+		fmt.Sprintf("%05d", sags)).
+		Line(obis.Code(fmt.Sprintf("1-0:%d.36.0", c)),
+			fmt.Sprintf("%05d", swells))
+}
+
+// Sets the text message, which is hex encoded.
+func (b *TelegramBuilder) Message(text string) *TelegramBuilder {
+	return b.Line(obis.MessageText, strings.ToUpper(hex.EncodeToString(
+		[]byte(text))))
+}
+
+// Sets the average demand over the current quarter of an hour and the
+// peak demand this month, in W, as on Belgian meters.
+func (b *TelegramBuilder) Demand(avgW float64, peakAt time.Time,
+	peakW float64) *TelegramBuilder {
+	return b.Line(obis.AverageDemand, b.kW(avgW)).
+		Line(obis.MaxDemandMonth, FormatTimestamp(peakAt), b.kW(peakW))
+}
+
+// Sets a gas meter on the given M-Bus channel, with its serial number
+// and the reading in m3 at the given time.
+func (b *TelegramBuilder) Gas(channel int, id string, at time.Time,
+	m3 float64) *TelegramBuilder {
+	idCode := obis.MBusEquipmentID
+	if b.version == EMUCS {
+		idCode = obis.MBusEquipmentIDEMUCS
+	}
+	b.Line(obis.MBus(obis.MBusDeviceType, channel), "003")
+	b.Line(obis.MBus(idCode, channel), strings.ToUpper(hex.EncodeToString(
+		[]byte(id))))
+	switch b.version {
+	case DSMR22:
+		// A profile with the value on a line of its own.
+		code := obis.MBus(obis.MBusReadingDSMR22, channel)
+		return b.set(code, fmt.Sprintf("%s(%s)(00)(60)(1)(%s)(m3)\r\n(%09.3f)",
+			code, at.Format("060102150405"),
+			obis.MBus(obis.MBusReading, channel), m3))
+	case EMUCS:
+		return b.Line(obis.MBus(obis.MBusReadingEMUCS, channel),
+			FormatTimestamp(at), fmt.Sprintf("%09.3f*m3", m3))
+	}
+	return b.Line(obis.MBus(obis.MBusReading, channel),
+		FormatTimestamp(at), fmt.Sprintf("%09.3f*m3", m3))
+}
+
+// Returns the raw telegram, with the lines in the order of the standard
+// and those of M-Bus devices by channel.  Lines that are not part of the
+// standard follow those of the meter itself.
+func (b *TelegramBuilder) Bytes() []byte {
+	order := make(map[obis.Code]int)
+	for i, code := range specs[b.version].codes {
+		order[code] = i
+	}
+	key := func(code obis.Code) (int, int) {
+		channel := 0
+		if c := string(code); len(c) > 4 && c[:2] == "0-" && c[3] == ':' {
+			channel = int(c[2] - '0')
+		}
+		if i, ok := order[firstChannel(code)]; ok {
+			return channel, i
+		}
+		return channel, len(order)
+	}
+	lines := make([]builtLine, len(b.lines))
+	copy(lines, b.lines)
+	sort.SliceStable(lines, func(i, j int) bool {
+		ci, oi := key(lines[i].code)
+		cj, oj := key(lines[j].code)
+		if ci != cj {
+			return ci < cj
+		}
+		return oi < oj
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(b.header + "\r\n\r\n")
+	for _, line := range lines {
+		buf.WriteString(line.text + "\r\n")
+	}
+	buf.WriteByte('!')
+	if specs[b.version].checksum {
+		fmt.Fprintf(&buf, "%04X", crc(buf.Bytes()))
+	}
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// Returns the telegram as parsed from Bytes.
+func (b *TelegramBuilder) Telegram() (*Telegram, []error) {
+	return ParseTelegram(b.Bytes())
+}
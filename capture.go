@@ -0,0 +1,245 @@
+package dsmrp1
+
+// Reading and writing of captures: files with raw telegrams.
+//
+// In a capture every telegram is preceded by a line with the time it was
+// received, eg.
+//
+//	#2026-10-16T12:00:00.123+02:00
+//	/ISk5\2MT382-1000
+//
+//	1-3:0.2.8(50)
+//	...
+//	!E47C
+//
+// As P1 readers skip everything before a header, a capture is also
+// a valid P1 stream.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const captureTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+type CaptureWriter struct {
+	w io.Writer
+}
+
+type CaptureReader struct {
+	r *bufio.Reader
+}
+
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{w: w}
+}
+
+// Appends a raw telegram received at the given time.
+func (cw *CaptureWriter) Write(at time.Time, raw []byte) error {
+	buf := make([]byte, 0, len(raw)+32)
+	buf = append(buf, '#')
+	buf = append(buf, at.Format(captureTimeFormat)...)
+	buf = append(buf, '\r', '\n')
+	buf = append(buf, raw...)
+	_, err := cw.w.Write(buf)
+	return err
+}
+
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	return &CaptureReader{r: bufio.NewReader(r)}
+}
+
+// Returns the next raw telegram and the time it was received, which is
+// zero if the capture does not record it.  Returns io.EOF at the end of
+// the capture; an incomplete telegram at the end is ignored.
+func (cr *CaptureReader) Next() ([]byte, time.Time, error) {
+	var at time.Time
+	var ret []byte
+
+	for {
+		line, err := cr.r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			// Handle a missing final newline.
+			err = nil
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		switch {
+		case bytes.HasPrefix(line, []byte("#")):
+			// The time of the next telegram.  If we were reading one
+			// already, it was truncated.
+			ret = nil
+			at, err = time.Parse(captureTimeFormat,
+				string(bytes.TrimSpace(line[1:])))
+			if err != nil {
+				at = time.Time{}
+			}
+		case bytes.HasPrefix(line, []byte("/")):
+			// Start of a telegram.  If we were reading one already,
+			// it was truncated.
+			ret = append([]byte{}, line...)
+		case ret != nil:
+			ret = append(ret, line...)
+			if bytes.HasPrefix(line, []byte("!")) {
+				return ret, at, nil
+			}
+		}
+	}
+}
+
+// Parses the telegrams of a capture, or of any other stream of telegrams,
+// calling f for each in turn with the time it was received, which is
+// zero if the capture does not record it, and the errors of parsing it
+// as ParseTelegramWith does.  Anything before a header and a partial
+// telegram at the end are skipped.  Returns at the end of the stream,
+// on a read error, or with the error f returns.
+func ParseStream(r io.Reader, opts ParseOptions,
+	f func(t *Telegram, at time.Time, errs []error) error) error {
+	cr := &CaptureReader{r: bufio.NewReaderSize(r, 64*1024)}
+	for {
+		raw, at, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t, errs := ParseTelegramWith(raw, opts)
+		if err = f(t, at, errs); err != nil {
+			return err
+		}
+	}
+}
+
+// Telegrams parsed by a worker of ParseStreamParallel at a time.
+const parseBatchSize = 256
+
+type parseBatch struct {
+	raws [][]byte
+	ats  []time.Time
+	ts   []*Telegram
+	errs [][]error
+	done chan struct{} // closed when parsed
+}
+
+// Like ParseStream, but parses the telegrams with the given number of
+// workers, or one per CPU if zero, for large captures.  f is still called
+// for the telegrams in order, from a single goroutine.
+func ParseStreamParallel(r io.Reader, opts ParseOptions, workers int,
+	f func(t *Telegram, at time.Time, errs []error) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	todo := make(chan *parseBatch, workers)
+	ordered := make(chan *parseBatch, 2*workers)
+	stop := make(chan struct{})
+	var readErr error
+
+	// Splits the stream in batches at telegram boundaries.
+	go func() {
+		defer close(todo)
+		defer close(ordered)
+		cr := &CaptureReader{r: bufio.NewReaderSize(r, 64*1024)}
+		stopped := func() bool {
+			select {
+			case <-stop:
+				return true
+			default:
+				return false
+			}
+		}
+		for readErr == nil {
+			b := &parseBatch{done: make(chan struct{})}
+			for len(b.raws) < parseBatchSize {
+				if stopped() {
+					return
+				}
+				raw, at, err := cr.Next()
+				if err != nil {
+					if err != io.EOF {
+						readErr = err
+					}
+					break
+				}
+				b.raws = append(b.raws, raw)
+				b.ats = append(b.ats, at)
+			}
+			if len(b.raws) == 0 || stopped() {
+				return
+			}
+			select {
+			case ordered <- b:
+			case <-stop:
+				return
+			}
+			select {
+			case todo <- b:
+			case <-stop:
+				return
+			}
+			if len(b.raws) < parseBatchSize {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range todo {
+				b.ts = make([]*Telegram, len(b.raws))
+				b.errs = make([][]error, len(b.raws))
+				for j, raw := range b.raws {
+					b.ts[j], b.errs[j] = ParseTelegramWith(raw, opts)
+				}
+				close(b.done)
+			}
+		}()
+	}
+
+	for b := range ordered {
+		<-b.done
+		for j := range b.ts {
+			if err := f(b.ts[j], b.ats[j], b.errs[j]); err != nil {
+				close(stop)
+				return err
+			}
+		}
+	}
+	return readErr
+}
+
+// Opens a capture file for reading, decompressing it if its name ends
+// in .gz.
+func OpenCapture(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{gz, f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
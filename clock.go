@@ -0,0 +1,52 @@
+package dsmrp1
+
+// Clocks, so that what depends on the time can be tested with a fake one.
+
+import (
+	"sync"
+	"time"
+)
+
+// A source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// The clock of the system.
+var SystemClock Clock = systemClock{}
+
+// A clock that only moves when it is set or advanced, for deterministic
+// tests.  Safe for concurrent use.
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// Returns a fake clock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,416 @@
+package dsmrp1
+
+// Checks of telegrams against the P1 companion standards.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A version of the P1 companion standard.
+type Version int
+
+const (
+	UnknownVersion Version = iota
+	DSMR22                 // also DSMR 3, which does not differ on P1
+	DSMR4
+	DSMR5
+	EMUCS // Belgian eMUCS, based on DSMR 5
+)
+
+func (v Version) String() string {
+	switch v {
+	case DSMR22:
+		return "DSMR 2.2"
+	case DSMR4:
+		return "DSMR 4"
+	case DSMR5:
+		return "DSMR 5"
+	case EMUCS:
+		return "eMUCS"
+	}
+	return "unknown version"
+}
+
+// Returns the version of the standard the telegram follows.  Telegrams
+// before DSMR 4 do not state their version.
+func (t *Telegram) Version() Version {
+	if _, ok := t.Get(obis.EMUCSVersion); ok {
+		return EMUCS
+	}
+	return versionOf(map[string][]string{
+		string(obis.Version): {t.P1Version},
+	})
+}
+
+func versionOf(data map[string][]string) Version {
+	if _, ok := data[string(obis.EMUCSVersion)]; ok {
+		return EMUCS
+	}
+	args, ok := data[string(obis.Version)]
+	if !ok || len(args) == 1 && args[0] == "" {
+		return DSMR22
+	}
+	if len(args) == 1 && strings.HasPrefix(args[0], "4") {
+		return DSMR4
+	}
+	if len(args) == 1 && strings.HasPrefix(args[0], "5") {
+		return DSMR5
+	}
+	return UnknownVersion
+}
+
+// A way in which a telegram does not conform to the standard.
+type Violation struct {
+	Code    obis.Code // of the line; empty if about the whole telegram
+	Message string
+}
+
+func (v Violation) Error() string {
+	if v.Code == "" {
+		return v.Message
+	}
+	return fmt.Sprintf("%s: %s", v.Code, v.Message)
+}
+
+// What a version of the standard prescribes.
+type spec struct {
+	codes    []obis.Code          // of the lines that may appear
+	required []obis.Code          // of the lines that must appear
+	formats  map[obis.Code]string // of the arguments; see checkFormat
+	versions []string             // values of the version line
+	checksum bool
+}
+
+var (
+	registers = []obis.Code{obis.EquipmentID, obis.ImportTariff1,
+		obis.ImportTariff2, obis.ExportTariff1, obis.ExportTariff2,
+		obis.Tariff, obis.Power, obis.PowerOut}
+	dsmr4Required = append([]obis.Code{obis.Version, obis.Timestamp,
+		obis.PowerFailures, obis.LongPowerFailures, obis.PowerFailureLog,
+		obis.L1VoltageSags, obis.L1VoltageSwells, obis.L1Current,
+		obis.L1Power, obis.L1PowerOut}, registers...)
+
+	// Formats common to DSMR 4 and 5.
+	dsmr4Formats = map[obis.Code]string{
+		obis.Version:           "I2",
+		obis.Timestamp:         "TST",
+		obis.EquipmentID:       "S96",
+		obis.ImportTariff1:     "F9(3,3)*kWh",
+		obis.ImportTariff2:     "F9(3,3)*kWh",
+		obis.ExportTariff1:     "F9(3,3)*kWh",
+		obis.ExportTariff2:     "F9(3,3)*kWh",
+		obis.Tariff:            "I4",
+		obis.Power:             "F5(3,3)*kW",
+		obis.PowerOut:          "F5(3,3)*kW",
+		obis.PowerFailures:     "I5",
+		obis.LongPowerFailures: "I5",
+		obis.L1VoltageSags:     "I5",
+		obis.L2VoltageSags:     "I5",
+		obis.L3VoltageSags:     "I5",
+		obis.L1VoltageSwells:   "I5",
+		obis.L2VoltageSwells:   "I5",
+		obis.L3VoltageSwells:   "I5",
+		obis.MessageText:       "S1024",
+		obis.L1Current:         "I3*A",
+		obis.L2Current:         "I3*A",
+		obis.L3Current:         "I3*A",
+		obis.L1Power:           "F5(3,3)*kW",
+		obis.L2Power:           "F5(3,3)*kW",
+		obis.L3Power:           "F5(3,3)*kW",
+		obis.L1PowerOut:        "F5(3,3)*kW",
+		obis.L2PowerOut:        "F5(3,3)*kW",
+		obis.L3PowerOut:        "F5(3,3)*kW",
+		obis.MBusDeviceType:    "I3",
+		obis.MBusEquipmentID:   "S96",
+		obis.MBusReading:       "TST F8(2,3)*m3",
+	}
+
+	specs = map[Version]spec{
+		DSMR22: {
+			codes:    obis.DSMR22,
+			required: registers,
+			formats: map[obis.Code]string{
+				obis.EquipmentID:     "S96",
+				obis.Tariff:          "I4",
+				obis.MBusEquipmentID: "S96",
+			},
+		},
+		DSMR4: {
+			codes:    obis.DSMR4,
+			required: dsmr4Required,
+			formats: withFormats(dsmr4Formats, map[obis.Code]string{
+				obis.Threshold:   "F4(1,1)*kW",
+				obis.Switch:      "I1",
+				obis.MessageCode: "S8",
+				obis.MBusValve:   "I1",
+			}),
+			versions: []string{"40", "42"},
+			checksum: true,
+		},
+		DSMR5: {
+			codes: obis.DSMR5,
+			required: append([]obis.Code{obis.L1Voltage},
+				dsmr4Required...),
+			formats: withFormats(dsmr4Formats, map[obis.Code]string{
+				obis.L1Voltage: "F4(1,1)*V",
+				obis.L2Voltage: "F4(1,1)*V",
+				obis.L3Voltage: "F4(1,1)*V",
+			}),
+			versions: []string{"50"},
+			checksum: true,
+		},
+		EMUCS: {
+			codes: obis.EMUCS,
+			required: append([]obis.Code{obis.EMUCSVersion, obis.Timestamp,
+				obis.L1Current}, registers...),
+			formats: withFormats(dsmr4Formats, map[obis.Code]string{
+				obis.EMUCSVersion:         "I5",
+				obis.AverageDemand:        "F5(3,3)*kW",
+				obis.MaxDemandMonth:       "TST F5(3,3)*kW",
+				obis.L1Voltage:            "F4(1,1)*V",
+				obis.L2Voltage:            "F4(1,1)*V",
+				obis.L3Voltage:            "F4(1,1)*V",
+				obis.L1Current:            "F5(2,2)*A",
+				obis.L2Current:            "F5(2,2)*A",
+				obis.L3Current:            "F5(2,2)*A",
+				obis.Switch:               "I1",
+				obis.Threshold:            "F4(1,1)*kW",
+				obis.FuseThreshold:        "F3(0,0)*A",
+				obis.MBusEquipmentIDEMUCS: "S96",
+				obis.MBusValve:            "I1",
+				obis.MBusReadingEMUCS:     "TST F8(2,3)*m3",
+			}),
+			checksum: true,
+		},
+	}
+)
+
+// Returns the formats of base with those of extra added.
+func withFormats(base, extra map[obis.Code]string) map[obis.Code]string {
+	ret := make(map[obis.Code]string, len(base)+len(extra))
+	for code, format := range base {
+		ret[code] = format
+	}
+	for code, format := range extra {
+		ret[code] = format
+	}
+	return ret
+}
+
+// The allowed values of lines with a limited range.
+var ranges = map[obis.Code][]string{
+	obis.Tariff:    {"0001", "0002"},
+	obis.Switch:    {"0", "1", "2"},
+	obis.MBusValve: {"0", "1", "2"},
+}
+
+// Checks the telegram against the P1 companion standard of the given
+// version: whether the lines it requires are present, and whether the
+// values are of the prescribed format and in range.  This needs the raw
+// telegram.
+func CheckConformance(t *Telegram, version Version) []Violation {
+	ret := []Violation{}
+	s, ok := specs[version]
+	if !ok {
+		return append(ret, Violation{Message: "unknown version"})
+	}
+	if detected := t.Version(); detected != version {
+		ret = append(ret, Violation{Message: fmt.Sprintf(
+			"telegram is of %s", detected)})
+	}
+	end := bytes.LastIndexByte(t.Raw, '!')
+	if end == -1 {
+		return append(ret, Violation{Message: "no raw telegram"})
+	}
+	sum := strings.TrimSpace(string(t.Raw[end+1:]))
+	if s.checksum && len(sum) != 4 {
+		ret = append(ret, Violation{Message: "missing checksum"})
+	}
+	if !headerRegexp.Match(t.Raw) {
+		ret = append(ret, Violation{Message: "malformed header"})
+	}
+
+	var rawLines [][]byte
+	lines := bytes.Split(t.Raw[:end], []byte("\n"))
+	for _, line := range lines[1:] {
+		if line = bytes.TrimSpace(line); len(line) != 0 {
+			rawLines = append(rawLines, line)
+		}
+	}
+	data, err := parseLines(rawLines)
+	if err != nil {
+		return append(ret, Violation{Message: err.Error()})
+	}
+	ret = append(ret, checkLines(data, version)...)
+
+	codes := sortedCodes(data)
+	for _, code := range codes {
+		args := data[code]
+		if format, ok := s.formats[firstChannel(obis.Code(code))]; ok {
+			if err := checkFormat(args, format); err != nil {
+				ret = append(ret, Violation{obis.Code(code), err.Error()})
+				continue
+			}
+		}
+		if allowed, ok := ranges[obis.Code(code)]; ok &&
+			len(args) == 1 && !contains(allowed, args[0]) {
+			ret = append(ret, Violation{obis.Code(code), fmt.Sprintf(
+				"%s is out of range", args[0])})
+		}
+	}
+	if args, ok := data[string(obis.Version)]; ok && s.versions != nil &&
+		(len(args) != 1 || !contains(s.versions, args[0])) {
+		ret = append(ret, Violation{obis.Version, fmt.Sprintf(
+			"%v is not a version of %s", args, version)})
+	}
+	return ret
+}
+
+// The header, eg. "/ISK5\2M550T-1012": the identifier of the manufacturer,
+// the baud rate character and the identification of the meter.
+var headerRegexp = regexp.MustCompile(`^/[A-Za-z]{3}[0-9]\S*`)
+
+// Checks which lines are present against the version.
+func checkLines(data map[string][]string, version Version) []Violation {
+	s, ok := specs[version]
+	if !ok {
+		return []Violation{{obis.Version, "unknown version"}}
+	}
+	known := make(map[obis.Code]bool)
+	for _, code := range s.codes {
+		known[code] = true
+	}
+	ret := []Violation{}
+	for _, code := range sortedCodes(data) {
+		if !known[firstChannel(obis.Code(code))] {
+			ret = append(ret, Violation{obis.Code(code), fmt.Sprintf(
+				"not part of %s", version)})
+		}
+	}
+	for _, code := range s.required {
+		if _, ok := data[string(code)]; !ok {
+			ret = append(ret, Violation{code, fmt.Sprintf(
+				"required by %s", version)})
+		}
+	}
+	return ret
+}
+
+// Checks the arguments of a line against a format like the ones of the
+// standard, one per argument separated by spaces:
+//
+//	Fn(a,b)  a number of n digits of which a up to b decimals
+//	In       an integer of n digits
+//	Sn       a hex encoded string of at most n octets
+//	TST      a timestamp YYMMDDhhmmssX
+//
+// Numbers may be followed by their unit, eg. F9(3,3)*kWh.
+func checkFormat(args []string, format string) error {
+	formats := strings.Split(format, " ")
+	if len(args) != len(formats) {
+		return errors.New(fmt.Sprintf("expected %d arguments instead of %d",
+			len(formats), len(args)))
+	}
+	for i, f := range formats {
+		if err := checkArgument(args[i], f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var numberFormatRegexp = regexp.MustCompile(`^([FI])(\d+)(?:\((\d+),(\d+)\))?(?:\*(.+))?$`)
+
+func checkArgument(arg, format string) error {
+	switch format[0] {
+	case 'T':
+		if _, err := ParseTimestamp(arg); err != nil {
+			return err
+		}
+		return nil
+	case 'S':
+		n, _ := strconv.Atoi(format[1:])
+		if len(arg) > 2*n || len(arg)%2 != 0 || !isHex(arg) {
+			return errors.New(fmt.Sprintf(
+				"%s is not a hex encoded string of at most %d octets", arg, n))
+		}
+		return nil
+	}
+
+	m := numberFormatRegexp.FindStringSubmatch(format)
+	digits, _ := strconv.Atoi(m[2])
+	minDecimals, _ := strconv.Atoi(m[3])
+	maxDecimals, _ := strconv.Atoi(m[4])
+	number := arg
+	if m[5] != "" {
+		bits := strings.SplitN(arg, "*", 2)
+		if len(bits) != 2 || bits[1] != m[5] {
+			return errors.New(fmt.Sprintf("%s is not in %s", arg, m[5]))
+		}
+		number = bits[0]
+	}
+	decimals := 0
+	if i := strings.IndexByte(number, '.'); i != -1 {
+		decimals = len(number) - i - 1
+		number = number[:i] + number[i+1:]
+	}
+	if len(number) != digits || decimals < minDecimals ||
+		decimals > maxDecimals || !isDigits(number) {
+		return errors.New(fmt.Sprintf("%s is not of format %s", arg, format))
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789ABCDEFabcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(ss []string, s string) bool {
+	for _, t := range ss {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedCodes(data map[string][]string) []string {
+	ret := make([]string, 0, len(data))
+	for code := range data {
+		ret = append(ret, code)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Returns the code of an M-Bus device as if it were on the first
+// channel, eg. 0-1:24.2.1 for 0-3:24.2.1.
+func firstChannel(code obis.Code) obis.Code {
+	c := string(code)
+	if len(c) > 4 && strings.HasPrefix(c, "0-") && c[3] == ':' &&
+		c[2] >= '2' && c[2] <= '4' {
+		return obis.Code("0-1" + c[3:])
+	}
+	return code
+}
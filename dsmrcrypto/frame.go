@@ -0,0 +1,229 @@
+// Package dsmrcrypto reads and decrypts the frames of encrypted meter
+// ports, like those of the Smarty meters in Luxembourg and of the
+// Austrian meters: DLMS general-glo-ciphering APDUs with security
+// suite 0, AES-128-GCM.
+//
+//	DB                    tag
+//	08 <8 bytes>          length and system title
+//	<length>              length of the rest, BER encoded
+//	<1 byte>              security control byte
+//	<4 bytes>             frame counter
+//	<...>                 the encrypted data
+//	<12 bytes>            GCM tag, if authenticated
+//
+// The IV is the system title followed by the frame counter and the
+// additional authenticated data is the security control byte followed
+// by the authentication key.
+package dsmrcrypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	frameTag  = 0xdb
+	gcmTagLen = 12
+)
+
+// Bits of the security control byte.
+const (
+	Authenticated byte = 0x10
+	Encrypted     byte = 0x20
+)
+
+// The authentication key used by the Smarty meters.
+var SmartyAuthKey = []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+	0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+
+type Frame struct {
+	SystemTitle     []byte // 8 bytes identifying the meter
+	SecurityControl byte
+	Counter         uint32
+	Ciphertext      []byte // including the GCM tag, if authenticated
+}
+
+// Reads the next frame, skipping anything before its tag.
+func ReadFrame(r *bufio.Reader) (*Frame, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != frameTag {
+			continue
+		}
+		n, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if n != 8 {
+			// Not a frame after all.
+			r.UnreadByte()
+			continue
+		}
+		var f Frame
+		f.SystemTitle = make([]byte, 8)
+		if _, err = io.ReadFull(r, f.SystemTitle); err != nil {
+			return nil, err
+		}
+		length, err := readLength(r)
+		if err != nil {
+			return nil, err
+		}
+		if length < 1+4 {
+			return nil, errors.New(fmt.Sprintf(
+				"frame of %d bytes is too short", length))
+		}
+		rest := make([]byte, length)
+		if _, err = io.ReadFull(r, rest); err != nil {
+			return nil, err
+		}
+		f.SecurityControl = rest[0]
+		f.Counter = binary.BigEndian.Uint32(rest[1:5])
+		f.Ciphertext = rest[5:]
+		return &f, nil
+	}
+}
+
+// Parses a single frame, eg. one reassembled from M-Bus telegrams.
+func ParseFrame(buf []byte) (*Frame, error) {
+	if len(buf) < 2 || buf[0] != frameTag || buf[1] != 8 {
+		return nil, errors.New("not a general-glo-ciphering frame")
+	}
+	f, err := ReadFrame(bufio.NewReader(bytes.NewReader(buf)))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errors.New("frame is truncated")
+	}
+	return f, err
+}
+
+// Reads a length in the BER encoding: a single byte below 0x80, or 0x8n
+// followed by n bytes.
+func readLength(r *bufio.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b < 0x80 {
+		return int(b), nil
+	}
+	if b != 0x81 && b != 0x82 {
+		return 0, errors.New(fmt.Sprintf(
+			"unexpected length encoding %#x", b))
+	}
+	var length int
+	for i := byte(0); i < b&0x7f; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(c)
+	}
+	return length, nil
+}
+
+func appendLength(buf []byte, n int) []byte {
+	switch {
+	case n < 0x80:
+		return append(buf, byte(n))
+	case n < 0x100:
+		return append(buf, 0x81, byte(n))
+	default:
+		return append(buf, 0x82, byte(n>>8), byte(n))
+	}
+}
+
+// Returns the frame as sent by a meter.
+func (f *Frame) Bytes() []byte {
+	buf := append([]byte{frameTag, 8}, f.SystemTitle...)
+	buf = appendLength(buf, 1+4+len(f.Ciphertext))
+	buf = append(buf, f.SecurityControl)
+	buf = append(buf, byte(f.Counter>>24), byte(f.Counter>>16),
+		byte(f.Counter>>8), byte(f.Counter))
+	return append(buf, f.Ciphertext...)
+}
+
+func (f *Frame) gcm(key []byte) (cipher.AEAD, []byte, error) {
+	if len(f.SystemTitle) != 8 {
+		return nil, nil, errors.New("system title should be 8 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCMWithTagSize(block, gcmTagLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	iv := make([]byte, 12)
+	copy(iv, f.SystemTitle)
+	binary.BigEndian.PutUint32(iv[8:], f.Counter)
+	return gcm, iv, nil
+}
+
+// Decrypts the frame with the given keys and authenticates it, if the
+// security control byte says it is.  The authentication key is not
+// used for frames that are only encrypted.
+func (f *Frame) Decrypt(key, authKey []byte) ([]byte, error) {
+	if f.SecurityControl&Encrypted == 0 {
+		return nil, errors.New(fmt.Sprintf(
+			"unsupported security control byte %#x", f.SecurityControl))
+	}
+	gcm, iv, err := f.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if f.SecurityControl&Authenticated != 0 {
+		aad := append([]byte{f.SecurityControl}, authKey...)
+		return gcm.Open(nil, iv, f.Ciphertext, aad)
+	}
+	return ctr(key, iv, f.Ciphertext)
+}
+
+// Encrypts the plaintext into a frame, authenticated if the security
+// control byte says so, for instance to simulate a meter.
+func Encrypt(systemTitle []byte, securityControl byte, counter uint32,
+	key, authKey, plaintext []byte) (*Frame, error) {
+	f := &Frame{
+		SystemTitle:     systemTitle,
+		SecurityControl: securityControl,
+		Counter:         counter,
+	}
+	if securityControl&Encrypted == 0 {
+		return nil, errors.New(fmt.Sprintf(
+			"unsupported security control byte %#x", securityControl))
+	}
+	gcm, iv, err := f.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	if securityControl&Authenticated != 0 {
+		aad := append([]byte{securityControl}, authKey...)
+		f.Ciphertext = gcm.Seal(nil, iv, plaintext, aad)
+	} else if f.Ciphertext, err = ctr(key, iv, plaintext); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Encrypts or decrypts without authentication, as GCM does: in counter
+// mode starting at the IV followed by a counter of 2.
+func ctr(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, iv)
+	counter[15] = 2
+	ret := make([]byte, len(data))
+	cipher.NewCTR(block, counter).XORKeyStream(ret, data)
+	return ret, nil
+}
@@ -0,0 +1,121 @@
+package dsmrcrypto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	ret, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Test case 4 of the GCM specification of McGrew and Viega, with its IV
+// split into system title and frame counter, its additional data into
+// the security control byte and authentication key, and its tag
+// truncated to the 12 bytes of DLMS.
+var (
+	gcmKey = unhex("feffe9928665731c6d6a8f9467308308")
+	gcmIV  = unhex("cafebabefacedbaddecaf888")
+	gcmAAD = unhex("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	gcmP   = unhex("d9313225f88406e5a55909c5aff5269a" +
+		"86a7a9531534f7da2e4c303d8a318a72" +
+		"1c3c0c95956809532fcf0e2449a6b525" +
+		"b16aedf5aa0de657ba637b39")
+	gcmC = unhex("42831ec2217774244b7221b784d0d49c" +
+		"e3aa212f2c02a4e035c17e2329aca12e" +
+		"21d514b25466931c7d8f6a5aac84aa05" +
+		"1ba30b396a0aac973d58e091")
+	gcmT = unhex("5bc94fbc3221a5db94fae95ae7121a47")
+)
+
+func gcmFrame(sc byte, ciphertext []byte) []byte {
+	buf := append([]byte{frameTag, 8}, gcmIV[:8]...)
+	buf = appendLength(buf, 1+4+len(ciphertext))
+	buf = append(buf, sc)
+	buf = append(buf, gcmIV[8:]...)
+	return append(buf, ciphertext...)
+}
+
+func TestDecryptVector(t *testing.T) {
+	raw := gcmFrame(gcmAAD[0], append(append([]byte{}, gcmC...),
+		gcmT[:gcmTagLen]...))
+	f, err := ParseFrame(raw)
+	if err != nil {
+		t.Fatalf("ParseFrame: %v", err)
+	}
+	if f.Counter != 0xdecaf888 || f.SecurityControl != 0xfe {
+		t.Fatalf("ParseFrame: counter %#x, security control %#x",
+			f.Counter, f.SecurityControl)
+	}
+	plaintext, err := f.Decrypt(gcmKey, gcmAAD[1:])
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(plaintext, gcmP) {
+		t.Fatalf("Decrypt: got %x", plaintext)
+	}
+	if !bytes.Equal(f.Bytes(), raw) {
+		t.Fatalf("Bytes: got %x; expected %x", f.Bytes(), raw)
+	}
+
+	// A wrong authentication key fails to authenticate.
+	if _, err = f.Decrypt(gcmKey, SmartyAuthKey); err == nil {
+		t.Fatalf("Decrypt: accepted the wrong authentication key")
+	}
+
+	// Without authentication there is no tag.
+	f, err = ParseFrame(gcmFrame(Encrypted, gcmC))
+	if err != nil {
+		t.Fatalf("ParseFrame: %v", err)
+	}
+	if plaintext, err = f.Decrypt(gcmKey, nil); err != nil ||
+		!bytes.Equal(plaintext, gcmP) {
+		t.Fatalf("Decrypt without authentication: got %x, %v",
+			plaintext, err)
+	}
+}
+
+func TestSmartyRoundTrip(t *testing.T) {
+	telegram := []byte("/Ene5\\T210-D ESMR5.0\r\n\r\n" +
+		"1-3:0.2.8(50)\r\n0-0:1.0.0(261016120000S)\r\n" +
+		"1-0:1.8.0(001234.567*kWh)\r\n!ABCD\r\n")
+	title := []byte("SAG\x10\x00\x00\x00\x01")
+	f, err := Encrypt(title, Encrypted|Authenticated, 1234, gcmKey,
+		SmartyAuthKey, telegram)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// Readers skip to the next frame.
+	stream := append([]byte{0x00, frameTag, 0x42}, f.Bytes()...)
+	read, err := ReadFrame(bufio.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if !bytes.Equal(read.SystemTitle, title) || read.Counter != 1234 {
+		t.Fatalf("ReadFrame: system title %x, counter %d",
+			read.SystemTitle, read.Counter)
+	}
+	plaintext, err := read.Decrypt(gcmKey, SmartyAuthKey)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(plaintext, telegram) {
+		t.Fatalf("Decrypt: got %q", plaintext)
+	}
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	raw := gcmFrame(Encrypted, gcmC)
+	for _, buf := range [][]byte{nil, raw[1:], raw[:len(raw)-1]} {
+		if _, err := ParseFrame(buf); err == nil {
+			t.Errorf("ParseFrame(%x): expected an error", buf)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+// Nagios and Icinga plugin that checks the power, voltage, the age of the
+// latest telegram and the rate of invalid telegrams of a smart meter,
+// through dsmrp1d or directly.
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes and labels of the plugin protocol.
+const (
+	stateOK = iota
+	stateWarning
+	stateCritical
+	stateUnknown
+)
+
+var stateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// How bad the states are: a problem outweighs not knowing.
+var severity = []int{0, 2, 3, 1}
+
+// Exits with the given state and output.
+func exit(state int, text string, perfdata []string) {
+	fmt.Printf("DSMRP1 %s - %s", stateNames[state], text)
+	if len(perfdata) != 0 {
+		fmt.Printf(" | %s", strings.Join(perfdata, " "))
+	}
+	fmt.Println()
+	os.Exit(state)
+}
+
+// The result of the checks so far.
+type result struct {
+	state    int
+	problems []string
+	perfdata []string
+}
+
+func (r *result) raise(state int, problem string) {
+	if severity[state] > severity[r.state] {
+		r.state = state
+	}
+	r.problems = append(r.problems, problem)
+}
+
+// Checks v against the thresholds and adds it to the performance data.
+func (r *result) check(label, uom string, v float64, warn, crit *threshold,
+	problem string) {
+	if crit.alert(v) {
+		r.raise(stateCritical, problem)
+	} else if warn.alert(v) {
+		r.raise(stateWarning, problem)
+	}
+	r.perfdata = append(r.perfdata, fmt.Sprintf("%s=%s%s;%s;%s", label,
+		strconv.FormatFloat(v, 'f', -1, 64), uom, warn, crit))
+}
+
+func main() {
+	var url, token, meter, source string
+	var timeout time.Duration
+	var count int
+	specs := make(map[string]*string)
+	thresholds := []struct{ name, help string }{
+		{"power", "import power in W"},
+		{"voltage", "voltage of each phase in V, eg. 207:253"},
+		{"age", "age of the latest telegram in seconds"},
+		{"invalid", "percentage of telegrams that failed their checksum " +
+			"or to parse"},
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.StringVar(&url, "url", "http://localhost:1121",
+		"URL of dsmrp1d")
+	flags.StringVar(&token, "token", "",
+		"bearer token to authenticate to dsmrp1d")
+	flags.StringVar(&meter, "meter", "",
+		"name of the meter of dsmrp1d to check; by default the first")
+	flags.StringVar(&source, "serial", "",
+		"read from the meter at serial:/dev/..., tcp:host:port or a "+
+			"serial port instead of from dsmrp1d")
+	flags.IntVar(&count, "telegrams", 10,
+		"number of telegrams to read with -serial")
+	flags.DurationVar(&timeout, "timeout", 10*time.Second,
+		"give up after this long; with -serial, check the telegrams read "+
+			"so far")
+	for _, t := range thresholds {
+		specs["w-"+t.name] = flags.String("w-"+t.name, "",
+			"warning range of the "+t.help)
+		specs["c-"+t.name] = flags.String("c-"+t.name, "",
+			"critical range of the "+t.help)
+	}
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		os.Exit(stateUnknown)
+	}
+
+	parsed := make(map[string]*threshold)
+	for name, spec := range specs {
+		t, err := parseThreshold(*spec)
+		if err != nil {
+			exit(stateUnknown, fmt.Sprintf("-%s: %v", name, err), nil)
+		}
+		parsed[name] = t
+	}
+	wantCounts := parsed["w-invalid"] != nil || parsed["c-invalid"] != nil
+
+	var s *status
+	var err error
+	if source != "" {
+		s, err = readStatus(source, count, timeout)
+	} else {
+		s, err = fetchStatus(url, token, meter, timeout, wantCounts)
+	}
+	if err != nil {
+		exit(stateUnknown, err.Error(), nil)
+	}
+
+	var r result
+	var summary []string
+	t := s.telegram
+	if e := t.Electricity; e != nil {
+		r.check("power", "W", dsmrp1.Float64(e.W), parsed["w-power"],
+			parsed["c-power"], fmt.Sprintf("power %v W", e.W))
+		r.perfdata = append(r.perfdata, fmt.Sprintf("power_out=%sW",
+			strconv.FormatFloat(dsmrp1.Float64(e.WOut), 'f', -1, 64)))
+		summary = append(summary, fmt.Sprintf("%v W", e.W))
+	} else if parsed["w-power"] != nil || parsed["c-power"] != nil {
+		r.raise(stateUnknown, "no power in telegram")
+	}
+
+	var voltages []string
+	for i, p := range t.Phases() {
+		if p.Voltage == nil {
+			continue
+		}
+		r.check(fmt.Sprintf("voltage_l%d", i+1), "V",
+			dsmrp1.Float64(*p.Voltage),
+			parsed["w-voltage"], parsed["c-voltage"],
+			fmt.Sprintf("voltage L%d %v V", i+1, *p.Voltage))
+		voltages = append(voltages, fmt.Sprintf("%v", *p.Voltage))
+	}
+	if len(voltages) != 0 {
+		summary = append(summary, strings.Join(voltages, "/")+" V")
+	} else if parsed["w-voltage"] != nil || parsed["c-voltage"] != nil {
+		r.raise(stateUnknown, "no voltage in telegram")
+	}
+
+	age := s.age.Seconds()
+	r.check("age", "s", age, parsed["w-age"], parsed["c-age"],
+		fmt.Sprintf("telegram %.0f s old", age))
+
+	if s.haveCounts {
+		var pct float64
+		if total := s.telegrams + s.invalid; total != 0 {
+			pct = 100 * float64(s.invalid) / float64(total)
+		}
+		r.check("invalid", "%", pct, parsed["w-invalid"],
+			parsed["c-invalid"], fmt.Sprintf("%.1f%% invalid telegrams", pct))
+		r.perfdata[len(r.perfdata)-1] += ";0;100"
+	} else if wantCounts {
+		r.raise(stateUnknown, "no telegram counts")
+	}
+
+	text := strings.Join(summary, ", ")
+	if len(r.problems) != 0 {
+		text = strings.Join(r.problems, ", ")
+	}
+	exit(r.state, text, r.perfdata)
+}
@@ -0,0 +1,126 @@
+package main
+
+// Gets the latest telegram and the counts of valid and invalid
+// telegrams, from dsmrp1d or directly from the meter.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type status struct {
+	telegram   *dsmrp1.Telegram
+	age        time.Duration
+	haveCounts bool
+	telegrams  uint64
+	invalid    uint64
+}
+
+// Fetches the status of the meter from the dsmrp1d at url, or of its
+// first meter if none is given.  The counts are only fetched if
+// withCounts is set, as the admin endpoint may be protected.
+func fetchStatus(url, token, meter string, timeout time.Duration,
+	withCounts bool) (*status, error) {
+	var ret status
+	path := "/"
+	if meter != "" {
+		path = "/api/v1/meters/" + meter + "/telegram"
+	}
+	header, err := fetch(url, token, path, timeout, &ret.telegram)
+	if err != nil {
+		return nil, err
+	}
+	if secs, err := strconv.ParseFloat(header.Get("X-Age-Seconds"),
+		64); err == nil {
+		ret.age = time.Duration(secs * float64(time.Second))
+	}
+
+	if !withCounts {
+		return &ret, nil
+	}
+	var admin struct {
+		Meters []struct {
+			Name      string  `json:"name"`
+			Telegrams *uint64 `json:"telegrams"`
+			Invalid   *uint64 `json:"invalid_telegrams"`
+		} `json:"meters"`
+	}
+	if _, err := fetch(url, token, "/api/v1/admin/status", timeout,
+		&admin); err != nil {
+		return nil, err
+	}
+	for i, m := range admin.Meters {
+		if (m.Name == meter || meter == "" && i == 0) &&
+			m.Telegrams != nil && m.Invalid != nil {
+			ret.haveCounts = true
+			ret.telegrams, ret.invalid = *m.Telegrams, *m.Invalid
+		}
+	}
+	return &ret, nil
+}
+
+func fetch(url, token, path string, timeout time.Duration,
+	v interface{}) (http.Header, error) {
+	client := http.Client{Timeout: timeout}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(url, "/")+path,
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"could not connect to dsmrp1d at %s: %v", url, err))
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read response: %v", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("%s returned %s: %s", path,
+			resp.Status, strings.TrimSpace(string(body))))
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse %s: %v", path, err))
+	}
+	return resp.Header, nil
+}
+
+// Reads count telegrams from the meter, giving up after timeout.  The
+// status has the last of them, with the counts of all received.
+func readStatus(source string, count int, timeout time.Duration) (
+	*status, error) {
+	m, err := dsmrp1.OpenMeter(source)
+	if err != nil {
+		return nil, err
+	}
+	var ret status
+	deadline := time.After(timeout)
+loop:
+	for n := 0; n < count; n++ {
+		select {
+		case ret.telegram = <-m.C:
+		case <-deadline:
+			if ret.telegram == nil {
+				return nil, errors.New(fmt.Sprintf(
+					"no telegram from %s within %v", source, timeout))
+			}
+			break loop
+		}
+	}
+	stats := m.Stats()
+	ret.haveCounts = true
+	ret.telegrams, ret.invalid = stats.Telegrams, stats.Invalid
+	return &ret, nil
+}
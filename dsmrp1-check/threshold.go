@@ -0,0 +1,71 @@
+package main
+
+// Thresholds in the range syntax of the Nagios plugin guidelines:
+//
+//	10     alert if < 0 or > 10
+//	10:    alert if < 10
+//	~:10   alert if > 10
+//	10:20  alert if < 10 or > 20
+//	@10:20 alert if >= 10 and <= 20
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type threshold struct {
+	spec       string // as given, for the performance data
+	start, end float64
+	inside     bool // alert inside the range instead of outside
+}
+
+func parseThreshold(spec string) (*threshold, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	t := &threshold{spec: spec, end: math.Inf(1)}
+	s := spec
+	if strings.HasPrefix(s, "@") {
+		t.inside = true
+		s = s[1:]
+	}
+	start, end := "0", s
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		start, end = s[:i], s[i+1:]
+	}
+	var err error
+	if start == "~" {
+		t.start = math.Inf(-1)
+	} else if t.start, err = strconv.ParseFloat(start, 64); err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid range %s", spec))
+	}
+	if end != "" {
+		if t.end, err = strconv.ParseFloat(end, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid range %s", spec))
+		}
+	}
+	if t.start > t.end {
+		return nil, errors.New(fmt.Sprintf(
+			"invalid range %s: start is after end", spec))
+	}
+	return t, nil
+}
+
+// Returns whether v should raise an alert.
+func (t *threshold) alert(v float64) bool {
+	if t == nil {
+		return false
+	}
+	in := v >= t.start && v <= t.end
+	return in == t.inside
+}
+
+func (t *threshold) String() string {
+	if t == nil {
+		return ""
+	}
+	return t.spec
+}
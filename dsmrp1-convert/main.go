@@ -0,0 +1,164 @@
+package main
+
+// Converts captures of raw telegrams, as recorded by dsmrp1d -capture
+// or dsmrp1tail -record, to CSV, JSON lines, the InfluxDB line protocol
+// or Parquet.  The telegrams are parsed again, so archives benefit from
+// improvements to the parser.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/history"
+	"github.com/bwesterb/go-dsmrp1/parquet"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var formats = []string{"csv", "jsonl", "influx", "parquet"}
+
+// A telegram as a line of JSON.
+type jsonLine struct {
+	Time     time.Time        `json:"time"`
+	Telegram *dsmrp1.Telegram `json:"telegram"`
+}
+
+func main() {
+	var format, output, fromSpec, toSpec, measurement string
+	var strict bool
+	var workers int
+
+	flag.StringVar(&format, "format", "csv",
+		"output format: "+strings.Join(formats, ", "))
+	flag.StringVar(&output, "o", "-", "file to write to; - for stdout")
+	flag.StringVar(&fromSpec, "from", "",
+		"skip telegrams received before this time or date")
+	flag.StringVar(&toSpec, "to", "",
+		"skip telegrams received at or after this time or date")
+	flag.StringVar(&measurement, "measurement", "p1",
+		"measurement of the InfluxDB line protocol")
+	flag.BoolVar(&strict, "strict", false,
+		"fail on the first telegram that does not parse instead of "+
+			"skipping it")
+	flag.IntVar(&workers, "workers", 0,
+		"number of telegrams to parse in parallel; by default one per CPU")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: %s [flags] capture...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	known := false
+	for _, f := range formats {
+		known = known || f == format
+	}
+	if !known {
+		log.Fatalf("-format: expected one of %s", strings.Join(formats, ", "))
+	}
+	from, err := history.ParseTime(fromSpec, time.Time{})
+	if err != nil {
+		log.Fatalf("-from: %v", err)
+	}
+	to, err := history.ParseTime(toSpec, time.Unix(1<<62, 0))
+	if err != nil {
+		log.Fatalf("-to: %v", err)
+	}
+	measurement = strings.NewReplacer(",", `\,`, " ", `\ `).Replace(
+		measurement)
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+
+	var write func(t *dsmrp1.Telegram, at time.Time) error
+	var pw *parquet.Writer
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	switch format {
+	case "csv":
+		cw.Write(columns)
+		write = func(t *dsmrp1.Telegram, at time.Time) error {
+			return cw.Write(newRow(t, at).record())
+		}
+	case "jsonl":
+		write = func(t *dsmrp1.Telegram, at time.Time) error {
+			return enc.Encode(jsonLine{at, t})
+		}
+	case "influx":
+		write = func(t *dsmrp1.Telegram, at time.Time) error {
+			return newRow(t, at).writeInflux(bw, measurement)
+		}
+	case "parquet":
+		pw = parquet.NewWriter(bw, columns)
+		write = func(t *dsmrp1.Telegram, at time.Time) error {
+			return pw.Append(at, newRow(t, at).values)
+		}
+	}
+
+	var converted, invalid int
+	for _, path := range flag.Args() {
+		rc, err := dsmrp1.OpenCapture(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n := 0
+		err = dsmrp1.ParseStreamParallel(rc, dsmrp1.ParseOptions{}, workers,
+			func(t *dsmrp1.Telegram, at time.Time, errs []error) error {
+				n++
+				if errs != nil {
+					if strict {
+						return errors.New(fmt.Sprintf("telegram %d: %v",
+							n, errs[0]))
+					}
+					invalid++
+					return nil
+				}
+				if at.IsZero() {
+					// Plain captures do not record when the telegrams
+					// were received: use the time of the meter instead.
+					at, _ = dsmrp1.ParseTimestamp(t.TimeStamp)
+				}
+				if at.Before(from) || !at.Before(to) {
+					return nil
+				}
+				converted++
+				return write(t, at)
+			})
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		rc.Close()
+	}
+
+	cw.Flush()
+	if pw != nil {
+		if err = pw.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Converted %d telegrams; skipped %d invalid ones",
+		converted, invalid)
+}
@@ -0,0 +1,71 @@
+package main
+
+// The readings of a telegram as a row, with the same columns as the
+// export of dsmrp1d.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type row struct {
+	at     time.Time
+	values []*float64 // in the order of columns[1:]
+}
+
+var columns = []string{"time", "import_high_kwh", "import_low_kwh",
+	"export_high_kwh", "export_low_kwh", "power_w", "power_out_w", "gas_m3"}
+
+func newRow(t *dsmrp1.Telegram, at time.Time) row {
+	ret := row{at: at, values: make([]*float64, len(columns)-1)}
+	val := func(v float32) *float64 {
+		f := dsmrp1.Float64(v)
+		return &f
+	}
+	if e := t.Electricity; e != nil {
+		ret.values[0] = val(e.KWh)
+		ret.values[1] = val(e.KWhLow)
+		ret.values[2] = val(e.KWhOut)
+		ret.values[3] = val(e.KWhOutLow)
+		ret.values[4] = val(e.W)
+		ret.values[5] = val(e.WOut)
+	}
+	if t.Gas != nil {
+		ret.values[6] = val(t.Gas.LastRecord.Value)
+	}
+	return ret
+}
+
+func (r row) record() []string {
+	record := []string{r.at.Format("2006-01-02T15:04:05.000Z07:00")}
+	for _, v := range r.values {
+		if v == nil {
+			record = append(record, "")
+		} else {
+			record = append(record, strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	return record
+}
+
+// Writes the row as a line of the InfluxDB line protocol.  Rows without
+// any values are skipped, as a line needs at least one field.
+func (r row) writeInflux(w io.Writer, measurement string) error {
+	var fields []string
+	for i, v := range r.values {
+		if v != nil {
+			fields = append(fields, columns[i+1]+"="+
+				strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "%s %s %d\n", measurement,
+		strings.Join(fields, ","), r.at.UnixNano())
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+// Downsampling of the telegrams to a row per minute and per hour.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"strings"
+	"time"
+)
+
+// The readings stored of each telegram.
+type reading struct {
+	importHigh, importLow *float64 // kWh
+	exportHigh, exportLow *float64
+	power, powerOut       *float64 // W
+	gas                   *float64 // m3
+}
+
+func newReading(t *dsmrp1.Telegram) reading {
+	var ret reading
+	val := func(v float32) *float64 {
+		f := dsmrp1.Float64(v)
+		return &f
+	}
+	if e := t.Electricity; e != nil {
+		ret.importHigh = val(e.KWh)
+		ret.importLow = val(e.KWhLow)
+		ret.exportHigh = val(e.KWhOut)
+		ret.exportLow = val(e.KWhOutLow)
+		ret.power = val(e.W)
+		ret.powerOut = val(e.WOut)
+	}
+	if t.Gas != nil {
+		ret.gas = val(t.Gas.LastRecord.Value)
+	}
+	return ret
+}
+
+const rawColumns = "import_high_kwh DOUBLE, import_low_kwh DOUBLE, " +
+	"export_high_kwh DOUBLE, export_low_kwh DOUBLE, power_w DOUBLE, " +
+	"power_out_w DOUBLE, gas_m3 DOUBLE"
+
+// Like the raw telegrams, but with the mean power and the peak.
+const aggregateColumns = "import_high_kwh DOUBLE, import_low_kwh DOUBLE, " +
+	"export_high_kwh DOUBLE, export_low_kwh DOUBLE, power_w DOUBLE, " +
+	"power_max_w DOUBLE, power_out_w DOUBLE, gas_m3 DOUBLE"
+
+func (r reading) sqlValues() string {
+	return strings.Join([]string{sqlValue(r.importHigh),
+		sqlValue(r.importLow), sqlValue(r.exportHigh),
+		sqlValue(r.exportLow), sqlValue(r.power), sqlValue(r.powerOut),
+		sqlValue(r.gas)}, ", ")
+}
+
+// Collects the readings of a period, eg. a minute.
+type bucket struct {
+	start       time.Time
+	last        reading // for the registers, of the end of the period
+	n           int     // readings with power
+	powerSum    float64
+	powerOutSum float64
+	powerMax    float64
+}
+
+func (b *bucket) add(r reading) {
+	b.last = r
+	if r.power == nil || r.powerOut == nil {
+		return
+	}
+	b.n++
+	b.powerSum += *r.power
+	b.powerOutSum += *r.powerOut
+	if *r.power > b.powerMax {
+		b.powerMax = *r.power
+	}
+}
+
+func (b *bucket) sqlValues() string {
+	var power, powerMax, powerOut *float64
+	if b.n != 0 {
+		mean := b.powerSum / float64(b.n)
+		meanOut := b.powerOutSum / float64(b.n)
+		power, powerMax, powerOut = &mean, &b.powerMax, &meanOut
+	}
+	r := b.last
+	return strings.Join([]string{sqlValue(r.importHigh),
+		sqlValue(r.importLow), sqlValue(r.exportHigh),
+		sqlValue(r.exportLow), sqlValue(power), sqlValue(powerMax),
+		sqlValue(powerOut), sqlValue(r.gas)}, ", ")
+}
+
+// Downsamples the readings into a table with a row per period.
+type aggregator struct {
+	table  string
+	period time.Duration
+	cur    *bucket
+}
+
+// Adds the reading, writing the row of the previous period when a new
+// one starts.
+func (a *aggregator) add(db *database, at time.Time, r reading) {
+	start := at.Truncate(a.period)
+	if a.cur != nil && !a.cur.start.Equal(start) {
+		a.flush(db)
+	}
+	if a.cur == nil {
+		a.cur = &bucket{start: start}
+	}
+	a.cur.add(r)
+}
+
+// Writes the row of the current period, even if it is not over yet.
+func (a *aggregator) flush(db *database) {
+	if a.cur == nil {
+		return
+	}
+	db.exec("INSERT OR REPLACE INTO %s VALUES (%d, %s)", a.table,
+		a.cur.start.Unix(), a.cur.sqlValues())
+	a.cur = nil
+}
+
+// Returns the CREATE TABLE statement of a table.
+func createTable(table, columns string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s "+
+		"(time INTEGER PRIMARY KEY, %s)", table, columns)
+}
@@ -0,0 +1,117 @@
+package main
+
+// Writes to SQLite or DuckDB through their command line shells, which
+// read SQL statements from stdin, so that we do not need cgo drivers.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type database struct {
+	lock    sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	w       *bufio.Writer
+	pending int // statements since the last commit
+	closing bool
+	done    chan error
+}
+
+// Opens the database described by spec: sqlite:path or duckdb:path.  A
+// path without prefix is a SQLite database.  The shell is looked up as
+// sqlite3 or duckdb in $PATH unless given.
+func openDatabase(spec, shell string) (*database, error) {
+	kind, path := "sqlite", spec
+	if bits := strings.SplitN(spec, ":", 2); len(bits) == 2 &&
+		(bits[0] == "sqlite" || bits[0] == "duckdb") {
+		kind, path = bits[0], bits[1]
+	}
+	if shell == "" {
+		shell = map[string]string{"sqlite": "sqlite3", "duckdb": "duckdb"}[kind]
+	}
+	if path == "" {
+		return nil, errors.New("no path to the database given")
+	}
+
+	// -bail makes the shell exit on the first error, which we notice.
+	cmd := exec.Command(shell, "-bail", path)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	db := &database{
+		cmd:   cmd,
+		stdin: stdin,
+		w:     bufio.NewWriter(stdin),
+		done:  make(chan error, 1),
+	}
+	go func() {
+		err := cmd.Wait()
+		db.lock.Lock()
+		closing := db.closing
+		db.lock.Unlock()
+		if !closing {
+			log.Fatalf("%s exited: %v", shell, err)
+		}
+		db.done <- err
+	}()
+	db.w.WriteString("BEGIN;\n")
+	return db, nil
+}
+
+// Queues a statement, which is run with the next commit.
+func (db *database) exec(format string, args ...interface{}) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	fmt.Fprintf(db.w, format, args...)
+	db.w.WriteString(";\n")
+	db.pending++
+}
+
+// Commits the statements queued so far.
+func (db *database) commit() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.pending == 0 {
+		return nil
+	}
+	db.pending = 0
+	db.w.WriteString("COMMIT;\nBEGIN;\n")
+	return db.w.Flush()
+}
+
+// Commits and waits for the shell to finish.
+func (db *database) close() error {
+	db.lock.Lock()
+	db.closing = true
+	db.w.WriteString("COMMIT;\n")
+	err := db.w.Flush()
+	db.stdin.Close()
+	db.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return <-db.done
+}
+
+// Formats a value as an SQL literal.
+func sqlValue(v *float64) string {
+	if v == nil {
+		return "NULL"
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
@@ -0,0 +1,113 @@
+package main
+
+// Records the telegrams of a smart meter in a SQLite or DuckDB database,
+// with a row per telegram, per minute and per hour, each kept for as
+// long as configured.  Times are in seconds since the epoch.
+
+import (
+	"flag"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var source, dbSpec, shell string
+	var rawInterval, commitInterval time.Duration
+	retain := map[string]*time.Duration{
+		"telegrams": new(time.Duration),
+		"minutes":   new(time.Duration),
+		"hours":     new(time.Duration),
+	}
+
+	flag.StringVar(&source, "meter", "/dev/P1",
+		"meter to read from: a serial port, serial:/dev/... or tcp:host:port")
+	flag.StringVar(&dbSpec, "db", "sqlite:dsmrp1.sqlite",
+		"database to write to: sqlite:path or duckdb:path")
+	flag.StringVar(&shell, "shell", "",
+		"command line shell of the database; by default sqlite3 or duckdb")
+	flag.DurationVar(&rawInterval, "raw-interval", 0,
+		"minimum time between stored telegrams; 0 to store all")
+	flag.DurationVar(&commitInterval, "commit", 10*time.Second,
+		"time between commits")
+	flag.DurationVar(retain["telegrams"], "retain-raw", 7*24*time.Hour,
+		"how long to keep the telegrams; 0 to keep them forever")
+	flag.DurationVar(retain["minutes"], "retain-minutes", 90*24*time.Hour,
+		"how long to keep the rows per minute; 0 to keep them forever")
+	flag.DurationVar(retain["hours"], "retain-hours", 0,
+		"how long to keep the rows per hour; 0 to keep them forever")
+
+	flag.Parse()
+
+	m, err := dsmrp1.OpenMeter(source)
+	if err != nil {
+		log.Fatalf("OpenMeter(%s): %v", source, err)
+	}
+	db, err := openDatabase(dbSpec, shell)
+	if err != nil {
+		log.Fatalf("-db: %v", err)
+	}
+	db.exec("%s", createTable("telegrams", rawColumns))
+	db.exec("%s", createTable("minutes", aggregateColumns))
+	db.exec("%s", createTable("hours", aggregateColumns))
+	if err = db.commit(); err != nil {
+		log.Fatal(err)
+	}
+
+	aggregators := []*aggregator{
+		{table: "minutes", period: time.Minute},
+		{table: "hours", period: time.Hour},
+	}
+	expire := func() {
+		for table, d := range retain {
+			if *d != 0 {
+				db.exec("DELETE FROM %s WHERE time < %d", table,
+					time.Now().Add(-*d).Unix())
+			}
+		}
+	}
+	expire()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	commits := time.NewTicker(commitInterval)
+	expiries := time.NewTicker(time.Hour)
+	var last time.Time
+	for {
+		select {
+		case t := <-m.C:
+			at := time.Now()
+			r := newReading(t)
+			for _, a := range aggregators {
+				a.add(db, at, r)
+			}
+			if at.Sub(last) < rawInterval {
+				continue
+			}
+			last = at
+			db.exec("INSERT OR REPLACE INTO telegrams VALUES (%d, %s)",
+				at.Unix(), r.sqlValues())
+
+		case <-commits.C:
+			if err := db.commit(); err != nil {
+				log.Fatal(err)
+			}
+
+		case <-expiries.C:
+			expire()
+
+		case <-signals:
+			// Keep what we have of the current minute and hour.
+			for _, a := range aggregators {
+				a.flush(db)
+			}
+			if err := db.close(); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+// Decrypts the telegrams of an encrypted P1 port, such as those of the
+// Smarty meters in Luxembourg, and writes them to stdout as a plain P1
+// port would send them, eg.
+//
+//	dsmrp1-decrypt -source /dev/ttyUSB0 | dsmrp1tail -source -
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/dsmrcrypto"
+	"github.com/tarm/serial"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// The authentication key used by the Smarty meters.
+const defaultAuthKey = "00112233445566778899AABBCCDDEEFF"
+
+// Opens the source: - for stdin, file:path, tcp:host:port, or
+// serial:/dev/... or just the path of a serial port.
+func openSource(spec string) (io.ReadCloser, error) {
+	if spec == "-" {
+		return os.Stdin, nil
+	}
+	bits := strings.SplitN(spec, ":", 2)
+	if len(bits) == 2 {
+		switch bits[0] {
+		case "file":
+			return os.Open(bits[1])
+		case "tcp":
+			return net.DialTimeout("tcp", bits[1], 10*time.Second)
+		case "serial":
+			spec = bits[1]
+		}
+	}
+	return serial.OpenPort(&serial.Config{
+		Name:     spec,
+		Baud:     115200,
+		Parity:   serial.ParityNone,
+		StopBits: serial.Stop1,
+	})
+}
+
+func parseKey(name, s string) []byte {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != 16 {
+		log.Fatalf("%s: expected 32 hexadecimal digits", name)
+	}
+	return key
+}
+
+func main() {
+	var source, keySpec, authKeySpec string
+	var check bool
+
+	flag.StringVar(&source, "source", "-",
+		"where to read the encrypted stream from: - for stdin, file:path, "+
+			"tcp:host:port or a serial port")
+	flag.StringVar(&keySpec, "key", "",
+		"decryption key as provided by the grid operator, in hex; "+
+			"defaults to $DSMRP1_KEY")
+	flag.StringVar(&authKeySpec, "auth-key", defaultAuthKey,
+		"authentication key, in hex")
+	flag.BoolVar(&check, "check", false,
+		"only pass on telegrams that parse")
+
+	flag.Parse()
+
+	if keySpec == "" {
+		// The environment does not show up in ps.
+		keySpec = os.Getenv("DSMRP1_KEY")
+	}
+	if keySpec == "" {
+		log.Fatal("-key or $DSMRP1_KEY is required")
+	}
+	key := parseKey("-key", keySpec)
+	authKey := parseKey("-auth-key", authKeySpec)
+
+	rc, err := openSource(source)
+	if err != nil {
+		log.Fatalf("%s: %v", source, err)
+	}
+	defer rc.Close()
+
+	r := bufio.NewReader(rc)
+	var last uint32
+	for {
+		f, err := dsmrcrypto.ReadFrame(r)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", source, err)
+		}
+		plain, err := f.Decrypt(key, authKey)
+		if err != nil {
+			log.Printf("Frame %d: failed to decrypt; wrong key?", f.Counter)
+			continue
+		}
+		if f.Counter <= last {
+			log.Printf("Frame %d: counter went back from %d", f.Counter,
+				last)
+		}
+		last = f.Counter
+		if check {
+			if _, errs := dsmrp1.ParseTelegram(plain); errs != nil {
+				log.Printf("Frame %d: %v", f.Counter, errs[0])
+				continue
+			}
+		}
+		if _, err = os.Stdout.Write(plain); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
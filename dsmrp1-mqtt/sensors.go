@@ -0,0 +1,133 @@
+package main
+
+// The readings published to MQTT and how Home Assistant should present
+// them.
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+)
+
+type sensor struct {
+	key         string // in the state JSON and the unique_id
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+	icon        string
+}
+
+// Returns the sensors for which t has a reading with their values.
+// Which sensors there are depends on the meter: gas, three phases, and
+// whether it reports the voltage.
+func readings(t *dsmrp1.Telegram) ([]sensor, map[string]float64) {
+	var sensors []sensor
+	values := make(map[string]float64)
+	add := func(s sensor, v float64) {
+		sensors = append(sensors, s)
+		values[s.key] = v
+	}
+	energy := func(key, name string, v float32) {
+		add(sensor{key, name, "kWh", "energy", "total_increasing", ""},
+			dsmrp1.Float64(v))
+	}
+	power := func(key, name string, v float32) {
+		add(sensor{key, name, "W", "power", "measurement", ""},
+			dsmrp1.Float64(v))
+	}
+
+	if e := t.Electricity; e != nil {
+		energy("energy_import_low", "Energy import low tariff", e.KWhLow)
+		energy("energy_import_high", "Energy import high tariff", e.KWh)
+		energy("energy_export_low", "Energy export low tariff", e.KWhOutLow)
+		energy("energy_export_high", "Energy export high tariff", e.KWhOut)
+		power("power_import", "Power import", e.W)
+		power("power_export", "Power export", e.WOut)
+		add(sensor{"tariff", "Tariff", "", "", "", "mdi:clock-outline"},
+			float64(e.Tariff))
+		add(sensor{"power_failures", "Power failures", "", "",
+			"total_increasing", "mdi:flash-off"}, float64(e.PowerFailures))
+		add(sensor{"long_power_failures", "Long power failures", "", "",
+			"total_increasing", "mdi:flash-off"},
+			float64(e.LongPowerFailures))
+	}
+
+	for i, p := range t.Phases() {
+		l := fmt.Sprintf("l%d", i+1)
+		L := fmt.Sprintf("L%d", i+1)
+		if p.Voltage != nil {
+			add(sensor{"voltage_" + l, "Voltage " + L, "V", "voltage",
+				"measurement", ""}, dsmrp1.Float64(*p.Voltage))
+		}
+		add(sensor{"current_" + l, "Current " + L, "A", "current",
+			"measurement", ""}, dsmrp1.Float64(p.Current))
+		power("power_import_"+l, "Power import "+L, p.Power)
+		power("power_export_"+l, "Power export "+L, p.PowerOut)
+	}
+
+	if t.Gas != nil {
+		add(sensor{"gas", "Gas", "m³", "gas", "total_increasing", ""},
+			dsmrp1.Float64(t.Gas.LastRecord.Value))
+	}
+	return sensors, values
+}
+
+// The device all sensors belong to.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model,omitempty"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+}
+
+// A Home Assistant MQTT discovery message for a sensor.
+type haSensorConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	ObjectID          string   `json:"object_id"`
+	StateTopic        string   `json:"state_topic"`
+	ValueTemplate     string   `json:"value_template"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	Icon              string   `json:"icon,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+// Returns the discovery topics and messages of the sensors of the meter
+// with the given identifier.
+func discovery(discoveryPrefix, prefix, id string, t *dsmrp1.Telegram,
+	sensors []sensor) map[string][]byte {
+	node := "dsmrp1_" + id
+	device := haDevice{
+		Identifiers: []string{node},
+		Name:        "Smart meter",
+		Model:       t.HeaderId,
+	}
+	if len(t.HeaderMarker) >= 4 {
+		// The three letter manufacturer code follows the /, eg. /ISk5.
+		device.Manufacturer = t.HeaderMarker[1:4]
+	}
+	ret := make(map[string][]byte)
+	for _, s := range sensors {
+		body, _ := json.Marshal(haSensorConfig{
+			Name:              s.name,
+			UniqueID:          node + "_" + s.key,
+			ObjectID:          "dsmrp1_" + s.key,
+			StateTopic:        prefix + "/state",
+			ValueTemplate:     "{{ value_json." + s.key + " }}",
+			AvailabilityTopic: prefix + "/status",
+			Unit:              s.unit,
+			DeviceClass:       s.deviceClass,
+			StateClass:        s.stateClass,
+			Icon:              s.icon,
+			Device:            device,
+		})
+		topic := fmt.Sprintf("%s/sensor/%s/%s/config", discoveryPrefix,
+			node, s.key)
+		ret[topic] = body
+	}
+	return ret
+}
@@ -0,0 +1,83 @@
+package main
+
+// Configuration from the environment, as set in the plugin
+// configuration of munin-node, eg.
+//
+//	[dsmrp1]
+//	env.url http://localhost:1121
+//	env.timeout 10
+//	env.max_age 60
+//	env.prefix p1
+//	env.category P1
+//
+// Without dsmrp1d, the plugin can read the meter itself:
+//
+//	[dsmrp1]
+//	user root
+//	env.serial /dev/P1
+//	env.timeout 30
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type config struct {
+	url      string        // of dsmrp1d
+	timeout  time.Duration // of the requests to dsmrp1d
+	maxAge   time.Duration // of telegrams before they are stale; 0 for any
+	prefix   string        // of the names of the graphs
+	category string        // of the graphs
+
+	// Serial port or tcp:host:port of the meter to read directly
+	// instead of asking dsmrp1d, and how long to wait for a telegram.
+	serial        string
+	serialTimeout time.Duration
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Parses a duration in seconds, like munin's own timeouts, or with a
+// unit.
+func parseTimeout(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid duration: %s", s))
+	}
+	return d, nil
+}
+
+func loadConfig() (config, error) {
+	cfg := config{
+		url:      getenv("url", "http://localhost:1121"),
+		prefix:   getenv("prefix", "p1"),
+		category: getenv("category", "P1"),
+	}
+	if os.Getenv("url") == "" {
+		cfg.serial = os.Getenv("serial")
+	}
+	var err error
+	if cfg.timeout, err = parseTimeout(getenv("timeout", "10")); err != nil {
+		return cfg, errors.New(fmt.Sprintf("env.timeout: %v", err))
+	}
+	// DSMR 4 meters send a telegram every ten seconds, and we may just
+	// have missed the start of one.
+	if cfg.serialTimeout, err = parseTimeout(getenv("timeout", "30")); err != nil {
+		return cfg, errors.New(fmt.Sprintf("env.timeout: %v", err))
+	}
+	if cfg.maxAge, err = parseTimeout(getenv("max_age", "60")); err != nil {
+		return cfg, errors.New(fmt.Sprintf("env.max_age: %v", err))
+	}
+	return cfg, nil
+}
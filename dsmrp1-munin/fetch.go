@@ -0,0 +1,156 @@
+package main
+
+// Fetching telegrams from dsmrp1d.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time to wait before retrying a failed request, eg. while dsmrp1d
+// restarts.
+const retryDelay = 2 * time.Second
+
+// Fetches path below the URL of dsmrp1d and decodes the JSON into v.
+// Retries once if the request fails.  Returns the response headers.
+func fetch(cfg config, path string, v interface{}) (http.Header, error) {
+	header, err := fetchOnce(cfg, path, v)
+	if err != nil && err != errNotFound {
+		time.Sleep(retryDelay)
+		header, err = fetchOnce(cfg, path, v)
+	}
+	return header, err
+}
+
+var errNotFound = errors.New("dsmrp1d returned 404 Not Found")
+
+func fetchOnce(cfg config, path string, v interface{}) (http.Header, error) {
+	client := http.Client{Timeout: cfg.timeout}
+	url := strings.TrimSuffix(cfg.url, "/") + path
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"could not connect to dsmrp1d at %s: %v", cfg.url, err))
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to read response: %v", err))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("dsmrp1d returned %s: %s",
+			resp.Status, strings.TrimSpace(string(body))))
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return nil, errors.New(fmt.Sprintf("failed to parse %s: %v", path, err))
+	}
+	return resp.Header, nil
+}
+
+// Fetches the latest telegram of the meter, or of the first meter if
+// none is given.  Returns an error if it is older than cfg.maxAge.  If
+// configured, reads the telegram from the meter instead.
+func fetchTelegram(cfg config, meter string) (*dsmrp1.Telegram, error) {
+	if cfg.serial != "" {
+		return readTelegram(cfg)
+	}
+	var telegram *dsmrp1.Telegram
+	path := "/"
+	if meter != "" {
+		path = "/api/v1/meters/" + meter + "/telegram"
+	}
+	header, err := fetch(cfg, path, &telegram)
+	if err != nil {
+		return nil, err
+	}
+	if telegram == nil {
+		return nil, errors.New("no data, yet")
+	}
+	age, err := strconv.ParseFloat(header.Get("X-Age-Seconds"), 64)
+	if err == nil && cfg.maxAge != 0 &&
+		time.Duration(age*float64(time.Second)) > cfg.maxAge {
+		return nil, errors.New(fmt.Sprintf(
+			"telegram is stale: %.0fs old", age))
+	}
+	return telegram, nil
+}
+
+// Returns the file in which the last telegram of the meter is kept, or
+// "" if munin-node does not provide a directory for it.
+func lastTelegramPath(meter string) string {
+	dir := os.Getenv("MUNIN_PLUGSTATE")
+	if dir == "" {
+		return ""
+	}
+	name := "dsmrp1"
+	if meter != "" {
+		name += "_" + meter
+	}
+	return filepath.Join(dir, name+".json")
+}
+
+func saveLastTelegram(meter string, t *dsmrp1.Telegram) error {
+	path := lastTelegramPath(meter)
+	if path == "" {
+		return nil
+	}
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+func loadLastTelegram(meter string) (*dsmrp1.Telegram, error) {
+	path := lastTelegramPath(meter)
+	if path == "" {
+		return nil, errors.New("MUNIN_PLUGSTATE is not set")
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t dsmrp1.Telegram
+	if err = json.Unmarshal(buf, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Returns the graphs of the latest telegram.  If there is no recent
+// telegram, returns the graphs of the last one seen, with unknown
+// values, so that a restart of dsmrp1d leaves a gap in the graphs
+// instead of a spike.
+func currentGraphs(cfg config, meter string) ([]graph, error) {
+	t, err := fetchTelegram(cfg, meter)
+	if err == nil {
+		if err2 := saveLastTelegram(meter, t); err2 != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save telegram: %v\n", err2)
+		}
+		return graphs(t), nil
+	}
+	last, err2 := loadLastTelegram(meter)
+	if err2 != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "%v; reporting unknown values\n", err)
+	gs := graphs(last)
+	for i := range gs {
+		for j := range gs[i].fields {
+			gs[i].fields[j].value = "U"
+		}
+	}
+	return gs, nil
+}
@@ -0,0 +1,326 @@
+package main
+
+// The graphs and their values.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"strconv"
+)
+
+type graph struct {
+	name   string // of the multigraph, after the configured prefix
+	title  string
+	vlabel string
+	period string // graph_period, if not second
+	args   string // graph_args
+	fields []field
+}
+
+type field struct {
+	name     string
+	label    string
+	typ      string // GAUGE if empty
+	draw     string // LINE1 if empty
+	min      string
+	negative string // field drawn below the axis with this one
+	hidden   bool   // only drawn as the negative of another field
+	value    string // U if unknown
+}
+
+// Formats a GAUGE value.  The meter's values are float32s, so print no
+// more digits than those have.
+func gauge(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 32)
+}
+
+// Formats an energy register in kWh as a DERIVE value in joules, which
+// munin turns into Watts.
+func joules(kWh float64) string {
+	return strconv.FormatInt(int64(kWh*1000*60*60), 10)
+}
+
+// Formats an optional GAUGE value.
+func optionalGauge(v *float32) string {
+	if v == nil {
+		return "U"
+	}
+	return gauge(float64(*v))
+}
+
+// Returns the graphs for the telegram.  Which graphs there are depends
+// on the meter, eg. the phase graphs are only there for meters with
+// three phases.
+func graphs(t *dsmrp1.Telegram) []graph {
+	var ret []graph
+	if e := t.Electricity; e != nil {
+		kWh := e.ImportTotal() - e.ExportTotal()
+		ret = append(ret, graph{
+			name:   "kWh",
+			title:  "Electricity usage",
+			vlabel: "Watt",
+			fields: []field{{
+				name:  "kWh",
+				label: "Watt",
+				typ:   "DERIVE",
+				value: strconv.FormatInt(int64(kWh*1000*60*60), 10),
+			}},
+		})
+	}
+	if t.Gas != nil {
+		dm3 := t.Gas.LastRecord.Value
+		ret = append(ret, graph{
+			name:   "dm3",
+			title:  "gas usage",
+			vlabel: "dm3/h",
+			period: "hour",
+			fields: []field{{
+				name:  "dm3",
+				label: "dm3/h",
+				typ:   "DERIVE",
+				value: strconv.FormatInt(int64(dm3*1000), 10),
+			}},
+		}, graph{
+			name:   "gas_reading",
+			title:  "Gas meter reading",
+			vlabel: "m3",
+			// Zoom in on the reading instead of starting at zero.
+			args: "--alt-autoscale --units-exponent 0",
+			fields: []field{{
+				name:  "m3",
+				label: "m3",
+				value: gauge(float64(dm3)),
+			}},
+		})
+	}
+	ret = append(ret, energyGraphs(t)...)
+	ret = append(ret, phaseGraphs(t)...)
+	ret = append(ret, gridGraphs(t)...)
+	return ret
+}
+
+// Returns graphs of the energy registers, split into imported and
+// exported energy and by tariff, instead of netting it all, which hides
+// what a household with solar panels uses.  Also returns a graph of the
+// power the meter measures itself, which is more accurate over short
+// intervals than the power derived from the registers.
+func energyGraphs(t *dsmrp1.Telegram) []graph {
+	e := t.Electricity
+	if e == nil {
+		return nil
+	}
+	return []graph{{
+		name:   "import_export",
+		title:  "Electricity imported and exported",
+		vlabel: "Watt in (+) / out (-)",
+		fields: []field{{
+			name:   "export",
+			label:  "exported",
+			typ:    "DERIVE",
+			min:    "0",
+			hidden: true,
+			value:  joules(e.ExportTotal()),
+		}, {
+			name:     "import",
+			label:    "Watt",
+			typ:      "DERIVE",
+			min:      "0",
+			negative: "export",
+			value:    joules(e.ImportTotal()),
+		}},
+	}, {
+		name:   "power",
+		title:  "Electricity power as measured by the meter",
+		vlabel: "Watt in (+) / out (-)",
+		fields: []field{{
+			name:   "wout",
+			label:  "exported",
+			hidden: true,
+			value:  gauge(float64(e.WOut)),
+		}, {
+			name:     "w",
+			label:    "Watt",
+			negative: "wout",
+			value:    gauge(float64(e.W)),
+		}},
+	}, {
+		name:   "tariffs",
+		title:  "Electricity usage by tariff",
+		vlabel: "Watt in (+) / out (-)",
+		fields: []field{{
+			name:   "export_low",
+			label:  "exported, low tariff",
+			typ:    "DERIVE",
+			min:    "0",
+			hidden: true,
+			value:  joules(float64(e.KWhOutLow)),
+		}, {
+			name:   "export_high",
+			label:  "exported, high tariff",
+			typ:    "DERIVE",
+			min:    "0",
+			hidden: true,
+			value:  joules(float64(e.KWhOut)),
+		}, {
+			name:     "import_low",
+			label:    "low tariff",
+			typ:      "DERIVE",
+			draw:     "AREA",
+			min:      "0",
+			negative: "export_low",
+			value:    joules(float64(e.KWhLow)),
+		}, {
+			name:     "import_high",
+			label:    "high tariff",
+			typ:      "DERIVE",
+			draw:     "STACK",
+			min:      "0",
+			negative: "export_high",
+			value:    joules(float64(e.KWh)),
+		}},
+	}}
+}
+
+// Returns graphs of the power, voltage and current per phase, which
+// show imbalance between the phases.
+func phaseGraphs(t *dsmrp1.Telegram) []graph {
+	e, me := t.Electricity, t.MultiphaseElectricity
+	if e == nil || me == nil {
+		return nil
+	}
+	phases := []struct {
+		power, powerOut, current float32
+		voltage                  *float32
+	}{
+		{e.L1Power, e.L1PowerOut, e.L1Current, e.L1Voltage},
+		{me.L2Power, me.L2PowerOut, me.L2Current, me.L2Voltage},
+		{me.L3Power, me.L3PowerOut, me.L3Current, me.L3Voltage},
+	}
+	power := graph{
+		name:   "phase_power",
+		title:  "Electricity usage per phase",
+		vlabel: "Watt",
+	}
+	voltage := graph{
+		name:   "phase_voltage",
+		title:  "Voltage per phase",
+		vlabel: "Volt",
+	}
+	current := graph{
+		name:   "phase_current",
+		title:  "Current per phase",
+		vlabel: "Ampere",
+	}
+	for i, p := range phases {
+		name := fmt.Sprintf("l%d", i+1)
+		label := fmt.Sprintf("L%d", i+1)
+		power.fields = append(power.fields, field{
+			name:  name,
+			label: label,
+			value: gauge(float64(p.power) - float64(p.powerOut)),
+		})
+		voltage.fields = append(voltage.fields, field{
+			name:  name,
+			label: label,
+			value: optionalGauge(p.voltage),
+		})
+		current.fields = append(current.fields, field{
+			name:  name,
+			label: label,
+			value: gauge(float64(p.current)),
+		})
+	}
+	return []graph{power, voltage, current}
+}
+
+// Returns a graph of the power failures and the voltage sags and
+// swells, which show problems with the grid.
+func gridGraphs(t *dsmrp1.Telegram) []graph {
+	e := t.Electricity
+	if e == nil {
+		return nil
+	}
+	counter := func(name, label string, n int32) field {
+		return field{
+			name:  name,
+			label: label,
+			typ:   "DERIVE",
+			min:   "0",
+			value: strconv.FormatInt(int64(n), 10),
+		}
+	}
+	g := graph{
+		name:   "grid_quality",
+		title:  "Power failures, voltage sags and swells",
+		vlabel: "events per ${graph_period}",
+		period: "hour",
+		fields: []field{
+			counter("power_failures", "power failures", e.PowerFailures),
+			counter("long_power_failures", "long power failures",
+				e.LongPowerFailures),
+			counter("l1_sags", "L1 sags", e.L1VoltageSags),
+			counter("l1_swells", "L1 swells", e.L1VoltageSwells),
+		},
+	}
+	if me := t.MultiphaseElectricity; me != nil {
+		g.fields = append(g.fields,
+			counter("l2_sags", "L2 sags", me.L2VoltageSags),
+			counter("l2_swells", "L2 swells", me.L2VoltageSwells),
+			counter("l3_sags", "L3 sags", me.L3VoltageSags),
+			counter("l3_swells", "L3 swells", me.L3VoltageSwells))
+	}
+	return []graph{g}
+}
+
+// Prints the configuration of the graphs, and their values too if
+// withValues is set, as munin's dirtyconfig allows.
+func printConfig(cfg config, gs []graph, withValues bool) {
+	for i, g := range gs {
+		if i > 0 {
+			fmt.Println("")
+		}
+		fmt.Printf("multigraph %s_%s\n", cfg.prefix, g.name)
+		fmt.Printf("graph_title %s\n", g.title)
+		fmt.Printf("graph_vlabel %s\n", g.vlabel)
+		if g.period != "" {
+			fmt.Printf("graph_period %s\n", g.period)
+		}
+		if g.args != "" {
+			fmt.Printf("graph_args %s\n", g.args)
+		}
+		fmt.Printf("graph_category %s\n", cfg.category)
+		for _, f := range g.fields {
+			fmt.Printf("%s.label %s\n", f.name, f.label)
+			if f.typ != "" {
+				fmt.Printf("%s.type %s\n", f.name, f.typ)
+			}
+			if f.draw != "" {
+				fmt.Printf("%s.draw %s\n", f.name, f.draw)
+			}
+			if f.min != "" {
+				fmt.Printf("%s.min %s\n", f.name, f.min)
+			}
+			if f.negative != "" {
+				fmt.Printf("%s.negative %s\n", f.name, f.negative)
+			}
+			if f.hidden {
+				fmt.Printf("%s.graph no\n", f.name)
+			}
+			if withValues {
+				fmt.Printf("%s.value %s\n", f.name, f.value)
+			}
+		}
+	}
+}
+
+func printValues(cfg config, gs []graph) {
+	for i, g := range gs {
+		if i > 0 {
+			fmt.Println("")
+		}
+		fmt.Printf("multigraph %s_%s\n", cfg.prefix, g.name)
+		for _, f := range g.fields {
+			fmt.Printf("%s.value %s\n", f.name, f.value)
+		}
+	}
+}
@@ -1,74 +1,99 @@
 package main
 
 // Munin plugin for electricity and gas data provided by the dsmrp1d daemon.
-
-// TODO allow configuration of dsmrp1d host
+//
+// Link it as dsmrp1 for the first meter of dsmrp1d, or as dsmrp1_<meter>
+// for each of several meters; munin-node-configure suggests the links.
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/bwesterb/go-dsmrp1"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// The magic markers at the end are read by munin-node-configure from
+// the binary, so this must be used somewhere.
+const usage = `usage: dsmrp1[_meter] [config|autoconf|suggest]
+
+#%# family=auto
+#%# capabilities=autoconf suggest
+`
+
+// Returns the meter of a plugin linked as dsmrp1_<meter>, if any.
+func meterFromName(name string) string {
+	name = filepath.Base(name)
+	if i := strings.IndexByte(name, '_'); i != -1 {
+		return name[i+1:]
+	}
+	return ""
+}
+
 func main() {
-	var url string = "http://localhost:1121"
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	meter := meterFromName(os.Args[0])
+	if meter != "" && os.Getenv("prefix") == "" {
+		cfg.prefix += "_" + meter
+	}
+
 	if len(os.Args) == 1 {
-		var telegram *dsmrp1.Telegram
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Fatalf("Could not connect to dsmrp1d at %s", url)
-		}
-		body, err := ioutil.ReadAll(resp.Body)
+		gs, err := currentGraphs(cfg, meter)
 		if err != nil {
-			log.Fatalf("Failed to read response: %v", err)
+			log.Fatal(err)
 		}
-		err = json.Unmarshal(body, &telegram)
+		printValues(cfg, gs)
+		return
+	}
+
+	switch os.Args[1] {
+	case "config":
+		// Which graphs there are depends on the meter.
+		gs, err := currentGraphs(cfg, meter)
 		if err != nil {
-			log.Fatalf("Failed to parse telegram %v", err)
+			log.Fatal(err)
 		}
-		if telegram == nil {
-			log.Fatal("No data, yet")
+		if meter != "" {
+			for i := range gs {
+				gs[i].title += " (" + meter + ")"
+			}
 		}
-
-		e := telegram.Electricity
-
-		kWh := e.KWh + e.KWhLow - e.KWhOut - e.KWhOutLow
-		dm3 := telegram.Gas.LastRecord.Value
-
-		fmt.Println("multigraph p1_kWh")
-		fmt.Printf("kWh.value %d\n", int64(kWh*1000*60*60))
-		fmt.Println("")
-		fmt.Println("multigraph p1_dm3")
-		fmt.Printf("dm3.value %d\n", int64(dm3*1000))
+		// With dirtyconfig, munin-node takes the values from the config
+		// and does not run us again to fetch them.
+		printConfig(cfg, gs, os.Getenv("MUNIN_CAP_DIRTYCONFIG") == "1")
 		return
-	}
 
-	if os.Args[1] == "config" {
-		fmt.Println("multigraph p1_kWh")
-		fmt.Println("graph_title Electricity usage")
-		fmt.Println("graph_vlabel Watt")
-		fmt.Println("graph_category P1")
-		fmt.Println("kWh.label Watt")
-		fmt.Println("kWh.type DERIVE")
-		fmt.Println("")
-		fmt.Println("multigraph p1_dm3")
-		fmt.Println("graph_title gas usage")
-		fmt.Println("graph_vlabel dm3/h")
-		fmt.Println("graph_period hour")
-		fmt.Println("graph_category P1")
-		fmt.Println("dm3.label dm3/h")
-		fmt.Println("dm3.type DERIVE")
+	case "autoconf":
+		if _, err := fetchTelegram(cfg, meter); err != nil {
+			fmt.Printf("no (%v)\n", err)
+			return
+		}
+		fmt.Println("yes")
 		return
-	}
 
-	if os.Args[1] == "autoconf" {
-		fmt.Println("yes")
+	case "suggest":
+		if cfg.serial != "" {
+			return
+		}
+		var meters []struct {
+			Name string `json:"name"`
+		}
+		if _, err := fetch(cfg, "/api/v1/meters", &meters); err != nil {
+			log.Fatal(err)
+		}
+		if len(meters) < 2 {
+			// The plain plugin covers a single meter.
+			return
+		}
+		for _, m := range meters {
+			fmt.Println(m.Name)
+		}
 		return
 	}
 
+	fmt.Fprint(os.Stderr, usage)
 	os.Exit(-1)
 }
@@ -0,0 +1,73 @@
+package main
+
+// Reads a telegram directly from the meter, for installations without
+// dsmrp1d.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A lock file older than this was left behind by a plugin that crashed.
+const staleLock = 5 * time.Minute
+
+// Takes the lock file, waiting for at most timeout, so that munin
+// running several plugins at once does not open the serial port more
+// than once.  Returns a function that releases the lock.
+func lock(path string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(path); err == nil &&
+			time.Since(fi.ModTime()) > staleLock {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New(fmt.Sprintf("%s is locked", path))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Returns the lock file for the serial port.
+func lockPath(cfg config) string {
+	dir := os.Getenv("MUNIN_PLUGSTATE")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "dsmrp1-"+filepath.Base(cfg.serial)+".lock")
+}
+
+// Reads one telegram from the meter at cfg.serial.
+func readTelegram(cfg config) (*dsmrp1.Telegram, error) {
+	unlock, err := lock(lockPath(cfg), cfg.serialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	m, err := dsmrp1.OpenMeter(cfg.serial)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case t := <-m.C:
+		return t, nil
+	case <-time.After(cfg.serialTimeout):
+		return nil, errors.New(fmt.Sprintf(
+			"no telegram from %s within %v", cfg.serial, cfg.serialTimeout))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+// Prometheus exporter that reads the meter directly, for those who only
+// want the metrics of dsmrp1d.
+
+import (
+	"flag"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func main() {
+	var source string
+	var listen string
+	var stale time.Duration
+
+	flag.StringVar(&source, "meter", "/dev/P1",
+		"meter to read from: a serial port, serial:/dev/... or tcp:host:port")
+	flag.StringVar(&listen, "listen", ":9121",
+		"address to serve the metrics on")
+	flag.DurationVar(&stale, "stale", time.Minute,
+		"stop reporting readings when the latest telegram is older; "+
+			"0 to disable")
+
+	flag.Parse()
+
+	m, err := dsmrp1.OpenMeter(source)
+	if err != nil {
+		log.Fatalf("OpenMeter(%s): %v", source, err)
+	}
+
+	// The meter is not named, so the metrics have no meter label.
+	p := sinks.NewPrometheus()
+	p.Start("")
+	var lock sync.Mutex
+	var received time.Time
+	go func() {
+		for t := range m.C {
+			lock.Lock()
+			received = time.Now()
+			lock.Unlock()
+			p.HandleTelegram(t, received)
+		}
+	}()
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		at := received
+		lock.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeStats(w, m.Stats())
+		// Rather a gap in the graphs than a flat line of old readings.
+		if !at.IsZero() && (stale == 0 || time.Since(at) <= stale) {
+			sinks.WritePrometheusMetrics(w, p)
+		}
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("dsmrp1-prom: metrics are at /metrics\n"))
+	})
+
+	log.Printf("Serving metrics of %s on %s", source, listen)
+	log.Fatal(http.ListenAndServe(listen, nil))
+}
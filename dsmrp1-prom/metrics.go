@@ -0,0 +1,32 @@
+package main
+
+// The metrics of the connection to the meter.  Those of the readings are
+// written by sinks.Prometheus, under the same names as the /metrics
+// endpoint of dsmrp1d.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io"
+)
+
+func writeStats(w io.Writer, stats dsmrp1.MeterStats) {
+	telegrams := &sinks.MetricFamily{Name: "dsmrp1_telegrams_total",
+		Type: "counter", Help: "Number of valid telegrams received."}
+	invalid := &sinks.MetricFamily{Name: "dsmrp1_invalid_telegrams_total",
+		Type: "counter", Help: "Number of telegrams that failed to parse."}
+	crcErrors := &sinks.MetricFamily{Name: "dsmrp1_crc_errors_total",
+		Type: "counter", Help: "Number of telegrams with a wrong checksum."}
+	reconnects := &sinks.MetricFamily{Name: "dsmrp1_reconnects_total",
+		Type: "counter", Help: "Number of times the meter was reconnected."}
+
+	telegrams.Add("", float64(stats.Telegrams))
+	invalid.Add("", float64(stats.Invalid))
+	crcErrors.Add("", float64(stats.CRCErrors))
+	reconnects.Add("", float64(stats.Reconnects))
+
+	for _, f := range []*sinks.MetricFamily{telegrams, invalid, crcErrors,
+		reconnects} {
+		f.Write(w)
+	}
+}
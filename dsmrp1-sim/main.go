@@ -0,0 +1,100 @@
+package main
+
+// Simulates a P1 smart meter, for developing and testing without one.
+// The telegrams describe a household with a configurable profile and
+// are written to stdout, served over TCP or on a pseudo terminal.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+func parseProfile(s string) (profile, error) {
+	var p profile
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "solar":
+			p.solar = true
+		case "3phase", "3-phase":
+			p.threePhase = true
+		case "gas":
+			p.gas = true
+		case "belgian":
+			p.belgian = true
+		default:
+			return p, errors.New(fmt.Sprintf("unknown profile: %s", name))
+		}
+	}
+	return p, nil
+}
+
+func main() {
+	var version int
+	var profileSpec string
+	var interval time.Duration
+	var speed float64
+	var out string
+	var seed int64
+
+	flag.IntVar(&version, "dsmr", 5, "DSMR version of the meter: 4 or 5")
+	flag.StringVar(&profileSpec, "profile", "gas",
+		"comma separated features of the household: "+
+			"solar, 3phase, gas and belgian")
+	flag.DurationVar(&interval, "interval", 0,
+		"time between telegrams; by default 1s for DSMR 5 and 10s for DSMR 4")
+	flag.Float64Var(&speed, "speed", 1,
+		"speed of the simulated clock, eg. 60 for an hour per minute")
+	flag.StringVar(&out, "out", "-",
+		"where to send the telegrams: - for stdout, tcp:host:port or pty")
+	flag.Int64Var(&seed, "seed", 0,
+		"seed of the simulation; by default the current time")
+
+	flag.Parse()
+
+	if version != 4 && version != 5 {
+		log.Fatalf("-dsmr: expected 4 or 5, not %d", version)
+	}
+	p, err := parseProfile(profileSpec)
+	if err != nil {
+		log.Fatalf("-profile: %v", err)
+	}
+	gasEvery := 5 * time.Minute
+	if version == 4 {
+		gasEvery = time.Hour
+	}
+	if interval == 0 {
+		interval = time.Second
+		if version == 4 {
+			interval = 10 * time.Second
+		}
+	}
+	if speed <= 0 {
+		log.Fatalf("-speed: must be positive")
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	o, err := openOutput(out)
+	if err != nil {
+		log.Fatalf("-out: %v", err)
+	}
+
+	h := newHousehold(p, seed)
+	start := time.Now()
+	step := time.Duration(float64(interval) * speed)
+	at := start
+	h.step(at, step)
+	h.recordGas(at, gasEvery)
+	for range time.Tick(interval) {
+		at = at.Add(step)
+		h.step(at, step)
+		h.recordGas(at, gasEvery)
+		o.send(h.telegram(at.Truncate(time.Second), version))
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+// A simple model of a household: a base load with a fridge, appliances
+// switched on at random, solar panels following the sun behind passing
+// clouds, and gas for heating and hot water.
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// What the simulated meter has.
+type profile struct {
+	solar      bool
+	threePhase bool
+	gas        bool
+	belgian    bool
+}
+
+// An appliance that is switched on now and then.
+type appliance struct {
+	name     string
+	w        float64
+	duration time.Duration
+	perDay   float64 // how often it is switched on
+}
+
+var appliances = []appliance{
+	{"kettle", 2000, 3 * time.Minute, 4},
+	{"microwave", 1100, 4 * time.Minute, 2},
+	{"oven", 2200, 45 * time.Minute, 0.5},
+	{"washing machine", 500, 90 * time.Minute, 0.5},
+	{"dishwasher", 1800, 60 * time.Minute, 0.7},
+}
+
+// Peak power of the solar panels.
+const solarPeakW = 3500
+
+type household struct {
+	profile profile
+	rng     *rand.Rand
+
+	// Registers, indexed by tariff minus one.
+	kWh    [2]float64
+	kWhOut [2]float64
+	gasM3  float64
+
+	// Reading and time of the last gas record.
+	gasRecordAt time.Time
+	gasRecord   float64
+
+	powerFailures     int
+	longPowerFailures int
+	sags, swells      [3]int
+
+	running []runningAppliance
+	clouds  float64 // fraction of the solar power that gets through
+
+	// Of the last step.
+	tariff      int
+	load, solar [3]float64 // W per phase
+	voltage     [3]float64
+	peakW, avgW float64 // Belgian capacity tariff
+	peakAt      time.Time
+	avgStart    time.Time
+	avgWh       float64
+}
+
+type runningAppliance struct {
+	appliance
+	phase int
+	until time.Time
+}
+
+func newHousehold(p profile, seed int64) *household {
+	rng := rand.New(rand.NewSource(seed))
+	return &household{
+		profile: p,
+		rng:     rng,
+		kWh:     [2]float64{3000 + rng.Float64()*10000, 3000 + rng.Float64()*10000},
+		gasM3:   1000 + rng.Float64()*5000,
+		clouds:  1,
+	}
+}
+
+// Returns the tariff at the given time: low (1) at night and in the
+// weekend, high (2) otherwise.
+func tariffAt(at time.Time) int {
+	if at.Weekday() == time.Saturday || at.Weekday() == time.Sunday ||
+		at.Hour() >= 23 || at.Hour() < 7 {
+		return 1
+	}
+	return 2
+}
+
+// Advances the household by dt to the given time.
+func (h *household) step(at time.Time, dt time.Duration) {
+	hours := dt.Hours()
+	hour := float64(at.Hour()) + float64(at.Minute())/60
+
+	// Base load: more in the evening, with a fridge switching on and
+	// off every hour.
+	base := 120 + 60*math.Max(0, math.Sin(math.Pi*(hour-16)/8)) +
+		h.rng.NormFloat64()*5
+	if at.Minute() < 20 {
+		base += 80
+	}
+	var load [3]float64
+	if h.profile.threePhase {
+		for i := range load {
+			load[i] = base / 3
+		}
+	} else {
+		load[0] = base
+	}
+
+	// Appliances, only while people are awake.
+	var running []runningAppliance
+	for _, ra := range h.running {
+		if at.Before(ra.until) {
+			running = append(running, ra)
+		}
+	}
+	if hour >= 7 && hour < 23 {
+		for _, a := range appliances {
+			// Spread the uses over the sixteen waking hours.
+			if h.rng.Float64() < a.perDay*hours/16 {
+				phase := 0
+				if h.profile.threePhase {
+					phase = h.rng.Intn(3)
+				}
+				running = append(running, runningAppliance{
+					a, phase, at.Add(a.duration)})
+			}
+		}
+	}
+	h.running = running
+	for _, ra := range running {
+		load[ra.phase] += ra.w
+	}
+
+	// Solar panels on L1, behind clouds that come and go.
+	var solar [3]float64
+	if h.profile.solar {
+		h.clouds += h.rng.NormFloat64() * 0.05 * math.Sqrt(hours*60)
+		h.clouds = math.Max(0.2, math.Min(1, h.clouds))
+		sun := math.Max(0, math.Sin(math.Pi*(hour-6)/14))
+		solar[0] = solarPeakW * sun * h.clouds
+	}
+
+	// Registers.
+	h.tariff = tariffAt(at)
+	var imported, exported float64
+	for i := range load {
+		net := load[i] - solar[i]
+		if net > 0 {
+			imported += net
+		} else {
+			exported -= net
+		}
+	}
+	h.kWh[h.tariff-1] += imported * hours / 1000
+	h.kWhOut[h.tariff-1] += exported * hours / 1000
+	h.load, h.solar = load, solar
+
+	// Voltage drops with the load and rises with the solar power.
+	for i := range h.voltage {
+		h.voltage[i] = 230 + h.rng.NormFloat64() -
+			(load[i]-solar[i])*0.002
+	}
+
+	// Now and then the grid misbehaves.
+	if h.rng.Float64() < hours/(24*30) {
+		h.sags[h.rng.Intn(3)]++
+	}
+	if h.rng.Float64() < hours/(24*60) {
+		h.swells[h.rng.Intn(3)]++
+	}
+	if h.rng.Float64() < hours/(24*365) {
+		h.powerFailures++
+	}
+
+	// Gas: heating in the morning and evening, and showers.
+	if h.profile.gas {
+		rate := 0.05
+		if (hour >= 6 && hour < 9) || (hour >= 17 && hour < 23) {
+			rate = 0.4
+		}
+		if hour >= 7 && hour < 8 && h.rng.Float64() < 0.3 {
+			rate += 1.5
+		}
+		h.gasM3 += rate * hours
+	}
+
+	// The Belgian capacity tariff: average demand per quarter hour and
+	// its peak this month.
+	quarter := at.Truncate(15 * time.Minute)
+	if !quarter.Equal(h.avgStart) {
+		h.avgStart, h.avgWh = quarter, 0
+	}
+	h.avgWh += imported * hours
+	h.avgW = h.avgWh / math.Max(at.Sub(h.avgStart).Hours(), hours)
+	if h.avgW > h.peakW || at.Month() != h.peakAt.Month() {
+		h.peakW, h.peakAt = h.avgW, at
+	}
+}
+
+// Updates the gas record, which the meter only does every five minutes
+// (DSMR 5) or hour (DSMR 4).
+func (h *household) recordGas(at time.Time, every time.Duration) {
+	recordAt := at.Truncate(every)
+	if recordAt.After(h.gasRecordAt) {
+		h.gasRecordAt, h.gasRecord = recordAt, h.gasM3
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+// Where the telegrams go: stdout, the clients of a TCP port or a pseudo
+// terminal.  A meter does not wait for anyone, so telegrams that cannot
+// be written right away are dropped.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+type output interface {
+	send(telegram []byte)
+}
+
+// Writes telegrams to w from a goroutine, dropping those that arrive
+// while it is still busy with the previous one.
+type droppingWriter struct {
+	c chan []byte
+}
+
+func newDroppingWriter(w io.Writer, onError func(error)) *droppingWriter {
+	d := &droppingWriter{c: make(chan []byte, 1)}
+	go func() {
+		for telegram := range d.c {
+			if _, err := w.Write(telegram); err != nil {
+				onError(err)
+				return
+			}
+		}
+	}()
+	return d
+}
+
+func (d *droppingWriter) send(telegram []byte) {
+	select {
+	case d.c <- telegram:
+	default:
+	}
+}
+
+func (d *droppingWriter) close() {
+	close(d.c)
+}
+
+// Sends the telegrams to every client connected to a TCP port, like
+// ser2net or a WiFi P1 dongle.
+type tcpOutput struct {
+	lock    sync.Mutex
+	clients map[net.Conn]*droppingWriter
+}
+
+func listenTCP(addr string) (*tcpOutput, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	o := &tcpOutput{clients: make(map[net.Conn]*droppingWriter)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("Accept: %v", err)
+				continue
+			}
+			o.lock.Lock()
+			o.clients[conn] = newDroppingWriter(conn, func(error) {
+				o.remove(conn)
+			})
+			o.lock.Unlock()
+		}
+	}()
+	return o, nil
+}
+
+func (o *tcpOutput) remove(conn net.Conn) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if d, ok := o.clients[conn]; ok {
+		d.close()
+		conn.Close()
+		delete(o.clients, conn)
+	}
+}
+
+func (o *tcpOutput) send(telegram []byte) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for _, d := range o.clients {
+		d.send(telegram)
+	}
+}
+
+// Opens the output described by spec: "-" for stdout, "tcp:host:port"
+// or "pty".
+func openOutput(spec string) (output, error) {
+	switch {
+	case spec == "-":
+		return newDroppingWriter(os.Stdout, func(err error) {
+			log.Fatalf("Failed to write to stdout: %v", err)
+		}), nil
+	case strings.HasPrefix(spec, "tcp:"):
+		return listenTCP(strings.TrimPrefix(spec, "tcp:"))
+	case spec == "pty":
+		master, path, err := openPty()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Serving telegrams on %s", path)
+		return newDroppingWriter(master, func(err error) {
+			log.Fatalf("Failed to write to %s: %v", path, err)
+		}), nil
+	}
+	return nil, errors.New(fmt.Sprintf(
+		"expected -, tcp:host:port or pty: %s", spec))
+}
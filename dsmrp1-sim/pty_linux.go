@@ -0,0 +1,71 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Opens a pseudo terminal.  Returns its master, to write the telegrams
+// to, and the path of its slave, to read them from as if it were the
+// serial port of a meter.
+func openPty() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var unlock int32
+	if err = ioctl(master.Fd(), syscall.TIOCSPTLCK,
+		uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var n uint32
+	if err = ioctl(master.Fd(), syscall.TIOCGPTN,
+		uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	path := "/dev/pts/" + strconv.Itoa(int(n))
+
+	// Put the slave in raw mode, so that it passes the telegrams on as
+	// they are and does not echo them back.
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	defer slave.Close()
+	var t syscall.Termios
+	if err = ioctl(slave.Fd(), syscall.TCGETS,
+		uintptr(unsafe.Pointer(&t))); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK |
+		syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL |
+		syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON |
+		syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	if err = ioctl(slave.Fd(), syscall.TCSETS,
+		uintptr(unsafe.Pointer(&t))); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	return master, path, nil
+}
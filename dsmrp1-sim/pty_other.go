@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func openPty() (*os.File, string, error) {
+	return nil, "", errors.New("pseudo terminals are only supported on Linux")
+}
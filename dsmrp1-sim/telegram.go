@@ -0,0 +1,70 @@
+package main
+
+// Formats the state of the household as a P1 telegram.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"math"
+	"time"
+)
+
+// Serial numbers of the simulated meters.
+const (
+	electricityID = "E0044007198568717"
+	gasID         = "G0039001765056517"
+)
+
+// Returns the telegram of the household at the given time, as sent by a
+// meter of the given DSMR version (4 or 5).
+func (h *household) telegram(at time.Time, version int) []byte {
+	v := dsmrp1.DSMR5
+	if h.profile.belgian {
+		v = dsmrp1.EMUCS
+	} else if version == 4 {
+		v = dsmrp1.DSMR4
+	}
+	b := dsmrp1.NewTelegramBuilder(v)
+	if h.profile.belgian {
+		// Belgian meters are based on DSMR 5 and so is the simulated
+		// one, so that the parser knows the telegrams.
+		b.Line(obis.Version, "50")
+	}
+	b.Timestamp(at).
+		EquipmentID(electricityID).
+		Import(1, h.kWh[0]).
+		Import(2, h.kWh[1]).
+		Export(1, h.kWhOut[0]).
+		Export(2, h.kWhOut[1]).
+		Tariff(dsmrp1.Tariff(h.tariff))
+	if h.profile.belgian {
+		b.Demand(math.Max(0, h.avgW), h.peakAt, math.Max(0, h.peakW))
+	}
+
+	var net [3]float64
+	var total float64
+	for i := range net {
+		net[i] = h.load[i] - h.solar[i]
+		total += net[i]
+	}
+	b.Power(math.Max(0, total), math.Max(0, -total)).
+		PowerFailures(h.powerFailures, h.longPowerFailures)
+
+	phases := 1
+	if h.profile.threePhase {
+		phases = 3
+	}
+	for i := 0; i < phases; i++ {
+		b.VoltageEvents(i+1, h.sags[i], h.swells[i])
+	}
+	b.Message("")
+	for i := 0; i < phases; i++ {
+		b.Phase(i+1, h.voltage[i], math.Abs(net[i])/h.voltage[i],
+			math.Max(0, net[i]), math.Max(0, -net[i]))
+	}
+
+	if h.profile.gas {
+		b.Gas(1, gasID, h.gasRecordAt, h.gasRecord)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,49 @@
+package main
+
+// Hands the telegrams to all connected clients.
+
+import (
+	"sync"
+)
+
+type hub struct {
+	lock    sync.Mutex
+	latest  []byte
+	clients map[chan []byte]bool
+}
+
+func newHub() *hub {
+	return &hub{clients: make(map[chan []byte]bool)}
+}
+
+// Returns a channel with the latest telegram, if any, followed by the
+// ones to come.  A client that falls behind misses telegrams rather than
+// holding up the others.
+func (h *hub) subscribe() chan []byte {
+	c := make(chan []byte, 1)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.latest != nil {
+		c <- h.latest
+	}
+	h.clients[c] = true
+	return c
+}
+
+func (h *hub) unsubscribe(c chan []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.clients, c)
+}
+
+func (h *hub) publish(msg []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.latest = msg
+	for c := range h.clients {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+// Reads a P1 smart meter and pushes its telegrams as JSON to browsers,
+// for kiosk displays and wall tablets, over a WebSocket at /ws or as
+// server-sent events at /events.  A new client gets the latest telegram
+// right away.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How often to ping idle clients, so that proxies keep the connection.
+const keepAlive = 30 * time.Second
+
+func wsHandler(h *hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := wsUpgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer conn.Close()
+
+		c := h.subscribe()
+		defer h.unsubscribe(c)
+		pongs := make(chan []byte, 1)
+		done := make(chan error, 1)
+		go func() { done <- wsReadLoop(conn, rw.Reader, pongs) }()
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case msg := <-c:
+				err = wsWriteFrame(conn, rw.Writer, wsText, msg)
+			case payload := <-pongs:
+				err = wsWriteFrame(conn, rw.Writer, wsPong, payload)
+			case <-ticker.C:
+				err = wsWriteFrame(conn, rw.Writer, wsPing, nil)
+			case <-done:
+				wsWriteFrame(conn, rw.Writer, wsClose, nil)
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func sseHandler(h *hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported",
+				http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		flusher.Flush()
+
+		c := h.subscribe()
+		defer h.unsubscribe(c)
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case msg := <-c:
+				_, err = fmt.Fprintf(w, "data: %s\n\n", msg)
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			case <-r.Context().Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func main() {
+	var source string
+	var listen string
+	var origin string
+	var interval time.Duration
+
+	flag.StringVar(&source, "meter", "/dev/P1",
+		"meter to read from: a serial port, serial:/dev/... or tcp:host:port")
+	flag.StringVar(&listen, "listen", ":1123", "address to serve on")
+	flag.StringVar(&origin, "allow-origin", "*",
+		"value of Access-Control-Allow-Origin for /events; "+
+			"empty to leave it out")
+	flag.DurationVar(&interval, "interval", 0,
+		"push at most one telegram per interval, eg. 5s")
+
+	flag.Parse()
+
+	m, err := dsmrp1.OpenMeter(source)
+	if err != nil {
+		log.Fatalf("OpenMeter(%s): %v", source, err)
+	}
+
+	h := newHub()
+	go func() {
+		var last time.Time
+		for t := range m.C {
+			if interval != 0 && time.Since(last) < interval {
+				continue
+			}
+			last = time.Now()
+			msg, err := json.Marshal(t)
+			if err != nil {
+				log.Printf("json: %v", err)
+				continue
+			}
+			h.publish(msg)
+		}
+	}()
+
+	events := sseHandler(h)
+	http.Handle("/ws", wsHandler(h))
+	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		events(w, r)
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "dsmrp1-ws: telegrams are at /ws (WebSocket) "+
+			"and /events (server-sent events)")
+	})
+
+	log.Printf("Serving telegrams of %s on %s", source, listen)
+	log.Fatal(http.ListenAndServe(listen, nil))
+}
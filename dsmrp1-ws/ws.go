@@ -0,0 +1,149 @@
+package main
+
+// Just enough of the WebSocket protocol (RFC 6455) to push text messages
+// to browsers.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of WebSocket frames.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// Completes the opening handshake and returns the connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn,
+	*bufio.ReadWriter, error) {
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, nil, errors.New("not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// Returns whether the comma separated header contains the token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Writes an unmasked frame, as a server does.
+func wsWriteFrame(conn net.Conn, w *bufio.Writer, opcode byte,
+	payload []byte) error {
+	w.WriteByte(0x80 | opcode)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n < 1<<16:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return w.Flush()
+}
+
+// Reads frames from the client, answering pings, until it closes the
+// connection.  The contents of other messages are ignored.
+func wsReadLoop(conn net.Conn, r *bufio.Reader, pongs chan<- []byte) error {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		opcode := header[0] & 0xf
+		masked := header[1]&0x80 != 0
+		n := uint64(header[1] & 0x7f)
+		switch n {
+		case 126:
+			var n16 uint16
+			if err := binary.Read(r, binary.BigEndian, &n16); err != nil {
+				return err
+			}
+			n = uint64(n16)
+		case 127:
+			if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+				return err
+			}
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return err
+			}
+		}
+		if opcode != wsPing {
+			if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+				return err
+			}
+			if opcode == wsClose {
+				return io.EOF
+			}
+			continue
+		}
+		if n > 125 {
+			return errors.New("ping too long")
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		select {
+		case pongs <- payload:
+		default:
+		}
+	}
+}
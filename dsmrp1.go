@@ -7,12 +7,18 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
 	"github.com/howeyc/crc16"
 	"github.com/tarm/serial"
+	"io"
 	"log"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Tariff int32
@@ -22,7 +28,8 @@ const (
 	TariffLow         = 2
 )
 
-// We noramalize units to kWh, W, s, m3, A and V
+// We noramalize units to kWh, W, s, m3, A and V.  Fields state the
+// unit they are normalized to in their "unit" tag.
 var normalizedUnits map[string]float32 = map[string]float32{
 	"kWh": 1,
 	"kW":  1000,
@@ -34,49 +41,82 @@ var normalizedUnits map[string]float32 = map[string]float32{
 }
 
 type ElectricityData struct {
-	KWh       float32 `obis:"1-0:1.8.2" type:"unit"`
-	KWhLow    float32 `obis:"1-0:1.8.1" type:"unit"`
-	KWhOut    float32 `obis:"1-0:2.8.2" type:"unit"`
-	KWhOutLow float32 `obis:"1-0:2.8.1" type:"unit"`
+	KWh       float32 `obis:"1-0:1.8.2" type:"unit" unit:"kWh"`
+	KWhLow    float32 `obis:"1-0:1.8.1" type:"unit" unit:"kWh"`
+	KWhOut    float32 `obis:"1-0:2.8.2" type:"unit" unit:"kWh"`
+	KWhOutLow float32 `obis:"1-0:2.8.1" type:"unit" unit:"kWh"`
 	Tariff    Tariff  `obis:"0-0:96.14.0" type:"int"`
 
-	W         float32  `obis:"1-0:1.7.0" type:"unit"`
-	WOut      float32  `obis:"1-0:2.7.0" type:"unit"`
-	Threshold *float32 `obis:"0-0:17.0.0" type:"unit"`
-	Switch    *string  `obis:"0-0:96.3.10" type:"id"`
+	// The registers per tariff, starting at tariff 1, for meters with
+	// more tariffs than the two above, which are also the first two here.
+	// See ImportPerTariff and ExportPerTariff.
+	KWhTariffs    []float32
+	KWhOutTariffs []float32
+
+	// Registers over all tariffs, which some meters have besides or
+	// instead of those per tariff, in which case those are zero.  See
+	// ImportTotal and ExportTotal.
+	KWhTotal    *float32 `obis:"1-0:1.8.0" type:"unit" unit:"kWh"`
+	KWhOutTotal *float32 `obis:"1-0:2.8.0" type:"unit" unit:"kWh"`
+
+	W      float32 `obis:"1-0:1.7.0" type:"unit" unit:"W"`
+	WOut   float32 `obis:"1-0:2.7.0" type:"unit" unit:"W"`
+	Switch *string `obis:"0-0:96.3.10" type:"id"`
+
+	// Above the threshold the meter may open its breaker.  DSMR 2.2 and 4
+	// meters supervise the power, in kW, and DSMR 3 meters the current,
+	// in A, in the same line.  Belgian meters supervise the current in
+	// 1-0:31.4.0.  See also Supervision.
+	Threshold     *float32 `obis:"0-0:17.0.0" type:"unit" unit:"W"`
+	ThresholdA    *float32
+	FuseThreshold *float32 `obis:"1-0:31.4.0" type:"unit" unit:"A"`
 
 	PowerFailures     int32  `obis:"0-0:96.7.21" type:"int"`
 	LongPowerFailures int32  `obis:"0-0:96.7.9" type:"int"`
 	PowerFailuresLog  string `obis:"1-0:99.97.0" type:"log"`
 
+	// The entries of PowerFailuresLog, oldest first as the meter sends
+	// them.
+	PowerFailureEvents []PowerFailure
+
 	L1VoltageSags   int32    `obis:"1-0:32.32.0" type:"int"`
 	L1VoltageSwells int32    `obis:"1-0:32.36.0" type:"int"`
-	L1Current       float32  `obis:"1-0:31.7.0" type:"unit"`
-	L1Voltage       *float32 `obis:"1-0:32.7.0" type:"unit"`
-	L1Power         float32  `obis:"1-0:21.7.0" type:"unit"`
-	L1PowerOut      float32  `obis:"1-0:22.7.0" type:"unit"`
+	L1Current       float32  `obis:"1-0:31.7.0" type:"unit" unit:"A"`
+	L1Voltage       *float32 `obis:"1-0:32.7.0" type:"unit" unit:"V"`
+	L1Power         float32  `obis:"1-0:21.7.0" type:"unit" unit:"W"`
+	L1PowerOut      float32  `obis:"1-0:22.7.0" type:"unit" unit:"W"`
 }
 
 type MultiphaseElectricityData struct {
 	L2VoltageSags   int32    `obis:"1-0:52.32.0" type:"int"`
 	L2VoltageSwells int32    `obis:"1-0:52.36.0" type:"int"`
-	L2Current       float32  `obis:"1-0:51.7.0" type:"unit"`
-	L2Voltage       *float32 `obis:"1-0:52.7.0" type:"unit"`
-	L2Power         float32  `obis:"1-0:41.7.0" type:"unit"`
-	L2PowerOut      float32  `obis:"1-0:42.7.0" type:"unit"`
+	L2Current       float32  `obis:"1-0:51.7.0" type:"unit" unit:"A"`
+	L2Voltage       *float32 `obis:"1-0:52.7.0" type:"unit" unit:"V"`
+	L2Power         float32  `obis:"1-0:41.7.0" type:"unit" unit:"W"`
+	L2PowerOut      float32  `obis:"1-0:42.7.0" type:"unit" unit:"W"`
 	L3VoltageSags   int32    `obis:"1-0:72.32.0" type:"int"`
 	L3VoltageSwells int32    `obis:"1-0:72.36.0" type:"int"`
-	L3Current       float32  `obis:"1-0:71.7.0" type:"unit"`
-	L3Voltage       *float32 `obis:"1-0:72.7.0" type:"unit"`
-	L3Power         float32  `obis:"1-0:61.7.0" type:"unit"`
-	L3PowerOut      float32  `obis:"1-0:62.7.0" type:"unit"`
+	L3Current       float32  `obis:"1-0:71.7.0" type:"unit" unit:"A"`
+	L3Voltage       *float32 `obis:"1-0:72.7.0" type:"unit" unit:"V"`
+	L3Power         float32  `obis:"1-0:61.7.0" type:"unit" unit:"W"`
+	L3PowerOut      float32  `obis:"1-0:62.7.0" type:"unit" unit:"W"`
 }
 
 type GasData struct {
 	Type       string    `obis:"0-1:24.1.0" type:"id"`
 	Id         string    `obis:"0-1:96.1.0" type:"id"`
 	Switch     *string   `obis:"0-1:24.4.0" type:"id"`
-	LastRecord GasRecord `obis:"0-1:24.2.1" type:"gasrecord"`
+	LastRecord GasRecord `obis:"0-1:24.2.1" type:"gasrecord" unit:"m3"`
+
+	// The hourly values of DSMR 2.2 meters, oldest first.  The last
+	// is also the LastRecord.
+	Profile []GasRecord
+}
+
+// Entry of the power failure event log.
+type PowerFailure struct {
+	End      string  // timestamp of the end of the failure
+	Duration float32 // in seconds
 }
 
 type GasRecord struct {
@@ -99,53 +139,257 @@ type Telegram struct {
 	MsgNumeric *string `obis:"0-0:96.13.1" type:"id"`
 	MsgTxt     *string `obis:"0-0:96.13.0" type:"id"`
 
+	// The lines of the telegram not parsed into the fields above, by
+	// OBIS code.  See also Get.
 	Other map[string][]string
+
+	// The telegram as received, from header up to and including
+	// the checksum.
+	Raw []byte `json:"-"`
+
+	// The errors of the lines that failed to parse, as returned by
+	// ParseTelegram.  See also FailedSections.
+	Errors []error `json:"-"`
 }
 
+// A connection to a meter.  The telegrams it sends are delivered on C,
+// which is closed after Close.  Stats, SetClock and Close may be called
+// from any goroutine, also while telegrams are being read.
 type Meter struct {
-	C       chan *Telegram
-	s       *serial.Port
-	r       *bufio.Reader
-	running bool
+	// Accessed atomically; first for alignment on 32-bit platforms.
+	telegrams  uint64
+	invalid    uint64
+	crcErrors  uint64
+	partial    uint64
+	reconnects uint64
+
+	C     chan *Telegram
+	opts  ParseOptions
+	ropts ReaderOptions
+	open  func() (io.ReadCloser, error)
+	r     *bufio.Reader // only used by the reader goroutine
+	stop  chan struct{} // closed by Close
+
+	lock     sync.Mutex // protects rc, closed, clock and received
+	rc       io.ReadCloser
+	closed   bool
+	clock    Clock
+	received time.Time // of the last telegram delivered
+}
+
+// Counts of what happened on the connection to a meter.
+type MeterStats struct {
+	Telegrams  uint64 // telegrams delivered on C
+	Invalid    uint64 // telegrams dropped as they failed to parse
+	CRCErrors  uint64 // of which as their checksum did not match
+	Partial    uint64 // delivered with errors; see ReaderOptions.Partial
+	Reconnects uint64 // times the connection was reopened after an error
+
+	// When the last telegram was received, by the clock of the meter;
+	// zero if none was.
+	LastTelegram time.Time
+}
+
+// Returns the counts of what happened on the connection so far.
+func (m *Meter) Stats() MeterStats {
+	return MeterStats{
+		Telegrams:    atomic.LoadUint64(&m.telegrams),
+		Invalid:      atomic.LoadUint64(&m.invalid),
+		CRCErrors:    atomic.LoadUint64(&m.crcErrors),
+		Partial:      atomic.LoadUint64(&m.partial),
+		Reconnects:   atomic.LoadUint64(&m.reconnects),
+		LastTelegram: m.lastTelegram(),
+	}
+}
+
+func (m *Meter) lastTelegram() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.received
 }
 
+// Sets the clock that tells when telegrams are received, which is the
+// SystemClock by default.
+func (m *Meter) SetClock(c Clock) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.clock = c
+}
+
+var errCRCMismatch = errors.New("CRC mismatch")
+
 func crc(data []byte) uint16 {
 	return crc16.Update(0xffff, crc16.IBMTable, data) ^ 0xffff
 }
 
+// Connects to a meter on the given serial port.
 func NewMeter(serialDev string) (*Meter, error) {
+	return newMeter(serialOpener(serialDev), ParseOptions{}, ReaderOptions{})
+}
+
+// Connects to a meter exposed over TCP (eg. by ser2net or a WiFi P1
+// dongle) at the given host:port.
+func DialMeter(addr string) (*Meter, error) {
+	return newMeter(tcpOpener(addr, 0), ParseOptions{}, ReaderOptions{})
+}
+
+// Connects to the meter described by source, which is either
+// "serial:/dev/ttyUSB0", "tcp:host:port" or the path of a serial port.
+func OpenMeter(source string) (*Meter, error) {
+	return OpenMeterWith(source, ParseOptions{})
+}
+
+// Like OpenMeter, but parses the telegrams with the given options.
+func OpenMeterWith(source string, opts ParseOptions) (*Meter, error) {
+	return OpenMeterWithReader(source, opts, ReaderOptions{})
+}
+
+// Returns the function that opens the port to the meter described by
+// source, as in OpenMeter.
+func opener(source string, ropts ReaderOptions) func() (io.ReadCloser, error) {
+	bits := strings.SplitN(source, ":", 2)
+	if len(bits) == 2 {
+		switch bits[0] {
+		case "serial":
+			return serialOpener(bits[1])
+		case "tcp":
+			return tcpOpener(bits[1], ropts.OSBuffer)
+		}
+	}
+	return serialOpener(source)
+}
+
+func serialOpener(serialDev string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return serial.OpenPort(&serial.Config{
+			Name:     serialDev,
+			Baud:     115200,
+			Parity:   serial.ParityNone,
+			StopBits: serial.Stop1,
+		})
+	}
+}
+
+func newMeter(open func() (io.ReadCloser, error), opts ParseOptions,
+	ropts ReaderOptions) (*Meter, error) {
 	var m Meter
 	var err error
 
 	m.C = make(chan *Telegram, 1)
-	m.s, err = serial.OpenPort(&serial.Config{
-		Name:     serialDev,
-		Baud:     115200,
-		Parity:   serial.ParityNone,
-		StopBits: serial.Stop1,
-	})
+	m.opts = opts
+	m.ropts = ropts
+	m.clock = SystemClock
+	m.open = open
+	m.stop = make(chan struct{})
+	m.rc, err = open()
 	if err != nil {
 		return nil, err
 	}
 
-	m.r = bufio.NewReader(m.s)
-	m.running = true
+	m.r = ropts.newReader(m.rc)
 
 	go func() {
-		for m.running {
-			t, err2 := m.readTelegram()
-			if err2 != nil {
-				log.Printf("Meter: %v", err2)
+		defer close(m.C)
+		if ropts.Realtime {
+			if err := raisePriority(); err != nil {
+				log.Printf("Meter: raising priority: %v", err)
+			}
+		}
+		for !m.stopped() {
+			raw, err := readRawTelegram(m.r)
+			if err != nil {
+				if m.stopped() {
+					// The read failed because Close closed the port.
+					return
+				}
+				log.Printf("Meter: %v", err)
+				m.reconnect()
+				continue
+			}
+			t, errs := ParseTelegramWith(raw, m.opts)
+			if errs != nil && (t == nil || !m.ropts.Partial) {
+				log.Printf("Meter: %v", errs)
+				atomic.AddUint64(&m.invalid, 1)
+				if errs[0] == errCRCMismatch {
+					atomic.AddUint64(&m.crcErrors, 1)
+				}
 				continue
 			}
-			m.C <- t
+			m.lock.Lock()
+			m.received = m.clock.Now()
+			m.lock.Unlock()
+			select {
+			case m.C <- t:
+				atomic.AddUint64(&m.telegrams, 1)
+				if errs != nil {
+					atomic.AddUint64(&m.partial, 1)
+				}
+			case <-m.stop:
+				return
+			}
 		}
-		close(m.C)
 	}()
 
 	return &m, nil
 }
 
+// Returns whether Close was called.
+func (m *Meter) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Closes the connection to the meter.  C is closed shortly after; a
+// telegram that is being read is dropped.
+func (m *Meter) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	close(m.stop)
+	return m.rc.Close()
+}
+
+// Reopens the connection to the meter after a read error, retrying
+// with exponential backoff.
+func (m *Meter) reconnect() {
+	m.lock.Lock()
+	m.rc.Close()
+	m.lock.Unlock()
+	backoff := time.Second
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-m.stop:
+			return
+		}
+		rc, err := m.open()
+		if err == nil {
+			m.lock.Lock()
+			if m.closed {
+				m.lock.Unlock()
+				rc.Close()
+				return
+			}
+			m.rc = rc
+			m.lock.Unlock()
+			m.r = m.ropts.newReader(rc)
+			atomic.AddUint64(&m.reconnects, 1)
+			return
+		}
+		log.Printf("Meter: reconnecting: %v", err)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
 // Parse the lines in a telegram.
 func parseLines(rawLines [][]byte) (map[string][]string, error) {
 	var lines []string
@@ -157,6 +401,10 @@ func parseLines(rawLines [][]byte) (map[string][]string, error) {
 	for _, rawLine := range rawLines {
 		sRawLine := string(rawLine)
 		if strings.HasPrefix(sRawLine, "(") {
+			if len(lines) == 0 {
+				return nil, errors.New(fmt.Sprintf(
+					"Continuation line without OBIS: %v", sRawLine))
+			}
 			lines[len(lines)-1] += sRawLine
 			continue
 		}
@@ -187,69 +435,102 @@ func parseLines(rawLines [][]byte) (map[string][]string, error) {
 	return ret, nil
 }
 
-func (m *Meter) readTelegram() (*Telegram, []error) {
-	var rawLines [][]byte = [][]byte{}
-	var line []byte
-	var checkSumBody []byte
-	var checkSumLine []byte
-	var err error
-	var ret Telegram
+// Reads the next telegram from r, skipping anything before its header.
+// Returns the telegram from the header up to and including the line
+// with the checksum.
+func readRawTelegram(r *bufio.Reader) ([]byte, error) {
+	var ret []byte
 
 	// wait for header
 	for {
-		line, err = m.r.ReadBytes(byte('\n'))
+		line, err := r.ReadBytes(byte('\n'))
 		if err != nil {
-			return nil, []error{err}
+			return nil, err
 		}
 		if !bytes.HasPrefix(line, []byte("/")) {
 			log.Printf("Skipping line %v", line)
 			continue
 		}
+		ret = line
 		break
 	}
 
-	// parse header
-	checkSumBody = line
+	// read up to and including the checksum
+	for {
+		line, err := r.ReadBytes(byte('\n'))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, line...)
+		if bytes.HasPrefix(line, []byte("!")) {
+			return ret, nil
+		}
+	}
+}
+
+// Checks and parses a raw telegram: everything from the header line
+// up to and including the line with the checksum.
+//
+// Returns nil and the error if the telegram could not be parsed at all.
+// If only some fields could not be parsed, the errors are returned
+// together with the telegram.
+func ParseTelegram(raw []byte) (*Telegram, []error) {
+	return ParseTelegramWith(raw, ParseOptions{})
+}
+
+// Like ParseTelegram, but with the given options.
+func ParseTelegramWith(raw []byte, opts ParseOptions) (*Telegram, []error) {
+	var rawLines [][]byte = [][]byte{}
+	var ret Telegram
+
+	// Split off the checksum
+	end := bytes.LastIndexByte(raw, '!')
+	if end == -1 {
+		return nil, []error{errors.New("Missing checksum line")}
+	}
+	checkSumBody := raw[:end+1]
+	checkSumLine := raw[end:]
+
+	lines := bytes.SplitAfter(raw[:end], []byte("\n"))
 
-	if len(line) < 5 {
+	// parse header
+	line := lines[0]
+	if !bytes.HasPrefix(line, []byte("/")) {
+		return nil, []error{errors.New("Missing header line")}
+	}
+	if len(line) < 6 {
 		return nil, []error{errors.New("Header line too short")}
 	}
 
 	ret.HeaderMarker = string(line[:6])
 	ret.HeaderId = strings.TrimSpace(string(line[6:]))
 
-	line, err = m.r.ReadBytes(byte('\n'))
-	if err != nil {
-		return nil, []error{err}
-	}
-	if strings.TrimSpace(string(line)) != "" {
+	if len(lines) < 2 || strings.TrimSpace(string(lines[1])) != "" {
 		return nil, []error{errors.New("Line after header is not blank")}
 	}
-	checkSumBody = append(checkSumBody, line...)
 
-	// read data
-	for {
-		line, err = m.r.ReadBytes(byte('\n'))
-		if bytes.HasPrefix(line, []byte("!")) {
-			checkSumLine = line
-			break
+	// data
+	for _, line := range lines[2:] {
+		if len(line) == 0 {
+			continue
 		}
-		checkSumBody = append(checkSumBody, line...)
 		rawLines = append(rawLines, bytes.TrimSpace(line))
 	}
 
-	// Check CRC
-	crc1 := crc(append(checkSumBody, '!'))
-	crc2, err := strconv.ParseInt(strings.TrimSpace(string(checkSumLine[1:])),
-		16, 32)
-	if err != nil {
-		return nil, []error{errors.New(
-			fmt.Sprintf("Could not parse checksum: %v", err))}
-	}
+	// Check CRC, which telegrams before DSMR 4 do not have.
+	if sum := strings.TrimSpace(string(checkSumLine[1:])); sum != "" {
+		crc1 := crc(checkSumBody)
+		crc2, err := strconv.ParseInt(sum, 16, 32)
+		if err != nil {
+			return nil, []error{errors.New(
+				fmt.Sprintf("Could not parse checksum: %v", err))}
+		}
 
-	if int64(crc1) != crc2 {
-		return nil, []error{errors.New("CRC mismatch")}
+		if int64(crc1) != crc2 {
+			return nil, []error{errCRCMismatch}
+		}
 	}
+	ret.Raw = raw
 
 	// parse the lines
 	data, err := parseLines(rawLines)
@@ -257,25 +538,89 @@ func (m *Meter) readTelegram() (*Telegram, []error) {
 		return nil, []error{err}
 	}
 
-	errs := []error{}
-	errs = append(errs, fillStruct(&ret, data)...)
+	errs := opts.applyFactors(data)
+	if opts.Profile == Strict {
+		errs = append(errs, checkStrict(data)...)
+	}
+	errs = append(errs, fillStruct(&ret, "", data)...)
 
-	if _, present := data["1-0:1.8.1"]; present {
+	_, tariffs := data[string(obis.ImportTariff1)]
+	_, importTotal := data[string(obis.ImportTotal)]
+	_, exportTotal := data[string(obis.ExportTotal)]
+	if tariffs || importTotal {
 		var e ElectricityData
-		errs = append(errs, fillStruct(&e, data)...)
+		if args := data[string(obis.Threshold)]; len(args) == 1 &&
+			strings.HasSuffix(args[0], "*A") {
+			var a struct {
+				ThresholdA *float32 `obis:"0-0:17.0.0" type:"unit"`
+			}
+			errs = append(errs, fillStruct(&a, "Electricity", data)...)
+			e.ThresholdA = a.ThresholdA
+		}
+		if args, ok := data[string(obis.PowerFailureLog)]; ok {
+			events, err := parseLog(args)
+			if err != nil {
+				errs = append(errs, LineError{obis.PowerFailureLog,
+					"Electricity", err.Error()})
+			}
+			e.PowerFailureEvents = events
+		}
+		eErrs := fillStruct(&e, "Electricity", data)
+		// Some meters only have the registers over all tariffs.
+		if importTotal {
+			eErrs = withoutMissing(eErrs, obis.ImportTariff1,
+				obis.ImportTariff2)
+		}
+		if exportTotal {
+			eErrs = withoutMissing(eErrs, obis.ExportTariff1,
+				obis.ExportTariff2)
+		}
+		errs = append(errs, eErrs...)
+		e.KWhTariffs = []float32{e.KWhLow, e.KWh}
+		e.KWhOutTariffs = []float32{e.KWhOutLow, e.KWhOut}
+		errs = append(errs, parseTariffs(data, obis.ImportTariff1,
+			&e.KWhTariffs)...)
+		errs = append(errs, parseTariffs(data, obis.ExportTariff1,
+			&e.KWhOutTariffs)...)
 		ret.Electricity = &e
 	}
 
-	if _, present := data["1-0:41.7.0"]; present {
+	if _, present := data[string(obis.L2Power)]; present {
 		var e MultiphaseElectricityData
-		errs = append(errs, fillStruct(&e, data)...)
+		errs = append(errs, fillStruct(&e, "MultiphaseElectricity", data)...)
 		ret.MultiphaseElectricity = &e
 	}
 
-	if _, present := data["0-1:24.2.1"]; present {
+	if _, present := data[string(obis.MBusReading)]; present {
 		var g GasData
-		errs = append(errs, fillStruct(&g, data)...)
+		errs = append(errs, fillStruct(&g, "Gas", data)...)
 		ret.Gas = &g
+	} else if args, present := data[string(obis.MBusReadingDSMR22)]; present {
+		delete(data, string(obis.MBusReadingDSMR22))
+		profile, err := parseGasProfile(args)
+		if err != nil {
+			errs = append(errs, LineError{obis.MBusReadingDSMR22, "Gas",
+				err.Error()})
+		} else if len(profile) != 0 {
+			var ids struct {
+				Type   *string `obis:"0-1:24.1.0" type:"id"`
+				Id     *string `obis:"0-1:96.1.0" type:"id"`
+				Switch *string `obis:"0-1:24.4.0" type:"id"`
+			}
+			errs = append(errs, fillStruct(&ids, "Gas", data)...)
+			g := GasData{
+				Switch:     ids.Switch,
+				LastRecord: profile[len(profile)-1],
+				Profile:    profile,
+			}
+			if ids.Type != nil {
+				g.Type = *ids.Type
+			}
+			if ids.Id != nil {
+				g.Id = *ids.Id
+			}
+			ret.Gas = &g
+		}
 	}
 
 	ret.Other = data
@@ -283,46 +628,202 @@ func (m *Meter) readTelegram() (*Telegram, []error) {
 	if len(errs) == 0 {
 		errs = nil
 	}
+	ret.Errors = errs
 
 	return &ret, errs
 }
 
+// Returns the arguments of the line with the given OBIS code, if it
+// was not parsed into one of the fields of the telegram.
+func (t *Telegram) Get(code obis.Code) ([]string, bool) {
+	args, ok := t.Other[string(code)]
+	return args, ok
+}
+
 // Parse and normalize OBIS unit value like "123*A"
 func parseUnit(v string) (float32, error) {
 	bits := strings.SplitN(v, "*", 2)
 	if len(bits) != 2 {
 		return 0, errors.New(fmt.Sprintf("not a unit %v", v))
 	}
-	amount, err := strconv.ParseFloat(bits[0], 32)
+	amount, err := parseAmount(bits[0], 32)
 	if err != nil {
 		return 0, errors.New(fmt.Sprintf("could not parse amount: %s", err))
 	}
-	factor, ok := normalizedUnits[bits[1]]
+	factor, ok := normalizedUnits[strings.TrimSpace(bits[1])]
 	if !ok {
 		return 0, errors.New(fmt.Sprintf("unknown unit: %v", v))
 	}
 	return float32(amount) * factor, nil
 }
 
+// Parses an amount like "001.234".  Some firmwares pad amounts with
+// spaces, prefix a plus sign or use a decimal comma, eg. " +001,234".
+// Unlike strconv.ParseFloat, this does not accept infinities, NaN or
+// hexadecimal floats, which no meter sends.
+func parseAmount(s string, bitSize int) (float64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "+") {
+		s = strings.TrimSpace(s[1:])
+	}
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.-eE", r)
+	}) != -1 {
+		return 0, errors.New(fmt.Sprintf("invalid amount: %q", s))
+	}
+	return strconv.ParseFloat(s, bitSize)
+}
+
+// Highest tariff of which the registers are parsed.
+const maxTariff = 9
+
+// Parses the registers of tariffs 3 and up into regs, which holds those
+// of tariffs 1 and 2.  Registers of tariffs the meter skips are zero.
+func parseTariffs(data map[string][]string, tariff1 obis.Code,
+	regs *[]float32) []error {
+	errs := []error{}
+	for tariff := 3; tariff <= maxTariff; tariff++ {
+		code := string(obis.ForTariff(tariff1, tariff))
+		args, ok := data[code]
+		if !ok {
+			continue
+		}
+		delete(data, code)
+		if len(args) != 1 {
+			errs = append(errs, LineError{obis.Code(code), "Electricity",
+				"wrong number of arguments"})
+			continue
+		}
+		v, err := parseUnit(args[0])
+		if err != nil {
+			errs = append(errs, LineError{obis.Code(code), "Electricity",
+				err.Error()})
+			continue
+		}
+		for len(*regs) < tariff {
+			*regs = append(*regs, 0)
+		}
+		(*regs)[tariff-1] = v
+	}
+	return errs
+}
+
+// Parse the gas profile of DSMR 2.2 like
+// "0-1:24.3.0(120517020000)(08)(60)(1)(0-1:24.2.1)(m3)\r\n(00124.477)"
+// split into arguments: the timestamp of the first value, a status, the
+// period in minutes, the number of values, their OBIS code and unit, and
+// then the values themselves.
+func parseGasProfile(args []string) ([]GasRecord, error) {
+	if len(args) < 6 {
+		return nil, errors.New("wrong number of arguments")
+	}
+	period, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not parse period: %s", err))
+	}
+	n, err := strconv.Atoi(args[3])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not parse count: %s", err))
+	}
+	if len(args) != 6+n {
+		return nil, errors.New("wrong number of values")
+	}
+	if len(args[0]) < 12 {
+		return nil, errors.New(fmt.Sprintf("malformed timestamp: %s", args[0]))
+	}
+	start, err := time.Parse("060102150405", args[0][:12])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("malformed timestamp: %s", args[0]))
+	}
+	ret := make([]GasRecord, n)
+	for i := 0; i < n; i++ {
+		v, err := parseUnit(args[6+i] + "*" + args[5])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("value: %s", err))
+		}
+		at := start.Add(time.Duration(i*period) * time.Minute)
+		ret[i] = GasRecord{
+			TimeStamp: at.Format("060102150405") + args[0][12:],
+			Value:     v,
+		}
+	}
+	return ret, nil
+}
+
+// Parse a power failure event log like
+// "2)(0-0:96.7.19)(101208152415W)(0000000240*s)(101208151004W)(0000000301*s"
+// split into arguments.
+func parseLog(args []string) ([]PowerFailure, error) {
+	ret := []PowerFailure{}
+	if len(args) == 0 || (len(args) == 1 && args[0] == "") {
+		return ret, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not parse count: %s", err))
+	}
+	if n == 0 {
+		return ret, nil
+	}
+	if len(args) != 2+2*n {
+		return nil, errors.New("wrong number of arguments")
+	}
+	for i := 0; i < n; i++ {
+		v, err := parseUnit(args[3+2*i])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("duration: %s", err))
+		}
+		ret = append(ret, PowerFailure{End: args[2+2*i], Duration: v})
+	}
+	return ret, nil
+}
+
+// Returns the errors except those about the given lines missing.
+func withoutMissing(errs []error, codes ...obis.Code) []error {
+	ret := []error{}
+	for _, err := range errs {
+		le, ok := err.(LineError)
+		if ok && le.Message == errMissingData {
+			missing := false
+			for _, code := range codes {
+				missing = missing || le.Code == code
+			}
+			if missing {
+				continue
+			}
+		}
+		ret = append(ret, err)
+	}
+	return ret
+}
+
+// Message of the LineError of a line that is missing.
+const errMissingData = "missing data"
+
 // Fills the given struct (annotated by "obis" and "type" tags) with
-// the values from the the telegram.
-func fillStruct(s interface{}, data map[string][]string) []error {
+// the values from the the telegram.  The errors are LineErrors of the
+// given section.
+func fillStruct(s interface{}, section string,
+	data map[string][]string) []error {
 	ret := []error{}
 	sv := reflect.Indirect(reflect.ValueOf(s))
 	st := sv.Type()
 	for i := 0; i < st.NumField(); i++ {
 		fieldType := st.Field(i)
-		if obis, ok := fieldType.Tag.Lookup("obis"); ok {
-			args, ok := data[obis]
+		if code, ok := fieldType.Tag.Lookup("obis"); ok {
+			args, ok := data[code]
 			if !ok {
 				// Create an error for non-optional (i.e. non pointer) fields.
 				if fieldType.Type.Kind() != reflect.Ptr {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"Missing data for %s", obis)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						errMissingData})
 				}
 				continue
 			}
-			delete(data, obis)
+			delete(data, code)
 			field := sv.FieldByIndex(fieldType.Index)
 			if fieldType.Type.Kind() == reflect.Ptr {
 				// Handle *type as type
@@ -332,50 +833,53 @@ func fillStruct(s interface{}, data map[string][]string) []error {
 			switch typ, _ := fieldType.Tag.Lookup("type"); typ {
 			case "id":
 				if len(args) != 1 {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: wrong number of arguments", obis)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						"wrong number of arguments"})
 					continue
 				}
 				field.SetString(args[0])
 			case "int":
 				if len(args) != 1 {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: wrong number of arguments", obis)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						"wrong number of arguments"})
 					continue
 				}
-				i, err := strconv.Atoi(args[0])
+				i, err := strconv.Atoi(strings.TrimSpace(args[0]))
 				if err != nil {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: could not parse amount: %s", obis, err)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						fmt.Sprintf("could not parse amount: %s", err)})
 					continue
 				}
 				field.SetInt(int64(i))
 			case "gasrecord":
 				var g GasRecord
 				if len(args) != 2 {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: wrong number of arguments", obis)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						"wrong number of arguments"})
 					continue
 				}
 				v, err := parseUnit(args[1])
 				if err != nil {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: value: %s", obis, err)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						fmt.Sprintf("value: %s", err)})
 					continue
 				}
 				g.Value = v
 				g.TimeStamp = args[0]
 				field.Set(reflect.ValueOf(g))
+			case "log":
+				// As it appears in the telegram; see parseLog.
+				field.SetString(strings.Join(args, ")("))
 			case "unit":
 				if len(args) != 1 {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: wrong number of arguments", obis)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						"wrong number of arguments"})
 					continue
 				}
 				v, err := parseUnit(args[0])
 				if err != nil {
-					ret = append(ret, errors.New(fmt.Sprintf(
-						"%s: %s", obis, err)))
+					ret = append(ret, LineError{obis.Code(code), section,
+						err.Error()})
 					continue
 				}
 				field.SetFloat(float64(v))
@@ -384,3 +888,135 @@ func fillStruct(s interface{}, data map[string][]string) []error {
 	}
 	return ret
 }
+
+// Returns the registers of the energy imported per tariff, starting at
+// tariff 1.
+func (e *ElectricityData) ImportPerTariff() []float32 {
+	if len(e.KWhTariffs) >= 2 {
+		return e.KWhTariffs
+	}
+	return []float32{e.KWhLow, e.KWh}
+}
+
+// Returns the registers of the energy exported per tariff, like
+// ImportPerTariff.
+func (e *ElectricityData) ExportPerTariff() []float32 {
+	if len(e.KWhOutTariffs) >= 2 {
+		return e.KWhOutTariffs
+	}
+	return []float32{e.KWhOutLow, e.KWhOut}
+}
+
+// Returns the energy imported over all tariffs: the total register if
+// the meter has one and otherwise the sum of the registers per tariff.
+func (e *ElectricityData) ImportTotal() float64 {
+	if e.KWhTotal != nil {
+		return float64(*e.KWhTotal)
+	}
+	return sum32(e.ImportPerTariff())
+}
+
+// Returns the energy exported over all tariffs, like ImportTotal.
+func (e *ElectricityData) ExportTotal() float64 {
+	if e.KWhOutTotal != nil {
+		return float64(*e.KWhOutTotal)
+	}
+	return sum32(e.ExportPerTariff())
+}
+
+func sum32(vs []float32) float64 {
+	var ret float64
+	for _, v := range vs {
+		ret += float64(v)
+	}
+	return ret
+}
+
+// Measurements of a single phase.
+type Phase struct {
+	Voltage       *float32
+	Current       float32
+	Power         float32
+	PowerOut      float32
+	VoltageSags   int32
+	VoltageSwells int32
+}
+
+// Returns the measurements per phase: none if there is no electricity
+// data, one for single phase connections and three otherwise.
+func (t *Telegram) Phases() []Phase {
+	if t.Electricity == nil {
+		return nil
+	}
+	e := t.Electricity
+	ret := []Phase{{e.L1Voltage, e.L1Current, e.L1Power, e.L1PowerOut,
+		e.L1VoltageSags, e.L1VoltageSwells}}
+	if m := t.MultiphaseElectricity; m != nil {
+		ret = append(ret,
+			Phase{m.L2Voltage, m.L2Current, m.L2Power, m.L2PowerOut,
+				m.L2VoltageSags, m.L2VoltageSwells},
+			Phase{m.L3Voltage, m.L3Current, m.L3Power, m.L3PowerOut,
+				m.L3VoltageSags, m.L3VoltageSwells})
+	}
+	return ret
+}
+
+// Returns the current with sub-ampere resolution.  Many meters report the
+// current rounded to whole amperes.  In that case the current is derived
+// from the power and voltage, if known, and derived is true.  As the
+// power is the active power, the derived current is only used if it is
+// consistent with the reported one.
+func (p Phase) PreciseCurrent() (current float32, derived bool) {
+	if p.Current != float32(math.Trunc(float64(p.Current))) ||
+		p.Voltage == nil || *p.Voltage <= 0 {
+		return p.Current, false
+	}
+	estimate := (p.Power + p.PowerOut) / *p.Voltage
+	if estimate < p.Current-0.5 || estimate >= p.Current+1 {
+		return p.Current, false
+	}
+	return estimate, true
+}
+
+// Meters report a threshold of 999 kW or more if they do not supervise
+// the power.
+const noThresholdW = 999000
+
+// Returns the limits the meter supervises: on the power it imports in W
+// and on the current per phase in A.  Both are zero if not supervised.
+func (e *ElectricityData) Supervision() (w, a float32) {
+	if e.Threshold != nil && *e.Threshold > 0 && *e.Threshold < noThresholdW {
+		w = *e.Threshold
+	}
+	if e.ThresholdA != nil {
+		a = *e.ThresholdA
+	}
+	if e.FuseThreshold != nil && *e.FuseThreshold > 0 &&
+		(a == 0 || *e.FuseThreshold < a) {
+		a = *e.FuseThreshold
+	}
+	return
+}
+
+// Dutch meters report local time with an S (summer) or W (winter) suffix.
+var (
+	summerTime = time.FixedZone("CEST", 2*60*60)
+	winterTime = time.FixedZone("CET", 60*60)
+)
+
+// Parses a timestamp as used in telegrams like "101209113020W".
+func ParseTimestamp(s string) (time.Time, error) {
+	if len(s) != 13 {
+		return time.Time{}, errors.New(fmt.Sprintf("malformed timestamp: %s", s))
+	}
+	var loc *time.Location
+	switch s[12] {
+	case 'S':
+		loc = summerTime
+	case 'W':
+		loc = winterTime
+	default:
+		return time.Time{}, errors.New(fmt.Sprintf("malformed timestamp: %s", s))
+	}
+	return time.ParseInLocation("060102150405", s[:12], loc)
+}
@@ -0,0 +1,296 @@
+package dsmrp1
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestContinuationWithoutObis(t *testing.T) {
+	raw := "/ISK5\\2M550T-1012\r\n\r\n(123)\r\n!\r\n"
+	tg, errs := ParseTelegram([]byte(raw))
+	if tg != nil || len(errs) != 1 {
+		t.Fatalf("ParseTelegram: got %v, %v; expected a single error",
+			tg, errs)
+	}
+
+	var got []error
+	err := ParseStream(strings.NewReader(raw), ParseOptions{},
+		func(t *Telegram, at time.Time, errs []error) error {
+			got = append(got, errs...)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("ParseStream: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("ParseStream: got %v; expected a single error", got)
+	}
+}
+
+func TestCaptureTruncated(t *testing.T) {
+	capture := "#2026-10-16T12:00:00.000Z\r\n/ISK5\\2M550T-1012\r\n\r\n" +
+		"1-3:0.2.8(50)\r\n" +
+		"#2026-10-16T12:00:01.000Z\r\n/ISK5\\2M550T-1012\r\n\r\n" +
+		"1-3:0.2.8(50)\r\n!\r\n"
+	cr := NewCaptureReader(strings.NewReader(capture))
+	raw, at, err := cr.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	expected := time.Date(2026, 10, 16, 12, 0, 1, 0, time.UTC)
+	if !at.Equal(expected) {
+		t.Fatalf("Next: got time %v; expected %v", at, expected)
+	}
+	if strings.Count(string(raw), "/") != 1 {
+		t.Fatalf("Next: got telegram %q", raw)
+	}
+}
+
+// A slow and endless stream of telegrams, one per read, that counts the
+// reads.
+type endlessStream struct {
+	raw   []byte
+	reads int32
+}
+
+func (s *endlessStream) Read(p []byte) (int, error) {
+	atomic.AddInt32(&s.reads, 1)
+	time.Sleep(100 * time.Microsecond)
+	return copy(p, s.raw), nil
+}
+
+func TestParseStreamParallelStops(t *testing.T) {
+	s := &endlessStream{raw: testTelegramBytes(time.Now())}
+	stop := errors.New("stop")
+	done := make(chan error, 1)
+	go func() {
+		done <- ParseStreamParallel(s, ParseOptions{}, 2,
+			func(t *Telegram, at time.Time, errs []error) error {
+				return stop
+			})
+	}()
+	select {
+	case err := <-done:
+		if err != stop {
+			t.Fatalf("ParseStreamParallel: got %v; expected %v", err, stop)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ParseStreamParallel did not return")
+	}
+
+	// At most the read of a telegram that was under way finishes.
+	reads := atomic.LoadInt32(&s.reads)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&s.reads); n > reads+2 {
+		t.Fatalf("read %d times more after returning", n-reads)
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		expected float32
+		ok       bool
+	}{
+		{"001234.567*kWh", 1234.567, true},
+		{"01.193*kW", 1193, true},
+		{"00230*V", 230, true},
+		{" 001.5*kW", 1500, true},
+		{"001.5 * kW ", 1500, true},
+		{"+001.5*kW", 1500, true},
+		{"+ 001.5*kW", 1500, true},
+		{"-001.5*kW", -1500, true},
+		{"001,5*kW", 1500, true},
+		{" +001,5*kWh", 1.5, true},
+		{"001.5", 0, false},
+		{"001.5*J", 0, false},
+		{"*kW", 0, false},
+		{"abc*kW", 0, false},
+		{"0x1p0*kW", 0, false},
+		{"Inf*kW", 0, false},
+		{"NaN*kW", 0, false},
+		{"1,000.5*kW", 0, false},
+		{"1.0.0*kW", 0, false},
+		{"++1*kW", 0, false},
+	} {
+		got, err := parseUnit(c.in)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("parseUnit(%q): got %v; expected an error", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseUnit(%q): %v", c.in, err)
+		} else if got != c.expected {
+			t.Errorf("parseUnit(%q): got %v; expected %v",
+				c.in, got, c.expected)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for _, c := range []struct {
+		in       string
+		expected float64
+		ok       bool
+	}{
+		{"00012345678", 12345678, true},
+		{"  42  ", 42, true},
+		{"+7", 7, true},
+		{"-7", -7, true},
+		{"3,25", 3.25, true},
+		{"1e3", 1000, true},
+		{"", 0, false},
+		{"+", 0, false},
+		{"1_000", 0, false},
+		{"infinity", 0, false},
+	} {
+		got, err := parseAmount(c.in, 64)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("parseAmount(%q): got %v; expected an error",
+					c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAmount(%q): %v", c.in, err)
+		} else if got != c.expected {
+			t.Errorf("parseAmount(%q): got %v; expected %v",
+				c.in, got, c.expected)
+		}
+	}
+}
+
+func TestFailedSections(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name     string
+		b        *TelegramBuilder
+		opts     ParseOptions
+		expected []string
+	}{
+		{"complete", testTelegramBuilder(at), ParseOptions{}, []string{}},
+		{"bad gas reading", testTelegramBuilder(at).
+			Gas(1, "G0001", at, 12).
+			Line("0-1:24.2.1", FormatTimestamp(at), "x*m3"),
+			ParseOptions{}, []string{"Gas"}},
+		{"bad register", testTelegramBuilder(at).
+			Line("1-0:1.8.1", "x*kWh"),
+			ParseOptions{}, []string{"Electricity"}},
+		{"bad register of tariff 3", testTelegramBuilder(at).
+			Line("1-0:1.8.3", "x*kWh"),
+			ParseOptions{}, []string{"Electricity"}},
+		{"unknown line", testTelegramBuilder(at).
+			Line("1-0:99.1.0", "42"),
+			ParseOptions{Profile: Strict}, []string{}},
+		{"bad timestamp", testTelegramBuilder(at).
+			Line("0-0:1.0.0", "1", "2"),
+			ParseOptions{}, []string{}},
+	} {
+		tg, errs := ParseTelegramWith(c.b.Bytes(), c.opts)
+		if tg == nil {
+			t.Errorf("%s: %v", c.name, errs)
+			continue
+		}
+		tg.Errors = errs
+		got := tg.FailedSections()
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("%s: got %v; expected %v (errors %v)", c.name, got,
+				c.expected, errs)
+		}
+	}
+}
+
+func TestTotalRegistersOnly(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	raw := NewTelegramBuilder(DSMR5).
+		Timestamp(at).
+		EquipmentID("test").
+		Line("1-0:1.8.0", "001234.500*kWh").
+		Line("1-0:2.8.0", "000012.250*kWh").
+		Tariff(1).
+		Power(230, 0).
+		PowerFailures(0, 0).
+		Phase(1, 230, 1, 230, 0).
+		VoltageEvents(1, 0, 0).
+		Bytes()
+	tg, errs := ParseTelegram(raw)
+	if errs != nil {
+		t.Fatalf("ParseTelegram: %v", errs)
+	}
+	if tg.Electricity == nil {
+		t.Fatalf("no Electricity section")
+	}
+	if tg.Electricity.ImportTotal() != 1234.5 ||
+		tg.Electricity.ExportTotal() != 12.25 {
+		t.Fatalf("got totals %v and %v", tg.Electricity.ImportTotal(),
+			tg.Electricity.ExportTotal())
+	}
+
+	// Without a total, the registers per tariff are still required.
+	raw = NewTelegramBuilder(DSMR5).
+		Timestamp(at).
+		EquipmentID("test").
+		Line("1-0:1.8.0", "001234.500*kWh").
+		Tariff(1).
+		Power(230, 0).
+		PowerFailures(0, 0).
+		Phase(1, 230, 1, 230, 0).
+		VoltageEvents(1, 0, 0).
+		Bytes()
+	_, errs = ParseTelegram(raw)
+	if len(errs) != 2 {
+		t.Fatalf("ParseTelegram: got %v; expected the export registers "+
+			"per tariff to be missing", errs)
+	}
+}
+
+func TestPowerFailuresLog(t *testing.T) {
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	log := "2)(0-0:96.7.19)(101208152415W)(0000000240*s)(101208151004W)" +
+		"(0000000301*s"
+	tg, errs := ParseTelegram(testTelegramBuilder(at).
+		Line(obis.PowerFailureLog, log).Bytes())
+	if errs != nil {
+		t.Fatalf("ParseTelegram: %v", errs)
+	}
+	e := tg.Electricity
+	if e.PowerFailuresLog != log {
+		t.Fatalf("PowerFailuresLog: got %q; expected %q",
+			e.PowerFailuresLog, log)
+	}
+	expected := []PowerFailure{{"101208152415W", 240}, {"101208151004W", 301}}
+	if fmt.Sprint(e.PowerFailureEvents) != fmt.Sprint(expected) {
+		t.Fatalf("PowerFailureEvents: got %v; expected %v",
+			e.PowerFailureEvents, expected)
+	}
+
+	// A malformed log is kept as is, but has no events.
+	tg, errs = ParseTelegram(testTelegramBuilder(at).
+		Line(obis.PowerFailureLog, "2", "0-0:96.7.19").Bytes())
+	if len(errs) != 1 || tg.Electricity.PowerFailuresLog != "2)(0-0:96.7.19" ||
+		tg.Electricity.PowerFailureEvents != nil {
+		t.Fatalf("ParseTelegram of a malformed log: got %+v, %v",
+			tg.Electricity, errs)
+	}
+	if sections := tg.FailedSections(); len(sections) != 1 ||
+		sections[0] != "Electricity" {
+		t.Fatalf("FailedSections: got %v", sections)
+	}
+}
+
+func TestFloat64(t *testing.T) {
+	for _, v := range []float32{0, 123.4, -0.1, 1234.567, 3e-7} {
+		got := Float64(v)
+		if fmt.Sprint(got) != fmt.Sprint(v) {
+			t.Errorf("Float64(%v): got %v", v, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+// Reports the internals of the daemon for debugging.
+
+import (
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Report served at /api/v1/admin/status.
+type adminStatus struct {
+	Started       time.Time     `json:"started"`
+	UptimeSeconds float64       `json:"uptime_seconds"`
+	Goroutines    int           `json:"goroutines"`
+	Config        string        `json:"config_fingerprint"`
+	Meters        []meterStatus `json:"meters"`
+	Queues        []queueStatus `json:"queues"`
+	Alerts        []alert       `json:"alerts"`
+}
+
+type meterStatus struct {
+	meterInfo
+
+	// Not set when replaying a capture.
+	Telegrams  *uint64 `json:"telegrams,omitempty"`
+	Invalid    *uint64 `json:"invalid_telegrams,omitempty"`
+	CRCErrors  *uint64 `json:"crc_errors,omitempty"`
+	Reconnects *uint64 `json:"reconnects,omitempty"`
+}
+
+type queueStatus struct {
+	Name      string `json:"name"`
+	Length    int    `json:"length"`
+	Capacity  int    `json:"capacity"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+func adminStatusHandler(meters []*meter, queues queueMetrics, as *alerts,
+	fingerprint string, started time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := adminStatus{
+			Started:       started,
+			UptimeSeconds: time.Since(started).Seconds(),
+			Goroutines:    runtime.NumGoroutine(),
+			Config:        fingerprint,
+			Meters:        []meterStatus{},
+			Queues:        []queueStatus{},
+			Alerts:        as.list(),
+		}
+		for _, m := range meters {
+			ms := meterStatus{meterInfo: m.info()}
+			if m.conn != nil {
+				stats := m.conn.Stats()
+				ms.Telegrams = &stats.Telegrams
+				ms.Invalid = &stats.Invalid
+				ms.CRCErrors = &stats.CRCErrors
+				ms.Reconnects = &stats.Reconnects
+			}
+			status.Meters = append(status.Meters, ms)
+		}
+		for _, q := range queues {
+			status.Queues = append(status.Queues, queueStatus{
+				Name:      q.name,
+				Length:    len(q.jobs),
+				Capacity:  cap(q.jobs),
+				Delivered: atomic.LoadUint64(&q.delivered),
+				Failed:    atomic.LoadUint64(&q.failed),
+				Dropped:   atomic.LoadUint64(&q.dropped),
+			})
+		}
+		writeJSON(w, r, status)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+// Keeps track of active alerts raised by the monitors.
+
+import (
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type alert struct {
+	Meter   string    `json:"meter"`
+	Name    string    `json:"name"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+}
+
+type alerts struct {
+	lock      sync.Mutex
+	active    map[string]*alert
+	listeners []func(a alert, active bool)
+}
+
+func newAlerts() *alerts {
+	return &alerts{active: make(map[string]*alert)}
+}
+
+// Registers a function that is called whenever an alert is raised or
+// cleared.
+func (as *alerts) listen(f func(a alert, active bool)) {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	as.listeners = append(as.listeners, f)
+}
+
+// Raises or clears the given alert.  Does nothing if it already is in
+// the requested state.
+func (as *alerts) set(meter, name string, active bool, message string,
+	at time.Time) {
+	key := meter + "/" + name
+
+	as.lock.Lock()
+	a, wasActive := as.active[key]
+	if active == wasActive {
+		as.lock.Unlock()
+		return
+	}
+	if active {
+		a = &alert{Meter: meter, Name: name, Message: message, Since: at}
+		as.active[key] = a
+	} else {
+		delete(as.active, key)
+	}
+	listeners := as.listeners
+	as.lock.Unlock()
+
+	if active {
+		log.Printf("alert raised: %s: %s", key, message)
+	} else {
+		log.Printf("alert cleared: %s", key)
+	}
+	for _, f := range listeners {
+		f(*a, active)
+	}
+}
+
+// Returns the active alerts sorted by meter and name.
+func (as *alerts) list() []alert {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+	ret := []alert{}
+	for _, a := range as.active {
+		ret = append(ret, *a)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Meter != ret[j].Meter {
+			return ret[i].Meter < ret[j].Meter
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+func (as *alerts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, as.list())
+}
+
+func (as *alerts) writeMetrics(w io.Writer) {
+	f := &sinks.MetricFamily{Name: "dsmrp1d_alert_active", Type: "gauge",
+		Help: "Alerts that are currently raised."}
+	for _, a := range as.list() {
+		f.Add(sinks.Labels("meter", a.Meter, "alert", a.Name), 1)
+	}
+	f.Write(w)
+}
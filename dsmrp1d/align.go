@@ -0,0 +1,86 @@
+package main
+
+// Passes one telegram per period, aligned to the wall clock, on to the
+// sinks instead of every telegram as it arrives.  Many time series
+// databases prefer samples at fixed times.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"sync"
+	"time"
+)
+
+type aligner struct {
+	period time.Duration
+	sinks  []observer
+
+	lock      sync.Mutex
+	prev      *dsmrp1.Telegram
+	prevAt    time.Time
+	prevStale []string
+}
+
+func newAligner(period time.Duration) *aligner {
+	return &aligner{period: period}
+}
+
+// Adds a sink to pass the aligned telegrams to.
+func (a *aligner) add(o observer) {
+	a.sinks = append(a.sinks, o)
+}
+
+func (a *aligner) observe(t *dsmrp1.Telegram, at time.Time, stale []string) {
+	a.lock.Lock()
+	prev, prevAt, prevStale := a.prev, a.prevAt, a.prevStale
+	a.prev, a.prevAt, a.prevStale = t, at, stale
+	a.lock.Unlock()
+
+	// Is there a boundary in (prevAt, at]?  After a gap spanning several
+	// boundaries, only the last one is passed on.
+	boundary := at.Truncate(a.period)
+	if prev == nil || !boundary.After(prevAt) {
+		return
+	}
+	// The interpolation mixes both telegrams, so a section is stale if
+	// it is in either.
+	aligned := interpolateTelegram(prev, prevAt, t, at, boundary)
+	stale = append(append([]string{}, prevStale...), stale...)
+	for _, o := range a.sinks {
+		o.observe(aligned, boundary, stale)
+	}
+}
+
+func lerp32(a, b float32, frac float64) float32 {
+	return float32(float64(a) + (float64(b)-float64(a))*frac)
+}
+
+// Returns the telegram at the given time between two telegrams.  The
+// energy registers are interpolated; the other values, including the
+// gas reading which carries its own timestamp, are taken from the
+// nearest telegram.
+func interpolateTelegram(prev *dsmrp1.Telegram, prevAt time.Time,
+	next *dsmrp1.Telegram, nextAt, at time.Time) *dsmrp1.Telegram {
+	frac := float64(at.Sub(prevAt)) / float64(nextAt.Sub(prevAt))
+	nearest := next
+	if frac < 0.5 {
+		nearest = prev
+	}
+	ret := *nearest
+	ret.Raw = nil
+
+	if ts, err := dsmrp1.ParseTimestamp(nearest.TimeStamp); err == nil {
+		ret.TimeStamp = at.In(ts.Location()).Format("060102150405") +
+			nearest.TimeStamp[12:]
+	}
+
+	pe, ne := prev.Electricity, next.Electricity
+	if pe != nil && ne != nil && prev.ID == next.ID {
+		e := *nearest.Electricity
+		e.KWh = lerp32(pe.KWh, ne.KWh, frac)
+		e.KWhLow = lerp32(pe.KWhLow, ne.KWhLow, frac)
+		e.KWhOut = lerp32(pe.KWhOut, ne.KWhOut, frac)
+		e.KWhOutLow = lerp32(pe.KWhOutLow, ne.KWhOutLow, frac)
+		ret.Electricity = &e
+	}
+	return &ret
+}
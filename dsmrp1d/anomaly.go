@@ -0,0 +1,213 @@
+package main
+
+// Learns the usual standby power and nightly gas use from the night
+// hours, when little else is running, and raises alerts when a night
+// deviates strongly: eg. a failed fridge lowers the standby power and a
+// water heater that is stuck on raises it.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The night runs from nightStartHour to nightEndHour local time.
+	nightStartHour = 1
+	nightEndHour   = 5
+
+	// Number of nights the baseline is computed over.
+	baselineNights = 14
+
+	// Minimum number of nights before anomalies are reported.
+	minBaselineNights = 5
+)
+
+// Measurements of one night.
+type nightUsage struct {
+	Date       string   `json:"date"`
+	StandbyW   float64  `json:"standby_w"` // lowest power seen
+	GasM3      *float64 `json:"gas_m3,omitempty"`
+	Complete   bool     `json:"complete"` // whether we saw the whole night
+	gasStart   *float64
+	gasEnd     *float64
+	firstSeen  time.Time
+	lastSeen   time.Time
+	inProgress bool
+}
+
+type baselineState struct {
+	Nights []nightUsage `json:"nights"` // completed, oldest first
+}
+
+// Report served at /api/v1/baseline.
+type baselineReport struct {
+	Nights     int         `json:"nights"` // complete nights in the baseline
+	StandbyW   *float64    `json:"standby_w"`
+	NightGasM3 *float64    `json:"night_gas_m3"`
+	LastNight  *nightUsage `json:"last_night"`
+	Tonight    *nightUsage `json:"tonight,omitempty"`
+}
+
+type anomalyDetector struct {
+	meter string
+	name  string
+	as    *alerts
+
+	lock    sync.Mutex
+	state   baselineState
+	tonight nightUsage
+}
+
+func newAnomalyDetector(meter string, as *alerts) *anomalyDetector {
+	a := &anomalyDetector{meter: meter, name: meter + "-baseline", as: as}
+	if err := loadState(a.name, &a.state); err != nil {
+		log.Printf("baseline: loading state: %v", err)
+	}
+	return a
+}
+
+func median(xs []float64) float64 {
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// Returns the baseline standby power and nightly gas use, if known.
+func (a *anomalyDetector) baseline() (int, *float64, *float64) {
+	var standby, gas []float64
+	for _, n := range a.state.Nights {
+		if !n.Complete {
+			continue
+		}
+		standby = append(standby, n.StandbyW)
+		if n.GasM3 != nil {
+			gas = append(gas, *n.GasM3)
+		}
+	}
+	var standbyW, gasM3 *float64
+	if len(standby) > 0 {
+		v := median(standby)
+		standbyW = &v
+	}
+	if len(gas) > 0 {
+		v := median(gas)
+		gasM3 = &v
+	}
+	return len(standby), standbyW, gasM3
+}
+
+func (a *anomalyDetector) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if !registersValid(t, stale) {
+		return
+	}
+	power := float64(t.Electricity.W) - float64(t.Electricity.WOut)
+	regs := readRegisters(t, stale)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	local := at.Local()
+	date := local.Format("2006-01-02")
+	hour := local.Hour()
+	night := hour >= nightStartHour && hour < nightEndHour
+
+	if a.tonight.inProgress && (!night || a.tonight.Date != date) {
+		a.finishNight(at)
+	}
+	if !night {
+		return
+	}
+
+	n := &a.tonight
+	if !n.inProgress {
+		*n = nightUsage{Date: date, StandbyW: power, inProgress: true,
+			firstSeen: local, gasStart: regs.Gas}
+	}
+	if power < n.StandbyW {
+		n.StandbyW = power
+	}
+	if regs.Gas != nil {
+		n.gasEnd = regs.Gas
+	}
+	n.lastSeen = local
+}
+
+// Adds the night that just ended to the baseline and checks it.
+func (a *anomalyDetector) finishNight(at time.Time) {
+	n := a.tonight
+	a.tonight = nightUsage{}
+
+	// We saw the whole night if we saw its first and last minutes.
+	start := time.Date(n.firstSeen.Year(), n.firstSeen.Month(),
+		n.firstSeen.Day(), nightStartHour, 0, 0, 0, n.firstSeen.Location())
+	end := start.Add((nightEndHour - nightStartHour) * time.Hour)
+	n.Complete = n.firstSeen.Sub(start) < 10*time.Minute &&
+		end.Sub(n.lastSeen) < 10*time.Minute
+	if n.gasStart != nil && n.gasEnd != nil {
+		gas := *n.gasEnd - *n.gasStart
+		n.GasM3 = &gas
+	}
+	n.inProgress = false
+
+	if n.Complete {
+		a.check(n, at)
+	}
+
+	a.state.Nights = append(a.state.Nights, n)
+	if len(a.state.Nights) > baselineNights {
+		a.state.Nights = a.state.Nights[len(a.state.Nights)-baselineNights:]
+	}
+	if err := saveState(a.name, a.state); err != nil {
+		log.Printf("baseline: saving state: %v", err)
+	}
+}
+
+// Compares a complete night with the baseline of the previous nights.
+func (a *anomalyDetector) check(n nightUsage, at time.Time) {
+	count, standby, gas := a.baseline()
+	if count < minBaselineNights {
+		return
+	}
+
+	a.as.set(a.meter, "standby-high",
+		n.StandbyW > *standby*1.5 && n.StandbyW-*standby > 50,
+		fmt.Sprintf("standby power of %.0f W last night is well above "+
+			"the usual %.0f W; is something stuck on?", n.StandbyW, *standby), at)
+	a.as.set(a.meter, "standby-low",
+		*standby >= 30 && n.StandbyW < *standby*0.5,
+		fmt.Sprintf("standby power of %.0f W last night is well below "+
+			"the usual %.0f W; did an appliance like a fridge fail?",
+			n.StandbyW, *standby), at)
+	if gas != nil && n.GasM3 != nil {
+		a.as.set(a.meter, "night-gas-high",
+			*n.GasM3 > 2*(*gas) && *n.GasM3-*gas > 0.2,
+			fmt.Sprintf("%.2f m3 gas used last night, against the usual "+
+				"%.2f m3", *n.GasM3, *gas), at)
+	}
+}
+
+func (a *anomalyDetector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	var report baselineReport
+	report.Nights, report.StandbyW, report.NightGasM3 = a.baseline()
+	if len(a.state.Nights) > 0 {
+		last := a.state.Nights[len(a.state.Nights)-1]
+		report.LastNight = &last
+	}
+	if a.tonight.inProgress {
+		tonight := a.tonight
+		report.Tonight = &tonight
+	}
+	a.lock.Unlock()
+	writeJSON(w, r, report)
+}
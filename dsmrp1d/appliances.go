@@ -0,0 +1,268 @@
+package main
+
+// Recognizes appliances, like a kettle or an oven, from the steps they
+// cause in the power drawn.  Disaggregation modules are pluggable: they
+// receive the power samples and report the runs of appliances.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of appliance runs to keep.
+	maxApplianceRuns = 1000
+
+	// Smallest step in power that is considered an appliance switching.
+	minApplianceStep = 300
+
+	// A step down matches a step up if they differ at most this fraction.
+	applianceStepTolerance = 0.25
+
+	// Forget appliances that were switched on longer ago than this.
+	maxApplianceRun = 12 * time.Hour
+)
+
+// A run of an appliance, reported when it is switched off.
+type applianceRun struct {
+	Appliance string    `json:"appliance"` // eg. kettle; "unknown" if not recognized
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	PowerW    float64   `json:"power_w"`
+	Duration  float64   `json:"duration_seconds"`
+	KWh       float64   `json:"kwh"`
+}
+
+// A disaggregation module.
+type disaggregator interface {
+	// Receives the net power in W of every telegram, about once a
+	// second for DSMR 5 meters, and returns the runs of appliances that
+	// ended.
+	sample(at time.Time, w float64) []applianceRun
+}
+
+// The available disaggregation modules by the name given to
+// -disaggregate.
+var disaggregators = map[string]func(sigs []applianceSignature) disaggregator{
+	"edges": func(sigs []applianceSignature) disaggregator {
+		return &edgeDetector{sigs: sigs}
+	},
+}
+
+// Describes the runs of an appliance.
+type applianceSignature struct {
+	name        string
+	minW, maxW  float64
+	maxDuration time.Duration // zero if unlimited
+}
+
+var defaultApplianceSignatures = []string{
+	"kettle:1800-3000:6m",
+	"microwave:700-1400:20m",
+	"oven:1800-3800",
+}
+
+// Parses a signature of the form name:minW-maxW[:maxDuration], eg.
+// "kettle:1800-3000:6m".
+func parseApplianceSignature(s string) (applianceSignature, error) {
+	var ret applianceSignature
+	bits := strings.Split(s, ":")
+	if len(bits) < 2 || len(bits) > 3 || bits[0] == "" {
+		return ret, errors.New(fmt.Sprintf(
+			"%s: expected name:minW-maxW[:maxDuration]", s))
+	}
+	ret.name = bits[0]
+	powers := strings.SplitN(bits[1], "-", 2)
+	var err error
+	if len(powers) != 2 {
+		return ret, errors.New(fmt.Sprintf("%s: expected minW-maxW", bits[1]))
+	}
+	if ret.minW, err = strconv.ParseFloat(powers[0], 64); err != nil {
+		return ret, err
+	}
+	if ret.maxW, err = strconv.ParseFloat(powers[1], 64); err != nil {
+		return ret, err
+	}
+	if len(bits) == 3 {
+		if ret.maxDuration, err = time.ParseDuration(bits[2]); err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// Returns the name of the first signature matching the run.
+func recognizeAppliance(sigs []applianceSignature, w float64,
+	duration time.Duration) string {
+	for _, sig := range sigs {
+		if w >= sig.minW && w <= sig.maxW &&
+			(sig.maxDuration == 0 || duration <= sig.maxDuration) {
+			return sig.name
+		}
+	}
+	return "unknown"
+}
+
+// The built-in disaggregation module: pairs a step up in power with a
+// later step down of about the same size, and recognizes the appliance
+// by the size of the step and the time between.
+type edgeDetector struct {
+	sigs []applianceSignature
+
+	prev    *float64
+	pending []applianceStep // steps up not yet matched
+}
+
+type applianceStep struct {
+	at time.Time
+	w  float64
+}
+
+func (d *edgeDetector) sample(at time.Time, w float64) []applianceRun {
+	prev := d.prev
+	d.prev = &w
+	if prev == nil {
+		return nil
+	}
+
+	i := 0
+	for i < len(d.pending) && at.Sub(d.pending[i].at) > maxApplianceRun {
+		i++
+	}
+	d.pending = d.pending[i:]
+
+	step := w - *prev
+	if step >= minApplianceStep {
+		d.pending = append(d.pending, applianceStep{at, step})
+		return nil
+	}
+	if step > -minApplianceStep {
+		return nil
+	}
+
+	best := -1
+	for i, up := range d.pending {
+		diff := math.Abs(up.w + step)
+		if diff > up.w*applianceStepTolerance {
+			continue
+		}
+		if best == -1 || diff < math.Abs(d.pending[best].w+step) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return nil
+	}
+	up := d.pending[best]
+	d.pending = append(d.pending[:best], d.pending[best+1:]...)
+	duration := at.Sub(up.at)
+	return []applianceRun{{
+		Appliance: recognizeAppliance(d.sigs, up.w, duration),
+		Start:     up.at,
+		End:       at,
+		PowerW:    up.w,
+		Duration:  duration.Seconds(),
+		KWh:       up.w * duration.Hours() / 1000,
+	}}
+}
+
+// Keeps the runs reported by the disaggregation module of a meter.
+type applianceLog struct {
+	name string
+	d    disaggregator // nil if disabled
+
+	lock      sync.Mutex
+	runs      []applianceRun // oldest first
+	listeners []func(run applianceRun)
+}
+
+func newApplianceLog(meter string, d disaggregator) *applianceLog {
+	l := &applianceLog{name: meter + "-appliances", d: d}
+	if err := loadState(l.name, &l.runs); err != nil {
+		log.Printf("appliances: loading state: %v", err)
+	}
+	return l
+}
+
+// Registers a function that is called for every run reported.
+func (l *applianceLog) listen(f func(run applianceRun)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.listeners = append(l.listeners, f)
+}
+
+func (l *applianceLog) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if l.d == nil || t.Electricity == nil {
+		return
+	}
+	power := float64(t.Electricity.W) - float64(t.Electricity.WOut)
+
+	l.lock.Lock()
+	runs := l.d.sample(at, power)
+	if len(runs) == 0 {
+		l.lock.Unlock()
+		return
+	}
+	l.runs = append(l.runs, runs...)
+	if len(l.runs) > maxApplianceRuns {
+		l.runs = l.runs[len(l.runs)-maxApplianceRuns:]
+	}
+	if err := saveState(l.name, l.runs); err != nil {
+		log.Printf("appliances: saving state: %v", err)
+	}
+	listeners := l.listeners
+	l.lock.Unlock()
+
+	for _, run := range runs {
+		for _, f := range listeners {
+			f(run)
+		}
+	}
+}
+
+// Serves the runs, newest first.  Supports the query parameters
+// since (RFC 3339), appliance and limit.
+func (l *applianceLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	var err error
+	q := r.URL.Query()
+	if s := q.Get("since"); s != "" {
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, http.StatusBadRequest, apiError{
+				Error: "since: " + err.Error()})
+			return
+		}
+	}
+	limit := maxApplianceRuns
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, apiError{
+				Error: "limit: expected a non-negative number"})
+			return
+		}
+	}
+	appliance := q.Get("appliance")
+
+	l.lock.Lock()
+	ret := []applianceRun{}
+	for i := len(l.runs) - 1; i >= 0 && len(ret) < limit; i-- {
+		run := l.runs[i]
+		if run.End.Before(since) ||
+			(appliance != "" && run.Appliance != appliance) {
+			continue
+		}
+		ret = append(ret, run)
+	}
+	l.lock.Unlock()
+
+	writeJSON(w, r, ret)
+}
@@ -0,0 +1,73 @@
+package main
+
+// Per listener authentication.
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Credentials that grant access to a listener.  If neither a password
+// nor a token is set, no authentication is required.
+type authConfig struct {
+	user     string
+	password string
+	token    string
+}
+
+func (a authConfig) enabled() bool {
+	return a.password != "" || a.token != ""
+}
+
+func (a authConfig) check(r *http.Request) bool {
+	if a.token != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") && secureCompare(
+			strings.TrimPrefix(header, "Bearer "), a.token) {
+			return true
+		}
+	}
+	if a.password != "" {
+		user, password, ok := r.BasicAuth()
+		if ok && secureCompare(user, a.user) &&
+			secureCompare(password, a.password) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefix of the routes that are only served on listeners that require
+// credentials: they expose the state, captures and configuration.
+const adminPrefix = "/api/v1/admin/"
+
+// Wraps h to require the configured credentials.  Without credentials,
+// the admin API is refused.
+func (a authConfig) wrap(h http.Handler) http.Handler {
+	if !a.enabled() {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(path.Clean(r.URL.Path)+"/", adminPrefix) {
+				http.Error(w, "The admin API requires a listener with "+
+					"a password or token", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.check(r) {
+			if a.password != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="dsmrp1d"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
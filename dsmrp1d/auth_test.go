@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminRequiresAuth(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, c := range []struct {
+		auth     authConfig
+		path     string
+		token    string
+		expected int
+	}{
+		{authConfig{}, "/api/v1/telegram", "", http.StatusOK},
+		{authConfig{}, "/api/v1/admin/status", "", http.StatusForbidden},
+		{authConfig{}, "/api/v1/admin/backup", "", http.StatusForbidden},
+		{authConfig{}, "/api/v1//admin/./backup", "", http.StatusForbidden},
+		{authConfig{}, "/api/v1/admin", "", http.StatusForbidden},
+		{authConfig{token: "s3cret"}, "/api/v1/admin/status", "",
+			http.StatusUnauthorized},
+		{authConfig{token: "s3cret"}, "/api/v1/admin/status", "s3cret",
+			http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		c.auth.wrap(ok).ServeHTTP(w, r)
+		if w.Code != c.expected {
+			t.Errorf("%s: got %d; expected %d", c.path, w.Code, c.expected)
+		}
+	}
+}
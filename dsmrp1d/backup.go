@@ -0,0 +1,241 @@
+package main
+
+// Backup and restore of the state and capture directories.
+//
+// A backup is a gzipped tar archive with the files of the state
+// directory under state/, those of the capture directory under
+// capture/ and the command line of the daemon, with its secrets
+// redacted, in config.json.
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Configuration stored in a backup.
+type backupConfig struct {
+	Args    []string  `json:"args"`
+	Created time.Time `json:"created"`
+}
+
+// Writes a backup of the given directories to w.  Files that are still
+// being appended to (like the current capture) are included up to their
+// size at the start of the backup.
+func writeBackup(w io.Writer, dirs map[string]string, args []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = redactSecrets(arg)
+	}
+	cfg, err := json.MarshalIndent(backupConfig{redacted, time.Now()},
+		"", "  ")
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{
+		Name:    "config.json",
+		Mode:    0644,
+		Size:    int64(len(cfg)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(cfg); err != nil {
+		return err
+	}
+
+	for prefix, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if !fi.Mode().IsRegular() || strings.HasSuffix(fi.Name(), ".tmp") {
+				continue
+			}
+			if err = backupFile(tw, prefix+"/"+fi.Name(),
+				filepath.Join(dir, fi.Name()), fi); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func backupFile(tw *tar.Writer, name, path string, fi os.FileInfo) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil // removed in the meantime
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(fi.Mode().Perm()),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, fi.Size())
+	return err
+}
+
+// Restores a backup read from r into the given directories.  Existing
+// files are only overwritten if force is set.
+func restoreBackup(r io.Reader, dirs map[string]string, force bool) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == "config.json" {
+			var cfg backupConfig
+			if err = json.NewDecoder(tr).Decode(&cfg); err == nil {
+				log.Printf("Backup of %v was made with: %s", cfg.Created,
+					strings.Join(cfg.Args, " "))
+			}
+			continue
+		}
+		bits := strings.SplitN(hdr.Name, "/", 2)
+		if len(bits) != 2 || strings.ContainsAny(bits[1], `/\`) ||
+			bits[1] == ".." || hdr.Typeflag != tar.TypeReg {
+			return errors.New(fmt.Sprintf("unexpected entry: %s", hdr.Name))
+		}
+		dir, ok := dirs[bits[0]]
+		if !ok {
+			return errors.New(fmt.Sprintf("unexpected entry: %s", hdr.Name))
+		}
+		if dir == "" {
+			log.Printf("Skipping %s: no -%s directory given", hdr.Name,
+				map[string]string{"state": "state-dir", "capture": "capture"}[bits[0]])
+			continue
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, bits[1])
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(path, flags, os.FileMode(hdr.Mode).Perm())
+		if os.IsExist(err) {
+			return errors.New(fmt.Sprintf(
+				"%s already exists; use -force to overwrite", path))
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+	}
+}
+
+// Serves a backup of the given directories.  If the backup fails after
+// part of it has been sent, the connection is aborted, so that the
+// client does not mistake the truncated archive for a backup.
+func backupHandler(dirs map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noWriteTimeout(w)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"dsmrp1d-"+
+			time.Now().Format("20060102T150405")+".tar.gz\"")
+		sw := &statusWriter{ResponseWriter: w}
+		err := writeBackup(sw, dirs, os.Args[1:])
+		if err == nil {
+			return
+		}
+		log.Printf("backup: %v", err)
+		if sw.code != 0 {
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Del("Content-Disposition")
+		writeError(w, http.StatusInternalServerError, apiError{
+			Error: err.Error()})
+	})
+}
+
+// Runs the backup and restore subcommands:
+//
+//	dsmrp1d backup [-state-dir dir] [-capture dir] file
+//	dsmrp1d restore [-state-dir dir] [-capture dir] [-force] file
+//
+// The daemon should not be running during a restore, as it would
+// overwrite the restored state with its own.
+func runBackup(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	state := fs.String("state-dir", "", "state directory of the daemon")
+	capture := fs.String("capture", "", "capture directory of the daemon")
+	force := fs.Bool("force", false, "overwrite existing files on restore")
+	logFormat := fs.String("log-format", "text", logFormatUsage)
+	fs.Parse(args)
+	if err := setLogFormat(*logFormat); err != nil {
+		log.Fatalf("-log-format: %v", err)
+	}
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: dsmrp1d %s [-state-dir dir] [-capture dir] file", cmd)
+	}
+	dirs := map[string]string{"state": *state, "capture": *capture}
+
+	if cmd == "backup" {
+		f, err := os.Create(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = writeBackup(f, dirs, nil)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err = restoreBackup(f, dirs, *force); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+// Tracks consumption this month against a monthly budget, projecting it
+// to the end of the month.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Do not raise alerts before we have seen this much of the month, as
+// the projection is too noisy.
+const minBudgetElapsed = 24 * time.Hour
+
+// Monthly budgets; zero if not set.
+type budgetConfig struct {
+	kWh float64 // net electricity: import minus export
+	m3  float64 // gas
+}
+
+type budgetProgress struct {
+	Consumed  float64 `json:"consumed"`
+	Budget    float64 `json:"budget"`
+	Fraction  float64 `json:"fraction"` // of the budget consumed
+	Projected float64 `json:"projected"`
+	Over      bool    `json:"over"` // whether the projection exceeds the budget
+}
+
+// Report served at /api/v1/budget.
+type budgetReport struct {
+	Month       string          `json:"month"`
+	Partial     bool            `json:"partial"` // whether we missed the start of the month
+	Elapsed     float64         `json:"elapsed"` // fraction of the month
+	Electricity *budgetProgress `json:"electricity_kwh,omitempty"`
+	Gas         *budgetProgress `json:"gas_m3,omitempty"`
+}
+
+type budgetTracker struct {
+	meter string
+	cfg   budgetConfig
+	regs  *registerTracker
+	as    *alerts
+
+	lock   sync.Mutex
+	report budgetReport
+}
+
+func newBudgetTracker(meter string, cfg budgetConfig, regs *registerTracker,
+	as *alerts) *budgetTracker {
+	return &budgetTracker{meter: meter, cfg: cfg, regs: regs, as: as}
+}
+
+func (cfg budgetConfig) enabled() bool {
+	return cfg.kWh > 0 || cfg.m3 > 0
+}
+
+func monthBounds(t time.Time) (time.Time, time.Time) {
+	t = t.Local()
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 1, 0)
+}
+
+func newBudgetProgress(consumed, budget, scale float64) *budgetProgress {
+	projected := consumed * scale
+	return &budgetProgress{
+		Consumed:  consumed,
+		Budget:    budget,
+		Fraction:  consumed / budget,
+		Projected: projected,
+		Over:      projected > budget,
+	}
+}
+
+func (b *budgetTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if !b.cfg.enabled() {
+		return
+	}
+	_, month, _, monthStart := b.regs.usage()
+	start, end := monthBounds(at)
+
+	// If we missed the start of the month, we project from when we
+	// started tracking.
+	from := start
+	if monthStart.Partial && monthStart.At.After(start) {
+		from = monthStart.At
+	}
+	elapsed := at.Sub(from)
+	if elapsed <= 0 {
+		return
+	}
+	scale := float64(end.Sub(from)) / float64(elapsed)
+
+	report := budgetReport{
+		Month:   monthStart.Period,
+		Partial: monthStart.Partial,
+		Elapsed: float64(at.Sub(start)) / float64(end.Sub(start)),
+	}
+	alert := func(name string, p *budgetProgress, unit string) {
+		b.as.set(b.meter, name, p.Over && elapsed >= minBudgetElapsed,
+			fmt.Sprintf("projected %.0f %s this month exceeds the budget "+
+				"of %.0f %s", p.Projected, unit, p.Budget, unit), at)
+	}
+	if b.cfg.kWh > 0 {
+		report.Electricity = newBudgetProgress(
+			month.importKWh()-month.exportKWh(), b.cfg.kWh, scale)
+		alert("budget-electricity", report.Electricity, "kWh")
+	}
+	if b.cfg.m3 > 0 && month.Gas != nil {
+		report.Gas = newBudgetProgress(*month.Gas, b.cfg.m3, scale)
+		alert("budget-gas", report.Gas, "m3")
+	}
+
+	b.lock.Lock()
+	b.report = report
+	b.lock.Unlock()
+}
+
+func (b *budgetTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.lock.Lock()
+	report := b.report
+	b.lock.Unlock()
+	writeJSON(w, r, report)
+}
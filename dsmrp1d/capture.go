@@ -0,0 +1,135 @@
+package main
+
+// Records raw telegrams to rotating capture files.
+
+import (
+	"compress/gzip"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Configuration of the capture files.
+type captureConfig struct {
+	dir     string
+	rotate  time.Duration // start a new file after this long; 0 for never
+	maxSize int64         // start a new file after this many bytes; 0 for never
+	gzip    bool
+
+	// Only record while triggered, eg. by an MQTT command.
+	triggerOnly bool
+}
+
+// Writes the telegrams of a single meter to capture files named
+// <meter>-<start time>.p1 (or .p1.gz) in the configured directory.
+type captureFile struct {
+	cfg  captureConfig
+	name string
+
+	lock    sync.Mutex
+	f       *os.File
+	gz      *gzip.Writer
+	cw      *dsmrp1.CaptureWriter
+	opened  time.Time
+	written int64
+	until   time.Time // end of the current trigger
+}
+
+// Records telegrams until the given time, even if triggerOnly is set.
+func (c *captureFile) trigger(until time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.until = until
+}
+
+func newCaptureFile(cfg captureConfig, name string) *captureFile {
+	return &captureFile{cfg: cfg, name: name}
+}
+
+func (c *captureFile) open(at time.Time) error {
+	path := filepath.Join(c.cfg.dir,
+		c.name+"-"+at.Format("20060102T150405")+".p1")
+	if c.cfg.gzip {
+		path += ".gz"
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.f = f
+	var w io.Writer = f
+	if c.cfg.gzip {
+		c.gz = gzip.NewWriter(f)
+		w = c.gz
+	}
+	c.cw = dsmrp1.NewCaptureWriter(w)
+	c.opened = at
+	c.written = 0
+	return nil
+}
+
+func (c *captureFile) closeFile() {
+	if c.f == nil {
+		return
+	}
+	if c.gz != nil {
+		if err := c.gz.Close(); err != nil {
+			log.Printf("capture %s: %v", c.name, err)
+		}
+		c.gz = nil
+	}
+	if err := c.f.Close(); err != nil {
+		log.Printf("capture %s: %v", c.name, err)
+	}
+	c.f = nil
+}
+
+func (c *captureFile) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	c.write(at, t.Raw)
+}
+
+// Appends the raw telegram, rotating the file first if required.
+func (c *captureFile) write(at time.Time, raw []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cfg.triggerOnly && at.After(c.until) {
+		// Start a new file with the next trigger.
+		c.closeFile()
+		return
+	}
+
+	if c.f != nil && ((c.cfg.rotate != 0 && at.Sub(c.opened) >= c.cfg.rotate) ||
+		(c.cfg.maxSize != 0 && c.written >= c.cfg.maxSize)) {
+		c.closeFile()
+	}
+	if c.f == nil {
+		if err := c.open(at); err != nil {
+			log.Printf("capture %s: %v", c.name, err)
+			return
+		}
+	}
+
+	err := c.cw.Write(at, raw)
+	if err == nil && c.gz != nil {
+		// Flush, so that a crash does not lose more than a telegram.
+		err = c.gz.Flush()
+	}
+	if err != nil {
+		log.Printf("capture %s: %v", c.name, err)
+		return
+	}
+	c.written += int64(len(raw))
+}
+
+func (c *captureFile) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closeFile()
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+// Estimates the CO2 emitted for the imported electricity, using the
+// carbon intensity of the grid from a feed such as electricitymaps.com.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of days of which the emissions are kept.
+	co2Days = 31
+
+	// Do not use an intensity older than this.
+	co2MaxAge = 3 * time.Hour
+)
+
+// Configuration of the carbon intensity feed.  The feed is polled for
+// the current intensity in gCO2eq/kWh, eg.
+// https://api.electricitymap.org/v3/carbon-intensity/latest?zone=NL
+type co2FeedConfig struct {
+	url      string        // JSON endpoint to poll
+	token    string        // sent in the auth-token header, if set
+	field    string        // dotted path to the value in the JSON
+	interval time.Duration // between polls
+}
+
+func (c co2FeedConfig) enabled() bool {
+	return c.url != ""
+}
+
+// The latest carbon intensity of the grid, shared by all meters.
+type gridIntensity struct {
+	lock    sync.Mutex
+	gPerKWh *float64
+	at      time.Time
+}
+
+// Returns the current intensity in gCO2eq/kWh, if known.
+func (g *gridIntensity) get(now time.Time) (*float64, time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.gPerKWh == nil || now.Sub(g.at) > co2MaxAge {
+		return nil, g.at
+	}
+	v := *g.gPerKWh
+	return &v, g.at
+}
+
+func (g *gridIntensity) set(gPerKWh float64, at time.Time) {
+	g.lock.Lock()
+	g.gPerKWh = &gPerKWh
+	g.at = at
+	g.lock.Unlock()
+}
+
+// Imported electricity and its estimated emissions over a period.
+type co2Period struct {
+	Date      string  `json:"date"` // eg. 2026-10-16 or 2026-10
+	ImportKWh float64 `json:"import_kwh"`
+
+	// Imported electricity of which the intensity was unknown: it is
+	// not included in the emissions.
+	UnknownKWh float64 `json:"unknown_kwh"`
+	CO2Kg      float64 `json:"co2_kg"`
+
+	// Average intensity of the imported electricity in gCO2eq/kWh.
+	GPerKWh *float64 `json:"g_per_kwh"`
+}
+
+func (p *co2Period) add(kWh float64, gPerKWh *float64) {
+	p.ImportKWh += kWh
+	if gPerKWh == nil {
+		p.UnknownKWh += kWh
+		return
+	}
+	p.CO2Kg += kWh * *gPerKWh / 1000
+}
+
+func (p co2Period) withAverage() co2Period {
+	if known := p.ImportKWh - p.UnknownKWh; known > 0 {
+		avg := p.CO2Kg * 1000 / known
+		p.GPerKWh = &avg
+	}
+	return p
+}
+
+type co2State struct {
+	Days    []co2Period `json:"days"` // oldest first; the last might be today
+	Month   co2Period   `json:"month"`
+	LastKWh *float64    `json:"last_kwh"` // import register last seen
+	LastID  string      `json:"last_id"`  // of the meter
+}
+
+// Report served at /api/v1/co2.
+type co2Report struct {
+	GPerKWh   *float64    `json:"g_per_kwh"` // current intensity
+	UpdatedAt *time.Time  `json:"updated_at"`
+	Today     *co2Period  `json:"today"`
+	ThisMonth *co2Period  `json:"this_month"`
+	Days      []co2Period `json:"days"`
+}
+
+type co2Tracker struct {
+	name string
+	grid *gridIntensity
+
+	lock  sync.Mutex
+	state co2State
+	saved time.Time
+}
+
+func newCO2Tracker(meter string, grid *gridIntensity) *co2Tracker {
+	c := &co2Tracker{name: meter + "-co2", grid: grid}
+	if err := loadState(c.name, &c.state); err != nil {
+		log.Printf("co2: loading state: %v", err)
+	}
+	return c
+}
+
+func (c *co2Tracker) observe(t *dsmrp1.Telegram, at time.Time, stale []string) {
+	if c.grid == nil || !registersValid(t, stale) {
+		return
+	}
+	kWh := readRegisters(t, stale).importKWh()
+	gPerKWh, _ := c.grid.get(at)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// No usage across a meter swap or reset.
+	var delta float64
+	if c.state.LastKWh != nil && kWh >= *c.state.LastKWh &&
+		c.state.LastID == t.ID {
+		delta = kWh - *c.state.LastKWh
+	}
+	c.state.LastKWh, c.state.LastID = &kWh, t.ID
+
+	local := at.Local()
+	date := local.Format("2006-01-02")
+	month := local.Format("2006-01")
+	changed := false
+	days := c.state.Days
+	if len(days) == 0 || days[len(days)-1].Date != date {
+		days = append(days, co2Period{Date: date})
+		if len(days) > co2Days {
+			days = days[len(days)-co2Days:]
+		}
+		changed = true
+	}
+	if c.state.Month.Date != month {
+		c.state.Month = co2Period{Date: month}
+		changed = true
+	}
+	days[len(days)-1].add(delta, gPerKWh)
+	c.state.Month.add(delta, gPerKWh)
+	c.state.Days = days
+
+	if changed || at.Sub(c.saved) >= time.Minute {
+		if err := saveState(c.name, c.state); err != nil {
+			log.Printf("co2: saving state: %v", err)
+		}
+		c.saved = at
+	}
+}
+
+func (c *co2Tracker) report(now time.Time) co2Report {
+	var report co2Report
+	if c.grid != nil {
+		var at time.Time
+		report.GPerKWh, at = c.grid.get(now)
+		if !at.IsZero() {
+			report.UpdatedAt = &at
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	date := now.Local().Format("2006-01-02")
+	for _, d := range c.state.Days {
+		d = d.withAverage()
+		report.Days = append(report.Days, d)
+		if d.Date == date {
+			report.Today = &report.Days[len(report.Days)-1]
+		}
+	}
+	if c.state.Month.Date == now.Local().Format("2006-01") {
+		month := c.state.Month.withAverage()
+		report.ThisMonth = &month
+	}
+	return report
+}
+
+func (c *co2Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, c.report(clock.Now()))
+}
+
+// Extracts the intensity from a feed payload: either a plain number or
+// JSON with the value at the configured field.
+func (c co2FeedConfig) parse(payload []byte) (float64, error) {
+	if c.field == "" {
+		return strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	}
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return 0, err
+	}
+	path, v := lookupField(tree, strings.Split(c.field, "."))
+	n, ok := v.(json.Number)
+	if path == nil || !ok {
+		return 0, errors.New(fmt.Sprintf("no number at %s", c.field))
+	}
+	return n.Float64()
+}
+
+// Polls the feed for the intensity.
+func (c co2FeedConfig) poll(g *gridIntensity) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		gPerKWh, err := c.fetch(client)
+		if err != nil {
+			log.Printf("co2: %s: %v", c.url, err)
+		} else {
+			g.set(gPerKWh, clock.Now())
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+func (c co2FeedConfig) fetch(client *http.Client) (float64, error) {
+	req, err := http.NewRequest("GET", c.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("auth-token", c.token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(resp.Status)
+	}
+	return c.parse(body)
+}
@@ -0,0 +1,200 @@
+package main
+
+// Submits the readings to a local collectd through the plain text
+// protocol of its unixsock plugin:
+//
+//	-sink collectd[:/path/to/socket[?options]]
+//
+// Recognized options are interval, between submissions (default 10s),
+// and host, the host name of the values (default the host name).
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCollectdSocket = "/var/run/collectd-unixsock"
+
+func init() {
+	registerSink("collectd", newCollectdSink)
+}
+
+type collectdSink struct {
+	path     string
+	host     string
+	interval time.Duration
+	plugin   string
+
+	lock sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	last time.Time
+}
+
+func newCollectdSink(config string) (Sink, error) {
+	s := &collectdSink{path: defaultCollectdSocket, interval: 10 * time.Second}
+	bits := strings.SplitN(config, "?", 2)
+	if bits[0] != "" {
+		s.path = bits[0]
+	}
+	if len(bits) == 2 {
+		opts, err := url.ParseQuery(bits[1])
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range opts {
+			value := values[len(values)-1]
+			switch key {
+			case "interval":
+				s.interval, err = time.ParseDuration(value)
+				if err == nil && s.interval < time.Second {
+					err = errors.New("interval should be at least 1s")
+				}
+			case "host":
+				s.host = value
+			default:
+				err = errors.New(fmt.Sprintf("unknown option %s", key))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.host == "" {
+		var err error
+		if s.host, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *collectdSink) Start(meter string) error {
+	s.plugin = "dsmrp1-" + meter
+	return nil
+}
+
+// A value in terms of collectd: a type from its types.db and an
+// instance.
+type collectdValue struct {
+	typ, instance string
+	value         float64
+}
+
+func collectdValues(t *dsmrp1.Telegram) []collectdValue {
+	var ret []collectdValue
+	add := func(typ, instance string, v float64) {
+		ret = append(ret, collectdValue{typ, instance, v})
+	}
+	// The energy type of collectd is in Wh.  Round off the noise of the
+	// multiplication, as the meters report whole Wh.
+	wh := func(kWh float32) float64 {
+		return math.Round(dsmrp1.Float64(kWh)*1e6) / 1e3
+	}
+	if e := t.Electricity; e != nil {
+		add("energy", "import_high", wh(e.KWh))
+		add("energy", "import_low", wh(e.KWhLow))
+		add("energy", "export_high", wh(e.KWhOut))
+		add("energy", "export_low", wh(e.KWhOutLow))
+		add("power", "import", dsmrp1.Float64(e.W))
+		add("power", "export", dsmrp1.Float64(e.WOut))
+	}
+	for i, p := range t.Phases() {
+		phase := fmt.Sprintf("L%d", i+1)
+		if p.Voltage != nil {
+			add("voltage", phase, dsmrp1.Float64(*p.Voltage))
+		}
+		add("current", phase, dsmrp1.Float64(p.Current))
+		add("power", phase+"_import", dsmrp1.Float64(p.Power))
+		add("power", phase+"_export", dsmrp1.Float64(p.PowerOut))
+	}
+	if t.Gas != nil {
+		add("gauge", "gas_m3", dsmrp1.Float64(t.Gas.LastRecord.Value))
+	}
+	return ret
+}
+
+func (s *collectdSink) HandleTelegram(t *dsmrp1.Telegram, at time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// collectd expects a value per interval, not one per second.
+	if at.Sub(s.last) < s.interval {
+		return nil
+	}
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("unix", s.path, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn, s.r = conn, bufio.NewReader(conn)
+	}
+
+	for _, v := range collectdValues(t) {
+		err := s.putval(v, at)
+		if _, ok := err.(collectdError); ok {
+			// collectd refused the value, eg. because its types.db
+			// lacks the type: retrying will not help.
+			log.Printf("collectd sink: %s-%s: %v", v.typ, v.instance, err)
+			continue
+		}
+		if err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	s.last = at
+	return nil
+}
+
+// An error returned by collectd itself.
+type collectdError string
+
+func (e collectdError) Error() string {
+	return "collectd: " + string(e)
+}
+
+// Submits a value and reads the response, which is a status followed by
+// a message, eg. "0 Success: 1 value has been dispatched."
+func (s *collectdSink) putval(v collectdValue, at time.Time) error {
+	s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err := fmt.Fprintf(s.conn, "PUTVAL \"%s/%s/%s-%s\" interval=%d %d:%s\n",
+		s.host, s.plugin, v.typ, v.instance, int(s.interval.Seconds()),
+		at.Unix(), strconv.FormatFloat(v.value, 'f', -1, 64))
+	if err != nil {
+		return err
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	bits := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if status, err := strconv.Atoi(bits[0]); err != nil || status < 0 {
+		return collectdError(strings.TrimSpace(line))
+	}
+	return nil
+}
+
+func (s *collectdSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
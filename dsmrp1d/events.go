@@ -0,0 +1,184 @@
+package main
+
+// Keeps a log of power quality events: power failures and voltage
+// sags and swells.  Meter swaps, register resets and changes of the
+// position of the breaker or gas valve are logged as well.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Number of events to keep.
+const maxEvents = 1000
+
+type event struct {
+	Type  string `json:"type"` // power_failure, long_power_failure, ...
+	Phase string `json:"phase,omitempty"`
+
+	// For events derived from counters, this is when the change was
+	// noticed.  For events from the meter's failure log, this is when
+	// the failure started.
+	Time     time.Time `json:"time"`
+	Duration *float64  `json:"duration_seconds,omitempty"`
+
+	// "counter" if derived from a counter increase, "log" if from the
+	// meter's power failure log, "registers" for meter_swap and
+	// register_reset and "telegram" for breaker_position and
+	// valve_position.
+	Source string `json:"source"`
+
+	Detail string `json:"detail,omitempty"`
+}
+
+type eventLog struct {
+	name string
+
+	lock   sync.Mutex
+	events []event // oldest first
+	prev   *dsmrp1.Telegram
+}
+
+func newEventLog(meter string) *eventLog {
+	l := &eventLog{name: meter + "-events"}
+	if err := loadState(l.name, &l.events); err != nil {
+		log.Printf("events: loading state: %v", err)
+	}
+	return l
+}
+
+// Adds events for each time a counter increased.
+func counterEvents(typ, phase string, prev, cur int32, at time.Time) []event {
+	var ret []event
+	for i := prev; i < cur; i++ {
+		ret = append(ret, event{Type: typ, Phase: phase, Time: at,
+			Source: "counter"})
+	}
+	return ret
+}
+
+func (l *eventLog) observe(t *dsmrp1.Telegram, at time.Time, stale []string) {
+	// The counters of a stale section are zero or missing.
+	if t.Electricity == nil || isStale(stale, "Electricity") {
+		return
+	}
+	e := t.Electricity
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var added []event
+
+	// Long power failures are taken from the log, which also tells
+	// their duration.
+	for _, f := range e.PowerFailureEvents {
+		end, err := dsmrp1.ParseTimestamp(f.End)
+		if err != nil {
+			continue
+		}
+		duration := float64(f.Duration)
+		start := end.Add(-time.Duration(duration * float64(time.Second)))
+		if l.hasLogged(start) {
+			continue
+		}
+		added = append(added, event{Type: "long_power_failure", Time: start,
+			Duration: &duration, Source: "log"})
+	}
+
+	if l.prev != nil && l.prev.Electricity != nil && l.prev.ID == t.ID {
+		pe := l.prev.Electricity
+		added = append(added, counterEvents("power_failure", "",
+			pe.PowerFailures, e.PowerFailures, at)...)
+		if len(e.PowerFailureEvents) == 0 {
+			added = append(added, counterEvents("long_power_failure", "",
+				pe.LongPowerFailures, e.LongPowerFailures, at)...)
+		}
+		prevPhases := l.prev.Phases()
+		for i, p := range t.Phases() {
+			if i >= len(prevPhases) {
+				break
+			}
+			phase := fmt.Sprintf("L%d", i+1)
+			added = append(added, counterEvents("voltage_sag", phase,
+				prevPhases[i].VoltageSags, p.VoltageSags, at)...)
+			added = append(added, counterEvents("voltage_swell", phase,
+				prevPhases[i].VoltageSwells, p.VoltageSwells, at)...)
+		}
+	}
+	l.prev = t
+
+	l.add(added)
+}
+
+// Records an event noticed elsewhere.
+func (l *eventLog) record(ev event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.add([]event{ev})
+}
+
+func (l *eventLog) add(added []event) {
+	if len(added) == 0 {
+		return
+	}
+	l.events = append(l.events, added...)
+	if len(l.events) > maxEvents {
+		l.events = l.events[len(l.events)-maxEvents:]
+	}
+	if err := saveState(l.name, l.events); err != nil {
+		log.Printf("events: saving state: %v", err)
+	}
+}
+
+// Returns whether a failure from the log starting at the given time
+// was already recorded.
+func (l *eventLog) hasLogged(start time.Time) bool {
+	for _, ev := range l.events {
+		if ev.Source == "log" && ev.Time.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serves the events, newest first.  Supports the query parameters
+// since (RFC 3339), type and limit.
+func (l *eventLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	var err error
+	q := r.URL.Query()
+	if s := q.Get("since"); s != "" {
+		if since, err = time.Parse(time.RFC3339, s); err != nil {
+			writeError(w, http.StatusBadRequest, apiError{
+				Error: "since: " + err.Error()})
+			return
+		}
+	}
+	limit := maxEvents
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, apiError{
+				Error: "limit: expected a non-negative number"})
+			return
+		}
+	}
+	typ := q.Get("type")
+
+	l.lock.Lock()
+	ret := []event{}
+	for i := len(l.events) - 1; i >= 0 && len(ret) < limit; i-- {
+		ev := l.events[i]
+		if ev.Time.Before(since) || (typ != "" && ev.Type != typ) {
+			continue
+		}
+		ret = append(ret, ev)
+	}
+	l.lock.Unlock()
+
+	writeJSON(w, r, ret)
+}
@@ -0,0 +1,180 @@
+package main
+
+// Export of the captured history of a meter as CSV or Parquet.
+
+import (
+	"encoding/csv"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/history"
+	"github.com/bwesterb/go-dsmrp1/parquet"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Serves the telegrams in the capture files of a meter, or their
+// aggregates (see retention.go).
+type exporter struct {
+	dir  string
+	name string
+	re   *regexp.Regexp
+}
+
+func newExporter(dir, name string) *exporter {
+	return &exporter{
+		dir:  dir,
+		name: name,
+		re: regexp.MustCompile("^" + regexp.QuoteMeta(name) +
+			`-(\d{8}T\d{6})\.p1(\.gz)?$`),
+	}
+}
+
+type captureInfo struct {
+	path  string
+	start time.Time
+}
+
+// Returns the capture files of the meter sorted by start time.
+func (e *exporter) captures() ([]captureInfo, error) {
+	fis, err := ioutil.ReadDir(e.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []captureInfo
+	for _, fi := range fis {
+		m := e.re.FindStringSubmatch(fi.Name())
+		if m == nil {
+			continue
+		}
+		start, err := time.ParseInLocation("20060102T150405", m[1], time.Local)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, captureInfo{filepath.Join(e.dir, fi.Name()), start})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].start.Before(ret[j].start)
+	})
+	return ret, nil
+}
+
+// Calls f for each captured telegram received in [from, to).
+func (e *exporter) each(from, to time.Time, f func(history.Row) error) error {
+	cs, err := e.captures()
+	if err != nil {
+		return err
+	}
+	for i, c := range cs {
+		if !c.start.Before(to) {
+			break
+		}
+		// Skip files that ended before from.
+		if i+1 < len(cs) && !cs[i+1].start.After(from) {
+			continue
+		}
+		if err = e.eachIn(c.path, from, to, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exporter) eachIn(path string, from, to time.Time,
+	f func(history.Row) error) error {
+	rc, err := dsmrp1.OpenCapture(path)
+	if err != nil {
+		log.Printf("export: %v", err)
+		return nil
+	}
+	defer rc.Close()
+	cr := dsmrp1.NewCaptureReader(rc)
+	for {
+		raw, at, err := cr.Next()
+		if err != nil {
+			// Either the end of the file, or the end of the part
+			// that has been written of a capture in progress.
+			return nil
+		}
+		if at.Before(from) || !at.Before(to) {
+			continue
+		}
+		t, errs := dsmrp1.ParseTelegramWith(raw, parseOptions)
+		if errs != nil {
+			continue
+		}
+		if err = f(history.NewRow(t, at)); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := history.ParseTime(q.Get("from"), time.Time{})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, apiError{Error: "invalid from"})
+		return
+	}
+	to, err := history.ParseTime(q.Get("to"), clock.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, apiError{Error: "invalid to"})
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "parquet" {
+		writeError(w, http.StatusBadRequest, apiError{
+			Error: "format should be csv or parquet"})
+		return
+	}
+	each := e.each
+	switch resolution := q.Get("resolution"); resolution {
+	case "", "raw":
+	case "minute", "day":
+		each = func(from, to time.Time, f func(history.Row) error) error {
+			return e.eachAggregate(resolution, from, to, f)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, apiError{
+			Error: "resolution should be raw, minute or day"})
+		return
+	}
+	if _, err = e.captures(); err != nil {
+		writeError(w, http.StatusInternalServerError, apiError{
+			Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+
+		e.name+"-export."+format+"\"")
+	noWriteTimeout(w)
+
+	if format == "parquet" {
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		pw := parquet.NewWriter(w, history.Columns)
+		err = each(from, to, func(row history.Row) error {
+			return pw.Append(row.At, row.Values())
+		})
+		if err == nil {
+			err = pw.Close()
+		}
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write(history.Columns)
+		err = each(from, to, func(row history.Row) error {
+			return cw.Write(row.Record())
+		})
+		cw.Flush()
+	}
+	if err != nil {
+		log.Printf("export: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+// Field selection and flattening of JSON responses, for clients that
+// only want a few values.
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Writes v as JSON taking into account the "fields" and "flatten"
+// query parameters.
+//
+// fields is a comma separated list of dotted paths, eg.
+// "electricity.w,gas.last_record.value".  Path components are matched
+// case-insensitively with underscores ignored, so "last_record" selects
+// "LastRecord".  With flatten=true the result is a single object
+// mapping dotted paths to values.  Units and rounding are applied
+// according to output.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var err error
+	var body []byte
+
+	q := r.URL.Query()
+	fields := q.Get("fields")
+	flatten, _ := strconv.ParseBool(q.Get("flatten"))
+
+	if fields == "" && !flatten && !output.enabled() {
+		body, err = json.Marshal(v)
+	} else {
+		body, err = selectJSON(v, fields, flatten)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func selectJSON(v interface{}, fields string, flatten bool) ([]byte, error) {
+	var tree interface{}
+
+	// Convert v to a tree of maps and slices, so we do not have to
+	// deal with reflection on arbitrary types.
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err = dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	tree = output.apply(tree)
+
+	if fields == "" && !flatten {
+		return json.Marshal(tree)
+	}
+	if fields == "" {
+		flat := make(map[string]interface{})
+		flattenInto(flat, "", tree)
+		return json.Marshal(flat)
+	}
+
+	ret := make(map[string]interface{})
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		path, value := lookupField(tree, strings.Split(field, "."))
+		if flatten {
+			if _, ok := value.(map[string]interface{}); ok {
+				flattenInto(ret, field, value)
+			} else if _, ok := value.([]interface{}); ok {
+				flattenInto(ret, field, value)
+			} else {
+				ret[field] = value
+			}
+			continue
+		}
+		if path == nil {
+			continue
+		}
+		insertField(ret, path, value)
+	}
+	return json.Marshal(ret)
+}
+
+// Normalizes a field name for comparison.
+func normalizeField(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// Looks up the value at the given path.  Returns the path with the
+// actual keys used in the tree, or nil if the path does not exist.
+//
+// As keys might contain dots themselves (eg. OBIS references in Other),
+// we also try to match several path components at once.
+func lookupField(tree interface{}, path []string) ([]string, interface{}) {
+	if len(path) == 0 {
+		return []string{}, tree
+	}
+	switch node := tree.(type) {
+	case map[string]interface{}:
+		for n := 1; n <= len(path); n++ {
+			want := normalizeField(strings.Join(path[:n], "."))
+			for key, child := range node {
+				if normalizeField(key) != want {
+					continue
+				}
+				rest, value := lookupField(child, path[n:])
+				if rest != nil {
+					return append([]string{key}, rest...), value
+				}
+			}
+		}
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, nil
+		}
+		rest, value := lookupField(node[i], path[1:])
+		if rest != nil {
+			return append([]string{path[0]}, rest...), value
+		}
+	}
+	return nil, nil
+}
+
+// Sets the value at the given path in the nested map.
+func insertField(tree map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := tree[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			tree[key] = child
+		}
+		tree = child
+	}
+	tree[path[len(path)-1]] = value
+}
+
+// Adds all leaves of the tree to flat keyed by their dotted path.
+func flattenInto(flat map[string]interface{}, prefix string, tree interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch node := tree.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			flattenInto(flat, join(key), child)
+		}
+	case []interface{}:
+		for i, child := range node {
+			flattenInto(flat, join(strconv.Itoa(i)), child)
+		}
+	default:
+		flat[prefix] = tree
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+// Estimates the gas flow from successive gas meter readings.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of gas records to keep: an hour worth on DSMR 5 meters.
+const maxGasRecords = 13
+
+type gasReading struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value_m3"`
+}
+
+// Report served at /api/v1/gas/flow.
+type gasFlowReport struct {
+	// Flow between the two most recent readings.
+	FlowDm3PerHour *float64 `json:"flow_dm3_per_hour"`
+
+	// Average flow over the readings of (at most) the last hour.
+	AverageDm3PerHour *float64 `json:"average_dm3_per_hour"`
+
+	// Readings the flow is derived from.
+	From            *gasReading `json:"from"`
+	To              *gasReading `json:"to"`
+	IntervalSeconds float64     `json:"interval_seconds"`
+	Readings        int         `json:"readings"`
+
+	// "high" for a fresh estimate from readings at most 15 minutes
+	// apart, "medium" for hourly readings and "low" if the estimate is
+	// based on readings that are missing or too old.
+	Confidence string `json:"confidence"`
+}
+
+type gasFlowEstimator struct {
+	lock     sync.Mutex
+	readings []gasReading // oldest first, distinct times
+}
+
+func newGasFlowEstimator() *gasFlowEstimator {
+	return &gasFlowEstimator{}
+}
+
+func (g *gasFlowEstimator) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if t.Gas == nil || isStale(stale, "Gas") {
+		return
+	}
+	ts, err := dsmrp1.ParseTimestamp(t.Gas.LastRecord.TimeStamp)
+	if err != nil {
+		return
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	n := len(g.readings)
+	if n > 0 && !ts.After(g.readings[n-1].Time) {
+		return
+	}
+	g.readings = append(g.readings, gasReading{Time: ts,
+		Value: float64(t.Gas.LastRecord.Value)})
+	if len(g.readings) > maxGasRecords {
+		g.readings = g.readings[len(g.readings)-maxGasRecords:]
+	}
+}
+
+// Flow in dm3/h between two readings.
+func gasFlow(from, to gasReading) float64 {
+	dm3 := (to.Value - from.Value) * 1000
+	return math.Max(0, dm3/to.Time.Sub(from.Time).Hours())
+}
+
+func (g *gasFlowEstimator) report(now time.Time) gasFlowReport {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	ret := gasFlowReport{Readings: len(g.readings), Confidence: "low"}
+	n := len(g.readings)
+	if n < 2 {
+		return ret
+	}
+
+	from, to := g.readings[n-2], g.readings[n-1]
+	interval := to.Time.Sub(from.Time)
+	flow := gasFlow(from, to)
+	ret.From, ret.To = &from, &to
+	ret.FlowDm3PerHour = &flow
+	ret.IntervalSeconds = interval.Seconds()
+
+	first := n - 2
+	for first > 0 && to.Time.Sub(g.readings[first-1].Time) <= time.Hour {
+		first--
+	}
+	average := gasFlow(g.readings[first], to)
+	ret.AverageDm3PerHour = &average
+
+	switch {
+	case now.Sub(to.Time) > 2*interval+time.Minute:
+		// We missed readings, or the meter stopped sending them.
+	case interval <= 15*time.Minute:
+		ret.Confidence = "high"
+	case interval <= time.Hour+time.Minute:
+		ret.Confidence = "medium"
+	}
+	return ret
+}
+
+func (g *gasFlowEstimator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, g.report(clock.Now()))
+}
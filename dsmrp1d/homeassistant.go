@@ -0,0 +1,179 @@
+package main
+
+// Pushes sensor states directly to the REST API of Home Assistant.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A sensor derived from telegrams.
+type haSensor struct {
+	key         string
+	name        string
+	unit        string
+	deviceClass string
+	stateClass  string
+	value       func(t *dsmrp1.Telegram) *float64
+}
+
+func electricityValue(f func(e *dsmrp1.ElectricityData) float32) func(
+	t *dsmrp1.Telegram) *float64 {
+	return func(t *dsmrp1.Telegram) *float64 {
+		if t.Electricity == nil {
+			return nil
+		}
+		v := dsmrp1.Float64(f(t.Electricity))
+		return &v
+	}
+}
+
+func phaseValue(i int, f func(p dsmrp1.Phase) *float32) func(
+	t *dsmrp1.Telegram) *float64 {
+	return func(t *dsmrp1.Telegram) *float64 {
+		phases := t.Phases()
+		if i >= len(phases) || f(phases[i]) == nil {
+			return nil
+		}
+		v := dsmrp1.Float64(*f(phases[i]))
+		return &v
+	}
+}
+
+// The sensors exposed to Home Assistant.
+var haSensors = func() []haSensor {
+	ret := []haSensor{
+		{"power_import", "Power import", "W", "power", "measurement",
+			electricityValue(func(e *dsmrp1.ElectricityData) float32 { return e.W })},
+		{"power_export", "Power export", "W", "power", "measurement",
+			electricityValue(func(e *dsmrp1.ElectricityData) float32 { return e.WOut })},
+		{"energy_import_high", "Energy import high tariff", "kWh", "energy",
+			"total_increasing",
+			electricityValue(func(e *dsmrp1.ElectricityData) float32 { return e.KWh })},
+		{"energy_import_low", "Energy import low tariff", "kWh", "energy",
+			"total_increasing",
+			electricityValue(func(e *dsmrp1.ElectricityData) float32 { return e.KWhLow })},
+		{"energy_export_high", "Energy export high tariff", "kWh", "energy",
+			"total_increasing",
+			electricityValue(func(e *dsmrp1.ElectricityData) float32 { return e.KWhOut })},
+		{"energy_export_low", "Energy export low tariff", "kWh", "energy",
+			"total_increasing",
+			electricityValue(func(e *dsmrp1.ElectricityData) float32 { return e.KWhOutLow })},
+		{"gas", "Gas", "m³", "gas", "total_increasing",
+			func(t *dsmrp1.Telegram) *float64 {
+				if t.Gas == nil {
+					return nil
+				}
+				v := dsmrp1.Float64(t.Gas.LastRecord.Value)
+				return &v
+			}},
+	}
+	for i := 0; i < 3; i++ {
+		phase := fmt.Sprintf("L%d", i+1)
+		ret = append(ret,
+			haSensor{"voltage_" + strings.ToLower(phase), "Voltage " + phase,
+				"V", "voltage", "measurement",
+				phaseValue(i, func(p dsmrp1.Phase) *float32 { return p.Voltage })},
+			haSensor{"current_" + strings.ToLower(phase), "Current " + phase,
+				"A", "current", "measurement",
+				phaseValue(i, func(p dsmrp1.Phase) *float32 { return &p.Current })})
+	}
+	return ret
+}()
+
+// Posts the states of the sensors of a meter to Home Assistant at most
+// once per interval.
+type haPusher struct {
+	url      string
+	token    string
+	meter    string
+	interval time.Duration
+	q        *retryQueue
+	client   *http.Client
+
+	lock sync.Mutex
+	last time.Time
+}
+
+func newHAPusher(url, token, meter string, interval time.Duration,
+	cfg queueConfig) *haPusher {
+	// Only the latest states matter, so there is no need for a long
+	// queue.
+	cfg.size = 1
+	return &haPusher{
+		url:      strings.TrimSuffix(url, "/"),
+		token:    token,
+		meter:    meter,
+		interval: interval,
+		q:        newRetryQueue("homeassistant "+meter, cfg),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h *haPusher) observe(t *dsmrp1.Telegram, at time.Time, stale []string) {
+	h.lock.Lock()
+	if at.Sub(h.last) < h.interval {
+		h.lock.Unlock()
+		return
+	}
+	h.last = at
+	h.lock.Unlock()
+
+	h.q.push(func() error { return h.push(t) })
+}
+
+type haState struct {
+	State      string                 `json:"state"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+func (h *haPusher) push(t *dsmrp1.Telegram) error {
+	for _, s := range haSensors {
+		v := s.value(t)
+		if v == nil {
+			continue
+		}
+		entity := "sensor.dsmrp1_" + h.meter + "_" + s.key
+		body, _ := json.Marshal(haState{
+			State: fmt.Sprint(*v),
+			Attributes: map[string]interface{}{
+				"friendly_name":       "P1 " + h.meter + " " + s.name,
+				"unit_of_measurement": s.unit,
+				"device_class":        s.deviceClass,
+				"state_class":         s.stateClass,
+			},
+		})
+		if err := h.post("/api/states/"+entity, body); err != nil {
+			return errors.New(fmt.Sprintf("%s: %v", entity, err))
+		}
+	}
+	return nil
+}
+
+func (h *haPusher) post(path string, body []byte) error {
+	req, err := http.NewRequest("POST", h.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
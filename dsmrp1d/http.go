@@ -0,0 +1,173 @@
+package main
+
+// Generic HTTP helpers for the webservice: compression, caching and
+// logging.
+
+import (
+	"compress/gzip"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Wraps a http.ResponseWriter to gzip the response body.  The status
+// line is held back until the first non-empty Write, so that responses
+// without a body are sent without a Content-Encoding: an empty body is
+// not a valid gzip stream.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	code        int  // status code held back until the first Write
+	wroteHeader bool // whether the status line has been sent
+	compress    bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader || w.code != 0 {
+		return
+	}
+	w.code = code
+
+	// Responses without a body should not be compressed.
+	if code == http.StatusNotModified || code == http.StatusNoContent ||
+		w.Header().Get("Content-Encoding") != "" {
+		w.sendHeader(false)
+	}
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Sends the held back status line, compressed or not.
+func (w *gzipResponseWriter) sendHeader(compress bool) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.wroteHeader = true
+	w.compress = compress
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		w.sendHeader(w.Header().Get("Content-Encoding") == "")
+	}
+	if !w.compress {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.gz == nil {
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.gz.Write(b)
+}
+
+// Sends the status line if nothing was written and finishes the gzip
+// stream otherwise.
+func (w *gzipResponseWriter) Close() error {
+	if !w.wroteHeader {
+		w.sendHeader(false)
+	}
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// Compresses responses with gzip for clients that accept it.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		bits := strings.SplitN(strings.TrimSpace(enc), ";", 2)
+		if strings.TrimSpace(bits[0]) != "gzip" {
+			continue
+		}
+		if len(bits) == 2 && strings.Replace(bits[1], " ", "", -1) == "q=0" {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// Sets the ETag header on the response and returns whether the client
+// already has this version, in which case a 304 has been written.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	etag = "\"" + etag + "\""
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// Records the status code and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// Logs every request as a line of key=value pairs.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		log.Printf("http method=%s path=%q status=%d bytes=%d "+
+			"duration=%s remote=%s agent=%q",
+			r.Method, r.URL.RequestURI(), sw.status(), sw.bytes,
+			time.Since(start), r.RemoteAddr, r.UserAgent())
+	})
+}
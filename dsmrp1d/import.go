@@ -0,0 +1,273 @@
+package main
+
+// Import of history exported by other software into the aggregates of
+// the capture directory (see retention.go).
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/history"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns of an import format, by (normalized) header.
+type importFormat struct {
+	time       []string
+	importHigh []string // kWh
+	importLow  []string
+	exportHigh []string
+	exportLow  []string
+	power      []string // kW
+	powerOut   []string
+	gas        []string // m3
+}
+
+var importFormats = map[string]importFormat{
+	// Export of the readings of dsmr-reader, where tariff 1 is low.
+	"dsmr-reader": {
+		time:       []string{"timestamp"},
+		importLow:  []string{"electricity_delivered_1"},
+		importHigh: []string{"electricity_delivered_2"},
+		exportLow:  []string{"electricity_returned_1"},
+		exportHigh: []string{"electricity_returned_2"},
+		power:      []string{"electricity_currently_delivered"},
+		powerOut:   []string{"electricity_currently_returned"},
+		gas:        []string{"extra_device_delivered"},
+	},
+	// Export of the HomeWizard Energy app and the names of its API.
+	"homewizard": {
+		time:       []string{"time", "timestamp"},
+		importLow:  []string{"import t1 kwh", "total_power_import_t1_kwh"},
+		importHigh: []string{"import t2 kwh", "total_power_import_t2_kwh"},
+		exportLow:  []string{"export t1 kwh", "total_power_export_t1_kwh"},
+		exportHigh: []string{"export t2 kwh", "total_power_export_t2_kwh"},
+		gas:        []string{"gas m3", "total_gas_m3"},
+	},
+}
+
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z07",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseImportTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02 15:04:05.999999Z07:00", s); err == nil {
+		return t, nil
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("unknown time format: %s", s))
+}
+
+// Reads the rows of a CSV file in the given format.
+func readImport(r io.Reader, format importFormat) ([]history.Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	index := func(names []string) int {
+		for i, h := range header {
+			h = strings.ToLower(strings.TrimSpace(h))
+			for _, name := range names {
+				if h == name {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	timeIdx := index(format.time)
+	if timeIdx == -1 {
+		return nil, errors.New("no time column")
+	}
+	columns := []struct {
+		idx   int
+		scale float64
+		set   func(*history.Row, *float64)
+	}{
+		{index(format.importHigh), 1, func(row *history.Row, v *float64) { row.ImportHigh = v }},
+		{index(format.importLow), 1, func(row *history.Row, v *float64) { row.ImportLow = v }},
+		{index(format.exportHigh), 1, func(row *history.Row, v *float64) { row.ExportHigh = v }},
+		{index(format.exportLow), 1, func(row *history.Row, v *float64) { row.ExportLow = v }},
+		{index(format.power), 1000, func(row *history.Row, v *float64) { row.Power = v }},
+		{index(format.powerOut), 1000, func(row *history.Row, v *float64) { row.PowerOut = v }},
+		{index(format.gas), 1, func(row *history.Row, v *float64) { row.Gas = v }},
+	}
+
+	var ret []history.Row
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if timeIdx >= len(record) {
+			continue
+		}
+		at, err := parseImportTime(record[timeIdx])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: %v", line, err))
+		}
+		row := history.Row{At: at}
+		for _, c := range columns {
+			if c.idx == -1 || c.idx >= len(record) ||
+				strings.TrimSpace(record[c.idx]) == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(record[c.idx]), 64)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("line %d: %v", line, err))
+			}
+			v *= c.scale
+			c.set(&row, &v)
+		}
+		ret = append(ret, row)
+	}
+	return ret, nil
+}
+
+// Merges rows into the aggregate file at path, replacing existing rows
+// for the same time.
+func mergeAggregates(path string, rows []history.Row) error {
+	byTime := make(map[int64]history.Row)
+	err := eachAggregate(path, time.Time{}, time.Unix(1<<40, 0),
+		func(row history.Row) error {
+			byTime[row.At.UnixNano()] = row
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		byTime[row.At.UnixNano()] = row
+	}
+	merged := make([]history.Row, 0, len(byTime))
+	for _, row := range byTime {
+		merged = append(merged, row)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].At.Before(merged[j].At)
+	})
+	records := make([][]string, len(merged))
+	for i := range merged {
+		records[i] = merged[i].Record()
+	}
+	tmp := path + ".tmp"
+	if err = writeCSV(tmp, records); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Adds the rows to the minute and daily aggregates of the meter.
+func (e *exporter) importRows(rows []history.Row) error {
+	minutes := history.Downsample(rows, history.Minute)
+	for i := 0; i < len(minutes); {
+		day := history.StartOfDay(minutes[i].At)
+		j := i
+		for j < len(minutes) && history.StartOfDay(minutes[j].At).Equal(day) {
+			j++
+		}
+		if err := mergeAggregates(e.minutesPath(day), minutes[i:j]); err != nil {
+			return err
+		}
+		i = j
+	}
+	return mergeAggregates(e.daysPath(), history.Downsample(rows, history.Day))
+}
+
+// Handles POST requests with a CSV file to import.
+type importHandler struct {
+	e *exporter
+}
+
+func (h importHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, apiError{
+			Error: "use POST"})
+		return
+	}
+	format, ok := importFormats[r.URL.Query().Get("format")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, apiError{
+			Error: "format should be dsmr-reader or homewizard"})
+		return
+	}
+	// Large files take a while to upload and import.
+	noReadTimeout(w)
+	noWriteTimeout(w)
+	rows, err := readImport(r.Body, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	if err = h.e.importRows(rows); err != nil {
+		writeError(w, http.StatusInternalServerError, apiError{
+			Error: err.Error()})
+		return
+	}
+	writeJSON(w, r, struct {
+		Rows int `json:"rows"`
+	}{len(rows)})
+}
+
+// Runs the import subcommand: dsmrp1d import [flags] file...
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("capture", "", "capture directory of the daemon")
+	name := fs.String("meter", "default", "name of the meter to import into")
+	formatName := fs.String("format", "dsmr-reader",
+		"format of the files: dsmr-reader or homewizard")
+	logFormat := fs.String("log-format", "text", logFormatUsage)
+	fs.Parse(args)
+	if err := setLogFormat(*logFormat); err != nil {
+		log.Fatalf("-log-format: %v", err)
+	}
+
+	format, ok := importFormats[*formatName]
+	if !ok {
+		log.Fatalf("-format: unknown format %s", *formatName)
+	}
+	if *dir == "" || fs.NArg() == 0 {
+		log.Fatalf("usage: dsmrp1d import -capture dir [-meter name] " +
+			"[-format format] file...")
+	}
+	e := newExporter(*dir, *name)
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rows, err := readImport(f, format)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		if err = e.importRows(rows); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		log.Printf("Imported %d rows from %s", len(rows), path)
+	}
+}
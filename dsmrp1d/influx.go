@@ -0,0 +1,25 @@
+package main
+
+// Writes the readings to InfluxDB:
+//
+//	-sink influx:http://localhost:8086/api/v2/write?org=home&bucket=energy
+//
+// The API token, if any, is taken from $DSMRP1_INFLUX_TOKEN, so that it
+// does not show up in ps.
+
+import (
+	"errors"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"os"
+)
+
+func init() {
+	registerSink("influx", newInfluxSink)
+}
+
+func newInfluxSink(config string) (Sink, error) {
+	if config == "" {
+		return nil, errors.New("expected the URL of the write endpoint")
+	}
+	return sinks.NewInflux(config, os.Getenv("DSMRP1_INFLUX_TOKEN")), nil
+}
@@ -0,0 +1,215 @@
+package main
+
+// Protections against misbehaving clients: per client rate limits,
+// a cap on concurrent connections per client and server timeouts.
+
+import (
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type limitsConfig struct {
+	rate         float64 // requests per second per client; 0 for no limit
+	burst        int
+	maxConns     int // concurrent connections per client; 0 for no limit
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+}
+
+// Enforces the limits of a limitsConfig.  Clients are identified by
+// their IP address; clients on Unix domain sockets are not limited.
+type limiter struct {
+	cfg limitsConfig
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	conns   map[string]int
+	purged  time.Time
+
+	limited  uint64
+	rejected uint64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(cfg limitsConfig) *limiter {
+	return &limiter{
+		cfg:     cfg,
+		buckets: make(map[string]*tokenBucket),
+		conns:   make(map[string]int),
+	}
+}
+
+// Returns the IP address of the client or "" if it is not on TCP.
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Takes a token from the bucket of the client, returning whether there
+// was one.
+func (l *limiter) allow(ip string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	burst := float64(l.cfg.burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	// Forget clients whose bucket has been refilled completely.
+	if now.Sub(l.purged) > time.Minute {
+		for key, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*l.cfg.rate >= burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.purged = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.cfg.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wraps h to reject requests of clients that exceed the rate limit.
+func (l *limiter) wrap(h http.Handler) http.Handler {
+	if l.cfg.rate <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r.RemoteAddr)
+		if ip != "" && !l.allow(ip, time.Now()) {
+			atomic.AddUint64(&l.limited, 1)
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(math.Ceil(1/l.cfg.rate))))
+			writeError(w, http.StatusTooManyRequests, apiError{
+				Error: "too many requests",
+			})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Wraps ln to close connections of clients that already have the
+// maximum number of connections open.
+func (l *limiter) listener(ln net.Listener) net.Listener {
+	if l.cfg.maxConns <= 0 {
+		return ln
+	}
+	return &limitedListener{Listener: ln, l: l}
+}
+
+type limitedListener struct {
+	net.Listener
+	l *limiter
+}
+
+func (ll *limitedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ll.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := clientIP(c.RemoteAddr().String())
+		if ip == "" {
+			return c, nil
+		}
+
+		l := ll.l
+		l.lock.Lock()
+		if l.conns[ip] >= l.cfg.maxConns {
+			l.lock.Unlock()
+			atomic.AddUint64(&l.rejected, 1)
+			c.Close()
+			continue
+		}
+		l.conns[ip]++
+		l.lock.Unlock()
+		return &limitedConn{Conn: c, l: l, ip: ip}, nil
+	}
+}
+
+type limitedConn struct {
+	net.Conn
+	l      *limiter
+	ip     string
+	closed sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.closed.Do(func() {
+		c.l.lock.Lock()
+		c.l.conns[c.ip]--
+		if c.l.conns[c.ip] == 0 {
+			delete(c.l.conns, c.ip)
+		}
+		c.l.lock.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// Returns a server for h with the configured timeouts.  The read timeout
+// only covers the headers, so that uploads to import may take longer.
+func (l *limiter) server(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: l.cfg.readTimeout,
+		WriteTimeout:      l.cfg.writeTimeout,
+		IdleTimeout:       l.cfg.idleTimeout,
+	}
+}
+
+// Lifts the write timeout of the server for the response, for handlers
+// that stream downloads which may take longer, like exports and backups.
+func noWriteTimeout(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Printf("lifting write timeout: %v", err)
+	}
+}
+
+// Lifts the read timeout of the server for the request, for handlers
+// that take uploads, like imports.
+func noReadTimeout(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("lifting read timeout: %v", err)
+	}
+}
+
+func (l *limiter) writeMetrics(w io.Writer) {
+	limited := &sinks.MetricFamily{Name: "dsmrp1d_http_rate_limited_total",
+		Type: "counter", Help: "Requests rejected by the rate limit."}
+	rejected := &sinks.MetricFamily{Name: "dsmrp1d_http_connections_rejected_total",
+		Type: "counter", Help: "Connections closed as the client had too many open."}
+	limited.Add("", float64(atomic.LoadUint64(&l.limited)))
+	rejected.Add("", float64(atomic.LoadUint64(&l.rejected)))
+	limited.Write(w)
+	rejected.Write(w)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlowUpload(t *testing.T) {
+	lim := newLimiter(limitsConfig{readTimeout: 50 * time.Millisecond,
+		writeTimeout: 50 * time.Millisecond})
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = lim.server(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			noReadTimeout(w)
+			noWriteTimeout(w)
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintf(w, "%d", len(body))
+		}))
+	ts.Start()
+	defer ts.Close()
+
+	// An upload that takes longer than the read and write timeouts.
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(40 * time.Millisecond)
+			pw.Write([]byte("row\n"))
+		}
+		pw.Close()
+	}()
+	resp, err := http.Post(ts.URL, "text/csv", pr)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(got) != "16" {
+		t.Fatalf("got %d %q; expected 200 \"16\"", resp.StatusCode, got)
+	}
+}
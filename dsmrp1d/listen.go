@@ -0,0 +1,171 @@
+package main
+
+// Listening on TCP and Unix domain sockets.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Permissions for Unix domain sockets.
+type socketPerms struct {
+	mode  os.FileMode
+	group string // name or gid; empty to leave as is
+}
+
+// Configuration of a single listener.
+type listenerConfig struct {
+	addr     string
+	perms    socketPerms
+	certFile string // serve HTTPS if set
+	keyFile  string
+	auth     authConfig
+}
+
+// Parses a listener specification of the form address[?options], where
+// address is host:port or unix:/path and options are URL encoded, eg.
+//
+//	0.0.0.0:1122?cert=/etc/dsmrp1d/cert.pem&key=/etc/dsmrp1d/key.pem&token-file=/etc/dsmrp1d/token
+//	unix:/run/dsmrp1d.sock?mode=0600&group=munin
+//
+// Recognized options are cert, key, user, password, password-file,
+// token, token-file, mode and group.
+func parseListener(spec string, defaults socketPerms) (listenerConfig, error) {
+	var err error
+	ret := listenerConfig{addr: spec, perms: defaults}
+
+	bits := strings.SplitN(spec, "?", 2)
+	if len(bits) == 1 {
+		return ret, nil
+	}
+	ret.addr = bits[0]
+
+	opts, err := url.ParseQuery(bits[1])
+	if err != nil {
+		return ret, err
+	}
+	for key, values := range opts {
+		value := values[len(values)-1]
+		switch key {
+		case "cert":
+			ret.certFile = value
+		case "key":
+			ret.keyFile = value
+		case "user":
+			ret.auth.user = value
+		case "password":
+			ret.auth.password = value
+		case "password-file":
+			ret.auth.password, err = readSecret(value)
+		case "token":
+			ret.auth.token = value
+		case "token-file":
+			ret.auth.token, err = readSecret(value)
+		case "mode":
+			ret.perms.mode, err = parseMode(value)
+		case "group":
+			ret.perms.group = value
+		default:
+			err = errors.New(fmt.Sprintf("unknown option %s", key))
+		}
+		if err != nil {
+			return ret, errors.New(fmt.Sprintf("%s: %v", spec, err))
+		}
+	}
+
+	if (ret.certFile == "") != (ret.keyFile == "") {
+		return ret, errors.New(fmt.Sprintf(
+			"%s: both cert and key are required for HTTPS", spec))
+	}
+
+	return ret, nil
+}
+
+// Reads a password or token from a file.
+func readSecret(path string) (string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// Listens as configured and serves h until an error occurs.
+func (c listenerConfig) serve(h http.Handler, lim *limiter) error {
+	l, err := listen(c.addr, c.perms)
+	if err != nil {
+		return err
+	}
+	l = lim.listener(l)
+	srv := lim.server(c.auth.wrap(h))
+	if c.certFile != "" {
+		return srv.ServeTLS(l, c.certFile, c.keyFile)
+	}
+	return srv.Serve(l)
+}
+
+// Opens a listener on the given address, which is either a host:port
+// pair or "unix:" followed by the path of a Unix domain socket.
+func listen(addr string, perms socketPerms) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, "unix:")
+
+	// Remove a socket left behind by a previous run.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(path, perms.mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if perms.group != "" {
+		gid, err := lookupGroup(perms.group)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// Parses an octal file mode like "0660".
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New(fmt.Sprintf("invalid mode: %s", s))
+	}
+	return os.FileMode(mode), nil
+}
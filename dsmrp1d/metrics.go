@@ -0,0 +1,183 @@
+package main
+
+// Metrics in the Prometheus text exposition format.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the request latency histogram buckets in seconds.
+var latencyBuckets = []float64{
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Something that can write metrics in the Prometheus text format.
+type collector interface {
+	writeMetrics(w io.Writer)
+}
+
+// Request counts and latencies per endpoint.
+type httpMetrics struct {
+	lock      sync.Mutex
+	endpoints map[string]*endpointMetrics
+}
+
+type endpointMetrics struct {
+	codes   map[int]uint64
+	buckets []uint64 // cumulative counts per latencyBuckets entry
+	count   uint64
+	sum     float64
+}
+
+func newHTTPMetrics() *httpMetrics {
+	return &httpMetrics{endpoints: make(map[string]*endpointMetrics)}
+}
+
+func (m *httpMetrics) observe(endpoint string, code int, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.endpoints[endpoint]
+	if !ok {
+		e = &endpointMetrics{
+			codes:   make(map[int]uint64),
+			buckets: make([]uint64, len(latencyBuckets)),
+		}
+		m.endpoints[endpoint] = e
+	}
+
+	secs := d.Seconds()
+	e.codes[code]++
+	e.count++
+	e.sum += secs
+	for i, bound := range latencyBuckets {
+		if secs <= bound {
+			e.buckets[i]++
+		}
+	}
+}
+
+// Wraps h to record the metrics of its requests under the given name.
+func (m *httpMetrics) instrument(endpoint string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		m.observe(endpoint, sw.status(), time.Since(start))
+	})
+}
+
+func (m *httpMetrics) writeMetrics(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	endpoints := make([]string, 0, len(m.endpoints))
+	for endpoint := range m.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	writeMetricHeader(w, "dsmrp1d_http_requests_total", "counter",
+		"Number of HTTP requests handled.")
+	for _, endpoint := range endpoints {
+		e := m.endpoints[endpoint]
+		codes := make([]int, 0, len(e.codes))
+		for code := range e.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			writeMetric(w, "dsmrp1d_http_requests_total",
+				sinks.Labels("endpoint", endpoint, "code", strconv.Itoa(code)),
+				float64(e.codes[code]))
+		}
+	}
+
+	writeMetricHeader(w, "dsmrp1d_http_request_duration_seconds",
+		"histogram", "Latency of HTTP requests.")
+	for _, endpoint := range endpoints {
+		e := m.endpoints[endpoint]
+		for i, bound := range latencyBuckets {
+			writeMetric(w, "dsmrp1d_http_request_duration_seconds_bucket",
+				sinks.Labels("endpoint", endpoint,
+					"le", strconv.FormatFloat(bound, 'g', -1, 64)),
+				float64(e.buckets[i]))
+		}
+		writeMetric(w, "dsmrp1d_http_request_duration_seconds_bucket",
+			sinks.Labels("endpoint", endpoint, "le", "+Inf"), float64(e.count))
+		writeMetric(w, "dsmrp1d_http_request_duration_seconds_sum",
+			sinks.Labels("endpoint", endpoint), e.sum)
+		writeMetric(w, "dsmrp1d_http_request_duration_seconds_count",
+			sinks.Labels("endpoint", endpoint), float64(e.count))
+	}
+}
+
+// Serves the metrics of the given collectors.
+func metricsHandler(collectors ...collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, c := range collectors {
+			c.writeMetrics(w)
+		}
+	})
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeMetric(w io.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels,
+		strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// Exposes the latest readings of the meters, labelled by meter name.
+type meterMetrics []*meter
+
+func (ms meterMetrics) writeMetrics(w io.Writer) {
+	ps := make([]*sinks.Prometheus, 0, len(ms))
+	for _, m := range ms {
+		t, received := m.latest()
+		if t == nil {
+			continue
+		}
+		p := sinks.NewPrometheus()
+		p.Clock = clock
+		p.Start(m.name)
+		p.HandleTelegram(t, received)
+		ps = append(ps, p)
+	}
+	sinks.WritePrometheusMetrics(w, ps...)
+
+	// Counts of the connections, eg. to see whether -realtime reduces
+	// the telegrams lost to buffer overflows.
+	telegrams := &sinks.MetricFamily{Name: "dsmrp1d_meter_telegrams_total",
+		Type: "counter", Help: "Number of valid telegrams received."}
+	invalid := &sinks.MetricFamily{Name: "dsmrp1d_meter_invalid_telegrams_total",
+		Type: "counter", Help: "Number of telegrams that failed to parse."}
+	crcErrors := &sinks.MetricFamily{Name: "dsmrp1d_meter_crc_errors_total",
+		Type: "counter", Help: "Number of telegrams with a wrong checksum."}
+	reconnects := &sinks.MetricFamily{Name: "dsmrp1d_meter_reconnects_total",
+		Type: "counter", Help: "Number of times the meter was reconnected."}
+	for _, m := range ms {
+		if m.conn == nil {
+			continue
+		}
+		stats := m.conn.Stats()
+		l := sinks.Labels("meter", m.name)
+		telegrams.Add(l, float64(stats.Telegrams))
+		invalid.Add(l, float64(stats.Invalid))
+		crcErrors.Add(l, float64(stats.CRCErrors))
+		reconnects.Add(l, float64(stats.Reconnects))
+	}
+	for _, f := range []*sinks.MetricFamily{telegrams, invalid, crcErrors,
+		reconnects} {
+		f.Write(w)
+	}
+}
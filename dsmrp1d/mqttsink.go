@@ -0,0 +1,195 @@
+package main
+
+// Publishes telegrams to MQTT and accepts commands to control the
+// daemon at runtime.
+
+import (
+	"encoding/json"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Publication settings that can be changed at runtime.
+type mqttControl struct {
+	lock     sync.Mutex
+	paused   bool
+	interval time.Duration
+}
+
+func (c *mqttControl) get() (paused bool, interval time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.paused, c.interval
+}
+
+// Publishes the telegrams of a meter to <prefix>/<meter>/telegram,
+// through a queue so that a slow or unreachable broker does not hold up
+// the meter.
+type mqttPublisher struct {
+	mc      *sinks.MQTTClient
+	prefix  string
+	control *mqttControl
+	q       *retryQueue
+
+	lock sync.Mutex
+	last time.Time
+}
+
+func newMQTTPublisher(mc *sinks.MQTTClient, prefix, meter string,
+	control *mqttControl, cfg queueConfig) *mqttPublisher {
+	return &mqttPublisher{
+		mc:      mc,
+		prefix:  prefix + "/" + meter,
+		control: control,
+		q:       newRetryQueue("mqtt "+meter, cfg),
+	}
+}
+
+func (p *mqttPublisher) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	paused, interval := p.control.get()
+	if paused {
+		return
+	}
+
+	p.lock.Lock()
+	if interval != 0 && at.Sub(p.last) < interval {
+		p.lock.Unlock()
+		return
+	}
+	p.last = at
+	p.lock.Unlock()
+
+	p.publish("telegram", t)
+}
+
+// Queues the publication of v as JSON to <prefix>/<meter>/<topic>.
+func (p *mqttPublisher) publish(topic string, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("mqtt: %s: %v", topic, err)
+		return
+	}
+	topic = p.prefix + "/" + topic
+	p.q.push(func() error { return p.mc.Publish(topic, body, false) })
+}
+
+// A command received on <prefix>/command, eg.
+//
+//	{"command": "pause"}
+//	{"command": "resume"}
+//	{"command": "interval", "value": "10s"}
+//	{"command": "capture", "value": "15m"}
+//	{"command": "status"}
+//
+// The response is published on <prefix>/command/response.
+type mqttCommand struct {
+	Command string `json:"command"`
+	Value   string `json:"value,omitempty"`
+}
+
+type mqttResponse struct {
+	Command string      `json:"command"`
+	OK      bool        `json:"ok"`
+	Error   string      `json:"error,omitempty"`
+	Status  *daemonInfo `json:"status,omitempty"`
+}
+
+// Status reported in response to the status command.
+type daemonInfo struct {
+	Paused          bool        `json:"paused"`
+	IntervalSeconds float64     `json:"interval_seconds"`
+	UptimeSeconds   float64     `json:"uptime_seconds"`
+	Goroutines      int         `json:"goroutines"`
+	Meters          []meterInfo `json:"meters"`
+	Alerts          []alert     `json:"alerts"`
+}
+
+type mqttCommander struct {
+	mc       *sinks.MQTTClient
+	prefix   string
+	control  *mqttControl
+	meters   []*meter
+	captures []*captureFile
+	as       *alerts
+	started  time.Time
+}
+
+func (c *mqttCommander) start() {
+	c.mc.Subscribe(c.prefix+"/command", c.handle)
+}
+
+func (c *mqttCommander) handle(topic string, payload []byte) {
+	var cmd mqttCommand
+	var resp mqttResponse
+
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		// Allow plain commands without arguments, eg. "pause".
+		cmd.Command = string(payload)
+	}
+	resp.Command = cmd.Command
+	resp.OK = true
+
+	fail := func(msg string) {
+		resp.OK = false
+		resp.Error = msg
+	}
+
+	switch cmd.Command {
+	case "pause", "resume":
+		c.control.lock.Lock()
+		c.control.paused = cmd.Command == "pause"
+		c.control.lock.Unlock()
+	case "interval":
+		interval, err := time.ParseDuration(cmd.Value)
+		if err != nil || interval < 0 {
+			fail("expected a duration like 10s")
+			break
+		}
+		c.control.lock.Lock()
+		c.control.interval = interval
+		c.control.lock.Unlock()
+	case "capture":
+		duration, err := time.ParseDuration(cmd.Value)
+		if err != nil || duration <= 0 {
+			fail("expected a duration like 15m")
+			break
+		}
+		if len(c.captures) == 0 {
+			fail("capturing requires -capture")
+			break
+		}
+		until := clock.Now().Add(duration)
+		for _, cf := range c.captures {
+			cf.trigger(until)
+		}
+	case "status":
+		info := c.info()
+		resp.Status = &info
+	default:
+		fail("unknown command")
+	}
+
+	log.Printf("mqtt: command %q: ok=%v %s", cmd.Command, resp.OK, resp.Error)
+	body, _ := json.Marshal(resp)
+	c.mc.Publish(c.prefix+"/command/response", body, false)
+}
+
+func (c *mqttCommander) info() daemonInfo {
+	paused, interval := c.control.get()
+	ret := daemonInfo{
+		Paused:          paused,
+		IntervalSeconds: interval.Seconds(),
+		UptimeSeconds:   time.Since(c.started).Seconds(),
+		Goroutines:      runtime.NumGoroutine(),
+		Alerts:          c.as.list(),
+	}
+	for _, m := range c.meters {
+		ret.Meters = append(ret.Meters, m.info())
+	}
+	return ret
+}
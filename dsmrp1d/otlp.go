@@ -0,0 +1,346 @@
+package main
+
+// Exports meter metrics and daemon spans to an OpenTelemetry collector
+// using OTLP over HTTP with JSON encoding.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum number of spans to buffer between exports.
+const maxBufferedSpans = 2048
+
+// Records spans for export; nil if tracing is disabled.
+var tracer *otlpExporter
+
+type span struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	attrs    []string // key, value, key, value, ...
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Starts a span, which is a child of parent if not nil.  Returns nil if
+// tracing is disabled.
+func startSpan(parent *span, name string, attrs ...string) *span {
+	if tracer == nil {
+		return nil
+	}
+	s := &span{spanID: randomHex(8), name: name, start: time.Now(),
+		attrs: attrs}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	return s
+}
+
+// Ends the span with the given outcome and queues it for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	os := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              1, // internal
+		StartTimeUnixNano: otlpTime(s.start),
+		EndTimeUnixNano:   otlpTime(time.Now()),
+		Attributes:        otlpAttributes(s.attrs...),
+	}
+	if err != nil {
+		os.Status.Code = 2
+		os.Status.Message = err.Error()
+	}
+
+	tracer.lock.Lock()
+	defer tracer.lock.Unlock()
+	if len(tracer.spans) < maxBufferedSpans {
+		tracer.spans = append(tracer.spans, os)
+	}
+}
+
+// OTLP JSON encoding; see opentelemetry-proto.
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Unit  string     `json:"unit"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(pairs ...string) []otlpKeyValue {
+	var ret []otlpKeyValue
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ret = append(ret, otlpKeyValue{pairs[i], otlpValue{pairs[i+1]}})
+	}
+	return ret
+}
+
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	interval time.Duration
+	meters   []*meter
+	started  time.Time
+	client   *http.Client
+
+	lock  sync.Mutex
+	spans []otlpSpan
+}
+
+// Parses a comma separated list of key=value headers.
+func parseHeaders(s string) (map[string]string, error) {
+	ret := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		bits := strings.SplitN(pair, "=", 2)
+		if len(bits) != 2 {
+			return nil, errors.New(fmt.Sprintf("expected key=value: %s", pair))
+		}
+		ret[strings.TrimSpace(bits[0])] = strings.TrimSpace(bits[1])
+	}
+	return ret, nil
+}
+
+// Starts exporting to the OTLP/HTTP endpoint, eg. http://localhost:4318.
+func startOTLP(endpoint string, headers map[string]string,
+	interval time.Duration, meters []*meter) {
+	tracer = &otlpExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  headers,
+		interval: interval,
+		meters:   meters,
+		started:  time.Now(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	go func() {
+		for range time.Tick(interval) {
+			tracer.export()
+		}
+	}()
+}
+
+func (e *otlpExporter) export() {
+	resource := otlpResource{otlpAttributes("service.name", "dsmrp1d")}
+	scope := otlpScope{"dsmrp1d"}
+
+	if metrics := e.metrics(); len(metrics) > 0 {
+		if err := e.post("/v1/metrics", map[string]interface{}{
+			"resourceMetrics": []interface{}{map[string]interface{}{
+				"resource": resource,
+				"scopeMetrics": []interface{}{map[string]interface{}{
+					"scope":   scope,
+					"metrics": metrics,
+				}},
+			}},
+		}); err != nil {
+			log.Printf("otlp: metrics: %v", err)
+		}
+	}
+
+	e.lock.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.lock.Unlock()
+	if len(spans) > 0 {
+		if err := e.post("/v1/traces", map[string]interface{}{
+			"resourceSpans": []interface{}{map[string]interface{}{
+				"resource": resource,
+				"scopeSpans": []interface{}{map[string]interface{}{
+					"scope": scope,
+					"spans": spans,
+				}},
+			}},
+		}); err != nil {
+			log.Printf("otlp: traces: %v", err)
+		}
+	}
+}
+
+// Returns the latest readings of the meters as OTLP metrics.
+func (e *otlpExporter) metrics() []otlpMetric {
+	power := &otlpGauge{}
+	voltage := &otlpGauge{}
+	current := &otlpGauge{}
+	energy := &otlpSum{AggregationTemporality: 2, IsMonotonic: true}
+	gas := &otlpSum{AggregationTemporality: 2, IsMonotonic: true}
+
+	for _, m := range e.meters {
+		t, received := m.latest()
+		if t == nil {
+			continue
+		}
+		ts := otlpTime(received)
+		start := otlpTime(e.started)
+		point := func(v float64, attrs ...string) otlpDataPoint {
+			return otlpDataPoint{
+				Attributes:   otlpAttributes(append([]string{"meter", m.name}, attrs...)...),
+				TimeUnixNano: ts,
+				AsDouble:     v,
+			}
+		}
+		if el := t.Electricity; el != nil {
+			power.DataPoints = append(power.DataPoints,
+				point(dsmrp1.Float64(el.W), "direction", "import"),
+				point(dsmrp1.Float64(el.WOut), "direction", "export"))
+			for _, r := range []struct {
+				v                 float32
+				direction, tariff string
+			}{
+				{el.KWh, "import", "high"},
+				{el.KWhLow, "import", "low"},
+				{el.KWhOut, "export", "high"},
+				{el.KWhOutLow, "export", "low"},
+			} {
+				p := point(dsmrp1.Float64(r.v), "direction", r.direction,
+					"tariff", r.tariff)
+				p.StartTimeUnixNano = start
+				energy.DataPoints = append(energy.DataPoints, p)
+			}
+		}
+		for i, p := range t.Phases() {
+			phase := fmt.Sprintf("L%d", i+1)
+			if p.Voltage != nil {
+				voltage.DataPoints = append(voltage.DataPoints,
+					point(dsmrp1.Float64(*p.Voltage), "phase", phase))
+			}
+			current.DataPoints = append(current.DataPoints,
+				point(dsmrp1.Float64(p.Current), "phase", phase))
+		}
+		if t.Gas != nil {
+			p := point(dsmrp1.Float64(t.Gas.LastRecord.Value))
+			p.StartTimeUnixNano = start
+			gas.DataPoints = append(gas.DataPoints, p)
+		}
+	}
+
+	var ret []otlpMetric
+	if len(power.DataPoints) > 0 {
+		ret = append(ret, otlpMetric{Name: "dsmrp1.electricity.power",
+			Unit: "W", Gauge: power})
+	}
+	if len(energy.DataPoints) > 0 {
+		ret = append(ret, otlpMetric{Name: "dsmrp1.electricity.energy",
+			Unit: "kWh", Sum: energy})
+	}
+	if len(voltage.DataPoints) > 0 {
+		ret = append(ret, otlpMetric{Name: "dsmrp1.phase.voltage",
+			Unit: "V", Gauge: voltage})
+	}
+	if len(current.DataPoints) > 0 {
+		ret = append(ret, otlpMetric{Name: "dsmrp1.phase.current",
+			Unit: "A", Gauge: current})
+	}
+	if len(gas.DataPoints) > 0 {
+		ret = append(ret, otlpMetric{Name: "dsmrp1.gas", Unit: "m3",
+			Sum: gas})
+	}
+	return ret
+}
+
+func (e *otlpExporter) post(path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
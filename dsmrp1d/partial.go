@@ -0,0 +1,92 @@
+package main
+
+// Handles telegrams of which some lines failed to parse, eg. when the
+// reading of the gas meter timed out.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+)
+
+// What to do with the sections of a telegram that failed to parse.
+type partialPolicy int
+
+const (
+	partialDrop    partialPolicy = iota // drop the whole telegram
+	partialForward                      // pass on what was parsed
+	partialRetain                       // use the last good section
+	partialNull                         // leave the section out
+)
+
+func parsePartialPolicy(s string) (partialPolicy, error) {
+	switch s {
+	case "drop":
+		return partialDrop, nil
+	case "forward":
+		return partialForward, nil
+	case "retain":
+		return partialRetain, nil
+	case "null":
+		return partialNull, nil
+	}
+	return 0, errors.New(fmt.Sprintf(
+		"%s: should be drop, forward, retain or null", s))
+}
+
+// Last sections of a meter that parsed without errors.
+type goodSections struct {
+	electricity *dsmrp1.ElectricityData
+	multiphase  *dsmrp1.MultiphaseElectricityData
+	gas         *dsmrp1.GasData
+}
+
+// Applies the policy to the telegram.  Returns the telegram to use and
+// the sections that are stale: that failed to parse in this telegram.
+func (g *goodSections) apply(policy partialPolicy, t *dsmrp1.Telegram) (
+	*dsmrp1.Telegram, []string) {
+	stale := t.FailedSections()
+	failed := make(map[string]bool)
+	for _, section := range stale {
+		failed[section] = true
+	}
+	if !failed["Electricity"] && t.Electricity != nil {
+		g.electricity = t.Electricity
+	}
+	if !failed["MultiphaseElectricity"] && t.MultiphaseElectricity != nil {
+		g.multiphase = t.MultiphaseElectricity
+	}
+	if !failed["Gas"] && t.Gas != nil {
+		g.gas = t.Gas
+	}
+	if len(stale) == 0 || policy == partialForward {
+		return t, stale
+	}
+
+	ret := *t
+	if failed["Electricity"] {
+		ret.Electricity = nil
+		if policy == partialRetain {
+			ret.Electricity = g.electricity
+		}
+	}
+	if failed["MultiphaseElectricity"] {
+		ret.MultiphaseElectricity = nil
+		if policy == partialRetain {
+			ret.MultiphaseElectricity = g.multiphase
+		}
+	}
+	if failed["Gas"] {
+		ret.Gas = nil
+		if policy == partialRetain {
+			ret.Gas = g.gas
+		}
+	}
+	return &ret, stale
+}
+
+// A telegram served with the sections that are stale.
+type partialTelegram struct {
+	*dsmrp1.Telegram
+	SectionsStale []string `json:"sections_stale"`
+}
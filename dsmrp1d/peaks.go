@@ -0,0 +1,143 @@
+package main
+
+// Tracks the quarter-hour average demand peaks per month, on which
+// capacity tariffs (eg. the Belgian "capaciteitstarief") are based.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of months of peaks to keep.
+const peakHistoryMonths = 13
+
+// The highest quarter-hour average demand in a month.
+type monthPeak struct {
+	Month  string    `json:"month"` // eg. 2026-10
+	PeakKW float64   `json:"peak_kw"`
+	Start  time.Time `json:"start"` // start of the quarter with the peak
+}
+
+type peakTracker struct {
+	name string
+
+	lock         sync.Mutex
+	quarterStart time.Time
+	startKWh     float64
+	complete     bool // whether we have seen the start of this quarter
+	lastKWh      float64
+	lastAt       time.Time
+	months       []monthPeak // oldest first
+}
+
+// Report served at /api/v1/peaks.
+type peaksReport struct {
+	QuarterStart time.Time   `json:"quarter_start"`
+	AverageKW    float64     `json:"average_kw"`
+	Complete     bool        `json:"complete"`
+	MonthPeak    *monthPeak  `json:"month_peak"`
+	History      []monthPeak `json:"history"`
+}
+
+func newPeakTracker(meterName string) *peakTracker {
+	p := &peakTracker{name: meterName + "-peaks"}
+	if err := loadState(p.name, &p.months); err != nil {
+		log.Printf("peaks: loading state: %v", err)
+	}
+	return p
+}
+
+func importKWh(e *dsmrp1.ElectricityData) float64 {
+	return e.ImportTotal()
+}
+
+func (p *peakTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if !registersValid(t, stale) {
+		return
+	}
+	kWh := importKWh(t.Electricity)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	quarter := at.Truncate(15 * time.Minute)
+	if p.lastAt.IsZero() {
+		p.quarterStart = quarter
+		p.startKWh = kWh
+		p.complete = at.Equal(quarter)
+	} else if quarter.After(p.quarterStart) {
+		// Close the current quarter, even if the telegram is some
+		// quarters later, with the register interpolated at its end.
+		// It counts if we saw its start.
+		end := p.quarterStart.Add(15 * time.Minute)
+		if p.complete {
+			p.finishQuarter((p.interpolate(end, kWh, at) - p.startKWh) * 4)
+		}
+		p.quarterStart = quarter
+		p.startKWh = p.interpolate(quarter, kWh, at)
+		p.complete = quarter.Sub(p.lastAt) < 15*time.Minute
+	}
+	p.lastKWh = kWh
+	p.lastAt = at
+}
+
+// Returns the register at the given time, interpolated between the
+// last telegram and the current one with register kWh at time at.
+func (p *peakTracker) interpolate(when time.Time, kWh float64,
+	at time.Time) float64 {
+	span := at.Sub(p.lastAt)
+	if span <= 0 {
+		return kWh
+	}
+	return p.lastKWh + (kWh-p.lastKWh)*
+		float64(when.Sub(p.lastAt))/float64(span)
+}
+
+// Records the average demand of the quarter that just ended.
+func (p *peakTracker) finishQuarter(kW float64) {
+	month := p.quarterStart.Format("2006-01")
+	n := len(p.months)
+	if n == 0 || p.months[n-1].Month != month {
+		p.months = append(p.months, monthPeak{Month: month})
+		if len(p.months) > peakHistoryMonths {
+			p.months = p.months[len(p.months)-peakHistoryMonths:]
+		}
+		n = len(p.months)
+	}
+	if !p.months[n-1].Start.IsZero() && kW <= p.months[n-1].PeakKW {
+		return
+	}
+	p.months[n-1].PeakKW = kW
+	p.months[n-1].Start = p.quarterStart
+	if err := saveState(p.name, p.months); err != nil {
+		log.Printf("peaks: saving state: %v", err)
+	}
+}
+
+func (p *peakTracker) report() peaksReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ret := peaksReport{
+		QuarterStart: p.quarterStart,
+		Complete:     p.complete,
+		History:      append([]monthPeak{}, p.months...),
+	}
+	if elapsed := p.lastAt.Sub(p.quarterStart); elapsed > 0 {
+		ret.AverageKW = (p.lastKWh - p.startKWh) / elapsed.Hours()
+	}
+	if n := len(p.months); n > 0 &&
+		p.months[n-1].Month == p.quarterStart.Format("2006-01") {
+		peak := p.months[n-1]
+		ret.MonthPeak = &peak
+	}
+	return ret
+}
+
+func (p *peakTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, p.report())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPeaks(t *testing.T) {
+	p := newPeakTracker("test")
+	observe := func(minutes float64, kWh float64) {
+		at := testStart.Add(time.Duration(minutes * float64(time.Minute)))
+		p.observe(testTelegram(t, at, kWh, 0), at, nil)
+	}
+
+	// A steady 4 kW during the first quarter.
+	observe(0, 100)
+	observe(10, 100+4*10.0/60)
+	observe(14, 100+4*14.0/60)
+
+	// The telegrams stop for over a quarter, after which the usage
+	// continues at 2 kW.
+	observe(31, 100+4*15.0/60+2*16.0/60)
+
+	r := p.report()
+	if r.MonthPeak == nil {
+		t.Fatalf("quarter before the gap was not recorded")
+	}
+	if !r.MonthPeak.Start.Equal(testStart) {
+		t.Fatalf("peak starts at %v; expected %v", r.MonthPeak.Start,
+			testStart)
+	}
+	// The end of the quarter is interpolated over the gap.
+	expected := (4*14.0/60 + (2*16.0/60+4*1.0/60)/17) * 4
+	if math.Abs(r.MonthPeak.PeakKW-expected) > 0.01 {
+		t.Fatalf("peak is %v kW; expected %v", r.MonthPeak.PeakKW, expected)
+	}
+	if !r.QuarterStart.Equal(testStart.Add(30*time.Minute)) || r.Complete {
+		t.Fatalf("current quarter at %v, complete %v", r.QuarterStart,
+			r.Complete)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+// Persistence of the state of trackers across restarts.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Directory to store state in; empty if state should not be persisted.
+var stateDir string
+
+// Loads the state stored under the given name into v.  It is not an
+// error if there is no stored state.
+func loadState(name string, v interface{}) error {
+	if stateDir == "" {
+		return nil
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(stateDir, name+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// Atomically stores v under the given name.
+func saveState(name string, v interface{}) error {
+	if stateDir == "" {
+		return nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir, name+".json")
+	if err = ioutil.WriteFile(path+".tmp", buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
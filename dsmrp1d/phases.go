@@ -0,0 +1,124 @@
+package main
+
+// Monitors the load per phase relative to the main fuse.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An alert is cleared when the load drops this far below the warning
+// level, so that it does not flap.
+const phaseLoadHysteresis = 0.05
+
+type phaseLoad struct {
+	Phase string `json:"phase"`
+
+	// Current as reported by the meter, which is often rounded down to
+	// whole amperes.
+	Current float64 `json:"current"`
+
+	// Current derived from power and voltage, if the reported current
+	// is in whole amperes.
+	DerivedCurrent *float64 `json:"derived_current,omitempty"`
+
+	PowerW   float64  `json:"power_w"` // import minus export
+	Voltage  *float64 `json:"voltage,omitempty"`
+	Load     *float64 `json:"load,omitempty"` // fraction of the fuse
+	Overload bool     `json:"overload"`
+}
+
+// Report served at /api/v1/phases.
+type phasesReport struct {
+	FuseAmperes      float64     `json:"fuse_amperes,omitempty"`
+	Phases           []phaseLoad `json:"phases"`
+	ImbalanceAmperes float64     `json:"imbalance_amperes"`
+}
+
+type phaseMonitor struct {
+	meter string
+	fuse  float64 // in amperes; 0 if unknown
+	warn  float64 // fraction of the fuse at which to raise an alert
+	as    *alerts
+
+	lock   sync.Mutex
+	report phasesReport
+}
+
+func newPhaseMonitor(meter string, fuse, warn float64, as *alerts) *phaseMonitor {
+	return &phaseMonitor{meter: meter, fuse: fuse, warn: warn, as: as}
+}
+
+func (p *phaseMonitor) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	phases := t.Phases()
+	if phases == nil {
+		return
+	}
+
+	report := phasesReport{FuseAmperes: p.fuse}
+	minCurrent, maxCurrent := math.Inf(1), math.Inf(-1)
+	for i, ph := range phases {
+		l := phaseLoad{
+			Phase:   fmt.Sprintf("L%d", i+1),
+			Current: float64(ph.Current),
+			PowerW:  float64(ph.Power) - float64(ph.PowerOut),
+		}
+		current := l.Current
+		if ph.Voltage != nil {
+			voltage := float64(*ph.Voltage)
+			l.Voltage = &voltage
+		}
+		if precise, derived := ph.PreciseCurrent(); derived {
+			d := float64(precise)
+			l.DerivedCurrent = &d
+			current = math.Max(current, d)
+		}
+		minCurrent = math.Min(minCurrent, current)
+		maxCurrent = math.Max(maxCurrent, current)
+
+		if p.fuse > 0 {
+			load := current / p.fuse
+			l.Load = &load
+
+			// Keep an active alert until the load drops well below
+			// the warning level.
+			threshold := p.warn
+			if p.wasOverloaded(l.Phase) {
+				threshold -= phaseLoadHysteresis
+			}
+			l.Overload = load >= threshold
+			p.as.set(p.meter, "overload-"+l.Phase, l.Overload, fmt.Sprintf(
+				"%s at %.1f A is at %.0f%% of the %.0f A fuse",
+				l.Phase, current, load*100, p.fuse), at)
+		}
+		report.Phases = append(report.Phases, l)
+	}
+	report.ImbalanceAmperes = maxCurrent - minCurrent
+
+	p.lock.Lock()
+	p.report = report
+	p.lock.Unlock()
+}
+
+func (p *phaseMonitor) wasOverloaded(phase string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, l := range p.report.Phases {
+		if l.Phase == phase {
+			return l.Overload
+		}
+	}
+	return false
+}
+
+func (p *phaseMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	report := p.report
+	p.lock.Unlock()
+	writeJSON(w, r, report)
+}
@@ -0,0 +1,83 @@
+package main
+
+// Watches the positions of the breaker and gas valves, which the grid
+// operator can operate remotely, eg. to disconnect a household.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type positionState struct {
+	Name     string     `json:"name"` // breaker, valve-1, ...
+	Code     obis.Code  `json:"obis"`
+	Position int32      `json:"position"`
+	Text     string     `json:"text"`
+	Since    *time.Time `json:"since,omitempty"` // unknown until it changes
+}
+
+type positionMonitor struct {
+	meter  string
+	events *eventLog
+	as     *alerts
+
+	lock   sync.Mutex
+	states map[obis.Code]*positionState
+}
+
+func newPositionMonitor(meter string, events *eventLog,
+	as *alerts) *positionMonitor {
+	return &positionMonitor{meter: meter, events: events, as: as,
+		states: make(map[obis.Code]*positionState)}
+}
+
+// Returns the name of the breaker or valve with the given code.
+func positionName(code obis.Code) string {
+	if code == obis.Switch {
+		return "breaker"
+	}
+	return fmt.Sprintf("valve-%c", code[2])
+}
+
+func (p *positionMonitor) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for code, pos := range t.Positions() {
+		name := positionName(code)
+		s, known := p.states[code]
+		if !known {
+			s = &positionState{Name: name, Code: code, Position: int32(pos),
+				Text: pos.String()}
+			p.states[code] = s
+		} else if dsmrp1.Position(s.Position) != pos {
+			typ := "breaker_position"
+			if code != obis.Switch {
+				typ = "valve_position"
+			}
+			p.events.record(event{Type: typ, Time: at, Source: "telegram",
+				Detail: fmt.Sprintf("%s: %s -> %s", name, s.Text, pos)})
+			since := at
+			s.Position, s.Text, s.Since = int32(pos), pos.String(), &since
+		}
+		p.as.set(p.meter, name+"-disconnected", pos != dsmrp1.Connected,
+			fmt.Sprintf("%s is %s", name, pos), at)
+	}
+}
+
+// Serves the current positions sorted by name.
+func (p *positionMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.lock.Lock()
+	ret := []positionState{}
+	for _, s := range p.states {
+		ret = append(ret, *s)
+	}
+	p.lock.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	writeJSON(w, r, ret)
+}
@@ -0,0 +1,440 @@
+package main
+
+// Joins the day-ahead prices of a dynamic contract with the consumption
+// to compute the actual costs, and reports the cheapest hours ahead.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/history"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of cheapest slots reported if not specified.
+const defaultCheapest = 3
+
+// Configuration of the day-ahead price feed.  The feed is a JSON endpoint
+// with a list of prices, eg. that of EnergyZero:
+//
+//	https://api.energyzero.nl/v1/energyprices?fromDate={from}&tillDate={till}&interval=4&usageType=1&inclBtw=true
+//
+// In the URL {from} and {till} are replaced by the start of today and the
+// end of tomorrow.
+type priceFeedConfig struct {
+	url        string
+	list       string        // dotted path to the list of prices
+	timeField  string        // start of the slot in the entries, RFC 3339
+	valueField string        // price in the entries
+	scale      float64       // factor to convert the price to EUR/kWh
+	markup     float64       // added to the price, eg. taxes, in EUR/kWh
+	interval   time.Duration // between polls
+
+	below optionalFloat // raise price-low below this price
+	above optionalFloat // raise price-high above this price
+}
+
+func (c priceFeedConfig) enabled() bool {
+	return c.url != ""
+}
+
+// The price during a slot of the day-ahead market.
+type pricePoint struct {
+	Start     time.Time `json:"start"`
+	EURPerKWh float64   `json:"eur_per_kwh"`
+}
+
+// The latest day-ahead prices, shared by all meters.
+type dayAheadPrices struct {
+	lock    sync.Mutex
+	points  []pricePoint // sorted by start
+	updated time.Time
+}
+
+// Returns the end of the i-th slot: the start of the next, or an hour
+// after its start for the last.
+func (p *dayAheadPrices) end(i int) time.Time {
+	if i+1 < len(p.points) {
+		return p.points[i+1].Start
+	}
+	return p.points[i].Start.Add(time.Hour)
+}
+
+// Returns the price at t, if known.
+func (p *dayAheadPrices) at(t time.Time) *pricePoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	i := sort.Search(len(p.points), func(i int) bool {
+		return p.points[i].Start.After(t)
+	}) - 1
+	if i < 0 || !t.Before(p.end(i)) {
+		return nil
+	}
+	ret := p.points[i]
+	return &ret
+}
+
+// Returns the slots that have not ended at now.
+func (p *dayAheadPrices) upcoming(now time.Time) []pricePoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ret := []pricePoint{}
+	for i, pt := range p.points {
+		if now.Before(p.end(i)) {
+			ret = append(ret, pt)
+		}
+	}
+	return ret
+}
+
+// Returns the slots that start on the given day.
+func (p *dayAheadPrices) day(start time.Time) []pricePoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	end := start.AddDate(0, 0, 1)
+	ret := []pricePoint{}
+	for _, pt := range p.points {
+		if !pt.Start.Before(start) && pt.Start.Before(end) {
+			ret = append(ret, pt)
+		}
+	}
+	return ret
+}
+
+func (p *dayAheadPrices) set(points []pricePoint, at time.Time) {
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Start.Before(points[j].Start)
+	})
+	p.lock.Lock()
+	p.points = points
+	p.updated = at
+	p.lock.Unlock()
+}
+
+// Costs over a period.  Export is credited at the same price.
+type costPeriod struct {
+	Date      string  `json:"date"` // eg. 2026-10-16 or 2026-10
+	ImportKWh float64 `json:"import_kwh"`
+	ExportKWh float64 `json:"export_kwh"`
+	ImportEUR float64 `json:"import_eur"`
+	ExportEUR float64 `json:"export_eur"`
+	NetEUR    float64 `json:"net_eur"`
+
+	// Energy imported or exported while the price was unknown: it is
+	// not included in the costs.
+	UnpricedKWh float64 `json:"unpriced_kwh"`
+
+	// The costs per tariff of the meter, starting at tariff 1.
+	Tariffs []tariffCost `json:"tariffs"`
+}
+
+// Costs of the energy registered in one tariff.
+type tariffCost struct {
+	Tariff    int     `json:"tariff"`
+	ImportKWh float64 `json:"import_kwh"`
+	ExportKWh float64 `json:"export_kwh"`
+	ImportEUR float64 `json:"import_eur"`
+	ExportEUR float64 `json:"export_eur"`
+}
+
+func (c *costPeriod) add(imported, exported float64, price *pricePoint) {
+	c.ImportKWh += imported
+	c.ExportKWh += exported
+	if price == nil {
+		c.UnpricedKWh += imported + exported
+		return
+	}
+	c.ImportEUR += imported * price.EURPerKWh
+	c.ExportEUR += exported * price.EURPerKWh
+	c.NetEUR = c.ImportEUR - c.ExportEUR
+}
+
+// Adds the energy imported and exported per tariff.
+func (c *costPeriod) addTariffs(imported, exported []float64,
+	price *pricePoint) {
+	for len(c.Tariffs) < len(imported) || len(c.Tariffs) < len(exported) {
+		c.Tariffs = append(c.Tariffs, tariffCost{Tariff: len(c.Tariffs) + 1})
+	}
+	for i, kWh := range imported {
+		c.Tariffs[i].ImportKWh += kWh
+		if price != nil {
+			c.Tariffs[i].ImportEUR += kWh * price.EURPerKWh
+		}
+	}
+	for i, kWh := range exported {
+		c.Tariffs[i].ExportKWh += kWh
+		if price != nil {
+			c.Tariffs[i].ExportEUR += kWh * price.EURPerKWh
+		}
+	}
+}
+
+// Returns the increase of each register since last, or zero if it
+// decreased or was not seen.
+func increases(cur, last []float64) []float64 {
+	ret := make([]float64, len(cur))
+	for i := range cur {
+		if i < len(last) && cur[i] >= last[i] {
+			ret[i] = cur[i] - last[i]
+		}
+	}
+	return ret
+}
+
+type costState struct {
+	Day        costPeriod `json:"day"`
+	Month      costPeriod `json:"month"`
+	LastImport *float64   `json:"last_import_kwh"` // registers last seen
+	LastExport *float64   `json:"last_export_kwh"`
+	LastID     string     `json:"last_id"` // of the meter
+
+	// Registers per tariff last seen.
+	LastImportTariffs []float64 `json:"last_import_kwh_per_tariff"`
+	LastExportTariffs []float64 `json:"last_export_kwh_per_tariff"`
+}
+
+// Report served at /api/v1/prices.
+type priceReport struct {
+	Current   *pricePoint  `json:"current"`
+	UpdatedAt *time.Time   `json:"updated_at"`
+	Today     []pricePoint `json:"today"`
+	Tomorrow  []pricePoint `json:"tomorrow"`
+	Cheapest  []pricePoint `json:"cheapest"` // upcoming, cheapest first
+	Costs     struct {
+		Today     *costPeriod `json:"today"`
+		ThisMonth *costPeriod `json:"this_month"`
+	} `json:"costs"`
+}
+
+type costTracker struct {
+	name   string
+	meter  string
+	cfg    priceFeedConfig
+	prices *dayAheadPrices
+	as     *alerts
+
+	lock  sync.Mutex
+	state costState
+	saved time.Time
+}
+
+func newCostTracker(meter string, cfg priceFeedConfig,
+	prices *dayAheadPrices, as *alerts) *costTracker {
+	c := &costTracker{name: meter + "-costs", meter: meter, cfg: cfg,
+		prices: prices, as: as}
+	if err := loadState(c.name, &c.state); err != nil {
+		log.Printf("prices: loading state: %v", err)
+	}
+	return c
+}
+
+func (c *costTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if c.prices == nil || !registersValid(t, stale) {
+		return
+	}
+	regs := readRegisters(t, stale)
+	imported, exported := regs.importKWh(), regs.exportKWh()
+	price := c.prices.at(at)
+
+	if price != nil && c.cfg.below.set {
+		c.as.set(c.meter, "price-low", price.EURPerKWh < c.cfg.below.value,
+			fmt.Sprintf("electricity price of %.3f EUR/kWh is below %.3f",
+				price.EURPerKWh, c.cfg.below.value), at)
+	}
+	if price != nil && c.cfg.above.set {
+		c.as.set(c.meter, "price-high", price.EURPerKWh > c.cfg.above.value,
+			fmt.Sprintf("electricity price of %.3f EUR/kWh is above %.3f",
+				price.EURPerKWh, c.cfg.above.value), at)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// No usage across a meter swap or reset.
+	var dImport, dExport float64
+	sameMeter := c.state.LastID == t.ID
+	if c.state.LastImport != nil && imported >= *c.state.LastImport &&
+		sameMeter {
+		dImport = imported - *c.state.LastImport
+	}
+	if c.state.LastExport != nil && exported >= *c.state.LastExport &&
+		sameMeter {
+		dExport = exported - *c.state.LastExport
+	}
+	var dImports, dExports []float64
+	if sameMeter {
+		dImports = increases(regs.ImportTariffs, c.state.LastImportTariffs)
+		dExports = increases(regs.ExportTariffs, c.state.LastExportTariffs)
+	}
+	c.state.LastImport, c.state.LastExport = &imported, &exported
+	c.state.LastImportTariffs = regs.ImportTariffs
+	c.state.LastExportTariffs = regs.ExportTariffs
+	c.state.LastID = t.ID
+
+	local := at.Local()
+	date := local.Format("2006-01-02")
+	month := local.Format("2006-01")
+	changed := false
+	if c.state.Day.Date != date {
+		c.state.Day = costPeriod{Date: date}
+		changed = true
+	}
+	if c.state.Month.Date != month {
+		c.state.Month = costPeriod{Date: month}
+		changed = true
+	}
+	c.state.Day.add(dImport, dExport, price)
+	c.state.Month.add(dImport, dExport, price)
+	c.state.Day.addTariffs(dImports, dExports, price)
+	c.state.Month.addTariffs(dImports, dExports, price)
+
+	if changed || at.Sub(c.saved) >= time.Minute {
+		if err := saveState(c.name, c.state); err != nil {
+			log.Printf("prices: saving state: %v", err)
+		}
+		c.saved = at
+	}
+}
+
+func (c *costTracker) report(now time.Time, cheapest int) priceReport {
+	var report priceReport
+	if c.prices != nil {
+		report.Current = c.prices.at(now)
+		today := history.StartOfDay(now)
+		report.Today = c.prices.day(today)
+		report.Tomorrow = c.prices.day(today.AddDate(0, 0, 1))
+		report.Cheapest = c.prices.upcoming(now)
+		sort.SliceStable(report.Cheapest, func(i, j int) bool {
+			return report.Cheapest[i].EURPerKWh < report.Cheapest[j].EURPerKWh
+		})
+		if len(report.Cheapest) > cheapest {
+			report.Cheapest = report.Cheapest[:cheapest]
+		}
+		c.prices.lock.Lock()
+		if !c.prices.updated.IsZero() {
+			updated := c.prices.updated
+			report.UpdatedAt = &updated
+		}
+		c.prices.lock.Unlock()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	local := now.Local()
+	if c.state.Day.Date == local.Format("2006-01-02") {
+		day := c.state.Day
+		report.Costs.Today = &day
+	}
+	if c.state.Month.Date == local.Format("2006-01") {
+		month := c.state.Month
+		report.Costs.ThisMonth = &month
+	}
+	return report
+}
+
+// Serves the prices and costs.  The query parameter cheapest sets the
+// number of cheapest upcoming slots to report.
+func (c *costTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cheapest := defaultCheapest
+	if s := r.URL.Query().Get("cheapest"); s != "" {
+		var err error
+		if cheapest, err = strconv.Atoi(s); err != nil || cheapest < 0 {
+			writeError(w, http.StatusBadRequest, apiError{
+				Error: "cheapest: expected a non-negative number"})
+			return
+		}
+	}
+	writeJSON(w, r, c.report(clock.Now(), cheapest))
+}
+
+// Extracts the prices from the JSON returned by the feed.
+func (c priceFeedConfig) parse(payload []byte) ([]pricePoint, error) {
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	if c.list != "" {
+		var path []string
+		path, tree = lookupField(tree, strings.Split(c.list, "."))
+		if path == nil {
+			return nil, errors.New(fmt.Sprintf("nothing at %s", c.list))
+		}
+	}
+	entries, ok := tree.([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of prices")
+	}
+	var ret []pricePoint
+	for i, entry := range entries {
+		_, v := lookupField(entry, strings.Split(c.timeField, "."))
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%d: no time at %s",
+				i, c.timeField))
+		}
+		start, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%d: %v", i, err))
+		}
+		_, v = lookupField(entry, strings.Split(c.valueField, "."))
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("%d: no number at %s",
+				i, c.valueField))
+		}
+		price, err := n.Float64()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%d: %v", i, err))
+		}
+		ret = append(ret, pricePoint{start, price*c.scale + c.markup})
+	}
+	return ret, nil
+}
+
+// Polls the feed for the prices of today and tomorrow.
+func (c priceFeedConfig) poll(p *dayAheadPrices) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		points, err := c.fetch(client, clock.Now())
+		if err != nil {
+			log.Printf("prices: %v", err)
+		} else {
+			p.set(points, clock.Now())
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+func (c priceFeedConfig) fetch(client *http.Client, now time.Time) (
+	[]pricePoint, error) {
+	today := history.StartOfDay(now)
+	url := strings.NewReplacer(
+		"{from}", today.UTC().Format(time.RFC3339),
+		"{till}", today.AddDate(0, 0, 2).UTC().Format(time.RFC3339),
+	).Replace(c.url)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("%s: %s", url, resp.Status))
+	}
+	return c.parse(body)
+}
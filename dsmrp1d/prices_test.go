@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCostsPerTariff(t *testing.T) {
+	prices := &dayAheadPrices{}
+	prices.set([]pricePoint{{testStart, 0.25}}, testStart)
+	c := newCostTracker("test", priceFeedConfig{}, prices, nil)
+	c.observe(threeTariffTelegram(t, testStart, [3]float64{10, 20, 30}),
+		testStart, nil)
+	at := testStart.Add(time.Minute)
+	c.observe(threeTariffTelegram(t, at, [3]float64{11, 20, 33}), at, nil)
+
+	day := c.state.Day
+	if day.ImportKWh != 4 || day.ImportEUR != 1 {
+		t.Fatalf("got %v kWh for %v EUR; expected 4 kWh for 1 EUR",
+			day.ImportKWh, day.ImportEUR)
+	}
+	if len(day.Tariffs) != 3 {
+		t.Fatalf("got %d tariffs; expected 3", len(day.Tariffs))
+	}
+	third := day.Tariffs[2]
+	if third.Tariff != 3 || third.ImportKWh != 3 || third.ImportEUR != 0.75 {
+		t.Fatalf("tariff 3: got %+v", third)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+// Bounded delivery queues with retries, so that a slow or unreachable
+// destination does not hold up the meters.
+
+import (
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Longest time to wait between attempts at a delivery.
+const maxDeliveryBackoff = time.Minute
+
+// Configuration of the delivery queues.
+type queueConfig struct {
+	size     int           // number of deliveries to buffer
+	attempts int           // at a delivery before it is dropped
+	backoff  time.Duration // before the first retry; doubles after each
+}
+
+type retryQueue struct {
+	name string
+	cfg  queueConfig
+	jobs chan func() error
+
+	delivered uint64
+	failed    uint64 // dropped after too many attempts
+	dropped   uint64 // dropped because the queue was full
+}
+
+// Creates a queue as configured and starts its worker.
+func newRetryQueue(name string, cfg queueConfig) *retryQueue {
+	q := &retryQueue{name: name, cfg: cfg,
+		jobs: make(chan func() error, cfg.size)}
+	go q.run()
+	return q
+}
+
+// Queues a delivery.  Drops it if the queue is full.
+func (q *retryQueue) push(job func() error) {
+	select {
+	case q.jobs <- job:
+	default:
+		if atomic.AddUint64(&q.dropped, 1)%100 == 1 {
+			log.Printf("%s: queue full; dropping", q.name)
+		}
+	}
+}
+
+func (q *retryQueue) run() {
+	for job := range q.jobs {
+		backoff := q.cfg.backoff
+		for attempt := 1; ; attempt++ {
+			s := startSpan(nil, "deliver", "queue", q.name,
+				"attempt", strconv.Itoa(attempt))
+			err := job()
+			s.finish(err)
+			if err == nil {
+				atomic.AddUint64(&q.delivered, 1)
+				break
+			}
+			if attempt >= q.cfg.attempts {
+				log.Printf("%s: giving up: %v", q.name, err)
+				atomic.AddUint64(&q.failed, 1)
+				break
+			}
+			log.Printf("%s: %v; retrying in %v", q.name, err, backoff)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxDeliveryBackoff {
+				backoff = maxDeliveryBackoff
+			}
+		}
+	}
+}
+
+// Delivery statistics of a set of queues.
+type queueMetrics []*retryQueue
+
+func (qs queueMetrics) writeMetrics(w io.Writer) {
+	length := &sinks.MetricFamily{Name: "dsmrp1d_queue_length", Type: "gauge",
+		Help: "Deliveries waiting in the queue."}
+	delivered := &sinks.MetricFamily{Name: "dsmrp1d_queue_delivered_total",
+		Type: "counter", Help: "Successful deliveries."}
+	failed := &sinks.MetricFamily{Name: "dsmrp1d_queue_failed_total",
+		Type: "counter", Help: "Deliveries dropped after too many attempts."}
+	dropped := &sinks.MetricFamily{Name: "dsmrp1d_queue_dropped_total",
+		Type: "counter", Help: "Deliveries dropped as the queue was full."}
+	for _, q := range qs {
+		l := sinks.Labels("queue", q.name)
+		length.Add(l, float64(len(q.jobs)))
+		delivered.Add(l, float64(atomic.LoadUint64(&q.delivered)))
+		failed.Add(l, float64(atomic.LoadUint64(&q.failed)))
+		dropped.Add(l, float64(atomic.LoadUint64(&q.dropped)))
+	}
+	for _, f := range []*sinks.MetricFamily{length, delivered, failed, dropped} {
+		f.Write(w)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+// Tracks the cumulative registers at the start of the day and month, so
+// that consumption over those periods can be reported.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"sync"
+	"time"
+)
+
+// The cumulative registers of a meter.
+type registers struct {
+	ImportHigh float64  `json:"import_high_kwh"`
+	ImportLow  float64  `json:"import_low_kwh"`
+	ExportHigh float64  `json:"export_high_kwh"`
+	ExportLow  float64  `json:"export_low_kwh"`
+	Import     float64  `json:"import_kwh"` // over all tariffs
+	Export     float64  `json:"export_kwh"`
+	Gas        *float64 `json:"gas_m3,omitempty"`
+
+	// Per tariff, starting at tariff 1, also for meters with more than
+	// the two tariffs above.
+	ImportTariffs []float64 `json:"import_kwh_per_tariff"`
+	ExportTariffs []float64 `json:"export_kwh_per_tariff"`
+}
+
+// Returns whether the section of the telegram failed to parse.
+func isStale(stale []string, section string) bool {
+	for _, s := range stale {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the electricity registers of the telegram can be
+// trusted.  Those of a section that failed to parse are zero when
+// forwarded and missing when nulled, which would look like a reset.
+func registersValid(t *dsmrp1.Telegram, stale []string) bool {
+	return t.Electricity != nil && !isStale(stale, "Electricity")
+}
+
+// Reads the registers of the telegram, leaving out the gas reading if
+// it is stale.
+func readRegisters(t *dsmrp1.Telegram, stale []string) registers {
+	var ret registers
+	if e := t.Electricity; e != nil {
+		ret.ImportHigh = float64(e.KWh)
+		ret.ImportLow = float64(e.KWhLow)
+		ret.ExportHigh = float64(e.KWhOut)
+		ret.ExportLow = float64(e.KWhOutLow)
+		ret.Import = e.ImportTotal()
+		ret.Export = e.ExportTotal()
+		ret.ImportTariffs = float64s(e.ImportPerTariff())
+		ret.ExportTariffs = float64s(e.ExportPerTariff())
+	}
+	if t.Gas != nil && !isStale(stale, "Gas") {
+		gas := float64(t.Gas.LastRecord.Value)
+		ret.Gas = &gas
+	}
+	return ret
+}
+
+func (r registers) importKWh() float64 { return r.Import }
+func (r registers) exportKWh() float64 { return r.Export }
+
+// Fills in the totals and tariffs of registers saved before those were
+// tracked.
+func (r *registers) fillTotals() {
+	if r.Import == 0 && r.Export == 0 {
+		r.Import = r.ImportHigh + r.ImportLow
+		r.Export = r.ExportHigh + r.ExportLow
+	}
+	if r.ImportTariffs == nil {
+		r.ImportTariffs = []float64{r.ImportLow, r.ImportHigh}
+		r.ExportTariffs = []float64{r.ExportLow, r.ExportHigh}
+	}
+}
+
+func float64s(vs []float32) []float64 {
+	ret := make([]float64, len(vs))
+	for i, v := range vs {
+		ret[i] = float64(v)
+	}
+	return ret
+}
+
+// Returns the difference per tariff; a tariff missing from either
+// counts as zero.
+func subTariffs(a, b []float64) []float64 {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	ret := make([]float64, n)
+	for i := range ret {
+		if i < len(a) {
+			ret[i] += a[i]
+		}
+		if i < len(b) {
+			ret[i] -= b[i]
+		}
+	}
+	return ret
+}
+
+// Returns the difference between the registers.
+func (r registers) sub(o registers) registers {
+	ret := registers{
+		ImportHigh: r.ImportHigh - o.ImportHigh,
+		ImportLow:  r.ImportLow - o.ImportLow,
+		ExportHigh: r.ExportHigh - o.ExportHigh,
+		ExportLow:  r.ExportLow - o.ExportLow,
+		Import:     r.Import - o.Import,
+		Export:     r.Export - o.Export,
+
+		ImportTariffs: subTariffs(r.ImportTariffs, o.ImportTariffs),
+		ExportTariffs: subTariffs(r.ExportTariffs, o.ExportTariffs),
+	}
+	if r.Gas != nil && o.Gas != nil {
+		gas := *r.Gas - *o.Gas
+		ret.Gas = &gas
+	}
+	return ret
+}
+
+// The registers at the start of a period.
+type periodStart struct {
+	Period    string    `json:"period"` // eg. 2026-10-16 or 2026-10
+	At        time.Time `json:"at"`
+	Partial   bool      `json:"partial"` // whether we missed the start
+	Registers registers `json:"registers"`
+}
+
+type registerTracker struct {
+	name   string
+	events *eventLog
+
+	lock   sync.Mutex
+	state  registerState
+	latest registers
+	seen   bool // whether latest is set
+}
+
+type registerState struct {
+	Day   periodStart `json:"day"`
+	Month periodStart `json:"month"`
+	Last  time.Time   `json:"last"` // time of the last telegram
+	ID    string      `json:"id"`   // of the meter
+}
+
+func newRegisterTracker(meter string, events *eventLog) *registerTracker {
+	r := &registerTracker{name: meter + "-registers", events: events}
+	if err := loadState(r.name, &r.state); err != nil {
+		log.Printf("registers: loading state: %v", err)
+	}
+	r.state.Day.Registers.fillTotals()
+	r.state.Month.Registers.fillTotals()
+	return r
+}
+
+// Registers that decrease by more than this were reset.
+const registerResetSlack = 0.01
+
+// Returns an event if the registers of the meter with the given ID do
+// not continue the previous ones: when the meter was replaced or its
+// registers were reset.
+func registerDiscontinuity(prevID string, prev registers, id string,
+	cur registers, at time.Time) *event {
+	if prevID != "" && id != "" && prevID != id {
+		return &event{Type: "meter_swap", Time: at, Source: "registers",
+			Detail: fmt.Sprintf("meter %s replaced by %s", prevID, id)}
+	}
+	check := func(name string, prev, cur float64) *event {
+		if cur >= prev-registerResetSlack {
+			return nil
+		}
+		return &event{Type: "register_reset", Time: at, Source: "registers",
+			Detail: fmt.Sprintf("%s decreased from %v to %v", name, prev, cur)}
+	}
+	if ev := check("import_kwh", prev.Import, cur.Import); ev != nil {
+		return ev
+	}
+	if ev := check("export_kwh", prev.Export, cur.Export); ev != nil {
+		return ev
+	}
+	if ev := check("import_high_kwh", prev.ImportHigh, cur.ImportHigh); ev != nil {
+		return ev
+	}
+	if ev := check("import_low_kwh", prev.ImportLow, cur.ImportLow); ev != nil {
+		return ev
+	}
+	if ev := check("export_high_kwh", prev.ExportHigh, cur.ExportHigh); ev != nil {
+		return ev
+	}
+	if ev := check("export_low_kwh", prev.ExportLow, cur.ExportLow); ev != nil {
+		return ev
+	}
+	for i := 2; i < len(prev.ImportTariffs) && i < len(cur.ImportTariffs); i++ {
+		if ev := check(fmt.Sprintf("import_tariff%d_kwh", i+1),
+			prev.ImportTariffs[i], cur.ImportTariffs[i]); ev != nil {
+			return ev
+		}
+	}
+	for i := 2; i < len(prev.ExportTariffs) && i < len(cur.ExportTariffs); i++ {
+		if ev := check(fmt.Sprintf("export_tariff%d_kwh", i+1),
+			prev.ExportTariffs[i], cur.ExportTariffs[i]); ev != nil {
+			return ev
+		}
+	}
+	if prev.Gas != nil && cur.Gas != nil {
+		return check("gas_m3", *prev.Gas, *cur.Gas)
+	}
+	return nil
+}
+
+func (r *registerTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if !registersValid(t, stale) {
+		return
+	}
+	regs := readRegisters(t, stale)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// While the gas reading is stale, we keep the last one.
+	if regs.Gas == nil && isStale(stale, "Gas") {
+		regs.Gas = r.latest.Gas
+	}
+
+	day := at.Format("2006-01-02")
+	month := at.Format("2006-01")
+	changed := false
+
+	// If the meter was replaced or reset, the registers restart.  We
+	// move the start of the periods along, so that the usage over them
+	// is that of the old meter up to the swap plus that of the new one
+	// since.  After a restart we do not know the usage of the old meter
+	// since the start of the period, and only count the new one.
+	var ev *event
+	if r.seen {
+		ev = registerDiscontinuity(r.state.ID, r.latest, t.ID, regs, at)
+	} else if r.state.Day.Period != "" {
+		ev = registerDiscontinuity(r.state.ID, r.state.Day.Registers,
+			t.ID, regs, at)
+	}
+	if ev != nil {
+		for _, p := range []*periodStart{&r.state.Day, &r.state.Month} {
+			if r.seen {
+				p.Registers = regs.sub(r.latest.sub(p.Registers))
+			} else {
+				p.Registers, p.Partial = regs, true
+			}
+		}
+		changed = true
+		if r.events != nil {
+			r.events.record(*ev)
+		}
+	}
+	if r.state.ID != t.ID {
+		r.state.ID = t.ID
+		changed = true
+	}
+
+	// We only know the registers at the start of the period, if we
+	// received a telegram late in the previous one.
+	partial := r.state.Last.IsZero() || at.Sub(r.state.Last) > time.Minute
+	if r.state.Day.Period != day {
+		r.state.Day = periodStart{day, at, partial, regs}
+		changed = true
+	}
+	if r.state.Month.Period != month {
+		r.state.Month = periodStart{month, at, partial, regs}
+		changed = true
+	}
+	r.state.Last = at
+	r.latest = regs
+	r.seen = true
+
+	if changed {
+		if err := saveState(r.name, r.state); err != nil {
+			log.Printf("registers: saving state: %v", err)
+		}
+	}
+}
+
+// Returns the consumption since the start of the day and month,
+// together with those starts.
+func (r *registerTracker) usage() (day, month registers,
+	dayStart, monthStart periodStart) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.latest.sub(r.state.Day.Registers),
+		r.latest.sub(r.state.Month.Registers),
+		r.state.Day, r.state.Month
+}
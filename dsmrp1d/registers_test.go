@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"testing"
+	"time"
+)
+
+func TestRegistersSkipStale(t *testing.T) {
+	events := newEventLog("test")
+	r := newRegisterTracker("test", events)
+	observe := func(minutes int, kWh float64, stale []string) {
+		at := testStart.Add(time.Duration(minutes) * time.Minute)
+		r.observe(testTelegram(t, at, kWh, 0), at, stale)
+	}
+
+	observe(0, 100, nil)
+	observe(1, 100.5, nil)
+
+	// With -partial=forward the registers of a failed section are zero,
+	// and with -partial=null the section is missing.
+	observe(2, 0, []string{"Electricity"})
+	nulled := testTelegram(t, testStart.Add(3*time.Minute), 0, 0)
+	nulled.Electricity = nil
+	r.observe(nulled, testStart.Add(3*time.Minute), []string{"Electricity"})
+
+	observe(4, 101, nil)
+
+	day, month, dayStart, _ := r.usage()
+	if day.importKWh() != 1 || month.importKWh() != 1 {
+		t.Fatalf("usage: got %v today and %v this month; expected 1",
+			day.importKWh(), month.importKWh())
+	}
+	if dayStart.Registers.ImportLow != 100 {
+		t.Fatalf("day starts at %v; expected 100",
+			dayStart.Registers.ImportLow)
+	}
+	if len(events.events) != 0 {
+		t.Fatalf("got events %+v", events.events)
+	}
+}
+
+func TestRegistersTotals(t *testing.T) {
+	r := newRegisterTracker("test", nil)
+	observe := func(minutes int, kWh float64) {
+		at := testStart.Add(time.Duration(minutes) * time.Minute)
+		tg, errs := dsmrp1.NewTelegramBuilder(dsmrp1.DSMR5).
+			Timestamp(at).
+			EquipmentID("test").
+			Line(obis.ImportTotal, fmt.Sprintf("%010.3f*kWh", kWh)).
+			Line(obis.ExportTotal, "000000.000*kWh").
+			Tariff(1).
+			Power(0, 0).
+			PowerFailures(0, 0).
+			Phase(1, 230, 0, 0, 0).
+			VoltageEvents(1, 0, 0).
+			Telegram()
+		if errs != nil {
+			t.Fatalf("building telegram: %v", errs)
+		}
+		r.observe(tg, at, nil)
+	}
+	observe(0, 100)
+	observe(1, 102.5)
+	day, _, _, _ := r.usage()
+	if day.importKWh() != 2.5 {
+		t.Fatalf("usage: got %v; expected 2.5", day.importKWh())
+	}
+}
+
+// Returns a telegram with the given registers of tariffs 1, 2 and 3.
+func threeTariffTelegram(t *testing.T, at time.Time,
+	kWh [3]float64) *dsmrp1.Telegram {
+	tg, errs := dsmrp1.NewTelegramBuilder(dsmrp1.DSMR5).
+		Timestamp(at).
+		EquipmentID("test").
+		Import(1, kWh[0]).
+		Import(2, kWh[1]).
+		Import(3, kWh[2]).
+		Export(1, 0).
+		Export(2, 0).
+		Tariff(3).
+		Power(0, 0).
+		PowerFailures(0, 0).
+		Phase(1, 230, 0, 0, 0).
+		VoltageEvents(1, 0, 0).
+		Telegram()
+	if errs != nil {
+		t.Fatalf("building telegram: %v", errs)
+	}
+	return tg
+}
+
+func TestRegistersThirdTariff(t *testing.T) {
+	r := newRegisterTracker("test", nil)
+	r.observe(threeTariffTelegram(t, testStart, [3]float64{10, 20, 30}),
+		testStart, nil)
+	at := testStart.Add(time.Minute)
+	r.observe(threeTariffTelegram(t, at, [3]float64{11, 20, 33}), at, nil)
+
+	day, _, _, _ := r.usage()
+	if day.importKWh() != 4 {
+		t.Fatalf("usage: got %v; expected 4", day.importKWh())
+	}
+	expected := []float64{1, 0, 3}
+	if fmt.Sprint(day.ImportTariffs) != fmt.Sprint(expected) {
+		t.Fatalf("usage per tariff: got %v; expected %v",
+			day.ImportTariffs, expected)
+	}
+}
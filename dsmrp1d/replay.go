@@ -0,0 +1,115 @@
+package main
+
+// Replays a capture instead of reading from a meter.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parses a replay speed like "10x" or "0.5".
+func parseSpeed(s string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, errors.New(fmt.Sprintf("invalid speed: %s", s))
+	}
+	return speed, nil
+}
+
+// A replayed telegram with the time it was recorded.
+type replayedTelegram struct {
+	t  *dsmrp1.Telegram
+	at time.Time
+}
+
+// Replays the telegrams in the capture file at path with the given
+// speed, keeping the time between telegrams as recorded.  Telegrams
+// without a recorded time are sent one second apart and stamped with
+// the time they are sent.  If loop is set, the capture is replayed
+// indefinitely, each time shifted to follow the previous one.
+func replayCapture(path string, speed float64, loop bool) (
+	<-chan replayedTelegram, error) {
+	rc, err := dsmrp1.OpenCapture(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan replayedTelegram, 1)
+	go func() {
+		defer close(c)
+		var shift time.Duration
+		for {
+			span := replayOnce(rc, speed, shift, c)
+			rc.Close()
+			if !loop {
+				log.Printf("Replay of %s finished", path)
+				return
+			}
+			shift += span + time.Second
+			if rc, err = dsmrp1.OpenCapture(path); err != nil {
+				log.Printf("Replay: %v", err)
+				return
+			}
+		}
+	}()
+	return c, nil
+}
+
+// Replays the capture in r once with the recorded times moved forward
+// by shift.  Returns the time between the first and last recorded time.
+func replayOnce(r io.Reader, speed float64, shift time.Duration,
+	c chan<- replayedTelegram) time.Duration {
+	var prev, first time.Time
+	start := true
+	cr := dsmrp1.NewCaptureReader(r)
+	for {
+		raw, at, err := cr.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Replay: %v", err)
+			}
+			if first.IsZero() {
+				return 0
+			}
+			return prev.Sub(first)
+		}
+
+		delay := time.Second
+		if start {
+			delay = 0
+		} else if !at.IsZero() && !prev.IsZero() {
+			delay = at.Sub(prev)
+		}
+		if !at.IsZero() {
+			prev = at
+			if first.IsZero() {
+				first = at
+			}
+		}
+		start = false
+		if delay > 0 {
+			time.Sleep(time.Duration(float64(delay) / speed))
+		}
+
+		sp := startSpan(nil, "parse telegram", "source", "replay")
+		t, errs := dsmrp1.ParseTelegramWith(raw, parseOptions)
+		if errs != nil && (t == nil || partialTelegrams == partialDrop) {
+			sp.finish(errs[0])
+			log.Printf("Replay: %v", errs)
+			continue
+		}
+		sp.finish(nil)
+		if at.IsZero() {
+			at = clock.Now()
+		} else {
+			at = at.Add(shift)
+		}
+		c <- replayedTelegram{t: t, at: at}
+	}
+}
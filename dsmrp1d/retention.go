@@ -0,0 +1,249 @@
+package main
+
+// Retention of the captured history.  Complete days of raw captures are
+// compacted into minute and daily aggregates, after which raw captures
+// and minute aggregates are removed once they are older than configured.
+//
+// Aggregates are CSV files in the capture directory with the columns of
+// an export: <meter>-minutes-<date>.csv per day and <meter>-days.csv.
+// Registers are the last value in the period and power is the average.
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/history"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type retentionConfig struct {
+	raw     time.Duration // keep raw captures this long; 0 for forever
+	minutes time.Duration // keep minute aggregates this long; 0 for forever
+}
+
+// Parses a duration that may also use the units d (day), w (week)
+// and y (365 days), eg. "7d".
+func parseRetention(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	if unit, ok := units[s[len(s)-1:]]; ok {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || n < 0 {
+			return 0, errors.New(fmt.Sprintf("invalid duration: %s", s))
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (e *exporter) minutesPath(day time.Time) string {
+	return filepath.Join(e.dir, e.name+"-minutes-"+day.Format("20060102")+".csv")
+}
+
+func (e *exporter) daysPath() string {
+	return filepath.Join(e.dir, e.name+"-days.csv")
+}
+
+// Calls f for each row in the aggregate file at path in [from, to).
+func eachAggregate(path string, from, to time.Time,
+	f func(history.Row) error) error {
+	fh, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	r := csv.NewReader(fh)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		row, err := history.ParseRecord(record)
+		if err != nil {
+			continue // the header
+		}
+		if row.At.Before(from) || !row.At.Before(to) {
+			continue
+		}
+		if err = f(row); err != nil {
+			return err
+		}
+	}
+}
+
+// Calls f for each aggregated row of the given resolution in [from, to).
+func (e *exporter) eachAggregate(resolution string, from, to time.Time,
+	f func(history.Row) error) error {
+	if resolution == "day" {
+		return eachAggregate(e.daysPath(), from, to, f)
+	}
+	first, err := e.firstMinutes()
+	if err != nil || first.IsZero() {
+		return err
+	}
+	day := history.StartOfDay(from)
+	if day.Before(first) {
+		day = first
+	}
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if err := eachAggregate(e.minutesPath(day), from, to, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the day of the oldest minute aggregates, if any.
+func (e *exporter) firstMinutes() (time.Time, error) {
+	matches, err := filepath.Glob(filepath.Join(e.dir, e.name+"-minutes-*.csv"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var first time.Time
+	for _, path := range matches {
+		day, err := e.minutesDay(path)
+		if err == nil && (first.IsZero() || day.Before(first)) {
+			first = day
+		}
+	}
+	return first, nil
+}
+
+func (e *exporter) minutesDay(path string) (time.Time, error) {
+	s := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path),
+		e.name+"-minutes-"), ".csv")
+	return time.ParseInLocation("20060102", s, time.Local)
+}
+
+// Returns the last day in the daily aggregates, or the zero time.
+func (e *exporter) lastDay() (time.Time, error) {
+	var last time.Time
+	err := eachAggregate(e.daysPath(), time.Time{}, clock.Now(),
+		func(row history.Row) error {
+			last = row.At
+			return nil
+		})
+	return last, err
+}
+
+// Writes the minute and daily aggregates of the given day.
+func (e *exporter) compactDay(day time.Time) error {
+	var rows []history.Row
+	err := e.each(day, day.AddDate(0, 0, 1), func(row history.Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	return e.importRows(rows)
+}
+
+// Writes the header and records to a new CSV file at path.
+func writeCSV(path string, records [][]string) error {
+	fh, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(fh)
+	w.Write(history.Columns)
+	w.WriteAll(records)
+	if err = w.Error(); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// Compacts complete days and removes history past its retention.
+func (e *exporter) compact(cfg retentionConfig, now time.Time) error {
+	cs, err := e.captures()
+	if err != nil {
+		return err
+	}
+	last, err := e.lastDay()
+	if err != nil {
+		return err
+	}
+	today := history.StartOfDay(now)
+	if len(cs) > 0 {
+		day := history.StartOfDay(cs[0].start)
+		if !last.IsZero() && !day.After(last) {
+			day = last.AddDate(0, 0, 1)
+		}
+		for ; day.Before(today); day = day.AddDate(0, 0, 1) {
+			if err = e.compactDay(day); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only remove raw captures of compacted days.
+	if last, err = e.lastDay(); err != nil {
+		return err
+	}
+	if cfg.raw > 0 {
+		cutoff := now.Add(-cfg.raw)
+		if end := last.AddDate(0, 0, 1); end.Before(cutoff) {
+			cutoff = end
+		}
+		for i, c := range cs {
+			// A capture ends when the next one starts.
+			if i+1 == len(cs) || cs[i+1].start.After(cutoff) {
+				break
+			}
+			if err = os.Remove(c.path); err != nil {
+				return err
+			}
+		}
+	}
+	if cfg.minutes > 0 {
+		matches, err := filepath.Glob(filepath.Join(e.dir,
+			e.name+"-minutes-*.csv"))
+		if err != nil {
+			return err
+		}
+		for _, path := range matches {
+			day, err := e.minutesDay(path)
+			if err != nil {
+				continue
+			}
+			if day.AddDate(0, 0, 1).Before(now.Add(-cfg.minutes)) {
+				if err = os.Remove(path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Compacts the history of the meter every hour.
+func (e *exporter) retain(cfg retentionConfig) {
+	go func() {
+		for {
+			if err := e.compact(cfg, clock.Now()); err != nil {
+				log.Printf("%s: compaction: %v", e.name, err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
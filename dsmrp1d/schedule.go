@@ -0,0 +1,140 @@
+package main
+
+// The schedule of the low tariff of the utility.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Meters and the schedule may disagree this long around a switch.
+const tariffSwitchGrace = 5 * time.Minute
+
+// The low tariff applies during the periods of the schedule.
+type tariffSchedule []lowTariffPeriod
+
+// A period of the day on some days of the week, in minutes since
+// midnight.  If from is after to, the period wraps around: it applies
+// before to and after from on the same day.
+type lowTariffPeriod struct {
+	days     [7]bool // indexed by time.Weekday
+	from, to int
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(s string) (int, error) {
+	for i, d := range weekdays {
+		if strings.ToLower(s) == d {
+			return i, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("unknown day: %s", s))
+}
+
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil ||
+		h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, errors.New(fmt.Sprintf("invalid time: %s", s))
+	}
+	return h*60 + m, nil
+}
+
+// Parses a comma separated list of periods of the form "days [hh:mm-hh:mm]",
+// where days is a day or range of days, eg.
+// "mon-fri 23:00-07:00,sat-sun" for a low tariff during the night and
+// the weekend.
+func parseTariffSchedule(s string) (tariffSchedule, error) {
+	var ret tariffSchedule
+	for _, spec := range strings.Split(s, ",") {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, errors.New(fmt.Sprintf("invalid period: %s", spec))
+		}
+		p := lowTariffPeriod{from: 0, to: 24 * 60}
+		bits := strings.SplitN(fields[0], "-", 2)
+		first, err := parseWeekday(bits[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bits) == 2 {
+			if last, err = parseWeekday(bits[1]); err != nil {
+				return nil, err
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			p.days[d] = true
+			if d == last {
+				break
+			}
+		}
+		if len(fields) == 2 {
+			bits = strings.SplitN(fields[1], "-", 2)
+			if len(bits) != 2 {
+				return nil, errors.New(fmt.Sprintf("invalid hours: %s", fields[1]))
+			}
+			if p.from, err = parseClock(bits[0]); err != nil {
+				return nil, err
+			}
+			if p.to, err = parseClock(bits[1]); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+func (s tariffSchedule) isLow(t time.Time) bool {
+	t = t.Local()
+	m := t.Hour()*60 + t.Minute()
+	for _, p := range s {
+		if !p.days[t.Weekday()] {
+			continue
+		}
+		if p.from <= p.to && m >= p.from && m < p.to {
+			return true
+		}
+		if p.from > p.to && (m >= p.from || m < p.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the tariff scheduled at t.
+func (s tariffSchedule) tariff(t time.Time) string {
+	if s.isLow(t) {
+		return "low"
+	}
+	return "high"
+}
+
+// Returns the time of the next switch after t, if any within a week.
+func (s tariffSchedule) nextSwitch(t time.Time) *time.Time {
+	low := s.isLow(t)
+	next := t.Truncate(time.Minute)
+	for i := 0; i < 7*24*60; i++ {
+		next = next.Add(time.Minute)
+		if s.isLow(next) != low {
+			return &next
+		}
+	}
+	return nil
+}
+
+// Returns whether the tariff reported by the meter disagrees with the
+// schedule, allowing for some slack around switches.
+func (s tariffSchedule) mismatch(reported string, t time.Time) bool {
+	scheduled := s.tariff(t)
+	return reported != scheduled &&
+		s.tariff(t.Add(-tariffSwitchGrace)) == scheduled &&
+		s.tariff(t.Add(tariffSwitchGrace)) == scheduled
+}
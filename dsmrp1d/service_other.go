@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+)
+
+const defaultSerialDev = "/dev/P1"
+
+// Only Windows has a service manager we integrate with; elsewhere use
+// eg. systemd.
+func startService() bool { return false }
+
+func runServiceCommand(cmd string, args []string) {
+	log.Fatalf("%s: services are only supported on Windows", cmd)
+}
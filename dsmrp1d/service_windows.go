@@ -0,0 +1,162 @@
+//go:build windows
+// +build windows
+
+package main
+
+// Integration with the Windows service manager.
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const serviceName = "dsmrp1d"
+
+// USB to serial adapters usually show up as the first free COM port.
+const defaultSerialDev = "COM3"
+
+type serviceHandler struct{}
+
+// Reports to the service manager until the daemon has shut down.
+func (serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest,
+	s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.Running,
+		Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s <- svc.Status{State: svc.StopPending,
+					WaitHint: 10000}
+				select {
+				case shutdownSignals <- os.Interrupt:
+				default: // already shutting down
+				}
+			}
+		case <-shutdownDone:
+			s <- svc.Status{State: svc.Stopped}
+			return false, 0
+		}
+	}
+}
+
+// Writes log messages to the Windows event log.
+type eventLogWriter struct {
+	el *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	if err := w.el.Info(1, strings.TrimRight(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Reports to the service manager if we are started as a service.
+// Returns whether we are, in which case the process exits once the
+// service manager has been told it stopped.
+func startService() bool {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		log.Fatalf("Failed to determine if we run as a service: %v", err)
+	}
+	if interactive {
+		return false
+	}
+	if el, err := eventlog.Open(serviceName); err == nil {
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{el})
+	}
+	go func() {
+		if err := svc.Run(serviceName, serviceHandler{}); err != nil {
+			log.Fatalf("Failed to run as a service: %v", err)
+		}
+		os.Exit(0)
+	}()
+	return true
+}
+
+// Runs the install, uninstall, start and stop subcommands.  The
+// arguments to install are passed to the daemon when the service starts.
+func runServiceCommand(cmd string, args []string) {
+	if err := serviceCommand(cmd, args); err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+func serviceCommand(cmd string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if cmd == "install" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if exe, err = filepath.Abs(exe); err != nil {
+			return err
+		}
+		s, err := m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "DSMR P1 daemon",
+			Description: "Serves the telegrams of P1 smart meters.",
+			StartType:   mgr.StartAutomatic,
+		}, args...)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		err = eventlog.InstallAsEventCreate(serviceName,
+			eventlog.Error|eventlog.Warning|eventlog.Info)
+		if err != nil {
+			s.Delete()
+			return err
+		}
+		return nil
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return errors.New(fmt.Sprintf("service %s is not installed: %v",
+			serviceName, err))
+	}
+	defer s.Close()
+
+	switch cmd {
+	case "uninstall":
+		if err = s.Delete(); err != nil {
+			return err
+		}
+		return eventlog.Remove(serviceName)
+	case "start":
+		return s.Start()
+	case "stop":
+		status, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		for deadline := time.Now().Add(10 * time.Second); status.State != svc.Stopped; {
+			if time.Now().After(deadline) {
+				return errors.New("service did not stop in time")
+			}
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
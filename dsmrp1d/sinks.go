@@ -0,0 +1,183 @@
+package main
+
+// Pluggable destinations for telegrams, so that niche integrations can
+// be added without patching the rest of the daemon.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A destination for the telegrams of a meter.  A sink is either compiled
+// in, by registering it with registerSink from an init function in its
+// own file, or runs as an external process using the exec sink.
+// Deliveries that fail are retried.
+type Sink = sinks.Sink
+
+// Creates a sink from the configuration given after its name to -sink.
+type sinkFactory func(config string) (Sink, error)
+
+var sinkRegistry = map[string]sinkFactory{}
+
+func registerSink(name string, f sinkFactory) {
+	if _, ok := sinkRegistry[name]; ok {
+		panic("sink registered twice: " + name)
+	}
+	sinkRegistry[name] = f
+}
+
+func init() {
+	registerSink("exec", newExecSink)
+}
+
+// Creates and starts the sink of the given specification,
+// name[:config], for a meter.
+func openSink(spec, meter string) (Sink, error) {
+	bits := strings.SplitN(spec, ":", 2)
+	f, ok := sinkRegistry[bits[0]]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown sink: %s", bits[0]))
+	}
+	var config string
+	if len(bits) == 2 {
+		config = bits[1]
+	}
+	s, err := f(config)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", bits[0], err))
+	}
+	if err = s.Start(meter); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", bits[0], err))
+	}
+	return s, nil
+}
+
+// Feeds the telegrams of a meter to a sink through a queue, so that a
+// slow sink does not hold up the meter.
+type sinkObserver struct {
+	sink Sink
+	q    *retryQueue
+}
+
+func newSinkObserver(spec, meter string, cfg queueConfig) (*sinkObserver, error) {
+	s, err := openSink(spec, meter)
+	if err != nil {
+		return nil, err
+	}
+	return &sinkObserver{
+		sink: s,
+		q:    newRetryQueue("sink "+meter+" "+redactSecrets(spec), cfg),
+	}, nil
+}
+
+func (o *sinkObserver) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	o.q.push(func() error { return o.sink.HandleTelegram(t, at) })
+}
+
+func (o *sinkObserver) Close() error {
+	return o.sink.Close()
+}
+
+// Runs a command and writes the telegrams to its standard input, one
+// JSON object per line:
+//
+//	{"meter": "default", "time": "2026-10-16T12:00:00Z", "telegram": {...}}
+//
+// The command is restarted if it exits.  Its output goes to our
+// standard error.
+type execSink struct {
+	args  []string
+	meter string
+
+	lock  sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+type execSinkMessage struct {
+	Meter    string           `json:"meter"`
+	Time     time.Time        `json:"time"`
+	Telegram *dsmrp1.Telegram `json:"telegram"`
+}
+
+func newExecSink(config string) (Sink, error) {
+	args := strings.Fields(config)
+	if len(args) == 0 {
+		return nil, errors.New("expected exec:command [args]")
+	}
+	return &execSink{args: args}, nil
+}
+
+func (s *execSink) Start(meter string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.meter = meter
+	return s.start()
+}
+
+func (s *execSink) start() error {
+	cmd := exec.Command(s.args[0], s.args[1:]...)
+	cmd.Env = append(os.Environ(), "DSMRP1D_METER="+s.meter)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd, s.stdin = cmd, stdin
+	return nil
+}
+
+// Closes the standard input of the command and waits for it to exit.
+func (s *execSink) stop() error {
+	if s.cmd == nil {
+		return nil
+	}
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	s.cmd, s.stdin = nil, nil
+	return err
+}
+
+func (s *execSink) HandleTelegram(t *dsmrp1.Telegram, at time.Time) error {
+	body, err := json.Marshal(execSinkMessage{s.meter, at, t})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cmd == nil {
+		if err = s.start(); err != nil {
+			return err
+		}
+	}
+	if _, err = s.stdin.Write(append(body, '\n')); err != nil {
+		// The command probably exited: restart it on the next attempt.
+		if err := s.stop(); err != nil {
+			log.Printf("exec sink %s: %v", s.args[0], err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *execSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stop()
+}
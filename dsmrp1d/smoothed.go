@@ -0,0 +1,137 @@
+package main
+
+// Serves the instantaneous values averaged over the last seconds, as the
+// power that DSMR 5 meters report every second is too noisy for
+// displays and control loops.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Report served at /api/v1/smoothed.
+type smoothedReport struct {
+	WindowSeconds float64         `json:"window_seconds"`
+	Telegrams     int             `json:"telegrams"` // averaged over
+	PowerW        *float64        `json:"power_w,omitempty"`
+	PowerOutW     *float64        `json:"power_out_w,omitempty"`
+	Phases        []smoothedPhase `json:"phases,omitempty"`
+}
+
+type smoothedPhase struct {
+	Phase     string   `json:"phase"`
+	Voltage   *float64 `json:"voltage,omitempty"`
+	Current   *float64 `json:"current,omitempty"`
+	PowerW    *float64 `json:"power_w,omitempty"`
+	PowerOutW *float64 `json:"power_out_w,omitempty"`
+}
+
+type smoothedSample struct {
+	at time.Time
+	r  dsmrp1.Reading
+}
+
+type smoother struct {
+	window time.Duration // longest window that can be requested
+
+	lock    sync.Mutex
+	samples []smoothedSample // within the window, oldest first
+}
+
+func newSmoother(window time.Duration) *smoother {
+	return &smoother{window: window}
+}
+
+func (s *smoother) observe(t *dsmrp1.Telegram, at time.Time, stale []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples = append(s.samples, smoothedSample{at, dsmrp1.NewReading(t)})
+	i := 0
+	for i < len(s.samples) && at.Sub(s.samples[i].at) > s.window {
+		i++
+	}
+	s.samples = append(s.samples[:0], s.samples[i:]...)
+}
+
+// An average of the values that are present.
+type mean struct {
+	sum float64
+	n   int
+}
+
+func (m *mean) add(v *float64) {
+	if v != nil {
+		m.sum += *v
+		m.n++
+	}
+}
+
+func (m mean) value() *float64 {
+	if m.n == 0 {
+		return nil
+	}
+	ret := roundTo(m.sum/float64(m.n), 3)
+	return &ret
+}
+
+type phaseMeans struct {
+	voltage, current, w, wOut mean
+}
+
+// Averages the samples received within the window before now.
+func (s *smoother) report(window time.Duration, now time.Time) smoothedReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := smoothedReport{WindowSeconds: window.Seconds()}
+	var w, wOut mean
+	var phases []phaseMeans
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) > window {
+			continue
+		}
+		ret.Telegrams++
+		w.add(sample.r.W)
+		wOut.add(sample.r.WOut)
+		for i, p := range sample.r.Phases {
+			if i >= len(phases) {
+				phases = append(phases, phaseMeans{})
+			}
+			phases[i].voltage.add(p.Voltage)
+			phases[i].current.add(p.PreciseCurrent)
+			phases[i].w.add(p.W)
+			phases[i].wOut.add(p.WOut)
+		}
+	}
+	ret.PowerW, ret.PowerOutW = w.value(), wOut.value()
+	for i, p := range phases {
+		ret.Phases = append(ret.Phases, smoothedPhase{
+			Phase:     "L" + strconv.Itoa(i+1),
+			Voltage:   p.voltage.value(),
+			Current:   p.current.value(),
+			PowerW:    p.w.value(),
+			PowerOutW: p.wOut.value(),
+		})
+	}
+	return ret
+}
+
+// Serves the averages over the window, or over the number of seconds
+// given by the query parameter seconds if that is shorter.
+func (s *smoother) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	window := s.window
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs <= 0 {
+			writeError(w, http.StatusBadRequest, apiError{
+				Error: "seconds: expected a positive number"})
+			return
+		}
+		if d := time.Duration(secs * float64(time.Second)); d < window {
+			window = d
+		}
+	}
+	writeJSON(w, r, s.report(window, clock.Now()))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSmoothedWindow(t *testing.T) {
+	useFakeClock(t, testStart)
+	s := newSmoother(10 * time.Second)
+	for i := 0; i < 20; i++ {
+		at := testStart.Add(time.Duration(i) * time.Second)
+		s.observe(testTelegram(t, at, 1, float64(100*i)), at, nil)
+	}
+	now := testStart.Add(19 * time.Second)
+
+	// Samples of seconds 15 up to 19.
+	r := s.report(4*time.Second, now)
+	if r.Telegrams != 5 || r.PowerW == nil || *r.PowerW != 1700 {
+		t.Fatalf("report: got %d telegrams and %v W", r.Telegrams,
+			r.PowerW)
+	}
+
+	// Older samples are forgotten.
+	if r = s.report(time.Hour, now); r.Telegrams != 11 {
+		t.Fatalf("report: got %d telegrams; expected 11", r.Telegrams)
+	}
+}
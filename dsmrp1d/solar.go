@@ -0,0 +1,243 @@
+package main
+
+// Summarizes export and net position for households with solar panels.
+// With a production feed from the inverter, self-consumption is
+// reported as well.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Configuration of the inverter production feed.  The feed provides the
+// cumulative production, either by polling a JSON HTTP endpoint or by
+// subscribing to an MQTT topic.
+type solarFeedConfig struct {
+	url      string        // JSON endpoint to poll
+	topic    string        // MQTT topic to subscribe to
+	field    string        // dotted path to the value in the JSON
+	scale    float64       // factor to convert the value to kWh
+	interval time.Duration // between polls of url
+}
+
+func (c solarFeedConfig) enabled() bool {
+	return c.url != "" || c.topic != ""
+}
+
+type solarPeriod struct {
+	ExportKWh float64 `json:"export_kwh"`
+	ImportKWh float64 `json:"import_kwh"`
+
+	// Import minus export: negative if more was exported.
+	NetKWh float64 `json:"net_kwh"`
+
+	// Only with a production feed.
+	ProductionKWh        *float64 `json:"production_kwh,omitempty"`
+	SelfConsumptionKWh   *float64 `json:"self_consumption_kwh,omitempty"`
+	SelfConsumptionRatio *float64 `json:"self_consumption_ratio,omitempty"`
+}
+
+// Report served at /api/v1/solar.
+type solarReport struct {
+	Today        solarPeriod `json:"today"`
+	ThisMonth    solarPeriod `json:"this_month"`
+	Partial      bool        `json:"partial"` // missing the start of the day
+	ExportW      float64     `json:"export_w"`
+	ProductionAt *time.Time  `json:"production_at,omitempty"`
+}
+
+type solarTracker struct {
+	name string
+	regs *registerTracker
+
+	lock   sync.Mutex
+	state  solarState
+	latest *float64 // cumulative production in kWh
+	at     time.Time
+	export float64 // latest export power in W
+}
+
+// Cumulative production at the start of the day and month.
+type solarState struct {
+	Day        string   `json:"day"`
+	DayStart   *float64 `json:"day_start"`
+	Month      string   `json:"month"`
+	MonthStart *float64 `json:"month_start"`
+}
+
+func newSolarTracker(meter string, regs *registerTracker) *solarTracker {
+	s := &solarTracker{name: meter + "-solar", regs: regs}
+	if err := loadState(s.name, &s.state); err != nil {
+		log.Printf("solar: loading state: %v", err)
+	}
+	return s
+}
+
+func (s *solarTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if t.Electricity == nil {
+		return
+	}
+	s.lock.Lock()
+	s.export = float64(t.Electricity.WOut)
+	s.lock.Unlock()
+}
+
+// Records a cumulative production reading from the feed.
+func (s *solarTracker) produced(kWh float64, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	day := at.Format("2006-01-02")
+	month := at.Format("2006-01")
+	changed := false
+	if s.state.Day != day || s.state.DayStart == nil {
+		s.state.Day, s.state.DayStart = day, &kWh
+		changed = true
+	}
+	if s.state.Month != month || s.state.MonthStart == nil {
+		s.state.Month, s.state.MonthStart = month, &kWh
+		changed = true
+	}
+	s.latest = &kWh
+	s.at = at
+
+	if changed {
+		if err := saveState(s.name, s.state); err != nil {
+			log.Printf("solar: saving state: %v", err)
+		}
+	}
+}
+
+func makeSolarPeriod(usage registers, production *float64) solarPeriod {
+	ret := solarPeriod{
+		ExportKWh: usage.exportKWh(),
+		ImportKWh: usage.importKWh(),
+		NetKWh:    usage.importKWh() - usage.exportKWh(),
+	}
+	if production == nil {
+		return ret
+	}
+	self := *production - ret.ExportKWh
+	if self < 0 {
+		// The feed and the meter are not perfectly in sync.
+		self = 0
+	}
+	ret.ProductionKWh = production
+	ret.SelfConsumptionKWh = &self
+	if *production > 0 {
+		ratio := self / *production
+		ret.SelfConsumptionRatio = &ratio
+	}
+	return ret
+}
+
+func (s *solarTracker) report() solarReport {
+	day, month, dayStart, _ := s.regs.usage()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var dayProduction, monthProduction *float64
+	ret := solarReport{Partial: dayStart.Partial, ExportW: s.export}
+	if s.latest != nil {
+		d := *s.latest - *s.state.DayStart
+		m := *s.latest - *s.state.MonthStart
+		dayProduction, monthProduction = &d, &m
+		at := s.at
+		ret.ProductionAt = &at
+	}
+	ret.Today = makeSolarPeriod(day, dayProduction)
+	ret.ThisMonth = makeSolarPeriod(month, monthProduction)
+	return ret
+}
+
+func (s *solarTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, s.report())
+}
+
+// Extracts the production in kWh from a feed payload: either a plain
+// number or JSON with the value at the configured field.
+func (c solarFeedConfig) parse(payload []byte) (float64, error) {
+	var value float64
+	var err error
+	if c.field == "" {
+		value, err = strconv.ParseFloat(
+			strings.TrimSpace(string(payload)), 64)
+	} else {
+		var tree interface{}
+		dec := json.NewDecoder(bytes.NewReader(payload))
+		dec.UseNumber()
+		if err = dec.Decode(&tree); err != nil {
+			return 0, err
+		}
+		path, v := lookupField(tree, strings.Split(c.field, "."))
+		n, ok := v.(json.Number)
+		if path == nil || !ok {
+			return 0, errors.New(fmt.Sprintf("no number at %s", c.field))
+		}
+		value, err = n.Float64()
+	}
+	return value * c.scale, err
+}
+
+// Feeds the production readings of the configured feed to the tracker.
+func (c solarFeedConfig) start(s *solarTracker, mc *sinks.MQTTClient) error {
+	if c.topic != "" {
+		if mc == nil {
+			return errors.New("subscribing to the production topic requires -mqtt")
+		}
+		mc.Subscribe(c.topic, func(topic string, payload []byte) {
+			kWh, err := c.parse(payload)
+			if err != nil {
+				log.Printf("solar: %s: %v", topic, err)
+				return
+			}
+			s.produced(kWh, clock.Now())
+		})
+	}
+	if c.url != "" {
+		go c.poll(s)
+	}
+	return nil
+}
+
+func (c solarFeedConfig) poll(s *solarTracker) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for {
+		kWh, err := c.fetch(client)
+		if err != nil {
+			log.Printf("solar: %s: %v", c.url, err)
+		} else {
+			s.produced(kWh, clock.Now())
+		}
+		time.Sleep(c.interval)
+	}
+}
+
+func (c solarFeedConfig) fetch(client *http.Client) (float64, error) {
+	resp, err := client.Get(c.url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(resp.Status)
+	}
+	return c.parse(body)
+}
@@ -0,0 +1,159 @@
+package main
+
+// Estimates the always-on ("vampire") load: the lowest power sustained
+// over a window of a few minutes during each day.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Power has to be sustained this long to count as standby.
+	standbyWindow = 10 * time.Minute
+
+	// Number of days of which the standby power is kept: a week and
+	// today.
+	standbyDays = 8
+)
+
+// Standby power of a day.
+type standbyDay struct {
+	Date     string  `json:"date"`
+	StandbyW float64 `json:"standby_w"`
+	KWh      float64 `json:"kwh"` // if the standby power ran all day
+}
+
+type standbyState struct {
+	Days []standbyDay `json:"days"` // oldest first; the last might be today
+}
+
+// Report served at /api/v1/standby.
+type standbyReport struct {
+	WindowSeconds float64      `json:"window_seconds"`
+	CurrentW      *float64     `json:"current_w"` // average over the window
+	Today         *standbyDay  `json:"today"`
+	Days          []standbyDay `json:"days"`
+	Week          *standbyWeek `json:"week"`
+}
+
+type standbyWeek struct {
+	Days     int     `json:"days"` // number of days averaged over
+	StandbyW float64 `json:"standby_w"`
+	KWh      float64 `json:"kwh"` // over seven days
+}
+
+type powerSample struct {
+	at    time.Time
+	power float64
+}
+
+type standbyTracker struct {
+	name string
+
+	lock    sync.Mutex
+	state   standbyState
+	samples []powerSample // within the window
+	current *float64
+	saved   time.Time
+}
+
+func newStandbyTracker(meter string) *standbyTracker {
+	s := &standbyTracker{name: meter + "-standby"}
+	if err := loadState(s.name, &s.state); err != nil {
+		log.Printf("standby: loading state: %v", err)
+	}
+	return s
+}
+
+func (s *standbyTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if t.Electricity == nil {
+		return
+	}
+	power := float64(t.Electricity.W) - float64(t.Electricity.WOut)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.samples = append(s.samples, powerSample{at, power})
+	i := 0
+	for i < len(s.samples) && at.Sub(s.samples[i].at) > standbyWindow {
+		i++
+	}
+	s.samples = s.samples[i:]
+
+	// Only trust the average if the samples cover most of the window.
+	s.current = nil
+	if at.Sub(s.samples[0].at) < standbyWindow*9/10 {
+		return
+	}
+	var sum float64
+	for _, sample := range s.samples {
+		sum += sample.power
+	}
+	avg := sum / float64(len(s.samples))
+	s.current = &avg
+
+	date := at.Local().Format("2006-01-02")
+	days := s.state.Days
+	if len(days) == 0 || days[len(days)-1].Date != date {
+		days = append(days, standbyDay{Date: date, StandbyW: avg})
+		if len(days) > standbyDays {
+			days = days[len(days)-standbyDays:]
+		}
+	} else if avg < days[len(days)-1].StandbyW {
+		days[len(days)-1].StandbyW = avg
+	} else {
+		return
+	}
+	days[len(days)-1].KWh = days[len(days)-1].StandbyW * 24 / 1000
+	s.state.Days = days
+
+	// A new minimum is often followed by another, so do not write
+	// the state every telegram.
+	if at.Sub(s.saved) >= time.Minute {
+		if err := saveState(s.name, s.state); err != nil {
+			log.Printf("standby: saving state: %v", err)
+		}
+		s.saved = at
+	}
+}
+
+func (s *standbyTracker) report(now time.Time) standbyReport {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report := standbyReport{
+		WindowSeconds: standbyWindow.Seconds(),
+		CurrentW:      s.current,
+		Days:          append([]standbyDay{}, s.state.Days...),
+	}
+	date := now.Local().Format("2006-01-02")
+	var sum float64
+	var complete int
+	for i := range report.Days {
+		if report.Days[i].Date == date {
+			report.Today = &report.Days[i]
+			continue
+		}
+		sum += report.Days[i].StandbyW
+		complete++
+	}
+	if complete > 0 {
+		avg := sum / float64(complete)
+		report.Week = &standbyWeek{
+			Days:     complete,
+			StandbyW: avg,
+			KWh:      avg * 7 * 24 / 1000,
+		}
+	}
+	return report
+}
+
+func (s *standbyTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, s.report(clock.Now()))
+}
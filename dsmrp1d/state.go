@@ -0,0 +1,238 @@
+package main
+
+// Keeps track of the telegrams received from the meters.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Something that wants to see every telegram of a meter.  Stale lists
+// the sections of the telegram that failed to parse; see -partial.
+type observer interface {
+	observe(t *dsmrp1.Telegram, at time.Time, stale []string)
+}
+
+// A meter the daemon reads from.
+type meter struct {
+	name      string
+	source    string
+	conn      *dsmrp1.Meter // nil when replaying
+	observers []observer
+
+	peaks   *peakTracker
+	phases  *phaseMonitor
+	events  *eventLog
+	gas     *gasFlowEstimator
+	regs    *registerTracker
+	tariff  *tariffTracker
+	solar   *solarTracker
+	usual   *anomalyDetector
+	standby *standbyTracker
+	budget  *budgetTracker
+	co2     *co2Tracker
+	costs   *costTracker
+
+	appliances *applianceLog
+	positions  *positionMonitor
+
+	supervision *supervisionMonitor
+	smoothed    *smoother
+
+	good goodSections // only used by receive
+
+	lock     sync.Mutex
+	telegram *dsmrp1.Telegram
+	received time.Time
+	stale    []string // sections that failed to parse in telegram
+}
+
+// Handles a telegram from the meter taken at the given time, which
+// observers see.  For staleness the telegram counts as received now,
+// which differs from at when replaying a capture.
+func (m *meter) receive(t *dsmrp1.Telegram, at time.Time) {
+	var stale []string
+	if partialTelegrams != partialDrop {
+		t, stale = m.good.apply(partialTelegrams, t)
+	}
+	m.lock.Lock()
+	m.telegram = t
+	m.received = clock.Now()
+	m.stale = stale
+	m.lock.Unlock()
+
+	root := startSpan(nil, "receive telegram", "meter", m.name)
+	for _, o := range m.observers {
+		child := startSpan(root, "observe",
+			"observer", strings.TrimPrefix(fmt.Sprintf("%T", o), "*main."))
+		o.observe(t, at, stale)
+		child.finish(nil)
+	}
+	root.finish(nil)
+}
+
+// Returns the latest telegram (or nil) and when it was received.
+func (m *meter) latest() (*dsmrp1.Telegram, time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.telegram, m.received
+}
+
+// Returns the sections of the latest telegram that failed to parse.
+func (m *meter) staleSections() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stale
+}
+
+// Parses a meter specification of the form name=source.
+func parseMeter(spec string) (*meter, error) {
+	bits := strings.SplitN(spec, "=", 2)
+	if len(bits) != 2 || bits[0] == "" || strings.Contains(bits[0], "/") {
+		return nil, errors.New(fmt.Sprintf(
+			"%s: expected name=source", spec))
+	}
+	return &meter{name: bits[0], source: bits[1]}, nil
+}
+
+// Body of error responses.
+type apiError struct {
+	Error      string   `json:"error"`
+	AgeSeconds *float64 `json:"age_seconds,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, code int, e apiError) {
+	body, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// Returns the age of a telegram received at the given time in seconds,
+// rounded down to a tenth of a second.
+func ageSeconds(received time.Time) float64 {
+	return math.Floor(clock.Now().Sub(received).Seconds()*10) / 10
+}
+
+// Serves the latest telegram of the meter.  If the telegram is older
+// than maxAge (unless zero), a 503 is returned instead.  With
+// annotated=true each value comes with its unit and OBIS code.  Unless
+// partial telegrams are dropped, the sections that failed to parse are
+// listed in the X-Sections-Stale header and, without annotated, in
+// sections_stale.
+func telegramHandler(m *meter, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, received := m.latest()
+		if t == nil {
+			writeError(w, http.StatusServiceUnavailable, apiError{
+				Error: "no telegram received yet",
+			})
+			return
+		}
+
+		age := ageSeconds(received)
+		if maxAge != 0 && clock.Now().Sub(received) > maxAge {
+			writeError(w, http.StatusServiceUnavailable, apiError{
+				Error:      "telegram is stale",
+				AgeSeconds: &age,
+			})
+			return
+		}
+		w.Header().Set("X-Age-Seconds", strconv.FormatFloat(age, 'f', -1, 64))
+
+		// The timestamp changes with every telegram, so it is a
+		// fine ETag for the current data.
+		if checkETag(w, r, t.TimeStamp) {
+			return
+		}
+		stale := m.staleSections()
+		if len(stale) != 0 {
+			w.Header().Set("X-Sections-Stale", strings.Join(stale, ","))
+		}
+		if annotated, _ := strconv.ParseBool(r.URL.Query().Get(
+			"annotated")); annotated {
+			writeJSON(w, r, annotatedTelegram{t})
+			return
+		}
+		if partialTelegrams != partialDrop {
+			if stale == nil {
+				stale = []string{}
+			}
+			writeJSON(w, r, partialTelegram{t, stale})
+			return
+		}
+		writeJSON(w, r, t)
+	})
+}
+
+// A telegram marshalled with the unit and OBIS code of each value.
+type annotatedTelegram struct {
+	*dsmrp1.Telegram
+}
+
+func (t annotatedTelegram) MarshalJSON() ([]byte, error) {
+	return t.MarshalAnnotated()
+}
+
+// Entry in the list of meters.
+type meterInfo struct {
+	Name       string   `json:"name"`
+	Source     string   `json:"source"`
+	ID         string   `json:"id,omitempty"`
+	AgeSeconds *float64 `json:"age_seconds"`
+}
+
+// Creates the handler of a per meter endpoint.
+type meterEndpoint func(m *meter) http.Handler
+
+func (m *meter) info() meterInfo {
+	ret := meterInfo{Name: m.name, Source: m.source}
+	if t, received := m.latest(); t != nil {
+		age := ageSeconds(received)
+		ret.ID = t.ID
+		ret.AgeSeconds = &age
+	}
+	return ret
+}
+
+// Serves /api/v1/meters and the per meter endpoints below it at
+// /api/v1/meters/{name}/{endpoint}.
+func metersHandler(meters []*meter, endpoints map[string]meterEndpoint) http.Handler {
+	handlers := make(map[string]http.Handler)
+	for _, m := range meters {
+		for name, endpoint := range endpoints {
+			handlers[m.name+"/"+name] = endpoint(m)
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path,
+			"/api/v1/meters"), "/")
+		if path == "" {
+			infos := []meterInfo{}
+			for _, m := range meters {
+				infos = append(infos, m.info())
+			}
+			writeJSON(w, r, infos)
+			return
+		}
+
+		h, ok := handlers[path]
+		if !ok {
+			writeError(w, http.StatusNotFound, apiError{
+				Error: "no such meter or endpoint",
+			})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
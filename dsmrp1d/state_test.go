@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/bwesterb/go-dsmrp1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+// Replaces the clock of the daemon by a fake one for the test.
+func useFakeClock(t *testing.T, now time.Time) *dsmrp1.FakeClock {
+	fc := dsmrp1.NewFakeClock(now)
+	prev := clock
+	clock = fc
+	t.Cleanup(func() { clock = prev })
+	return fc
+}
+
+// Returns a telegram with the given register of tariff 1 and power.
+func testTelegram(t *testing.T, at time.Time, kWh, w float64) *dsmrp1.Telegram {
+	tg, errs := dsmrp1.NewTelegramBuilder(dsmrp1.DSMR5).
+		Timestamp(at).
+		EquipmentID("test").
+		Import(1, kWh).
+		Import(2, 0).
+		Export(1, 0).
+		Export(2, 0).
+		Tariff(1).
+		Power(w, 0).
+		PowerFailures(0, 0).
+		Phase(1, 230, w/230, w, 0).
+		VoltageEvents(1, 0, 0).
+		Telegram()
+	if errs != nil {
+		t.Fatalf("building telegram: %v", errs)
+	}
+	return tg
+}
+
+func TestTelegramStale(t *testing.T) {
+	fc := useFakeClock(t, testStart)
+	m := &meter{name: "test"}
+	h := telegramHandler(m, time.Minute)
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		return rr
+	}
+
+	if rr := get(); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("before any telegram: got status %d", rr.Code)
+	}
+
+	m.receive(testTelegram(t, testStart, 1, 100), testStart)
+	fc.Advance(1500 * time.Millisecond)
+	rr := get()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("fresh telegram: got status %d", rr.Code)
+	}
+	if age := rr.Header().Get("X-Age-Seconds"); age != "1.5" {
+		t.Fatalf("X-Age-Seconds: got %q; expected 1.5", age)
+	}
+
+	fc.Advance(time.Minute)
+	rr = get()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stale telegram: got status %d", rr.Code)
+	}
+	var e apiError
+	if err := json.Unmarshal(rr.Body.Bytes(), &e); err != nil {
+		t.Fatalf("stale telegram: %v", err)
+	}
+	if e.AgeSeconds == nil || *e.AgeSeconds != 61.5 {
+		t.Fatalf("stale telegram: got age %v", e.AgeSeconds)
+	}
+}
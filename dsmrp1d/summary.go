@@ -0,0 +1,61 @@
+package main
+
+// The essentials of a meter in a payload small enough for a
+// microcontroller driving a display, like an ESP32 with e-paper.
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Report served at /api/v1/summary.  Keep it well under 512 bytes.
+type summary struct {
+	W      float64  `json:"w"`       // net power; negative if exporting
+	InKWh  float64  `json:"in_kwh"`  // imported today
+	OutKWh float64  `json:"out_kwh"` // exported today
+	GasM3  *float64 `json:"gas_m3,omitempty"`
+	Tariff string   `json:"tariff"`
+	Age    float64  `json:"age_s"` // of the telegram, in seconds
+}
+
+func roundTo(v float64, digits int) float64 {
+	scale := math.Pow10(digits)
+	return math.Round(v*scale) / scale
+}
+
+func summaryHandler(m *meter, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, received := m.latest()
+		if t == nil || t.Electricity == nil {
+			writeError(w, http.StatusServiceUnavailable, apiError{
+				Error: "no telegram received yet",
+			})
+			return
+		}
+		age := ageSeconds(received)
+		if maxAge != 0 && clock.Now().Sub(received) > maxAge {
+			writeError(w, http.StatusServiceUnavailable, apiError{
+				Error:      "telegram is stale",
+				AgeSeconds: &age,
+			})
+			return
+		}
+
+		day, _, _, _ := m.regs.usage()
+		e := t.Electricity
+		s := summary{
+			W:      roundTo(dsmrp1.Float64(e.W)-dsmrp1.Float64(e.WOut), 0),
+			InKWh:  roundTo(day.importKWh(), 3),
+			OutKWh: roundTo(day.exportKWh(), 3),
+			Tariff: tariffName(e.Tariff),
+			Age:    age,
+		}
+		if day.Gas != nil {
+			gas := roundTo(*day.Gas, 3)
+			s.GasM3 = &gas
+		}
+		writeJSON(w, r, s)
+	})
+}
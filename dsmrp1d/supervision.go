@@ -0,0 +1,95 @@
+package main
+
+// Monitors the load relative to the threshold above which the meter may
+// open its breaker.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Report served at /api/v1/threshold.
+type supervisionReport struct {
+	ThresholdW *float64 `json:"threshold_w,omitempty"`
+	ThresholdA *float64 `json:"threshold_a,omitempty"`
+	Load       *float64 `json:"load,omitempty"` // fraction of the threshold
+	Alert      bool     `json:"alert"`
+}
+
+type supervisionMonitor struct {
+	meter string
+	warn  float64 // fraction of the threshold at which to raise an alert
+	as    *alerts
+
+	lock   sync.Mutex
+	report supervisionReport
+}
+
+func newSupervisionMonitor(meter string, warn float64,
+	as *alerts) *supervisionMonitor {
+	return &supervisionMonitor{meter: meter, warn: warn, as: as}
+}
+
+func (s *supervisionMonitor) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if t.Electricity == nil {
+		return
+	}
+	var report supervisionReport
+	var load float64
+	var message string
+	w, a := t.Electricity.Supervision()
+	if w > 0 {
+		threshold := float64(w)
+		report.ThresholdW = &threshold
+		load = float64(t.Electricity.W) / threshold
+		message = fmt.Sprintf("%.0f W is at %.0f%% of the %.0f W threshold",
+			t.Electricity.W, load*100, threshold)
+	}
+	if a > 0 {
+		threshold := float64(a)
+		report.ThresholdA = &threshold
+		for i, ph := range t.Phases() {
+			current, _ := ph.PreciseCurrent()
+			if l := float64(current) / threshold; l > load {
+				load = l
+				message = fmt.Sprintf(
+					"L%d at %.1f A is at %.0f%% of the %.0f A threshold",
+					i+1, current, load*100, threshold)
+			}
+		}
+	}
+	if w == 0 && a == 0 {
+		s.as.set(s.meter, "threshold", false, "", at)
+		s.lock.Lock()
+		s.report = report
+		s.lock.Unlock()
+		return
+	}
+	load = math.Max(0, load)
+	report.Load = &load
+
+	// Keep an active alert until the load drops well below the warning
+	// level, like the phase monitor does.
+	s.lock.Lock()
+	threshold := s.warn
+	if s.report.Alert {
+		threshold -= phaseLoadHysteresis
+	}
+	report.Alert = load >= threshold
+	s.report = report
+	s.lock.Unlock()
+
+	s.as.set(s.meter, "threshold", report.Alert, message, at)
+}
+
+func (s *supervisionMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	report := s.report
+	s.lock.Unlock()
+	writeJSON(w, r, report)
+}
@@ -0,0 +1,157 @@
+package main
+
+// Reports today's consumption per tariff and tariff switches.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type tariffSplit struct {
+	High  float64 `json:"high"`
+	Low   float64 `json:"low"`
+	Total float64 `json:"total"`
+
+	// Starting at tariff 1, also for meters with more than two tariffs.
+	PerTariff []float64 `json:"per_tariff"`
+}
+
+// Report served at /api/v1/tariffs.
+type tariffReport struct {
+	Date      string      `json:"date"`
+	Partial   bool        `json:"partial"` // whether we missed the start of the day
+	ImportKWh tariffSplit `json:"import_kwh"`
+	ExportKWh tariffSplit `json:"export_kwh"`
+
+	Tariff        string     `json:"tariff"` // "high" or "low"
+	TariffCode    int        `json:"tariff_code"`
+	LastSwitch    *time.Time `json:"last_switch"`
+	SwitchesToday int        `json:"switches_today"`
+
+	// Only if a schedule is configured.
+	ScheduledTariff    string     `json:"scheduled_tariff,omitempty"`
+	NextSwitch         *time.Time `json:"next_switch,omitempty"`
+	SecondsUntilSwitch *float64   `json:"seconds_until_switch,omitempty"`
+	ScheduleMismatch   bool       `json:"schedule_mismatch,omitempty"`
+}
+
+// Dutch meters register the low tariff as tariff 1 in 1-0:1.8.1 and the
+// normal tariff as tariff 2, so the indicator is not compared with
+// dsmrp1.TariffHigh and dsmrp1.TariffLow.
+func tariffName(t dsmrp1.Tariff) string {
+	switch t {
+	case 1:
+		return "low"
+	case 2:
+		return "high"
+	}
+	return "unknown"
+}
+
+type tariffTracker struct {
+	name     string
+	meter    string
+	regs     *registerTracker
+	schedule tariffSchedule // nil if unknown
+	as       *alerts
+
+	lock  sync.Mutex
+	state tariffState
+}
+
+type tariffState struct {
+	Tariff        dsmrp1.Tariff `json:"tariff"`
+	LastSwitch    *time.Time    `json:"last_switch"`
+	Day           string        `json:"day"`
+	SwitchesToday int           `json:"switches_today"`
+}
+
+func newTariffTracker(meter string, regs *registerTracker,
+	schedule tariffSchedule, as *alerts) *tariffTracker {
+	t := &tariffTracker{name: meter + "-tariff", meter: meter, regs: regs,
+		schedule: schedule, as: as}
+	if err := loadState(t.name, &t.state); err != nil {
+		log.Printf("tariff: loading state: %v", err)
+	}
+	return t
+}
+
+func (tt *tariffTracker) observe(t *dsmrp1.Telegram, at time.Time,
+	stale []string) {
+	if t.Electricity == nil {
+		return
+	}
+	tariff := t.Electricity.Tariff
+	day := at.Format("2006-01-02")
+
+	if tt.schedule != nil && tariff != 0 {
+		reported := tariffName(tariff)
+		tt.as.set(tt.meter, "tariff-mismatch",
+			tt.schedule.mismatch(reported, at), fmt.Sprintf(
+				"meter reports the %s tariff, but the schedule has the %s tariff",
+				reported, tt.schedule.tariff(at)), at)
+	}
+
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	changed := false
+	if tt.state.Day != day {
+		tt.state.Day = day
+		tt.state.SwitchesToday = 0
+		changed = true
+	}
+	if tt.state.Tariff != tariff {
+		if tt.state.Tariff != 0 {
+			switched := at
+			tt.state.LastSwitch = &switched
+			tt.state.SwitchesToday++
+		}
+		tt.state.Tariff = tariff
+		changed = true
+	}
+	if changed {
+		if err := saveState(tt.name, tt.state); err != nil {
+			log.Printf("tariff: saving state: %v", err)
+		}
+	}
+}
+
+func (tt *tariffTracker) report(now time.Time) tariffReport {
+	day, _, dayStart, _ := tt.regs.usage()
+
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+
+	report := tariffReport{
+		Date:    dayStart.Period,
+		Partial: dayStart.Partial,
+		ImportKWh: tariffSplit{day.ImportHigh, day.ImportLow,
+			day.importKWh(), day.ImportTariffs},
+		ExportKWh: tariffSplit{day.ExportHigh, day.ExportLow,
+			day.exportKWh(), day.ExportTariffs},
+		Tariff:        tariffName(tt.state.Tariff),
+		TariffCode:    int(tt.state.Tariff),
+		LastSwitch:    tt.state.LastSwitch,
+		SwitchesToday: tt.state.SwitchesToday,
+	}
+	if tt.schedule != nil {
+		report.ScheduledTariff = tt.schedule.tariff(now)
+		report.NextSwitch = tt.schedule.nextSwitch(now)
+		if report.NextSwitch != nil {
+			seconds := report.NextSwitch.Sub(now).Seconds()
+			report.SecondsUntilSwitch = &seconds
+		}
+		report.ScheduleMismatch = tt.state.Tariff != 0 &&
+			tt.schedule.mismatch(report.Tariff, now)
+	}
+	return report
+}
+
+func (tt *tariffTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, tt.report(clock.Now()))
+}
@@ -0,0 +1,214 @@
+package main
+
+// Conversion of units and rounding of values in JSON responses.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A group of fields that share a quantity, such as power.
+type unitGroup struct {
+	native string             // unit the daemon uses internally
+	units  map[string]float64 // factor of each unit relative to native
+}
+
+var unitGroups = map[string]*unitGroup{
+	"power":   {"W", map[string]float64{"W": 1, "kW": 1000}},
+	"energy":  {"kWh", map[string]float64{"kWh": 1, "Wh": 0.001}},
+	"gas":     {"m3", map[string]float64{"m3": 1, "dm3": 0.001}},
+	"voltage": {"V", map[string]float64{"V": 1}},
+	"current": {"A", map[string]float64{"A": 1}},
+}
+
+// Fields of telegrams, which do not state their unit in their name.
+var telegramFieldGroups = []struct {
+	re    *regexp.Regexp
+	group string
+}{
+	{regexp.MustCompile(`^(w|wout|threshold|l[123]power(out)?)$`), "power"},
+	{regexp.MustCompile(`^kwh(low|out|outlow)?$`), "energy"},
+	{regexp.MustCompile(`^l[123]voltage$`), "voltage"},
+	{regexp.MustCompile(`^l[123]current$`), "current"},
+}
+
+// Output units and rounding per field group.
+type outputConfig struct {
+	units  map[string]string
+	digits map[string]int
+}
+
+// Output configuration of the JSON API.
+var output outputConfig
+
+func (o outputConfig) enabled() bool {
+	return len(o.units) > 0 || len(o.digits) > 0
+}
+
+// Parses a comma separated list of group=unit pairs, eg. "power=kW".
+func (o *outputConfig) parseUnits(s string) error {
+	o.units = make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		bits := strings.SplitN(pair, "=", 2)
+		g, ok := unitGroups[bits[0]]
+		if len(bits) != 2 || !ok {
+			return errors.New(fmt.Sprintf("expected group=unit: %s", pair))
+		}
+		if _, ok := g.units[bits[1]]; !ok {
+			return errors.New(fmt.Sprintf("unknown unit for %s: %s",
+				bits[0], bits[1]))
+		}
+		o.units[bits[0]] = bits[1]
+	}
+	return nil
+}
+
+// Parses a comma separated list of group=digits pairs, eg. "power=0".
+func (o *outputConfig) parseDigits(s string) error {
+	o.digits = make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		bits := strings.SplitN(pair, "=", 2)
+		if _, ok := unitGroups[bits[0]]; len(bits) != 2 || !ok {
+			return errors.New(fmt.Sprintf("expected group=digits: %s", pair))
+		}
+		digits, err := strconv.Atoi(bits[1])
+		if err != nil || digits < 0 {
+			return errors.New(fmt.Sprintf("invalid number of digits: %s", pair))
+		}
+		o.digits[bits[0]] = digits
+	}
+	return nil
+}
+
+// Finds the group of the field with the given key in the given parent.
+// Returns the group, the unit the value is in and, if the key states
+// the unit, the index of the unit in the "_" separated key.
+func classifyField(parent, key string) (string, string, int) {
+	norm := normalizeField(key)
+	for _, f := range telegramFieldGroups {
+		if f.re.MatchString(norm) {
+			return f.group, unitGroups[f.group].native, -1
+		}
+	}
+	if norm == "value" && normalizeField(parent) == "lastrecord" {
+		return "gas", "m3", -1
+	}
+
+	// Fields of reports, like "peak_kw" and "flow_dm3_per_hour".  Units
+	// after "per", as in "eur_per_kwh", are not the unit of the value.
+	for i, token := range strings.Split(key, "_") {
+		if token == "per" {
+			break
+		}
+		for name, g := range unitGroups {
+			for unit := range g.units {
+				if token == strings.ToLower(unit) {
+					return name, unit, i
+				}
+			}
+		}
+	}
+	return "", "", -1
+}
+
+// Converts and rounds the numbers in the tree, which is decoded using
+// json.Decoder.UseNumber, renaming keys that state their unit.
+func (o outputConfig) apply(tree interface{}) interface{} {
+	return o.applyIn("", tree)
+}
+
+func (o outputConfig) applyIn(parent string, tree interface{}) interface{} {
+	switch node := tree.(type) {
+	case map[string]interface{}:
+		if unit, ok := node["unit"].(string); ok && node["obis"] != nil {
+			return o.applyAnnotated(node, unit)
+		}
+		ret := make(map[string]interface{}, len(node))
+		for key, child := range node {
+			if n, ok := child.(json.Number); ok {
+				key, child = o.convert(parent, key, n)
+			} else {
+				child = o.applyIn(key, child)
+			}
+			ret[key] = child
+		}
+		return ret
+	case []interface{}:
+		for i, child := range node {
+			node[i] = o.applyIn(parent, child)
+		}
+	}
+	return tree
+}
+
+func (o outputConfig) convert(parent, key string, n json.Number) (
+	string, interface{}) {
+	group, unit, idx := classifyField(parent, key)
+	if group == "" {
+		return key, n
+	}
+	v, target := o.convertValue(group, unit, n)
+	if target != unit && idx >= 0 {
+		tokens := strings.Split(key, "_")
+		tokens[idx] = strings.ToLower(target)
+		key = strings.Join(tokens, "_")
+	}
+	return key, v
+}
+
+// Converts an annotated value, like {"value": 233.1, "unit": "V",
+// "obis": "1-0:32.7.0"}, in place.
+func (o outputConfig) applyAnnotated(node map[string]interface{},
+	unit string) interface{} {
+	n, ok := node["value"].(json.Number)
+	if !ok {
+		return node
+	}
+	for name, g := range unitGroups {
+		if g.native == unit {
+			node["value"], node["unit"] = o.convertValue(name, unit, n)
+		}
+	}
+	return node
+}
+
+// Converts and rounds the value in the given unit of the group.  Returns
+// the value and the unit it is in now.
+func (o outputConfig) convertValue(group, unit string, n json.Number) (
+	interface{}, string) {
+	target, convert := o.units[group]
+	if !convert {
+		target = unit
+	}
+	digits, round := o.digits[group]
+	if target == unit && !round {
+		return n, unit
+	}
+	v, err := n.Float64()
+	if err != nil {
+		return n, unit
+	}
+	if target != unit {
+		g := unitGroups[group]
+		v = v * g.units[unit] / g.units[target]
+	}
+	if round {
+		scale := math.Pow(10, float64(digits))
+		v = math.Round(v*scale) / scale
+	} else {
+		// Avoid float32 artifacts like 1.2300000190734863.
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 7, 64), 64)
+	}
+	return v, target
+}
@@ -0,0 +1,49 @@
+package main
+
+// Posts telegrams to webhooks.
+
+import (
+	"encoding/json"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/bwesterb/go-dsmrp1/sinks"
+	"sync"
+	"time"
+)
+
+// Posts the telegrams of a meter as JSON to a URL, at most once per
+// interval (if non-zero).
+type webhook struct {
+	wh       *sinks.Webhook
+	interval time.Duration
+	q        *retryQueue
+
+	lock sync.Mutex
+	last time.Time
+}
+
+func newWebhook(url, meter string, interval time.Duration, cfg queueConfig) *webhook {
+	wh := sinks.NewWebhook(url)
+	wh.Start(meter)
+	name := "webhook " + meter + " " + redactSecrets(url)
+	return &webhook{
+		wh:       wh,
+		interval: interval,
+		q:        newRetryQueue(name, cfg),
+	}
+}
+
+func (wh *webhook) observe(t *dsmrp1.Telegram, at time.Time, stale []string) {
+	wh.lock.Lock()
+	if wh.interval != 0 && at.Sub(wh.last) < wh.interval {
+		wh.lock.Unlock()
+		return
+	}
+	wh.last = at
+	wh.lock.Unlock()
+
+	body, err := json.Marshal(t)
+	if err != nil {
+		return
+	}
+	wh.q.push(func() error { return wh.wh.Post(body, at) })
+}
@@ -0,0 +1,262 @@
+package main
+
+// Checks the telegrams of a meter or capture for problems, as a
+// diagnostic to run before filing an issue.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Number of telegrams checked from a meter if -n is not given.
+const defaultCheckCount = 20
+
+// A gap between telegrams this many times the usual interval is
+// reported as missed telegrams.
+const checkGapFactor = 2.5
+
+// Common OBIS references the parser does not know, with what they are.
+var obisRegistry = map[string]string{
+	"0-0:96.1.4":  "version of the Belgian e-MUCS specification",
+	"0-0:98.1.0":  "history of monthly peak demand (Belgium)",
+	"1-0:1.4.0":   "current average demand over 15 minutes (Belgium)",
+	"1-0:1.6.0":   "peak demand this month (Belgium)",
+	"1-0:31.4.0":  "current limit of the breaker (Belgium)",
+	"1-0:3.8.0":   "reactive energy imported (Luxembourg)",
+	"1-0:4.8.0":   "reactive energy exported (Luxembourg)",
+	"1-0:1.8.0":   "energy imported, all tariffs",
+	"1-0:2.8.0":   "energy exported, all tariffs",
+	"0-0:96.3.10": "breaker state",
+	"0-1:24.2.3":  "gas reading, temperature corrected (Luxembourg)",
+	"0-2:24.1.0":  "type of the second M-Bus device",
+	"0-2:96.1.0":  "identifier of the second M-Bus device",
+	"0-2:24.2.1":  "reading of the second M-Bus device, eg. water",
+	"0-3:24.1.0":  "type of the third M-Bus device",
+	"0-3:96.1.0":  "identifier of the third M-Bus device",
+	"0-3:24.2.1":  "reading of the third M-Bus device, eg. heat",
+	"0-4:24.1.0":  "type of the fourth M-Bus device",
+	"0-4:96.1.0":  "identifier of the fourth M-Bus device",
+	"0-4:24.2.1":  "reading of the fourth M-Bus device",
+}
+
+// Returns the OBIS references the parser knows.
+func knownObis() map[string]bool {
+	ret := make(map[string]bool)
+	for _, v := range []interface{}{
+		dsmrp1.Telegram{},
+		dsmrp1.ElectricityData{},
+		dsmrp1.MultiphaseElectricityData{},
+		dsmrp1.GasData{},
+	} {
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			if obis := typ.Field(i).Tag.Get("obis"); obis != "" {
+				ret[obis] = true
+			}
+		}
+	}
+	return ret
+}
+
+type checker struct {
+	known map[string]bool
+
+	telegrams int
+	invalid   map[string]int                 // telegrams that could not be parsed, by error
+	fieldErrs map[string]int                 // by error
+	unknown   map[string]int                 // OBIS references, by telegrams seen in
+	guesses   map[string]dsmrp1.UnknownValue // the last of each reference
+	ids       map[string]bool
+	times     []time.Time
+}
+
+func newChecker() *checker {
+	return &checker{
+		known:     knownObis(),
+		invalid:   make(map[string]int),
+		fieldErrs: make(map[string]int),
+		unknown:   make(map[string]int),
+		guesses:   make(map[string]dsmrp1.UnknownValue),
+		ids:       make(map[string]bool),
+	}
+}
+
+func (c *checker) check(raw []byte, at time.Time) {
+	c.telegrams++
+	t, errs := dsmrp1.ParseTelegramWith(raw, parseOptions)
+	if t == nil {
+		c.invalid[errs[0].Error()]++
+		return
+	}
+	for _, err := range errs {
+		c.fieldErrs[err.Error()]++
+	}
+	for _, v := range t.UnknownValues() {
+		if !c.known[string(v.OBIS)] {
+			c.unknown[string(v.OBIS)]++
+			c.guesses[string(v.OBIS)] = v
+		}
+	}
+	c.ids[t.ID] = true
+	c.times = append(c.times, at)
+}
+
+// Writes the report.  Returns whether problems were found.
+func (c *checker) report(w io.Writer) bool {
+	problems := false
+	fmt.Fprintf(w, "telegrams:  %d\n", c.telegrams)
+	if c.telegrams == 0 {
+		fmt.Fprintf(w, "PROBLEM: no telegrams received\n")
+		return true
+	}
+
+	invalid := 0
+	for _, n := range c.invalid {
+		invalid += n
+	}
+	fmt.Fprintf(w, "invalid:    %d (%.1f%%)\n", invalid,
+		100*float64(invalid)/float64(c.telegrams))
+	for _, msg := range sortedKeys(c.invalid) {
+		fmt.Fprintf(w, "PROBLEM: %d telegrams: %s\n", c.invalid[msg], msg)
+		problems = true
+	}
+	for _, msg := range sortedKeys(c.fieldErrs) {
+		fmt.Fprintf(w, "PROBLEM: %d times: %s\n", c.fieldErrs[msg], msg)
+		problems = true
+	}
+	if len(c.ids) > 1 {
+		fmt.Fprintf(w, "meters:     %d different IDs\n", len(c.ids))
+	}
+
+	for _, obis := range sortedKeys(c.unknown) {
+		name, ok := obisRegistry[obis]
+		if !ok {
+			name = "not in the registry"
+		}
+		fmt.Fprintf(w, "unknown:    %s: %s%s\n", obis, name,
+			describeGuess(c.guesses[obis]))
+	}
+
+	if len(c.times) < 2 {
+		return problems
+	}
+	var intervals []float64
+	for i := 1; i < len(c.times); i++ {
+		intervals = append(intervals, c.times[i].Sub(c.times[i-1]).Seconds())
+	}
+	sorted := append([]float64{}, intervals...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	if median == 0 {
+		// A capture without recorded times.
+		return problems
+	}
+	var mean, sq float64
+	for _, d := range intervals {
+		mean += d
+	}
+	mean /= float64(len(intervals))
+	for _, d := range intervals {
+		sq += (d - mean) * (d - mean)
+	}
+	fmt.Fprintf(w, "interval:   %.2fs median, %.2fs max, %.3fs jitter\n",
+		median, sorted[len(sorted)-1],
+		math.Sqrt(sq/float64(len(intervals))))
+	gaps := 0
+	for _, d := range intervals {
+		if d > checkGapFactor*median {
+			gaps++
+		}
+	}
+	if gaps > 0 {
+		fmt.Fprintf(w, "PROBLEM: %d gaps longer than %.1fs; "+
+			"telegrams were missed\n", gaps, checkGapFactor*median)
+		problems = true
+	}
+	return problems
+}
+
+// Describes the guessed value of an unknown line, eg. " (quantity: 1.2 W)".
+func describeGuess(v dsmrp1.UnknownValue) string {
+	if v.GuessedType == "" {
+		return ""
+	}
+	value := fmt.Sprint(v.GuessedValue)
+	if v.Unit != "" {
+		value += " " + v.Unit
+	}
+	if v.Warning != "" {
+		value += "; " + v.Warning
+	}
+	return fmt.Sprintf(" (%s: %s)", v.GuessedType, value)
+}
+
+func sortedKeys(m map[string]int) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Checks count telegrams from the source, or all of a capture.  Returns
+// whether problems were found.
+func runCheck(w io.Writer, spec string, count int, timeout time.Duration) (
+	bool, error) {
+	rc, err := openRaw(spec)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	type rawTelegram struct {
+		raw []byte
+		at  time.Time
+		err error
+	}
+	c := make(chan rawTelegram, 1)
+	go func() {
+		cr := dsmrp1.NewCaptureReader(rc)
+		for {
+			raw, at, err := cr.Next()
+			if at.IsZero() {
+				at = time.Now()
+			}
+			c <- rawTelegram{raw, at, err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	ch := newChecker()
+	var timer <-chan time.Time
+	for count == 0 || ch.telegrams < count {
+		if timeout != 0 {
+			timer = time.After(timeout)
+		}
+		var r rawTelegram
+		select {
+		case r = <-c:
+		case <-timer:
+			fmt.Fprintf(w, "PROBLEM: no telegram received within %v\n",
+				timeout)
+			ch.report(w)
+			return true, nil
+		}
+		if r.err == io.EOF {
+			break
+		}
+		if r.err != nil {
+			return false, r.err
+		}
+		ch.check(r.raw, r.at)
+	}
+	return ch.report(w), nil
+}
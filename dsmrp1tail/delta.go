@@ -0,0 +1,108 @@
+package main
+
+// Prints what was used between successive telegrams instead of the
+// meter readings, eg. to see what an appliance draws.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"strconv"
+	"time"
+)
+
+type deltaFormat struct {
+	table tableFormat
+
+	prev   *dsmrp1.Telegram
+	prevAt time.Time
+
+	// Last change of the gas reading, which the meter only updates
+	// every five minutes or hour.
+	gasAt    time.Time
+	gasValue float64
+}
+
+var deltaColumns = []string{
+	"time", "seconds", "in_wh", "out_wh", "in_w", "out_w",
+	"gas_dm3", "gas_dm3_h",
+}
+
+func newDeltaFormat() *deltaFormat {
+	f := &deltaFormat{}
+	for _, col := range deltaColumns {
+		width := len(col)
+		if width < minColumnWidth {
+			width = minColumnWidth
+		}
+		f.table.widths = append(f.table.widths, width)
+	}
+	f.table.widths[0] = len("15:04:05")
+	return f
+}
+
+func importWh(e *dsmrp1.ElectricityData) float64 {
+	return e.ImportTotal() * 1000
+}
+
+func exportWh(e *dsmrp1.ElectricityData) float64 {
+	return e.ExportTotal() * 1000
+}
+
+func formatDelta(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+func (f *deltaFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	prev, prevAt := f.prev, f.prevAt
+	f.prev, f.prevAt = t, at
+	if prev == nil {
+		f.updateGas(t)
+		return f.table.writeRow(w, deltaColumns, nil)
+	}
+	if prev.ID != t.ID {
+		f.gasAt = time.Time{}
+		f.updateGas(t)
+		_, err := fmt.Fprintf(w, "meter changed from %s to %s\n",
+			prev.ID, t.ID)
+		return err
+	}
+
+	secs := at.Sub(prevAt).Seconds()
+	row := []string{at.Format("15:04:05"), formatDelta(secs, 1)}
+	pe, e := prev.Electricity, t.Electricity
+	if pe != nil && e != nil && secs > 0 {
+		in := importWh(e) - importWh(pe)
+		out := exportWh(e) - exportWh(pe)
+		row = append(row,
+			formatDelta(in, 0),
+			formatDelta(out, 0),
+			formatDelta(in*3600/secs, 0),
+			formatDelta(out*3600/secs, 0))
+	} else {
+		row = append(row, "", "", "", "")
+	}
+
+	gasAt, gasValue := f.gasAt, f.gasValue
+	if f.updateGas(t) && !gasAt.IsZero() {
+		dm3 := (f.gasValue - gasValue) * 1000
+		hours := f.gasAt.Sub(gasAt).Hours()
+		row = append(row, formatDelta(dm3, 0), formatDelta(dm3/hours, 0))
+	} else {
+		row = append(row, "", "")
+	}
+	return f.table.writeRow(w, row, nil)
+}
+
+// Records the gas reading of the telegram.  Returns whether it changed.
+func (f *deltaFormat) updateGas(t *dsmrp1.Telegram) bool {
+	if t.Gas == nil {
+		return false
+	}
+	at, err := dsmrp1.ParseTimestamp(t.Gas.LastRecord.TimeStamp)
+	if err != nil || !at.After(f.gasAt) {
+		return false
+	}
+	f.gasAt, f.gasValue = at, float64(t.Gas.LastRecord.Value)
+	return true
+}
@@ -0,0 +1,180 @@
+package main
+
+// Compares the telegrams of two sources field by field, eg. to validate
+// a change to the parser or to debug a flaky adapter.  Telegrams are
+// paired up by their timestamp.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// Number of telegrams kept per source waiting for their counterpart.
+const maxUnpaired = 100
+
+type differ struct {
+	w       io.Writer
+	names   [2]string
+	pending [2]map[string]received
+	order   [2][]string // timestamps in pending, oldest first
+
+	compared  int
+	different int
+}
+
+func newDiffer(w io.Writer, a, b string) *differ {
+	return &differ{
+		w:       w,
+		names:   [2]string{a, b},
+		pending: [2]map[string]received{{}, {}},
+	}
+}
+
+// Adds a telegram from source i, comparing it with its counterpart
+// from the other source if that has been seen already.
+func (d *differ) add(i int, r received) error {
+	ts := r.t.TimeStamp
+	other, ok := d.pending[1-i][ts]
+	if !ok {
+		if _, dup := d.pending[i][ts]; !dup {
+			d.order[i] = append(d.order[i], ts)
+		}
+		d.pending[i][ts] = r
+		if len(d.order[i]) > maxUnpaired {
+			delete(d.pending[i], d.order[i][0])
+			d.order[i] = d.order[i][1:]
+		}
+		return nil
+	}
+	delete(d.pending[1-i], ts)
+	for j, pts := range d.order[1-i] {
+		if pts == ts {
+			d.order[1-i] = append(d.order[1-i][:j], d.order[1-i][j+1:]...)
+			break
+		}
+	}
+
+	pair := [2]received{}
+	pair[i], pair[1-i] = r, other
+	return d.compare(ts, pair)
+}
+
+func (d *differ) compare(ts string, pair [2]received) error {
+	var values [2]map[string]string
+	var keys []string
+	seen := make(map[string]bool)
+	for i, r := range pair {
+		fields, err := flattenTelegram(r.t)
+		if err != nil {
+			return err
+		}
+		values[i] = make(map[string]string)
+		for _, f := range fields {
+			values[i][f.key] = fieldString(f.value)
+			if !seen[f.key] {
+				seen[f.key] = true
+				keys = append(keys, f.key)
+			}
+		}
+	}
+
+	d.compared++
+	found := false
+	for _, key := range keys {
+		a, okA := values[0][key]
+		b, okB := values[1][key]
+		var line string
+		switch {
+		case !okA:
+			line = fmt.Sprintf("%s: only in %s: %s", key, d.names[1], b)
+		case !okB:
+			line = fmt.Sprintf("%s: only in %s: %s", key, d.names[0], a)
+		case a != b:
+			line = fmt.Sprintf("%s: %s != %s", key, a, b)
+		default:
+			continue
+		}
+		if !found {
+			found = true
+			d.different++
+			if _, err := fmt.Fprintf(d.w, "telegram %s:\n", ts); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(d.w, "  %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *differ) report() error {
+	_, err := fmt.Fprintf(d.w, "%d telegrams compared, %d differ\n",
+		d.compared, d.different)
+	return err
+}
+
+// Compares the telegrams of the two sources until either ends, or
+// count telegrams are compared if count is not zero.  Returns whether
+// there were differences.
+//
+// When a source ends, the other is read on until the telegrams left
+// of the first are paired up, or are clearly never going to be.
+func runDiff(w io.Writer, a, b string, count int, timeout time.Duration) (
+	bool, error) {
+	var cs [2]<-chan received
+	for i, spec := range []string{a, b} {
+		c, err := openSource(spec, false)
+		if err != nil {
+			return false, err
+		}
+		cs[i] = c
+	}
+
+	d := newDiffer(w, a, b)
+	var timer <-chan time.Time
+	ended := -1
+	afterEnd := 0
+	for count == 0 || d.compared < count {
+		if ended != -1 && (len(d.pending[ended]) == 0 ||
+			afterEnd > maxUnpaired) {
+			break
+		}
+		if timeout != 0 {
+			timer = time.After(timeout)
+		}
+		var r received
+		var ok bool
+		i := 0
+		select {
+		case r, ok = <-cs[0]:
+		case r, ok = <-cs[1]:
+			i = 1
+		case <-timer:
+			return false, errors.New(fmt.Sprintf(
+				"no telegram received within %v", timeout))
+		}
+		if !ok {
+			if ended != -1 {
+				break
+			}
+			ended = i
+			cs[i] = nil
+			continue
+		}
+		if r.err != nil {
+			log.Printf("%s: %v", []string{a, b}[i], r.err)
+			continue
+		}
+		if ended != -1 {
+			afterEnd++
+		}
+		if err := d.add(i, r); err != nil {
+			return false, err
+		}
+	}
+	return d.different != 0, d.report()
+}
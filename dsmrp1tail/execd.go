@@ -0,0 +1,52 @@
+package main
+
+// Support for the execd input plugin of Telegraf, which runs us for as
+// long as it runs and reads metrics in the InfluxDB line protocol from
+// our stdout.  Depending on its signal setting, it asks for metrics by
+// sending a SIGHUP or writing a line to our stdin, or not at all.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Returns a channel on which Telegraf's requests for metrics arrive, or
+// nil if it does not send any and we should write telegrams as they come.
+func execdTriggers(sig string) (<-chan struct{}, error) {
+	c := make(chan struct{}, 1)
+	request := func() {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	switch sig {
+	case "none":
+		return nil, nil
+	case "STDIN":
+		go func() {
+			s := bufio.NewScanner(os.Stdin)
+			for s.Scan() {
+				request()
+			}
+			// Telegraf closes our stdin when it stops.
+			os.Exit(0)
+		}()
+	case "SIGHUP":
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		go func() {
+			for range signals {
+				request()
+			}
+		}()
+	default:
+		return nil, errors.New(fmt.Sprintf(
+			"expected none, STDIN or SIGHUP: %s", sig))
+	}
+	return c, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+// Flattens telegrams into a list of fields with dotted snake_case keys,
+// eg. electricity.kwh_low and gas.last_record.value.
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/bwesterb/go-dsmrp1"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type field struct {
+	key   string
+	value interface{} // json.Number, string, bool or nil
+}
+
+// Converts a Go field name to snake_case: LastRecord to last_record and
+// L1VoltageSags to l1_voltage_sags.  Runs of capitals stay together, so
+// KWhLow becomes kwh_low.
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Returns the fields of the telegram in the order of its JSON encoding.
+func flattenTelegram(t *dsmrp1.Telegram) ([]field, error) {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var ret []field
+	if err = flattenValue(dec, "", &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Reads the next value from the decoder and appends its fields.
+func flattenValue(dec *json.Decoder, prefix string, ret *[]field) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			// OBIS references in Other are kept as they are.
+			if prefix != "other" {
+				key = snakeCase(key)
+			}
+			if err = flattenValue(dec, join(key), ret); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err = flattenValue(dec, join(strconv.Itoa(i)), ret); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+	*ret = append(*ret, field{prefix, tok})
+	return nil
+}
+
+// Formats the value of a field for text output.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case json.Number:
+		return v.String()
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// Parses the comma separated list of fields to select.
+func parseFieldSelection(s string) []string {
+	var ret []string
+	for _, key := range strings.Split(s, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key != "" {
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+// Returns the fields selected by the keys, in the order of the keys.  A
+// key selects the field itself or all fields below it, eg. "gas" selects
+// gas.last_record.value.  Returns all fields if there are no keys.
+func selectFields(fields []field, keys []string) []field {
+	if len(keys) == 0 {
+		return fields
+	}
+	var ret []field
+	for _, key := range keys {
+		for _, f := range fields {
+			if f.key == key || strings.HasPrefix(f.key, key+".") {
+				ret = append(ret, f)
+			}
+		}
+	}
+	return ret
+}
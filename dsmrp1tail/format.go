@@ -0,0 +1,316 @@
+package main
+
+// Output formats of the telegrams.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Writes telegrams, received at the given time, in some format.
+type formatter interface {
+	format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error
+}
+
+// The formats by name.  They are created with the fields to write, as
+// given to -fields, or nil for all fields.
+var formats = map[string]func(sel []string) formatter{
+	"json":   func(sel []string) formatter { return jsonFormat{sel, true} },
+	"jsonl":  func(sel []string) formatter { return jsonFormat{sel, false} },
+	"csv":    func(sel []string) formatter { return &csvFormat{sel: sel} },
+	"influx": func(sel []string) formatter { return influxFormat{sel} },
+	"prom":   func(sel []string) formatter { return promFormat{sel} },
+	"table": func(sel []string) formatter {
+		return &tableFormat{sel: sel, human: isTerminal(os.Stdout)}
+	},
+}
+
+// Writes the telegram as JSON or, if fields are selected, a flat JSON
+// object with the selected fields.
+type jsonFormat struct {
+	sel    []string
+	indent bool
+}
+
+func (f jsonFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	var buf []byte
+	var err error
+	if f.sel != nil {
+		buf, err = flatJSON(t, f.sel, f.indent)
+	} else if f.indent {
+		buf, err = json.MarshalIndent(t, "", "  ")
+	} else {
+		buf, err = json.Marshal(t)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(buf))
+	return err
+}
+
+// Returns the selected fields of the telegram as a flat JSON object.
+func flatJSON(t *dsmrp1.Telegram, sel []string, indent bool) ([]byte, error) {
+	fields, err := flattenTelegram(t)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range selectFields(fields, sel) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	if !indent {
+		return b.Bytes(), nil
+	}
+	var out bytes.Buffer
+	err = json.Indent(&out, b.Bytes(), "", "  ")
+	return out.Bytes(), err
+}
+
+// Writes the fields as key=value lines, followed by an empty line.
+type flatFormat struct {
+	sel []string
+}
+
+func (f flatFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	fields, err := flattenTelegram(t)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, fl := range selectFields(fields, f.sel) {
+		fmt.Fprintf(&b, "%s=%s\n", fl.key, fieldString(fl.value))
+	}
+	if f.sel == nil {
+		b.WriteByte('\n')
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the telegram as received from the meter.
+type rawFormat struct{}
+
+func (rawFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	_, err := w.Write(t.Raw)
+	return err
+}
+
+// Writes a header with the fields of the first telegram, and then a
+// row per telegram.
+type csvFormat struct {
+	sel    []string
+	header []string
+}
+
+func (f *csvFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	fields, err := flattenTelegram(t)
+	if err != nil {
+		return err
+	}
+	fields = selectFields(fields, f.sel)
+	cw := csv.NewWriter(w)
+	if f.header == nil {
+		f.header = []string{"time"}
+		for _, fl := range fields {
+			f.header = append(f.header, fl.key)
+		}
+		cw.Write(f.header)
+	}
+	values := make(map[string]string)
+	for _, fl := range fields {
+		values[fl.key] = fieldString(fl.value)
+	}
+	record := []string{at.Format(time.RFC3339)}
+	for _, key := range f.header[1:] {
+		record = append(record, values[key])
+	}
+	cw.Write(record)
+	cw.Flush()
+	return cw.Error()
+}
+
+// InfluxDB line protocol, eg. for Telegraf.
+type influxFormat struct {
+	sel []string
+}
+
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+func (f influxFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	fields, err := flattenTelegram(t)
+	if err != nil {
+		return err
+	}
+	fields = selectFields(fields, f.sel)
+	line := "dsmrp1"
+	if t.ID != "" {
+		line += ",meter=" + influxKeyEscaper.Replace(t.ID)
+	}
+	var values []string
+	for _, fl := range fields {
+		key := influxKeyEscaper.Replace(fl.key)
+		switch v := fl.value.(type) {
+		case json.Number:
+			values = append(values, key+"="+v.String())
+		case string:
+			values = append(values,
+				key+"=\""+influxStringEscaper.Replace(v)+"\"")
+		case bool:
+			values = append(values, fmt.Sprintf("%s=%v", key, v))
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "%s %s %d\n", line, strings.Join(values, ","),
+		at.UnixNano())
+	return err
+}
+
+// Prometheus text exposition of the numeric fields, eg. for the
+// textfile collector of the node exporter.
+type promFormat struct {
+	sel []string
+}
+
+var promInvalid = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+func (f promFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	fields, err := flattenTelegram(t)
+	if err != nil {
+		return err
+	}
+	fields = selectFields(fields, f.sel)
+	labels := ""
+	if t.ID != "" {
+		labels = fmt.Sprintf("{meter=%q}", t.ID)
+	}
+	for _, fl := range fields {
+		n, ok := fl.value.(json.Number)
+		if !ok {
+			continue
+		}
+		name := "dsmrp1_" + promInvalid.ReplaceAllString(fl.key, "_")
+		if _, err = fmt.Fprintf(w, "%s%s %s\n", name, labels, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An aligned table with a row per telegram, for humans.  On a terminal
+// the values are shown with units and in color if they stand out.
+type tableFormat struct {
+	sel     []string
+	human   bool
+	columns []string
+	widths  []int
+}
+
+// The default columns of the table.
+var tableColumns = []string{
+	"electricity.tariff",
+	"electricity.w",
+	"electricity.wout",
+	"electricity.kwh",
+	"electricity.kwh_low",
+	"electricity.kwh_out",
+	"electricity.kwh_out_low",
+	"gas.last_record.value",
+}
+
+// Minimum width of the columns of the table.
+const minColumnWidth = 10
+
+func (f *tableFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	fields, err := flattenTelegram(t)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]string)
+	for _, fl := range fields {
+		values[fl.key] = fieldString(fl.value)
+	}
+
+	if f.widths == nil {
+		f.columns = tableColumns
+		if f.sel != nil {
+			f.columns = nil
+			for _, fl := range selectFields(fields, f.sel) {
+				f.columns = append(f.columns, fl.key)
+			}
+		}
+	}
+
+	row := []string{at.Format("15:04:05")}
+	colors := []string{""}
+	for _, col := range f.columns {
+		value, color := values[col], ""
+		if f.human {
+			value, color = humanValue(col, value)
+		}
+		row = append(row, value)
+		colors = append(colors, color)
+	}
+
+	if f.widths == nil {
+		header := []string{"time"}
+		f.widths = []int{len("15:04:05")}
+		for i, col := range f.columns {
+			header = append(header, col)
+			width := len(col)
+			if width < minColumnWidth {
+				width = minColumnWidth
+			}
+			// Units make the values wider than the column names.
+			if n := utf8.RuneCountInString(row[i+1]); n > width {
+				width = n
+			}
+			f.widths = append(f.widths, width)
+		}
+		if err = f.writeRow(w, header, nil); err != nil {
+			return err
+		}
+	}
+	return f.writeRow(w, row, colors)
+}
+
+// Writes the cells right aligned, each in the given color if any.
+func (f *tableFormat) writeRow(w io.Writer, cells, colors []string) error {
+	var line []string
+	for i, cell := range cells {
+		pad := f.widths[i] - utf8.RuneCountInString(cell)
+		if pad > 0 {
+			cell = strings.Repeat(" ", pad) + cell
+		}
+		if colors != nil && colors[i] != "" {
+			cell = colors[i] + cell + colorReset
+		}
+		line = append(line, cell)
+	}
+	_, err := fmt.Fprintln(w, strings.Join(line, "  "))
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+// Values with units and colors for the table on a terminal.
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// Voltage range allowed by EN 50160: 230V ± 10%.
+const (
+	minVoltage = 207
+	maxVoltage = 253
+)
+
+// Returns whether we write to a terminal that wants colors.
+func isTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns the value of the field with its unit, and the color to show it
+// in, if any.
+func humanValue(key, value string) (string, string) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value, ""
+	}
+	name := key[strings.LastIndexByte(key, '.')+1:]
+	switch {
+	case name == "w" || name == "wout" || strings.HasSuffix(name, "_power") ||
+		strings.HasSuffix(name, "_power_out"):
+		color := ""
+		if v > 0 && (name == "wout" || strings.HasSuffix(name, "_out")) {
+			color = colorGreen
+		}
+		return strconv.FormatFloat(v/1000, 'f', 3, 64) + " kW", color
+	case strings.HasPrefix(name, "kwh"):
+		return strconv.FormatFloat(v, 'f', 3, 64) + " kWh", ""
+	case strings.HasSuffix(name, "_voltage"):
+		color := ""
+		if v < minVoltage || v > maxVoltage {
+			color = colorRed
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64) + " V", color
+	case strings.HasSuffix(name, "_current"):
+		return strconv.FormatFloat(v, 'f', -1, 64) + " A", ""
+	case key == "gas.last_record.value":
+		return strconv.FormatFloat(v, 'f', 3, 64) + " m³", ""
+	}
+	return value, ""
+}
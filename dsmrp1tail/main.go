@@ -1,31 +1,348 @@
 package main
 
-// Connects a P1 smart meter via serial port and prints the parsed
-// telegrams as JSON objects.
+// Connects a P1 smart meter, or reads a capture, and prints the parsed
+// telegrams, by default as JSON objects.
 
 import (
-	"encoding/json"
+	"bytes"
 	"flag"
-	"fmt"
 	"github.com/bwesterb/go-dsmrp1"
 	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
 )
 
+// Options to parse telegrams with.
+var parseOptions dsmrp1.ParseOptions
+
 func main() {
 	var serialDev string
+	var source string
+	var format string
+	var fields string
+	var flatten bool
+	var count int
+	var timeout time.Duration
+	var interval time.Duration
+	var raw bool
+	var record string
+	var delta bool
+	var check bool
+	var when string
+	var execCmd string
+	var out outConfig
+	var diff string
+	var strict bool
+	var profile string
+	var execd string
+
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
 	flag.StringVar(&serialDev, "serial", "/dev/P1",
-		"path to serial port")
+		"path to serial port; see also -source")
+	flag.StringVar(&source, "source", "",
+		"where to read telegrams from: serial:/dev/P1, tcp:host:port, "+
+			"file:capture.p1 or - for a capture on stdin")
+	flag.StringVar(&format, "format", "json",
+		"output format: "+strings.Join(names, ", "))
+	flag.StringVar(&fields, "fields", "",
+		"comma separated fields to print, eg. electricity.w,gas; "+
+			"see -flatten for their names")
+	flag.BoolVar(&flatten, "flatten", false,
+		"print the fields as key=value lines instead of -format")
+	flag.IntVar(&count, "n", 0,
+		"exit after printing this many telegrams; 0 to run forever")
+	flag.DurationVar(&timeout, "timeout", 0,
+		"fail if no telegram is received within this time; 0 to wait forever")
+	flag.DurationVar(&interval, "interval", 0,
+		"print at most one telegram per interval, eg. 10s")
+	flag.BoolVar(&raw, "raw", false,
+		"print the telegrams as received instead of -format")
+	flag.BoolVar(&delta, "delta", false,
+		"print the energy and gas used between telegrams instead of "+
+			"-format; use with -interval for steadier numbers")
+	flag.BoolVar(&check, "check", false,
+		"check the telegrams for problems instead of printing them; "+
+			"exits with status 1 if any are found")
+	flag.StringVar(&when, "when", "",
+		"only print telegrams matching this condition, "+
+			"eg. 'electricity.w > 3000 && electricity.tariff == 1'")
+	flag.StringVar(&execCmd, "exec", "",
+		"instead of printing, run this command with the telegram on "+
+			"stdin and its fields in the environment, eg. DSMRP1_ELECTRICITY_W; "+
+			"with -when, only when the condition starts to match")
+	flag.StringVar(&out.dir, "out", "",
+		"write to files in this directory instead of stdout, "+
+			"by default as jsonl")
+	flag.DurationVar(&out.rotate, "rotate", 24*time.Hour,
+		"with -out, start a new file after this long; 0 to disable")
+	flag.Int64Var(&out.maxSize, "max-size", 0,
+		"with -out, start a new file after this many (uncompressed) bytes; "+
+			"0 to disable")
+	flag.BoolVar(&out.gzip, "gzip", false, "with -out, compress the files")
+	flag.StringVar(&diff, "diff", "",
+		"compare the telegrams of -source with those of this source, "+
+			"field by field; exits with status 1 if they differ")
+	flag.BoolVar(&strict, "strict", false,
+		"exit with status 1 on the first invalid telegram or read error, "+
+			"instead of skipping it or reconnecting")
+	flag.StringVar(&profile, "profile", "permissive",
+		"strict to report lines that are not part of the version of the "+
+			"telegram, or missing ones it requires, as errors; or permissive")
+	flag.StringVar(&execd, "execd", "",
+		"run as an execd input of Telegraf with the given signal setting: "+
+			"none, STDIN or SIGHUP; prints the latest telegram when asked, "+
+			"by default in the influx format")
+	flag.StringVar(&record, "record", "",
+		"append all telegrams received to this capture file, for replay")
 
 	flag.Parse()
 
-	m, err := dsmrp1.NewMeter(serialDev)
+	sel := parseFieldSelection(fields)
+	set := make(map[string]bool)
+	flag.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for _, conflict := range [][2]string{
+		{"flatten", "format"},
+		{"raw", "format"},
+		{"raw", "flatten"},
+		{"raw", "fields"},
+		{"delta", "format"},
+		{"delta", "flatten"},
+		{"delta", "raw"},
+		{"delta", "fields"},
+		{"check", "format"},
+		{"check", "flatten"},
+		{"check", "raw"},
+		{"check", "delta"},
+		{"check", "fields"},
+		{"check", "interval"},
+		{"check", "record"},
+		{"check", "when"},
+		{"check", "exec"},
+		{"out", "flatten"},
+		{"out", "raw"},
+		{"out", "delta"},
+		{"out", "exec"},
+		{"out", "check"},
+		{"diff", "format"},
+		{"diff", "flatten"},
+		{"diff", "raw"},
+		{"diff", "delta"},
+		{"diff", "fields"},
+		{"diff", "interval"},
+		{"diff", "record"},
+		{"diff", "when"},
+		{"diff", "exec"},
+		{"diff", "out"},
+		{"diff", "check"},
+		{"execd", "flatten"},
+		{"execd", "raw"},
+		{"execd", "delta"},
+		{"execd", "check"},
+		{"execd", "diff"},
+		{"execd", "out"},
+		{"execd", "exec"},
+		{"execd", "n"},
+	} {
+		if set[conflict[0]] && set[conflict[1]] {
+			log.Fatalf("-%s: cannot be combined with -%s",
+				conflict[0], conflict[1])
+		}
+	}
+
+	for _, name := range []string{"rotate", "max-size", "gzip"} {
+		if set[name] && out.dir == "" {
+			log.Fatalf("-%s: requires -out", name)
+		}
+	}
+	switch profile {
+	case "permissive":
+	case "strict":
+		parseOptions.Profile = dsmrp1.Strict
+	default:
+		log.Fatalf("-profile: unknown profile %s", profile)
+	}
+	if source == "" {
+		source = "serial:" + serialDev
+	}
+
+	if check {
+		if count == 0 && source != "-" && !strings.HasPrefix(source, "file:") {
+			count = defaultCheckCount
+		}
+		problems, err := runCheck(os.Stdout, source, count, timeout)
+		if err != nil {
+			log.Fatalf("Failed to check %s: %v", source, err)
+		}
+		if problems {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if diff != "" {
+		differs, err := runDiff(os.Stdout, source, diff, count, timeout)
+		if err != nil {
+			log.Fatalf("-diff: %v", err)
+		}
+		if differs {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var f formatter
+	if delta {
+		f = newDeltaFormat()
+	} else if raw {
+		f = rawFormat{}
+	} else if flatten {
+		f = flatFormat{sel}
+	} else {
+		if out.dir != "" && !set["format"] {
+			format = "jsonl"
+		}
+		if execd != "" && !set["format"] {
+			format = "influx"
+		}
+		newFormatter, ok := formats[format]
+		if !ok {
+			log.Fatalf("-format: unknown format %s", format)
+		}
+		f = newFormatter(sel)
+		if out.dir != "" {
+			if format == "table" {
+				log.Fatalf("-out: cannot write -format table to files")
+			}
+			rf := newRotatingFormat(out, format,
+				func() formatter { return newFormatter(sel) })
+			defer rf.Close()
+			f = rf
+		}
+	}
+
+	var cond condition
+	if when != "" {
+		var err error
+		if cond, err = parseCondition(when); err != nil {
+			log.Fatalf("-when: %v", err)
+		}
+	}
+	var execArgs []string
+	if execCmd != "" {
+		execArgs = strings.Fields(execCmd)
+	}
+
+	var trigger <-chan struct{}
+	if execd != "" {
+		if execd == "STDIN" && source == "-" {
+			log.Fatalf("-execd: STDIN cannot be combined with -source -")
+		}
+		var err error
+		if trigger, err = execdTriggers(execd); err != nil {
+			log.Fatalf("-execd: %v", err)
+		}
+	}
+
+	c, err := openSource(source, strict)
 	if err != nil {
-		log.Fatalf("Failed to create meter: %v", err)
+		log.Fatalf("Failed to open %s: %v", source, err)
+	}
+
+	var cw *dsmrp1.CaptureWriter
+	if record != "" {
+		rf, err := os.OpenFile(record,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("-record: %v", err)
+		}
+		defer rf.Close()
+		cw = dsmrp1.NewCaptureWriter(rf)
 	}
 
-	for w := range m.C {
-		s, _ := json.MarshalIndent(w, "", "  ")
-		fmt.Println(string(s))
+	var timer <-chan time.Time
+	var last time.Time
+	var pending *received // to print when Telegraf asks for it
+	matching := false
+	for printed := 0; count == 0 || printed < count; {
+		if timeout != 0 {
+			timer = time.After(timeout)
+		}
+		var r received
+		var ok bool
+		select {
+		case r, ok = <-c:
+			if !ok {
+				return
+			}
+		case <-timer:
+			log.Fatalf("No telegram received within %v", timeout)
+		case <-trigger:
+			if pending != nil {
+				if err = f.format(os.Stdout, pending.t, pending.at); err != nil {
+					log.Fatalf("Failed to write telegram: %v", err)
+				}
+				pending = nil
+			}
+			continue
+		}
+		if r.err != nil {
+			if strict {
+				log.Fatalf("%s: %v", source, r.err)
+			}
+			log.Printf("%s: %v", source, r.err)
+			continue
+		}
+		t, now := r.t, r.at
+		if cw != nil {
+			if err = cw.Write(now, t.Raw); err != nil {
+				log.Fatalf("Failed to record telegram: %v", err)
+			}
+		}
+		var fields []field
+		if cond != nil || execArgs != nil {
+			if fields, err = flattenTelegram(t); err != nil {
+				log.Fatalf("Failed to flatten telegram: %v", err)
+			}
+		}
+		if cond != nil {
+			wasMatching := matching
+			matching = cond.match(fields)
+			if !matching || (execArgs != nil && wasMatching) {
+				continue
+			}
+		}
+		if interval != 0 && now.Sub(last) < interval {
+			continue
+		}
+		last = now
+		if trigger != nil {
+			pending = &r
+			continue
+		}
+		if execArgs != nil {
+			var buf bytes.Buffer
+			if err = f.format(&buf, t, now); err != nil {
+				log.Fatalf("Failed to format telegram: %v", err)
+			}
+			cmd := exec.Command(execArgs[0], execArgs[1:]...)
+			cmd.Env = append(os.Environ(), fieldEnv(fields)...)
+			cmd.Stdin = &buf
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err = cmd.Run(); err != nil {
+				log.Printf("-exec: %v", err)
+			}
+		} else if err = f.format(os.Stdout, t, now); err != nil {
+			log.Fatalf("Failed to write telegram: %v", err)
+		}
+		printed++
 	}
 }
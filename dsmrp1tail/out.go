@@ -0,0 +1,120 @@
+package main
+
+// Writes the telegrams to files in a directory, starting a new file
+// every so often, for logging without the daemon.
+
+import (
+	"compress/gzip"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Configuration of the output files.
+type outConfig struct {
+	dir     string
+	rotate  time.Duration // start a new file after this long; 0 for never
+	maxSize int64         // start a new file after this many bytes; 0 for never
+	gzip    bool
+}
+
+// Writes the telegrams in a format to files named
+// dsmrp1-<start time>.<format> (or .<format>.gz) in the directory,
+// instead of to the writer it is given.  Every file gets a new
+// formatter, so that a CSV file starts with its header.
+type rotatingFormat struct {
+	cfg          outConfig
+	ext          string
+	newFormatter func() formatter
+
+	f       *os.File
+	gz      *gzip.Writer
+	w       io.Writer
+	fmtr    formatter
+	opened  time.Time
+	written int64
+}
+
+func newRotatingFormat(cfg outConfig, ext string,
+	newFormatter func() formatter) *rotatingFormat {
+	return &rotatingFormat{cfg: cfg, ext: ext, newFormatter: newFormatter}
+}
+
+// Counts the bytes written.
+type countingWriter struct {
+	w *rotatingFormat
+}
+
+func (cw countingWriter) Write(buf []byte) (int, error) {
+	var n int
+	var err error
+	if cw.w.gz != nil {
+		n, err = cw.w.gz.Write(buf)
+	} else {
+		n, err = cw.w.f.Write(buf)
+	}
+	cw.w.written += int64(n)
+	return n, err
+}
+
+func (r *rotatingFormat) open(at time.Time) error {
+	path := filepath.Join(r.cfg.dir,
+		"dsmrp1-"+at.Format("20060102T150405")+"."+r.ext)
+	if r.cfg.gzip {
+		path += ".gz"
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	if r.cfg.gzip {
+		r.gz = gzip.NewWriter(f)
+	}
+	r.w = countingWriter{r}
+	r.fmtr = r.newFormatter()
+	r.opened = at
+	r.written = 0
+	return nil
+}
+
+// Closes the current file, if any.
+func (r *rotatingFormat) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	var err error
+	if r.gz != nil {
+		err = r.gz.Close()
+		r.gz = nil
+	}
+	if err2 := r.f.Close(); err == nil {
+		err = err2
+	}
+	r.f = nil
+	return err
+}
+
+func (r *rotatingFormat) format(w io.Writer, t *dsmrp1.Telegram, at time.Time) error {
+	if r.f != nil && ((r.cfg.rotate != 0 && at.Sub(r.opened) >= r.cfg.rotate) ||
+		(r.cfg.maxSize != 0 && r.written >= r.cfg.maxSize)) {
+		if err := r.Close(); err != nil {
+			return err
+		}
+	}
+	if r.f == nil {
+		if err := r.open(at); err != nil {
+			return err
+		}
+	}
+	if err := r.fmtr.format(r.w, t, at); err != nil {
+		return err
+	}
+	if r.gz != nil {
+		// Flush, so that a crash does not lose more than a telegram.
+		return r.gz.Flush()
+	}
+	return nil
+}
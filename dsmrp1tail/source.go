@@ -0,0 +1,220 @@
+package main
+
+// Sources of telegrams: a meter, a capture file, standard input, a file
+// with telegrams as JSON lines or the telegram endpoint of dsmrp1d.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"github.com/tarm/serial"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// A telegram and the time it was received, or the error instead.
+type received struct {
+	t   *dsmrp1.Telegram
+	at  time.Time
+	err error
+}
+
+// How often the telegram endpoint of dsmrp1d is polled.
+const pollInterval = time.Second
+
+// Opens the source described by spec, which is either
+// "serial:/dev/P1", "tcp:host:port", "file:capture.p1", "-" for a
+// capture on standard input, "jsonl:file" for telegrams as written by
+// -format jsonl, or the URL of the telegram endpoint of dsmrp1d, eg.
+// http://host:8080/api/v1/telegram.  The channel is closed at the end
+// of a file.
+//
+// A meter reconnects on errors and drops invalid telegrams, unless
+// strict is set: then the invalid telegrams are passed on as errors
+// and the channel is closed on a read error.
+func openSource(spec string, strict bool) (<-chan received, error) {
+	if strings.HasPrefix(spec, "http://") ||
+		strings.HasPrefix(spec, "https://") {
+		return pollTelegrams(spec), nil
+	}
+	if spec == "-" || strings.HasPrefix(spec, "file:") {
+		rc, err := openRaw(spec)
+		if err != nil {
+			return nil, err
+		}
+		return readCapture(rc, false), nil
+	}
+	if strings.HasPrefix(spec, "jsonl:") {
+		f, err := os.Open(strings.TrimPrefix(spec, "jsonl:"))
+		if err != nil {
+			return nil, err
+		}
+		return readJSONLines(f), nil
+	}
+	if strict {
+		rc, err := openRaw(spec)
+		if err != nil {
+			return nil, err
+		}
+		return readCapture(rc, true), nil
+	}
+
+	m, err := dsmrp1.OpenMeterWith(spec, parseOptions)
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan received, 1)
+	go func() {
+		defer close(c)
+		for t := range m.C {
+			c <- received{t, time.Now(), nil}
+		}
+	}()
+	return c, nil
+}
+
+// Opens the stream of raw telegrams described by spec, as openSource,
+// without reconnecting or dropping invalid telegrams.  Read it with a
+// dsmrp1.CaptureReader, which skips anything before a header.
+func openRaw(spec string) (io.ReadCloser, error) {
+	if spec == "-" {
+		return os.Stdin, nil
+	}
+	bits := strings.SplitN(spec, ":", 2)
+	if len(bits) == 2 {
+		switch bits[0] {
+		case "file":
+			return dsmrp1.OpenCapture(bits[1])
+		case "tcp":
+			return net.DialTimeout("tcp", bits[1], 10*time.Second)
+		case "serial":
+			spec = bits[1]
+		}
+	}
+	return serial.OpenPort(&serial.Config{
+		Name:     spec,
+		Baud:     115200,
+		Parity:   serial.ParityNone,
+		StopBits: serial.Stop1,
+	})
+}
+
+// Parses the telegrams in the capture as fast as they can be read.
+// Telegrams without a recorded time get the time they are read.  The
+// telegrams that could not be parsed, even partially, are passed on as
+// errors, as is the end of a live stream.
+func readCapture(rc io.ReadCloser, live bool) <-chan received {
+	c := make(chan received, 1)
+	go func() {
+		defer close(c)
+		defer rc.Close()
+		cr := dsmrp1.NewCaptureReader(rc)
+		for {
+			raw, at, err := cr.Next()
+			if err == io.EOF && live {
+				err = errors.New("connection closed")
+			}
+			if err != nil {
+				if err != io.EOF {
+					c <- received{err: err}
+				}
+				return
+			}
+			if at.IsZero() {
+				at = time.Now()
+			}
+			t, errs := dsmrp1.ParseTelegramWith(raw, parseOptions)
+			if errs != nil {
+				c <- received{at: at, err: errors.New(
+					fmt.Sprintf("invalid telegram: %v", errs))}
+				continue
+			}
+			c <- received{t, at, nil}
+		}
+	}()
+	return c
+}
+
+// Reads telegrams as JSON objects, one per line.  The telegrams get
+// the time they are read.
+func readJSONLines(rc io.ReadCloser) <-chan received {
+	c := make(chan received, 1)
+	go func() {
+		defer close(c)
+		defer rc.Close()
+		scanner := bufio.NewScanner(rc)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			var t dsmrp1.Telegram
+			if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+				c <- received{at: time.Now(), err: err}
+				continue
+			}
+			c <- received{&t, time.Now(), nil}
+		}
+		if err := scanner.Err(); err != nil {
+			c <- received{err: err}
+		}
+	}()
+	return c
+}
+
+// Polls the telegram endpoint of dsmrp1d at url, passing on every new
+// telegram.
+func pollTelegrams(url string) <-chan received {
+	c := make(chan received, 1)
+	go func() {
+		etag, last := "", ""
+		for ; ; time.Sleep(pollInterval) {
+			t, newETag, err := fetchTelegram(url, etag)
+			if err != nil {
+				c <- received{at: time.Now(), err: errors.New(
+					fmt.Sprintf("%s: %v", url, err))}
+				continue
+			}
+			if t == nil || t.TimeStamp == last {
+				continue
+			}
+			etag, last = newETag, t.TimeStamp
+			c <- received{t, time.Now(), nil}
+		}
+	}()
+	return c
+}
+
+// Fetches the telegram at url.  Returns nil if it still has the given
+// ETag.
+func fetchTelegram(url, etag string) (*dsmrp1.Telegram, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New(resp.Status)
+	}
+	var t dsmrp1.Telegram
+	if err = json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return nil, "", err
+	}
+	return &t, resp.Header.Get("ETag"), nil
+}
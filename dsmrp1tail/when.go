@@ -0,0 +1,134 @@
+package main
+
+// Conditions on the fields of a telegram, as given to -when, eg.
+//
+//	electricity.w > 3000 && electricity.tariff == 1
+//
+// A condition is one or more comparisons of a field with a number or a
+// string, joined by && and ||, where && binds more strongly.
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type comparison struct {
+	key   string
+	op    string
+	value string
+}
+
+// Comparisons joined by ||, each a list joined by &&.
+type condition [][]comparison
+
+// The comparison operators.  Longer ones first, so that >= is not read
+// as >.
+var comparisonOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+func parseCondition(s string) (condition, error) {
+	var ret condition
+	for _, alternative := range strings.Split(s, "||") {
+		var all []comparison
+		for _, term := range strings.Split(alternative, "&&") {
+			c, err := parseComparison(strings.TrimSpace(term))
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, c)
+		}
+		ret = append(ret, all)
+	}
+	return ret, nil
+}
+
+func parseComparison(s string) (comparison, error) {
+	for _, op := range comparisonOps {
+		i := strings.Index(s, op)
+		if i == -1 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		value := strings.TrimSpace(s[i+len(op):])
+		if key == "" || value == "" {
+			break
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' &&
+			value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		return comparison{key, op, value}, nil
+	}
+	return comparison{}, errors.New(fmt.Sprintf(
+		"expected field, one of %s and value: %s",
+		strings.Join(comparisonOps, " "), s))
+}
+
+// Returns whether the fields match the condition.  A comparison with a
+// field that is missing never matches.
+func (c condition) match(fields []field) bool {
+	values := make(map[string]string)
+	for _, f := range fields {
+		values[f.key] = fieldString(f.value)
+	}
+	for _, all := range c {
+		ok := true
+		for _, cmp := range all {
+			if !cmp.match(values) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c comparison) match(values map[string]string) bool {
+	v, ok := values[c.key]
+	if !ok {
+		return false
+	}
+	x, err1 := strconv.ParseFloat(v, 64)
+	y, err2 := strconv.ParseFloat(c.value, 64)
+	if err1 != nil || err2 != nil {
+		switch c.op {
+		case "==":
+			return v == c.value
+		case "!=":
+			return v != c.value
+		}
+		return false
+	}
+	switch c.op {
+	case ">=":
+		return x >= y
+	case "<=":
+		return x <= y
+	case "==":
+		return x == y
+	case "!=":
+		return x != y
+	case ">":
+		return x > y
+	case "<":
+		return x < y
+	}
+	return false
+}
+
+// Returns the environment variables for -exec: the fields, eg.
+// DSMRP1_ELECTRICITY_W=1193.
+func fieldEnv(fields []field) []string {
+	var ret []string
+	for _, f := range fields {
+		key := strings.ToUpper(promInvalid.ReplaceAllString(f.key, "_"))
+		ret = append(ret, "DSMRP1_"+key+"="+fieldString(f.value))
+	}
+	return ret
+}
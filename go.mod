@@ -1,7 +1,9 @@
 module github.com/bwesterb/go-dsmrp1
 
+go 1.20
+
 require (
 	github.com/howeyc/crc16 v0.0.0-20171223171357-2b2a61e366a6
 	github.com/tarm/serial v0.0.0-20180830185346-98f6abe2eb07
-	golang.org/x/sys v0.0.0-20190124100055-b90733256f2e // indirect
+	golang.org/x/sys v0.0.0-20190124100055-b90733256f2e
 )
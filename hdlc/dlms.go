@@ -0,0 +1,205 @@
+package hdlc
+
+// The DLMS data-notification APDU:
+//
+//	0F                    tag
+//	<4 bytes>             long-invoke-id-and-priority
+//	<date-time>           09 0C <12 bytes>, 0C <12 bytes> or 00 if absent
+//	<data>                the notification body
+//
+// The body is an array or structure of DLMS data, see parseData.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const dataNotificationTag = 0x0f
+
+// DLMS data types.
+const (
+	typeNull        = 0x00
+	typeArray       = 0x01
+	typeStructure   = 0x02
+	typeBoolean     = 0x03
+	typeInt32       = 0x05
+	typeUint32      = 0x06
+	typeOctetString = 0x09
+	typeString      = 0x0a
+	typeInt8        = 0x0f
+	typeInt16       = 0x10
+	typeUint8       = 0x11
+	typeUint16      = 0x12
+	typeInt64       = 0x14
+	typeUint64      = 0x15
+	typeEnum        = 0x16
+	typeFloat32     = 0x17
+	typeFloat64     = 0x18
+)
+
+// A DLMS value: a number, string, octet string or list of values.
+type value struct {
+	typ     byte
+	number  float64
+	bytes   []byte  // of strings and octet strings
+	members []value // of arrays and structures
+}
+
+// Parses a data-notification into the time it was sent, if given, and
+// its body.
+func parseNotification(apdu []byte) (*time.Time, value, error) {
+	var body value
+	if len(apdu) < 6 || apdu[0] != dataNotificationTag {
+		return nil, body, errors.New("not a data-notification")
+	}
+	rest := apdu[5:]
+	var at *time.Time
+	switch rest[0] {
+	case typeNull:
+		rest = rest[1:]
+	case typeOctetString, 0x0c:
+		if rest[0] == typeOctetString {
+			rest = rest[1:]
+		}
+		if len(rest) < 13 || rest[0] != 0x0c {
+			return nil, body, errors.New("malformed date-time")
+		}
+		if t, ok := parseDateTime(rest[1:13]); ok {
+			at = &t
+		}
+		rest = rest[13:]
+	default:
+		return nil, body, errors.New(fmt.Sprintf(
+			"unexpected date-time type %#x", rest[0]))
+	}
+	body, rest, err := parseData(rest)
+	if err != nil {
+		return nil, body, err
+	}
+	return at, body, nil
+}
+
+// Parses a single DLMS value and returns it with what follows it.
+func parseData(buf []byte) (value, []byte, error) {
+	var v value
+	if len(buf) == 0 {
+		return v, nil, errors.New("data is truncated")
+	}
+	v.typ = buf[0]
+	buf = buf[1:]
+	fixed := func(n int) ([]byte, error) {
+		if len(buf) < n {
+			return nil, errors.New("data is truncated")
+		}
+		ret := buf[:n]
+		buf = buf[n:]
+		return ret, nil
+	}
+	var b []byte
+	var err error
+	switch v.typ {
+	case typeNull:
+	case typeArray, typeStructure:
+		if b, err = fixed(1); err != nil {
+			return v, nil, err
+		}
+		for i := 0; i < int(b[0]); i++ {
+			var m value
+			if m, buf, err = parseData(buf); err != nil {
+				return v, nil, err
+			}
+			v.members = append(v.members, m)
+		}
+	case typeOctetString, typeString:
+		if b, err = fixed(1); err != nil {
+			return v, nil, err
+		}
+		v.bytes, err = fixed(int(b[0]))
+	case typeBoolean, typeUint8, typeEnum:
+		if b, err = fixed(1); err == nil {
+			v.number = float64(b[0])
+		}
+	case typeInt8:
+		if b, err = fixed(1); err == nil {
+			v.number = float64(int8(b[0]))
+		}
+	case typeInt16:
+		if b, err = fixed(2); err == nil {
+			v.number = float64(int16(binary.BigEndian.Uint16(b)))
+		}
+	case typeUint16:
+		if b, err = fixed(2); err == nil {
+			v.number = float64(binary.BigEndian.Uint16(b))
+		}
+	case typeInt32:
+		if b, err = fixed(4); err == nil {
+			v.number = float64(int32(binary.BigEndian.Uint32(b)))
+		}
+	case typeUint32:
+		if b, err = fixed(4); err == nil {
+			v.number = float64(binary.BigEndian.Uint32(b))
+		}
+	case typeInt64:
+		if b, err = fixed(8); err == nil {
+			v.number = float64(int64(binary.BigEndian.Uint64(b)))
+		}
+	case typeUint64:
+		if b, err = fixed(8); err == nil {
+			v.number = float64(binary.BigEndian.Uint64(b))
+		}
+	case typeFloat32:
+		if b, err = fixed(4); err == nil {
+			v.number = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		}
+	case typeFloat64:
+		if b, err = fixed(8); err == nil {
+			v.number = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+	default:
+		return v, nil, errors.New(fmt.Sprintf("unsupported data type %#x", v.typ))
+	}
+	return v, buf, err
+}
+
+func (v value) isNumber() bool {
+	switch v.typ {
+	case typeArray, typeStructure, typeOctetString, typeString, typeNull:
+		return false
+	}
+	return true
+}
+
+// Returns whether the value is the scaler and unit of a register: a
+// structure of an int8 and an enum.
+func (v value) isScalerUnit() bool {
+	return v.typ == typeStructure && len(v.members) == 2 &&
+		v.members[0].typ == typeInt8 && v.members[1].typ == typeEnum
+}
+
+// Parses a DLMS date-time: year (2 bytes), month, day, weekday, hour,
+// minute, second, hundredths, deviation (2 bytes) and clock status.
+// Fields that are not specified are 0xFF.
+func parseDateTime(b []byte) (time.Time, bool) {
+	if len(b) != 12 {
+		return time.Time{}, false
+	}
+	year := int(binary.BigEndian.Uint16(b[0:2]))
+	if year == 0xffff || b[2] == 0xff || b[3] == 0xff || b[5] == 0xff ||
+		b[6] == 0xff {
+		return time.Time{}, false
+	}
+	second := 0
+	if b[7] != 0xff {
+		second = int(b[7])
+	}
+	loc := time.Local
+	// The deviation is the offset from local time to UTC in minutes.
+	if dev := int16(binary.BigEndian.Uint16(b[9:11])); dev != -0x8000 {
+		loc = time.FixedZone("", -int(dev)*60)
+	}
+	return time.Date(year, time.Month(b[2]), int(b[3]), int(b[5]),
+		int(b[6]), second, 0, loc), true
+}
@@ -0,0 +1,149 @@
+// Package hdlc decodes the binary telegrams of the HAN ports of Nordic
+// and Austrian meters: DLMS data-notifications pushed in HDLC frames,
+// instead of the ASCII telegrams of P1 ports.
+//
+// The objects of a notification are mapped to the fields of a
+// dsmrp1.Telegram by their OBIS codes.  Kaifa meters in Norway send
+// their lists without codes, which are mapped by position.
+package hdlc
+
+// HDLC frames of format type 3, as used by DLMS:
+//
+//	7E                    flag
+//	A<S><length>          format type, segmentation bit and 11 bit length
+//	<address>             destination, 1 to 4 bytes, the last one odd
+//	<address>             source
+//	<control>
+//	<2 bytes>             header checksum, if there is information
+//	<information>
+//	<2 bytes>             frame checksum
+//	7E                    flag
+//
+// The length counts everything between the flags.  The checksums are
+// CRC-16/X-25, least significant byte first.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	flag          = 0x7e
+	segmentedBit  = 0x08
+	maxAddressLen = 4
+)
+
+// The LLC header before the APDU in the information of the first frame.
+var llcHeader = []byte{0xe6, 0xe7, 0x00}
+
+type frame struct {
+	segmented bool
+	info      []byte
+}
+
+// Reads the next frame, skipping anything before its opening flag.
+func readFrame(r *bufio.Reader) (*frame, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != flag {
+			continue
+		}
+		// Frames may share the flag in between.
+		format, err := r.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if format[0]>>4 != 0xa {
+			continue
+		}
+		length := int(format[0]&0x07)<<8 | int(format[1])
+		if length < 2+1+1+1+2 {
+			return nil, errors.New(fmt.Sprintf(
+				"frame of %d bytes is too short", length))
+		}
+		buf := make([]byte, length)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if b, err := r.ReadByte(); err != nil || b != flag {
+			r.UnreadByte()
+			return nil, errors.New("missing closing flag")
+		}
+		r.UnreadByte() // may be the opening flag of the next frame
+		return parseFrame(buf)
+	}
+}
+
+// Parses the frame between the flags.
+func parseFrame(buf []byte) (*frame, error) {
+	n := len(buf)
+	if crcX25(buf[:n-2]) != uint16(buf[n-2])|uint16(buf[n-1])<<8 {
+		return nil, errors.New("frame checksum mismatch")
+	}
+	f := &frame{segmented: buf[0]&segmentedBit != 0}
+	i := 2
+	for _, what := range []string{"destination", "source"} {
+		start := i
+		for i < n-2 && buf[i]&1 == 0 {
+			i++
+		}
+		i++
+		if i > n-2 || i-start > maxAddressLen {
+			return nil, errors.New(fmt.Sprintf("malformed %s address", what))
+		}
+	}
+	i++ // control
+	if i >= n-2 {
+		return f, nil
+	}
+	if i+2 > n-2 {
+		return nil, errors.New("frame is too short")
+	}
+	if crcX25(buf[:i]) != uint16(buf[i])|uint16(buf[i+1])<<8 {
+		return nil, errors.New("header checksum mismatch")
+	}
+	f.info = buf[i+2 : n-2]
+	return f, nil
+}
+
+// Reads frames up to and including the last segment and returns the
+// APDU in their information.
+func readAPDU(r *bufio.Reader) ([]byte, error) {
+	var apdu []byte
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		apdu = append(apdu, f.info...)
+		if !f.segmented {
+			break
+		}
+	}
+	if len(apdu) < len(llcHeader) || string(apdu[:3]) != string(llcHeader) {
+		return nil, errors.New("missing LLC header")
+	}
+	return apdu[len(llcHeader):], nil
+}
+
+// CRC-16/X-25: the reflected CCITT polynomial with initial value and
+// final xor of 0xFFFF.
+func crcX25(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
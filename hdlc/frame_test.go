@@ -0,0 +1,165 @@
+package hdlc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	ret, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Returns what is between the flags of a frame from the meter (source
+// address 0x83) to the client (0x41) with the given information,
+// without its frame checksum.
+func frameBody(segmented bool, info []byte) []byte {
+	length := 2 + 1 + 1 + 1 + 2 + len(info) + 2
+	format := byte(0xa0) | byte(length>>8)&0x07
+	if segmented {
+		format |= segmentedBit
+	}
+	buf := []byte{format, byte(length), 0x41, 0x83, 0x13}
+	hcs := crcX25(buf)
+	buf = append(buf, byte(hcs), byte(hcs>>8))
+	return append(buf, info...)
+}
+
+// Appends the frame checksum to the body and puts it between flags.
+func closeFrame(body []byte) []byte {
+	fcs := crcX25(body)
+	buf := append([]byte{flag}, body...)
+	return append(buf, byte(fcs), byte(fcs>>8), flag)
+}
+
+func testFrame(segmented bool, info []byte) []byte {
+	return closeFrame(frameBody(segmented, info))
+}
+
+func reader(b []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(b))
+}
+
+func TestCRC(t *testing.T) {
+	// The check value of CRC-16/X-25.
+	if crc := crcX25([]byte("123456789")); crc != 0x906e {
+		t.Fatalf("crcX25: got %#x; expected 0x906e", crc)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	info := []byte("information")
+
+	// Anything before the opening flag is skipped.
+	raw := append([]byte("noise"), testFrame(false, info)...)
+	r := reader(raw)
+	f, err := readFrame(r)
+	if err != nil {
+		t.Fatalf("readFrame: %v", err)
+	}
+	if f.segmented || !bytes.Equal(f.info, info) {
+		t.Fatalf("readFrame: got %+v", f)
+	}
+	if _, err = readFrame(r); err != io.EOF {
+		t.Fatalf("readFrame at the end: got %v; expected %v", err, io.EOF)
+	}
+
+	// A frame without information, like an acknowledgement.
+	body := frameBody(false, nil)
+	body = body[:len(body)-2] // without header checksum
+	body[1] -= 2
+	if f, err = readFrame(reader(closeFrame(body))); err != nil ||
+		f.info != nil {
+		t.Fatalf("readFrame without information: got %+v, %v", f, err)
+	}
+
+	// Frames that share the flag in between.
+	first := testFrame(true, []byte("first"))
+	raw = append(first[:len(first)-1], testFrame(false, []byte("last"))...)
+	r = reader(raw)
+	for _, expected := range []string{"first", "last"} {
+		if f, err = readFrame(r); err != nil || string(f.info) != expected {
+			t.Fatalf("readFrame of shared flags: got %+v, %v", f, err)
+		}
+	}
+}
+
+func TestReadBadFrame(t *testing.T) {
+	good := testFrame(false, []byte("information"))
+
+	badFCS := append([]byte{}, good...)
+	badFCS[len(badFCS)-2] ^= 0x01
+
+	body := frameBody(false, []byte("information"))
+	body[5] ^= 0x01
+	badHCS := closeFrame(body)
+
+	badInfo := append([]byte{}, good...)
+	badInfo[10] ^= 0x01
+
+	unclosed := append([]byte{}, good...)
+	unclosed[len(unclosed)-1] = 0
+
+	body = frameBody(false, nil)[:3]
+	body[1] = 3
+	tooShort := closeFrame(body)
+
+	body = frameBody(false, nil)[:5]
+	body[1] = 7
+	body[3], body[4] = 0x82, 0x12 // the source address never ends
+	badAddress := closeFrame(body)
+
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		err  string
+	}{
+		{"bad FCS", badFCS, "frame checksum mismatch"},
+		{"bad information", badInfo, "frame checksum mismatch"},
+		{"bad HCS", badHCS, "header checksum mismatch"},
+		{"missing closing flag", unclosed, "missing closing flag"},
+		{"too short", tooShort, "frame of 3 bytes is too short"},
+		{"bad address", badAddress, "malformed source address"},
+		{"truncated", good[:len(good)/2], io.ErrUnexpectedEOF.Error()},
+		{"truncated format", good[:2], io.EOF.Error()},
+	} {
+		_, err := readFrame(reader(tc.raw))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got %v; expected %s", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestReadAPDU(t *testing.T) {
+	apdu := []byte("a notification split over three frames")
+	info := append(append([]byte{}, llcHeader...), apdu...)
+	var raw []byte
+	raw = append(raw, testFrame(true, info[:10])...)
+	raw = append(raw, testFrame(true, info[10:20])...)
+	raw = append(raw, testFrame(false, info[20:])...)
+	got, err := readAPDU(reader(raw))
+	if err != nil {
+		t.Fatalf("readAPDU: %v", err)
+	}
+	if !bytes.Equal(got, apdu) {
+		t.Fatalf("readAPDU: got %q; expected %q", got, apdu)
+	}
+
+	_, err = readAPDU(reader(testFrame(false, apdu)))
+	if err == nil || err.Error() != "missing LLC header" {
+		t.Fatalf("readAPDU without LLC header: got %v", err)
+	}
+
+	// The last segment is missing.
+	_, err = readAPDU(reader(testFrame(true, info)))
+	if err != io.EOF {
+		t.Fatalf("readAPDU without last segment: got %v; expected %v",
+			err, io.EOF)
+	}
+}
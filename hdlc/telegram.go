@@ -0,0 +1,322 @@
+package hdlc
+
+// Maps the objects of a notification to a telegram.
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DLMS units.
+const (
+	unitW    = 27
+	unitVar  = 29
+	unitWh   = 30
+	unitVarh = 32
+	unitA    = 33
+	unitV    = 35
+)
+
+// A value of a notification with its OBIS code and, if known, its
+// scaler and unit.
+type register struct {
+	code   string // eg. 1-0:1.7.0
+	value  value
+	scaler int
+	unit   int // 0 if not known
+}
+
+// Codes of the positions of the lists of Kaifa meters in Norway: 9 and
+// 13 values for single and three phase meters, and 14 and 18 values
+// once an hour, with the clock and energy registers appended.
+var (
+	kaifaSinglePhase = []string{"1-0:0.2.129", "0-0:96.1.0", "0-0:96.1.7",
+		"1-0:1.7.0", "1-0:2.7.0", "1-0:3.7.0", "1-0:4.7.0", "1-0:31.7.0",
+		"1-0:32.7.0"}
+	kaifaThreePhase = []string{"1-0:0.2.129", "0-0:96.1.0", "0-0:96.1.7",
+		"1-0:1.7.0", "1-0:2.7.0", "1-0:3.7.0", "1-0:4.7.0", "1-0:31.7.0",
+		"1-0:51.7.0", "1-0:71.7.0", "1-0:32.7.0", "1-0:52.7.0",
+		"1-0:72.7.0"}
+	kaifaHourly = []string{"0-0:1.0.0", "1-0:1.8.0", "1-0:2.8.0",
+		"1-0:3.8.0", "1-0:4.8.0"}
+)
+
+// Flattens the arrays and structures of v, except for those holding a
+// scaler and unit.
+func flatten(v value) []value {
+	if (v.typ != typeArray && v.typ != typeStructure) || v.isScalerUnit() {
+		return []value{v}
+	}
+	var ret []value
+	for _, m := range v.members {
+		ret = append(ret, flatten(m)...)
+	}
+	return ret
+}
+
+// Formats an OBIS code given as 6 bytes, leaving out the last group.
+// The channel of electricity codes is normalized to 0, as in P1
+// telegrams.
+func formatCode(b []byte) string {
+	channel := b[1]
+	if b[0] == 1 {
+		channel = 0
+	}
+	return fmt.Sprintf("%d-%d:%d.%d.%d", b[0], channel, b[2], b[3], b[4])
+}
+
+// Returns the registers of a notification body.
+func registers(body value) []register {
+	values := flatten(body)
+	var ret []register
+	for i := 0; i+1 < len(values); i++ {
+		v := values[i]
+		if v.typ != typeOctetString || len(v.bytes) != 6 ||
+			values[i+1].isScalerUnit() {
+			continue
+		}
+		r := register{code: formatCode(v.bytes), value: values[i+1]}
+		i++
+		if i+1 < len(values) && values[i+1].isScalerUnit() {
+			su := values[i+1].members
+			r.scaler, r.unit = int(su[0].number), int(su[1].number)
+			i++
+		}
+		ret = append(ret, r)
+	}
+	if len(ret) > 0 {
+		return ret
+	}
+
+	// A list without codes, as sent by Kaifa.
+	var codes []string
+	switch len(values) {
+	case 1:
+		codes = []string{"1-0:1.7.0"}
+	case 9, 14:
+		codes = append(kaifaSinglePhase, kaifaHourly...)
+	case 13, 18:
+		codes = append(kaifaThreePhase, kaifaHourly...)
+	}
+	for i, v := range values {
+		if i >= len(codes) {
+			break
+		}
+		r := register{code: codes[i], value: v}
+		// Kaifa reports currents in mA and voltages in 0.1 V.
+		switch r.kind() {
+		case unitA:
+			r.scaler = -3
+		case unitV:
+			r.scaler = -1
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// Returns the unit of the register: as given, or else the usual one
+// for its code.
+func (r register) kind() int {
+	if r.unit != 0 {
+		return r.unit
+	}
+	var c, d int
+	if _, err := fmt.Sscanf(r.code[strings.Index(r.code, ":")+1:],
+		"%d.%d.", &c, &d); err != nil || !strings.HasPrefix(r.code, "1-") {
+		return 0
+	}
+	switch {
+	case c == 31 || c == 51 || c == 71:
+		return unitA
+	case c == 32 || c == 52 || c == 72:
+		return unitV
+	case d == 7 && (c == 3 || c == 4):
+		return unitVar
+	case d == 8 && (c == 3 || c == 4):
+		return unitVarh
+	case d == 7:
+		return unitW
+	case d == 8:
+		return unitWh
+	}
+	return 0
+}
+
+// Returns the value of a numeric register in kWh, W, A, V, kvarh or
+// var, with the name of that unit.
+func (r register) normalized() (float64, string) {
+	v := r.value.number * math.Pow10(r.scaler)
+	switch r.kind() {
+	case unitWh:
+		return v / 1000, "kWh"
+	case unitVarh:
+		return v / 1000, "kvarh"
+	case unitW:
+		return v, "W"
+	case unitVar:
+		return v, "var"
+	case unitA:
+		return v, "A"
+	case unitV:
+		return v, "V"
+	}
+	return v, ""
+}
+
+// The zone of the timestamps of P1 telegrams, in winter.
+var cet = time.FixedZone("CET", 60*60)
+
+// Formats a time as the timestamp of a P1 telegram, eg. 101209113020W.
+func timestamp(t time.Time) string {
+	_, offset := t.Zone()
+	switch offset {
+	case 2 * 60 * 60:
+		return t.Format("060102150405") + "S"
+	case 60 * 60:
+	default:
+		t = t.In(cet)
+	}
+	return t.Format("060102150405") + "W"
+}
+
+// Decodes the telegram in a data-notification APDU.
+//
+// Meters with a single register for import and one for export report
+// those as tariff 1.  Lists without energy registers, like the power
+// that Kaifa meters send every few seconds, do not have Electricity
+// set: their values are in Other as they would appear in a P1
+// telegram, eg. 1-0:1.7.0 as 1234*W.
+func Decode(apdu []byte) (*dsmrp1.Telegram, error) {
+	at, body, err := parseNotification(apdu)
+	if err != nil {
+		return nil, err
+	}
+	regs := registers(body)
+
+	t := &dsmrp1.Telegram{Other: make(map[string][]string)}
+	if at != nil {
+		t.TimeStamp = timestamp(*at)
+	}
+	numbers := make(map[string]float64)
+	for _, r := range regs {
+		v := r.value
+		switch {
+		case r.code == "1-0:0.2.129" && v.typ == typeString:
+			t.HeaderId = string(v.bytes)
+		case r.code == "0-0:96.1.0" || r.code == "1-0:0.0.5":
+			t.ID = string(v.bytes)
+		case (r.code == "0-0:1.0.0" || r.code == "0-1:1.0.0") &&
+			v.typ == typeOctetString:
+			if clock, ok := parseDateTime(v.bytes); ok {
+				t.TimeStamp = timestamp(clock)
+			}
+		case v.typ == typeString:
+			t.Other[r.code] = []string{string(v.bytes)}
+		case v.typ == typeOctetString:
+			t.Other[r.code] = []string{hex.EncodeToString(v.bytes)}
+		case v.isNumber():
+			// Kamstrup reports currents in 0.01 A and energy in 0.01 kWh.
+			if strings.HasPrefix(t.HeaderId, "Kamstrup") && r.unit == 0 {
+				switch r.kind() {
+				case unitA:
+					r.scaler = -2
+				case unitWh, unitVarh:
+					r.scaler = 1
+				}
+			}
+			n, unit := r.normalized()
+			numbers[r.code] = n
+			s := strconv.FormatFloat(n, 'f', -1, 64)
+			if unit != "" {
+				s += "*" + unit
+			}
+			t.Other[r.code] = []string{s}
+		}
+	}
+
+	_, tariff1 := numbers["1-0:1.8.1"]
+	_, total := numbers["1-0:1.8.0"]
+	if !tariff1 && !total {
+		return t, nil
+	}
+	used := make(map[string]bool)
+	get := func(codes ...string) float32 {
+		for _, code := range codes {
+			if n, ok := numbers[code]; ok {
+				used[code] = true
+				return float32(n)
+			}
+		}
+		return 0
+	}
+	getPtr := func(code string) *float32 {
+		if _, ok := numbers[code]; !ok {
+			return nil
+		}
+		v := get(code)
+		return &v
+	}
+	e := &dsmrp1.ElectricityData{
+		KWh:         get("1-0:1.8.2"),
+		KWhLow:      get("1-0:1.8.1", "1-0:1.8.0"),
+		KWhOut:      get("1-0:2.8.2"),
+		KWhOutLow:   get("1-0:2.8.1", "1-0:2.8.0"),
+		KWhTotal:    getPtr("1-0:1.8.0"),
+		KWhOutTotal: getPtr("1-0:2.8.0"),
+		Tariff:      1, // the tariff of KWhLow
+		W:           get("1-0:1.7.0"),
+		WOut:        get("1-0:2.7.0"),
+		L1Current:   get("1-0:31.7.0"),
+		L1Voltage:   getPtr("1-0:32.7.0"),
+		L1Power:     get("1-0:21.7.0"),
+		L1PowerOut:  get("1-0:22.7.0"),
+	}
+	t.Electricity = e
+	for _, code := range []string{"1-0:51.7.0", "1-0:52.7.0", "1-0:41.7.0"} {
+		if _, ok := numbers[code]; ok {
+			t.MultiphaseElectricity = &dsmrp1.MultiphaseElectricityData{
+				L2Current:  get("1-0:51.7.0"),
+				L2Voltage:  getPtr("1-0:52.7.0"),
+				L2Power:    get("1-0:41.7.0"),
+				L2PowerOut: get("1-0:42.7.0"),
+				L3Current:  get("1-0:71.7.0"),
+				L3Voltage:  getPtr("1-0:72.7.0"),
+				L3Power:    get("1-0:61.7.0"),
+				L3PowerOut: get("1-0:62.7.0"),
+			}
+			break
+		}
+	}
+	for code := range used {
+		delete(t.Other, code)
+	}
+	return t, nil
+}
+
+// Reads telegrams from a HAN port or a capture of one.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Returns the next telegram.  After an error about a malformed frame or
+// notification, reading can continue with the next one.  Errors from
+// the underlying reader, like io.EOF, are returned as is.
+func (r *Reader) Next() (*dsmrp1.Telegram, error) {
+	apdu, err := readAPDU(r.r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(apdu)
+}
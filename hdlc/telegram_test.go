@@ -0,0 +1,103 @@
+package hdlc
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A data-notification sent on 16 October 2026 at noon CET, with the
+// equipment identifier, the imported energy and the power, each with
+// its OBIS code, and the latter two with their scaler and unit.
+var testAPDU = unhex("0f00000001" +
+	"090c07ea0a10050c0000ffffc400" +
+	"0103" +
+	"0202" + "0906" + "0000600100ff" + "0904" + "74657374" +
+	"0203" + "0906" + "0100010800ff" + "0600bc614e" + "02020f00161e" +
+	"0203" + "0906" + "0100010700ff" + "06000005dc" + "02020f00161b")
+
+func TestDecode(t *testing.T) {
+	tg, err := Decode(testAPDU)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if tg.ID != "test" || tg.TimeStamp != "261016120000W" {
+		t.Fatalf("Decode: got ID %q, timestamp %q", tg.ID, tg.TimeStamp)
+	}
+	e := tg.Electricity
+	if e == nil {
+		t.Fatalf("Decode: no electricity in %+v", tg)
+	}
+	if e.KWhLow != 12345.678 || e.KWh != 0 || e.Tariff != 1 ||
+		e.KWhTotal == nil || *e.KWhTotal != 12345.678 || e.W != 1500 {
+		t.Fatalf("Decode: got electricity %+v", e)
+	}
+	if tg.MultiphaseElectricity != nil {
+		t.Fatalf("Decode: got multiphase electricity")
+	}
+	if len(tg.Other) != 0 {
+		t.Fatalf("Decode: got other lines %v", tg.Other)
+	}
+}
+
+func TestDecodeWithoutCodes(t *testing.T) {
+	// The power that Kaifa meters send every few seconds, without time.
+	tg, err := Decode(unhex("0f00000001" + "00" + "0201" + "06000004d2"))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if tg.Electricity != nil || tg.TimeStamp != "" {
+		t.Fatalf("Decode: got %+v", tg)
+	}
+	if v := tg.Other["1-0:1.7.0"]; len(v) != 1 || v[0] != "1234*W" {
+		t.Fatalf("Decode: got 1-0:1.7.0 %v", v)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		apdu string
+		err  string
+	}{
+		{"wrong tag", "0e0000000100020100", "not a data-notification"},
+		{"too short", "0f000000", "not a data-notification"},
+		{"bad date-time", "0f00000001090b07ea", "malformed date-time"},
+		{"date-time type", "0f0000000111", "unexpected date-time type 0x11"},
+		{"unknown type", "0f000000010002011300", "unsupported data type 0x13"},
+		{"truncated number", "0f0000000100020106000004", "data is truncated"},
+		{"truncated string", "0f0000000100020109067465", "data is truncated"},
+		{"short structure", "0f000000010002020600000001", "data is truncated"},
+	} {
+		_, err := Decode(unhex(tc.apdu))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got %v; expected %s", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	info := append(append([]byte{}, llcHeader...), testAPDU...)
+	bad := testFrame(false, info)
+	bad[len(bad)-3] ^= 0x01
+	var raw []byte
+	raw = append(raw, bad...)
+	raw = append(raw, testFrame(true, info[:20])...)
+	raw = append(raw, testFrame(false, info[20:])...)
+
+	// Reading continues after a frame with a bad checksum.
+	r := NewReader(bytes.NewReader(raw))
+	if _, err := r.Next(); err == nil ||
+		err.Error() != "frame checksum mismatch" {
+		t.Fatalf("Next: got %v; expected a checksum mismatch", err)
+	}
+	tg, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if tg.Electricity == nil || tg.Electricity.W != 1500 {
+		t.Fatalf("Next: got %+v", tg)
+	}
+	if _, err = r.Next(); err == nil {
+		t.Fatalf("Next at the end: no error")
+	}
+}
@@ -0,0 +1,157 @@
+package history
+
+// Downsampling of rows into periods, like minutes, days and months.
+
+import (
+	"sort"
+	"time"
+)
+
+// Returns the start of the period containing a time.
+type Period func(time.Time) time.Time
+
+// Periods in local time.
+var (
+	Minute Period = func(t time.Time) time.Time { return t.Truncate(time.Minute) }
+	Hour   Period = func(t time.Time) time.Time { return t.Truncate(time.Hour) }
+	Day    Period = StartOfDay
+	Month  Period = StartOfMonth
+)
+
+// Returns the start of the day of t in local time.
+func StartOfDay(t time.Time) time.Time {
+	y, m, d := t.In(time.Local).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// Parses the start or end of a range of rows: a time in RFC 3339 or a
+// date in local time.  Returns def if s is empty.
+func ParseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// Returns the start of the month of t in local time.
+func StartOfMonth(t time.Time) time.Time {
+	y, m, _ := t.In(time.Local).Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.Local)
+}
+
+// Accumulates rows into an aggregate over the period starting at At.
+type Aggregate struct {
+	At time.Time
+
+	row    Row
+	n      int
+	power  float64
+	powerN int
+	out    float64
+}
+
+func (a *Aggregate) Add(row Row) {
+	if a.n == 0 {
+		a.row = row
+	} else {
+		// Keep the last known value of registers.
+		for i, v := range row.Values() {
+			if v != nil {
+				*a.row.valuePtrs()[i] = v
+			}
+		}
+	}
+	a.n++
+	if row.Power != nil && row.PowerOut != nil {
+		a.power += *row.Power
+		a.out += *row.PowerOut
+		a.powerN++
+	}
+}
+
+// Returns the number of rows added.
+func (a *Aggregate) Len() int {
+	return a.n
+}
+
+// Returns the aggregated row or nil if no rows were added.
+func (a *Aggregate) Result() *Row {
+	if a.n == 0 {
+		return nil
+	}
+	row := a.row
+	row.At = a.At
+	if a.powerN > 0 {
+		power := a.power / float64(a.powerN)
+		out := a.out / float64(a.powerN)
+		row.Power, row.PowerOut = &power, &out
+	}
+	return &row
+}
+
+// Aggregates rows into periods.  Sorts rows by time.
+func Downsample(rows []Row, period Period) []Row {
+	sort.Slice(rows, func(i, j int) bool { return rows[i].At.Before(rows[j].At) })
+	var ret []Row
+	var a Aggregate
+	for _, row := range rows {
+		start := period(row.At)
+		if a.n > 0 && !start.Equal(a.At) {
+			ret = append(ret, *a.Result())
+			a = Aggregate{}
+		}
+		if a.n == 0 {
+			a.At = start
+		}
+		a.Add(row)
+	}
+	if a.n > 0 {
+		ret = append(ret, *a.Result())
+	}
+	return ret
+}
+
+// Aggregates a stream of rows into periods, for instance per day or per
+// month, and keeps the last of those in a ring.
+type Aggregator struct {
+	period  Period
+	current Aggregate
+	done    *Ring
+}
+
+// Creates an aggregator that keeps the given number of complete periods.
+func NewAggregator(period Period, keep int) *Aggregator {
+	return &Aggregator{period: period, done: NewRing(keep)}
+}
+
+// Adds a row, which should not be older than those added before.
+// Returns the aggregate of the previous period if this row starts a new
+// one.
+func (a *Aggregator) Add(row Row) *Row {
+	var ret *Row
+	start := a.period(row.At)
+	if a.current.n > 0 && !start.Equal(a.current.At) {
+		ret = a.current.Result()
+		a.done.Add(*ret)
+		a.current = Aggregate{}
+	}
+	if a.current.n == 0 {
+		a.current.At = start
+	}
+	a.current.Add(row)
+	return ret
+}
+
+// Returns the aggregate of the current, incomplete, period or nil if
+// no rows were added yet.
+func (a *Aggregator) Current() *Row {
+	return a.current.Result()
+}
+
+// Returns the aggregates of the kept complete periods, oldest first.
+func (a *Aggregator) Rows() []Row {
+	return a.done.Rows()
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	at := func(secs int) time.Time {
+		return start.Add(time.Duration(secs) * time.Second)
+	}
+	rows := []Row{
+		{At: at(70), ImportLow: f(11), Power: f(300), PowerOut: f(0)},
+		{At: at(0), ImportLow: f(10), Power: f(100), PowerOut: f(0)},
+		{At: at(30), ImportLow: f(10.5), Power: f(300), PowerOut: f(20)},
+		{At: at(50), Gas: f(5)},
+	}
+	ret := Downsample(rows, Minute)
+	if len(ret) != 2 {
+		t.Fatalf("Downsample: got %d rows; expected 2", len(ret))
+	}
+	first := ret[0]
+	if !first.At.Equal(start) {
+		t.Fatalf("Downsample: got start %v; expected %v", first.At, start)
+	}
+	if *first.ImportLow != 10.5 || *first.Gas != 5 {
+		t.Fatalf("Downsample: registers %v", first.Record())
+	}
+	// The row without power does not count towards the average.
+	if *first.Power != 200 || *first.PowerOut != 10 {
+		t.Fatalf("Downsample: power %v, %v", *first.Power, *first.PowerOut)
+	}
+	if !ret[1].At.Equal(at(60)) || *ret[1].ImportLow != 11 {
+		t.Fatalf("Downsample: second row %v", ret[1].Record())
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	a := NewAggregator(Minute, 2)
+	if a.Current() != nil {
+		t.Fatalf("Current: expected nil before any row")
+	}
+	for i := 0; i < 4; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		prev := a.Add(Row{At: at, Power: f(float64(i)), PowerOut: f(0)})
+		if i == 0 && prev != nil {
+			t.Fatalf("Add: first row finished a period")
+		}
+		if i > 0 && (prev == nil || *prev.Power != float64(i-1)) {
+			t.Fatalf("Add: did not finish the period of minute %d", i-1)
+		}
+	}
+	if cur := a.Current(); cur == nil || *cur.Power != 3 {
+		t.Fatalf("Current: got %v", cur)
+	}
+	rows := a.Rows()
+	if len(rows) != 2 || *rows[0].Power != 1 || *rows[1].Power != 2 {
+		t.Fatalf("Rows: got %d rows", len(rows))
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	def := time.Unix(42, 0)
+	if got, err := ParseTime("", def); err != nil || !got.Equal(def) {
+		t.Fatalf("ParseTime(\"\"): got %v, %v", got, err)
+	}
+	got, err := ParseTime("2026-10-16T12:00:00Z", def)
+	if err != nil || !got.Equal(time.Date(2026, 10, 16, 12, 0, 0, 0,
+		time.UTC)) {
+		t.Fatalf("ParseTime: got %v, %v", got, err)
+	}
+	got, err = ParseTime("2026-10-16", def)
+	if err != nil || !got.Equal(StartOfDay(got)) || got.Day() != 16 {
+		t.Fatalf("ParseTime: got %v, %v", got, err)
+	}
+	if _, err = ParseTime("16-10-2026", def); err == nil {
+		t.Fatalf("ParseTime: expected an error")
+	}
+}
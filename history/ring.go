@@ -0,0 +1,67 @@
+package history
+
+// A ring buffer of recent rows.
+
+import (
+	"sync"
+	"time"
+)
+
+// Keeps the last rows added, up to its capacity.  Safe for concurrent
+// use.
+type Ring struct {
+	lock sync.Mutex
+	rows []Row
+	next int // where to add the next row
+	full bool
+}
+
+// Creates a ring that keeps the last n rows.
+func NewRing(n int) *Ring {
+	if n < 1 {
+		n = 1
+	}
+	return &Ring{rows: make([]Row, n)}
+}
+
+// Adds a row, replacing the oldest if the ring is full.
+func (r *Ring) Add(row Row) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rows[r.next] = row
+	r.next++
+	if r.next == len(r.rows) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Returns the number of rows kept.
+func (r *Ring) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.full {
+		return len(r.rows)
+	}
+	return r.next
+}
+
+// Returns the rows kept, oldest first.
+func (r *Ring) Rows() []Row {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]Row{}, r.rows[:r.next]...)
+	}
+	return append(append([]Row{}, r.rows[r.next:]...), r.rows[:r.next]...)
+}
+
+// Returns the rows kept at or after the given time, oldest first.
+func (r *Ring) Since(from time.Time) []Row {
+	rows := r.Rows()
+	i := 0
+	for i < len(rows) && rows[i].At.Before(from) {
+		i++
+	}
+	return rows[i:]
+}
@@ -0,0 +1,33 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	r := NewRing(3)
+	if r.Len() != 0 || len(r.Rows()) != 0 {
+		t.Fatalf("new ring is not empty")
+	}
+	for i := 0; i < 5; i++ {
+		r.Add(Row{At: start.Add(time.Duration(i) * time.Second)})
+	}
+	if r.Len() != 3 {
+		t.Fatalf("Len: got %d; expected 3", r.Len())
+	}
+	rows := r.Rows()
+	for i, row := range rows {
+		expected := start.Add(time.Duration(i+2) * time.Second)
+		if !row.At.Equal(expected) {
+			t.Fatalf("Rows[%d]: got %v; expected %v", i, row.At, expected)
+		}
+	}
+	if since := r.Since(start.Add(3 * time.Second)); len(since) != 2 {
+		t.Fatalf("Since: got %d rows; expected 2", len(since))
+	}
+	if since := r.Since(start.Add(time.Minute)); len(since) != 0 {
+		t.Fatalf("Since: got %d rows; expected none", len(since))
+	}
+}
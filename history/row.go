@@ -0,0 +1,102 @@
+// Package history keeps and downsamples the readings of a meter, with
+// the same semantics as the history of dsmrp1d: registers are the last
+// value in a period and power is the average.
+package history
+
+import (
+	"errors"
+	"github.com/bwesterb/go-dsmrp1"
+	"strconv"
+	"time"
+)
+
+// The readings at a point in time, or over a period starting then.
+// Readings that are missing are nil.
+type Row struct {
+	At         time.Time
+	ImportHigh *float64 // kWh
+	ImportLow  *float64
+	ExportHigh *float64
+	ExportLow  *float64
+	Power      *float64 // W
+	PowerOut   *float64
+	Gas        *float64 // m3
+}
+
+// Names of the time and the values of a row, as used in the exports of
+// dsmrp1d.
+var Columns = []string{"time", "import_high_kwh", "import_low_kwh",
+	"export_high_kwh", "export_low_kwh", "power_w", "power_out_w", "gas_m3"}
+
+// Returns the readings of the telegram received at the given time.
+func NewRow(t *dsmrp1.Telegram, at time.Time) Row {
+	return RowOf(dsmrp1.NewReading(t), at)
+}
+
+// Returns the row of a reading.  Tariffs past the second are not kept.
+func RowOf(r dsmrp1.Reading, at time.Time) Row {
+	row := Row{At: at, Power: r.W, PowerOut: r.WOut, Gas: r.GasM3}
+	tariff := func(vs []float64, i int) *float64 {
+		if i >= len(vs) {
+			return nil
+		}
+		v := vs[i]
+		return &v
+	}
+	row.ImportLow = tariff(r.ImportKWh, 0)
+	row.ImportHigh = tariff(r.ImportKWh, 1)
+	row.ExportLow = tariff(r.ExportKWh, 0)
+	row.ExportHigh = tariff(r.ExportKWh, 1)
+	return row
+}
+
+// Returns the values in the order of Columns[1:].
+func (row *Row) Values() []*float64 {
+	return []*float64{row.ImportHigh, row.ImportLow, row.ExportHigh,
+		row.ExportLow, row.Power, row.PowerOut, row.Gas}
+}
+
+func (row *Row) valuePtrs() []**float64 {
+	return []**float64{&row.ImportHigh, &row.ImportLow, &row.ExportHigh,
+		&row.ExportLow, &row.Power, &row.PowerOut, &row.Gas}
+}
+
+// Layout of the time in records.
+const recordTime = "2006-01-02T15:04:05.000Z07:00"
+
+// Formats the row as a CSV record with the columns of Columns.
+func (row *Row) Record() []string {
+	record := []string{row.At.Format(recordTime)}
+	for _, v := range row.Values() {
+		if v == nil {
+			record = append(record, "")
+		} else {
+			record = append(record, strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	return record
+}
+
+// Parses a record written by Record.
+func ParseRecord(record []string) (Row, error) {
+	var row Row
+	if len(record) != len(Columns) {
+		return row, errors.New("wrong number of columns")
+	}
+	at, err := time.Parse(recordTime, record[0])
+	if err != nil {
+		return row, err
+	}
+	row.At = at
+	for i, p := range row.valuePtrs() {
+		if record[i+1] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(record[i+1], 64)
+		if err != nil {
+			return row, err
+		}
+		*p = &v
+	}
+	return row, nil
+}
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func f(v float64) *float64 {
+	return &v
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	row := Row{
+		At:         time.Date(2026, 10, 16, 12, 0, 0, 123e6, time.UTC),
+		ImportHigh: f(2345.678),
+		ImportLow:  f(1234.5),
+		Power:      f(1193),
+		PowerOut:   f(0),
+	}
+	record := row.Record()
+	if len(record) != len(Columns) {
+		t.Fatalf("Record: got %d columns; expected %d", len(record),
+			len(Columns))
+	}
+	if record[3] != "" {
+		t.Fatalf("Record: missing value written as %q", record[3])
+	}
+	parsed, err := ParseRecord(record)
+	if err != nil {
+		t.Fatalf("ParseRecord: %v", err)
+	}
+	if !parsed.At.Equal(row.At) {
+		t.Fatalf("ParseRecord: got time %v; expected %v", parsed.At, row.At)
+	}
+	parsed.At = row.At
+	if !reflect.DeepEqual(parsed, row) {
+		t.Fatalf("ParseRecord: got %v; expected %v", parsed.Record(),
+			record)
+	}
+}
+
+func TestParseRecordErrors(t *testing.T) {
+	for _, record := range [][]string{
+		{"2026-10-16T12:00:00.000Z"},
+		{"yesterday", "", "", "", "", "", "", ""},
+		{"2026-10-16T12:00:00.000Z", "1,5", "", "", "", "", "", ""},
+	} {
+		if _, err := ParseRecord(record); err == nil {
+			t.Errorf("ParseRecord(%q): expected an error", record)
+		}
+	}
+}
+
+func TestRowOf(t *testing.T) {
+	w := 500.0
+	row := RowOf(dsmrp1.Reading{
+		ImportKWh: []float64{1, 2, 3},
+		ExportKWh: []float64{4},
+		W:         &w,
+	}, time.Time{})
+	if *row.ImportLow != 1 || *row.ImportHigh != 2 {
+		t.Fatalf("RowOf: import %v, %v", *row.ImportLow, *row.ImportHigh)
+	}
+	if *row.ExportLow != 4 || row.ExportHigh != nil {
+		t.Fatalf("RowOf: export %v, %v", *row.ExportLow, row.ExportHigh)
+	}
+	if *row.Power != 500 || row.PowerOut != nil || row.Gas != nil {
+		t.Fatalf("RowOf: got %v", row.Record())
+	}
+}
@@ -0,0 +1,198 @@
+package dsmrp1
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A meter connected through pipes: what the test writes to the
+// returned writers is read by the meter.  Every reconnect opens the
+// next pipe; once they run out, opening fails.
+func pipeMeter(t *testing.T, n int) (*Meter, []*io.PipeWriter) {
+	var lock sync.Mutex
+	var readers []*io.PipeReader
+	var writers []*io.PipeWriter
+	for i := 0; i < n; i++ {
+		r, w := io.Pipe()
+		readers = append(readers, r)
+		writers = append(writers, w)
+	}
+	open := func() (io.ReadCloser, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if len(readers) == 0 {
+			return nil, errors.New("no more pipes")
+		}
+		r := readers[0]
+		readers = readers[1:]
+		return r, nil
+	}
+	m, err := newMeter(open, ParseOptions{}, ReaderOptions{})
+	if err != nil {
+		t.Fatalf("newMeter: %v", err)
+	}
+	t.Cleanup(func() {
+		m.Close()
+		for _, w := range writers {
+			w.Close()
+		}
+	})
+	return m, writers
+}
+
+func testTelegramBytes(at time.Time) []byte {
+	return testTelegramBuilder(at).Bytes()
+}
+
+// Returns a builder of a complete telegram of a single phase meter.
+func testTelegramBuilder(at time.Time) *TelegramBuilder {
+	return NewTelegramBuilder(DSMR5).
+		Timestamp(at).
+		EquipmentID("test").
+		Import(1, 1).
+		Import(2, 2).
+		Export(1, 0).
+		Export(2, 0).
+		Tariff(1).
+		Power(230, 0).
+		PowerFailures(0, 0).
+		Phase(1, 230, 1, 230, 0).
+		VoltageEvents(1, 0, 0)
+}
+
+// Waits for C to be closed, returning the telegrams still delivered.
+func drain(t *testing.T, m *Meter) []*Telegram {
+	var ret []*Telegram
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case tg, ok := <-m.C:
+			if !ok {
+				return ret
+			}
+			ret = append(ret, tg)
+		case <-timeout:
+			t.Fatalf("C was not closed after Close")
+		}
+	}
+}
+
+func TestMeterReads(t *testing.T) {
+	m, ws := pipeMeter(t, 1)
+	at := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(at)
+	m.SetClock(clock)
+	go ws[0].Write(testTelegramBytes(at))
+	select {
+	case tg := <-m.C:
+		if tg.Electricity == nil || tg.Electricity.KWhLow != 1 {
+			t.Fatalf("got telegram %+v", tg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no telegram delivered")
+	}
+	stats := m.Stats()
+	if !stats.LastTelegram.Equal(at) {
+		t.Fatalf("LastTelegram: got %v; expected %v", stats.LastTelegram, at)
+	}
+}
+
+func TestMeterCloseDuringRead(t *testing.T) {
+	m, ws := pipeMeter(t, 1)
+	raw := testTelegramBytes(time.Now())
+
+	// Half a telegram, so that the reader blocks in the middle of it.
+	if _, err := ws[0].Write(raw[:len(raw)/2]); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if tgs := drain(t, m); len(tgs) != 0 {
+		t.Fatalf("got %d telegrams after Close", len(tgs))
+	}
+	if _, err := ws[0].Write(raw[len(raw)/2:]); err != io.ErrClosedPipe {
+		t.Fatalf("Write after Close: got %v; expected %v",
+			err, io.ErrClosedPipe)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestMeterCloseWhileDelivering(t *testing.T) {
+	m, ws := pipeMeter(t, 1)
+	raw := testTelegramBytes(time.Now())
+
+	// Nobody reads C, so after the first telegram fills its buffer the
+	// reader blocks on delivering the second.
+	go func() {
+		for i := 0; i < 3; i++ {
+			if _, err := ws[0].Write(raw); err != nil {
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.C) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("no telegram delivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if tgs := drain(t, m); len(tgs) > 2 {
+		t.Fatalf("got %d telegrams; expected at most 2", len(tgs))
+	}
+}
+
+func TestMeterCloseDuringReconnect(t *testing.T) {
+	m, ws := pipeMeter(t, 2)
+
+	// Breaking the connection makes the reader wait a second before it
+	// reconnects.
+	ws[0].CloseWithError(errors.New("unplugged"))
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	drain(t, m)
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("closing took %v", d)
+	}
+	if stats := m.Stats(); stats.Reconnects != 0 {
+		t.Fatalf("reconnected %d times after Close", stats.Reconnects)
+	}
+}
+
+func TestMeterConcurrentClose(t *testing.T) {
+	m, ws := pipeMeter(t, 1)
+	raw := testTelegramBytes(time.Now())
+	go func() {
+		for {
+			if _, err := ws[0].Write(raw); err != nil {
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Stats()
+				m.SetClock(SystemClock)
+			}
+			m.Close()
+		}()
+	}
+	drain(t, m)
+	wg.Wait()
+}
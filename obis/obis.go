@@ -0,0 +1,139 @@
+// Package obis defines the OBIS codes found in P1 telegrams: those of
+// the Dutch DSMR versions 2.2 up to 5 and of the Belgian eMUCS.
+package obis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An OBIS reduced ID as it appears in telegrams, eg. 1-0:1.8.1.
+type Code string
+
+// General.
+const (
+	Version      Code = "1-3:0.2.8"  // version of the P1 output
+	EMUCSVersion Code = "0-0:96.1.4" // version of eMUCS
+	Timestamp    Code = "0-0:1.0.0"  // time of the telegram, YYMMDDhhmmssX
+	EquipmentID  Code = "0-0:96.1.1" // serial number, hex encoded
+	MessageCode  Code = "0-0:96.13.1"
+	MessageText  Code = "0-0:96.13.0"
+)
+
+// Electricity registers.  Tariff 1 is the low tariff in the Netherlands
+// and the high tariff in Belgium.
+const (
+	ImportTariff1 Code = "1-0:1.8.1"
+	ImportTariff2 Code = "1-0:1.8.2"
+	ExportTariff1 Code = "1-0:2.8.1"
+	ExportTariff2 Code = "1-0:2.8.2"
+	ImportTotal   Code = "1-0:1.8.0"
+	ExportTotal   Code = "1-0:2.8.0"
+	Tariff        Code = "0-0:96.14.0" // the tariff in effect
+)
+
+// Returns the register of the given tariff, 1 to 9, eg.
+// ForTariff(ImportTariff1, 3) is 1-0:1.8.3.
+func ForTariff(c Code, tariff int) Code {
+	i := strings.LastIndexByte(string(c), '.')
+	if i == -1 {
+		return c
+	}
+	return c[:i+1] + Code(strconv.Itoa(tariff))
+}
+
+// Electricity.
+const (
+	Power             Code = "1-0:1.7.0" // actual power imported
+	PowerOut          Code = "1-0:2.7.0" // actual power exported
+	Threshold         Code = "0-0:17.0.0"
+	Switch            Code = "0-0:96.3.10" // position of the breaker
+	FuseThreshold     Code = "1-0:31.4.0"  // eMUCS
+	PowerFailures     Code = "0-0:96.7.21"
+	LongPowerFailures Code = "0-0:96.7.9"
+	PowerFailureLog   Code = "1-0:99.97.0"
+)
+
+// Capacity tariff of eMUCS.
+const (
+	AverageDemand    Code = "1-0:1.4.0"  // of the current quarter hour
+	MaxDemandMonth   Code = "1-0:1.6.0"  // peak of the current month
+	MaxDemandHistory Code = "0-0:98.1.0" // peaks of the last 13 months
+)
+
+// Per phase.
+const (
+	L1VoltageSags   Code = "1-0:32.32.0"
+	L2VoltageSags   Code = "1-0:52.32.0"
+	L3VoltageSags   Code = "1-0:72.32.0"
+	L1VoltageSwells Code = "1-0:32.36.0"
+	L2VoltageSwells Code = "1-0:52.36.0"
+	L3VoltageSwells Code = "1-0:72.36.0"
+	L1Voltage       Code = "1-0:32.7.0"
+	L2Voltage       Code = "1-0:52.7.0"
+	L3Voltage       Code = "1-0:72.7.0"
+	L1Current       Code = "1-0:31.7.0"
+	L2Current       Code = "1-0:51.7.0"
+	L3Current       Code = "1-0:71.7.0"
+	L1Power         Code = "1-0:21.7.0"
+	L2Power         Code = "1-0:41.7.0"
+	L3Power         Code = "1-0:61.7.0"
+	L1PowerOut      Code = "1-0:22.7.0"
+	L2PowerOut      Code = "1-0:42.7.0"
+	L3PowerOut      Code = "1-0:62.7.0"
+)
+
+// Devices on the M-Bus, on channel 1.  See MBus for the other channels.
+const (
+	MBusDeviceType       Code = "0-1:24.1.0" // 3 for gas, 7 for water
+	MBusEquipmentID      Code = "0-1:96.1.0"
+	MBusEquipmentIDEMUCS Code = "0-1:96.1.1"
+	MBusValve            Code = "0-1:24.4.0"
+	MBusReading          Code = "0-1:24.2.1" // DSMR 4 and 5
+	MBusReadingEMUCS     Code = "0-1:24.2.3"
+	MBusReadingDSMR22    Code = "0-1:24.3.0" // the gas profile of DSMR 2.2
+)
+
+// Returns the code of an M-Bus device on the given channel, 1 to 4,
+// eg. MBus(MBusReading, 2) is 0-2:24.2.1.
+func MBus(c Code, channel int) Code {
+	if !strings.HasPrefix(string(c), "0-1:") {
+		return c
+	}
+	return Code(fmt.Sprintf("0-%d:%s", channel, c[4:]))
+}
+
+// The codes of the versions of the standard.
+var (
+	DSMR22 = []Code{Timestamp, EquipmentID, ImportTariff1, ImportTariff2,
+		ExportTariff1, ExportTariff2, Tariff, Power, PowerOut, Threshold,
+		Switch, MessageCode, MessageText, MBusDeviceType, MBusEquipmentID,
+		MBusReadingDSMR22, MBusValve}
+
+	DSMR4 = []Code{Version, Timestamp, EquipmentID, ImportTariff1,
+		ImportTariff2, ExportTariff1, ExportTariff2, Tariff, Power, PowerOut,
+		Threshold, Switch, PowerFailures, LongPowerFailures,
+		PowerFailureLog, L1VoltageSags, L2VoltageSags, L3VoltageSags,
+		L1VoltageSwells, L2VoltageSwells, L3VoltageSwells, MessageCode,
+		MessageText, L1Current, L2Current, L3Current, L1Power, L2Power,
+		L3Power, L1PowerOut, L2PowerOut, L3PowerOut, MBusDeviceType,
+		MBusEquipmentID, MBusReading, MBusValve}
+
+	DSMR5 = []Code{Version, Timestamp, EquipmentID, ImportTariff1,
+		ImportTariff2, ExportTariff1, ExportTariff2, Tariff, Power, PowerOut,
+		PowerFailures, LongPowerFailures, PowerFailureLog, L1VoltageSags,
+		L2VoltageSags, L3VoltageSags, L1VoltageSwells, L2VoltageSwells,
+		L3VoltageSwells, MessageText, L1Voltage, L2Voltage, L3Voltage,
+		L1Current, L2Current, L3Current, L1Power, L2Power, L3Power,
+		L1PowerOut, L2PowerOut, L3PowerOut, MBusDeviceType, MBusEquipmentID,
+		MBusReading}
+
+	EMUCS = []Code{EMUCSVersion, Timestamp, EquipmentID, ImportTariff1,
+		ImportTariff2, ExportTariff1, ExportTariff2, Tariff, AverageDemand,
+		MaxDemandMonth, MaxDemandHistory, Power, PowerOut, L1Power, L2Power,
+		L3Power, L1PowerOut, L2PowerOut, L3PowerOut, L1Voltage, L2Voltage,
+		L3Voltage, L1Current, L2Current, L3Current, Switch, Threshold,
+		FuseThreshold, MessageText, MBusDeviceType, MBusEquipmentIDEMUCS,
+		MBusValve, MBusReadingEMUCS}
+)
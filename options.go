@@ -0,0 +1,94 @@
+package dsmrp1
+
+// Options of the parser.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"strconv"
+	"strings"
+)
+
+// How strictly telegrams are checked against the specification.
+type Profile int
+
+const (
+	// Lines the parser does not know end up in Telegram.Other.
+	Permissive Profile = iota
+
+	// Lines that are not part of the version of the telegram and
+	// missing lines that the version requires are errors.
+	Strict
+)
+
+// Options for parsing telegrams.  The zero value parses telegrams as
+// they are.
+type ParseOptions struct {
+	Profile Profile
+
+	// Factors by which the values of the given OBIS codes are multiplied
+	// before they are parsed, eg. the ratio of the current transformers
+	// of a meter that reports values on their secondary side, or the
+	// pulse factor of a gas meter.
+	Factors map[obis.Code]float64
+}
+
+// Multiplies the values with a unit, like "001.23*kWh", of the lines with
+// a factor.
+func (opts ParseOptions) applyFactors(data map[string][]string) []error {
+	errs := []error{}
+	for code, factor := range opts.Factors {
+		args, ok := data[string(code)]
+		if !ok {
+			continue
+		}
+		for i, arg := range args {
+			bits := strings.SplitN(arg, "*", 2)
+			if len(bits) != 2 {
+				continue
+			}
+			amount, err := parseAmount(bits[0], 64)
+			if err != nil {
+				errs = append(errs, errors.New(fmt.Sprintf(
+					"%s: could not parse amount: %s", code, err)))
+				continue
+			}
+			args[i] = strconv.FormatFloat(amount*factor, 'f', -1, 64) +
+				"*" + bits[1]
+		}
+	}
+	return errs
+}
+
+// Parses factors like "1-0:31.7.0=40,1-0:21.7.0=40".
+func ParseFactors(s string) (map[obis.Code]float64, error) {
+	ret := make(map[obis.Code]float64)
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		bits := strings.SplitN(spec, "=", 2)
+		if len(bits) != 2 {
+			return nil, errors.New(fmt.Sprintf(
+				"%s: should be code=factor", spec))
+		}
+		factor, err := strconv.ParseFloat(bits[1], 64)
+		if err != nil || factor == 0 {
+			return nil, errors.New(fmt.Sprintf(
+				"%s: invalid factor", spec))
+		}
+		ret[obis.Code(bits[0])] = factor
+	}
+	return ret, nil
+}
+
+// Checks the lines of a telegram against its version.
+func checkStrict(data map[string][]string) []error {
+	errs := []error{}
+	for _, v := range checkLines(data, versionOf(data)) {
+		errs = append(errs, v)
+	}
+	return errs
+}
@@ -0,0 +1,318 @@
+// Package parquet is a minimal writer of Parquet files with a timestamp
+// column followed by optional double columns, in uncompressed row groups
+// that are written as they fill up.
+//
+// See https://github.com/apache/parquet-format for the format.  The
+// metadata is encoded with the Thrift compact protocol.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Parquet constants.
+const (
+	typeInt64  = 2
+	typeDouble = 5
+
+	required = 0
+	optional = 1
+
+	timestampMillis = 9
+
+	plain = 0
+	rle   = 3
+)
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// Rows per row group; only the rows of one row group are kept in memory.
+const rowGroupSize = 64 * 1024
+
+// Writes a Parquet file as rows are appended.
+type Writer struct {
+	w       io.Writer
+	names   []string    // of the columns; the first is the timestamp
+	times   []int64     // milliseconds since the epoch
+	values  [][]float64 // non-null values per double column
+	defined [][]bool    // whether the value of a row is not null
+
+	offset    int64 // bytes written so far
+	rowGroups []rowGroup
+	rows      int64 // rows in the row groups written
+	err       error // first error writing to w
+}
+
+// Where the column chunks of a row group written to the file are.
+type rowGroup struct {
+	offsets []int64 // per column chunk
+	sizes   []int64 // per column chunk
+	rows    int64
+}
+
+// Returns a writer of a Parquet file to w with the given columns, of
+// which the first is the timestamp.  Close must be called to write the
+// metadata at the end of the file.
+func NewWriter(w io.Writer, names []string) *Writer {
+	return &Writer{
+		w:       w,
+		names:   names,
+		values:  make([][]float64, len(names)-1),
+		defined: make([][]bool, len(names)-1),
+	}
+}
+
+// Adds a row with a value for each of the double columns, writing the
+// row group if it is full.  Returns the first error writing the file.
+func (pw *Writer) Append(at time.Time, values []*float64) error {
+	pw.times = append(pw.times, at.UnixNano()/int64(time.Millisecond))
+	for i, v := range values {
+		pw.defined[i] = append(pw.defined[i], v != nil)
+		if v != nil {
+			pw.values[i] = append(pw.values[i], *v)
+		}
+	}
+	if len(pw.times) >= rowGroupSize {
+		pw.flush()
+	}
+	return pw.err
+}
+
+func (pw *Writer) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+// Writes the buffered rows as a row group.
+func (pw *Writer) flush() {
+	if pw.offset == 0 {
+		pw.write([]byte("PAR1"))
+	}
+	if len(pw.times) == 0 {
+		return
+	}
+
+	// Column chunks, each a single data page.
+	chunks := make([][]byte, len(pw.names))
+	data := make([]byte, 8*len(pw.times))
+	for i, t := range pw.times {
+		binary.LittleEndian.PutUint64(data[8*i:], uint64(t))
+	}
+	chunks[0] = dataPage(len(pw.times), data)
+	for c, values := range pw.values {
+		data := encodeDefinitionLevels(pw.defined[c])
+		for _, v := range values {
+			var tmp [8]byte
+			binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+			data = append(data, tmp[:]...)
+		}
+		chunks[c+1] = dataPage(len(pw.times), data)
+	}
+
+	rg := rowGroup{rows: int64(len(pw.times))}
+	for _, chunk := range chunks {
+		rg.offsets = append(rg.offsets, pw.offset)
+		rg.sizes = append(rg.sizes, int64(len(chunk)))
+		pw.write(chunk)
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.rows += rg.rows
+
+	pw.times = pw.times[:0]
+	for c := range pw.values {
+		pw.values[c] = pw.values[c][:0]
+		pw.defined[c] = pw.defined[c][:0]
+	}
+}
+
+// Encodes the definition levels of an optional column using runs of
+// the RLE/bit-packing hybrid encoding with bit width 1.
+func encodeDefinitionLevels(defined []bool) []byte {
+	var runs bytes.Buffer
+	for i := 0; i < len(defined); {
+		j := i
+		for j < len(defined) && defined[j] == defined[i] {
+			j++
+		}
+		writeUvarint(&runs, uint64(j-i)<<1)
+		if defined[i] {
+			runs.WriteByte(1)
+		} else {
+			runs.WriteByte(0)
+		}
+		i = j
+	}
+	ret := make([]byte, 4, 4+runs.Len())
+	binary.LittleEndian.PutUint32(ret, uint32(runs.Len()))
+	return append(ret, runs.Bytes()...)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+// Writes Thrift structs with the compact protocol.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	fields []int16 // id of the last field written per nested struct
+}
+
+func (tw *thriftWriter) begin() {
+	tw.fields = append(tw.fields, 0)
+}
+
+func (tw *thriftWriter) end() {
+	tw.buf.WriteByte(0)
+	tw.fields = tw.fields[:len(tw.fields)-1]
+}
+
+func (tw *thriftWriter) field(id int16, typ byte) {
+	last := &tw.fields[len(tw.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		tw.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		tw.buf.WriteByte(typ)
+		tw.varint(int64(id))
+	}
+	*last = id
+}
+
+func (tw *thriftWriter) varint(v int64) {
+	writeUvarint(&tw.buf, uint64((v<<1)^(v>>63)))
+}
+
+func (tw *thriftWriter) i32(id int16, v int32) {
+	tw.field(id, thriftI32)
+	tw.varint(int64(v))
+}
+
+func (tw *thriftWriter) i64(id int16, v int64) {
+	tw.field(id, thriftI64)
+	tw.varint(v)
+}
+
+func (tw *thriftWriter) str(id int16, s string) {
+	tw.field(id, thriftBinary)
+	writeUvarint(&tw.buf, uint64(len(s)))
+	tw.buf.WriteString(s)
+}
+
+func (tw *thriftWriter) list(id int16, elemType byte, n int) {
+	tw.field(id, thriftList)
+	if n < 15 {
+		tw.buf.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		tw.buf.WriteByte(0xf0 | elemType)
+		writeUvarint(&tw.buf, uint64(n))
+	}
+}
+
+func (tw *thriftWriter) structField(id int16) {
+	tw.field(id, thriftStruct)
+	tw.begin()
+}
+
+// Returns a data page with its header for a column.
+func dataPage(numValues int, data []byte) []byte {
+	var tw thriftWriter
+	tw.begin()
+	tw.i32(1, 0) // DATA_PAGE
+	tw.i32(2, int32(len(data)))
+	tw.i32(3, int32(len(data)))
+	tw.structField(5)
+	tw.i32(1, int32(numValues))
+	tw.i32(2, plain)
+	tw.i32(3, rle)
+	tw.i32(4, rle)
+	tw.end()
+	tw.end()
+	return append(tw.buf.Bytes(), data...)
+}
+
+// Writes the remaining rows and the metadata.
+func (pw *Writer) Close() error {
+	pw.flush()
+
+	// File metadata.
+	var tw thriftWriter
+	tw.begin()
+	tw.i32(1, 1) // version
+	tw.list(2, thriftStruct, len(pw.names)+1)
+	tw.begin()
+	tw.str(4, "schema")
+	tw.i32(5, int32(len(pw.names)))
+	tw.end()
+	for i, name := range pw.names {
+		tw.begin()
+		if i == 0 {
+			tw.i32(1, typeInt64)
+			tw.i32(3, required)
+			tw.str(4, name)
+			tw.i32(6, timestampMillis)
+		} else {
+			tw.i32(1, typeDouble)
+			tw.i32(3, optional)
+			tw.str(4, name)
+		}
+		tw.end()
+	}
+	tw.i64(3, pw.rows)
+	tw.list(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		tw.begin()
+		tw.list(1, thriftStruct, len(pw.names))
+		var total int64
+		for i, name := range pw.names {
+			typ := int32(typeDouble)
+			if i == 0 {
+				typ = typeInt64
+			}
+			tw.begin()
+			tw.i64(2, rg.offsets[i])
+			tw.structField(3)
+			tw.i32(1, typ)
+			tw.list(2, thriftI32, 2)
+			tw.varint(plain)
+			tw.varint(rle)
+			tw.list(3, thriftBinary, 1)
+			writeUvarint(&tw.buf, uint64(len(name)))
+			tw.buf.WriteString(name)
+			tw.i32(4, 0) // uncompressed
+			tw.i64(5, rg.rows)
+			tw.i64(6, rg.sizes[i])
+			tw.i64(7, rg.sizes[i])
+			tw.i64(9, rg.offsets[i])
+			tw.end()
+			tw.end()
+			total += rg.sizes[i]
+		}
+		tw.i64(2, total)
+		tw.i64(3, rg.rows)
+		tw.end()
+	}
+	tw.str(6, "go-dsmrp1")
+	tw.end()
+
+	pw.write(tw.buf.Bytes())
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], uint32(tw.buf.Len()))
+	pw.write(tmp[:])
+	pw.write([]byte("PAR1"))
+	return pw.err
+}
@@ -0,0 +1,48 @@
+package dsmrp1
+
+// Telegrams of which some lines failed to parse.
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"sort"
+)
+
+// A line of a telegram that failed to parse, or a line the telegram
+// should have had but did not.
+type LineError struct {
+	Code obis.Code // of the line
+
+	// The section of the telegram the line belongs to by the name of
+	// its field: Electricity, MultiphaseElectricity or Gas, which has
+	// the lines of all M-Bus devices.  Empty for lines of the telegram
+	// itself, like its timestamp.
+	Section string
+
+	Message string
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Returns the sections of the telegram of which lines failed to parse,
+// by the name of their field, eg. Gas if the reading of the gas meter
+// was missing.  Lines that are only rejected by the Strict profile or
+// that the parser does not know do not make a section fail.
+func (t *Telegram) FailedSections() []string {
+	sections := make(map[string]bool)
+	for _, err := range t.Errors {
+		var le LineError
+		if errors.As(err, &le) && le.Section != "" {
+			sections[le.Section] = true
+		}
+	}
+	ret := []string{}
+	for section := range sections {
+		ret = append(ret, section)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,62 @@
+package dsmrp1
+
+// Positions of the breaker of the electricity meter and the valves of
+// gas meters, which the grid operator can operate remotely.
+
+import (
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"strconv"
+)
+
+// Position of a breaker (0-0:96.3.10) or valve (0-n:24.4.0).
+type Position int32
+
+const (
+	Disconnected Position = 0
+	Connected    Position = 1
+
+	// Disconnected, but the customer may reconnect by pressing the
+	// button on the meter.
+	ReadyForReconnection Position = 2
+)
+
+func (p Position) String() string {
+	switch p {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	case ReadyForReconnection:
+		return "ready for reconnection"
+	}
+	return "position " + strconv.Itoa(int(p))
+}
+
+// Returns the positions of the breaker and valves reported in the
+// telegram by their OBIS code.  Values that are not a number are left out.
+func (t *Telegram) Positions() map[obis.Code]Position {
+	ret := make(map[obis.Code]Position)
+	add := func(code obis.Code, v *string) {
+		if v == nil {
+			return
+		}
+		p, err := strconv.Atoi(*v)
+		if err != nil {
+			return
+		}
+		ret[code] = Position(p)
+	}
+	if t.Electricity != nil {
+		add(obis.Switch, t.Electricity.Switch)
+	}
+	if t.Gas != nil {
+		add(obis.MBusValve, t.Gas.Switch)
+	}
+	for channel := 2; channel <= 4; channel++ {
+		code := obis.MBus(obis.MBusValve, channel)
+		if args, ok := t.Get(code); ok && len(args) == 1 {
+			add(code, &args[0])
+		}
+	}
+	return ret
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package dsmrp1
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// Nice value of the reader with ReaderOptions.Realtime.
+const realtimeNice = -10
+
+// Locks the calling goroutine to its thread and raises the priority of
+// that thread.
+func raisePriority() error {
+	runtime.LockOSThread()
+	return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(),
+		realtimeNice)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package dsmrp1
+
+import "runtime"
+
+// Locks the calling goroutine to its thread.  Raising the priority of a
+// thread is only supported on Linux.
+func raisePriority() error {
+	runtime.LockOSThread()
+	return nil
+}
@@ -0,0 +1,65 @@
+package dsmrp1
+
+// Options for reading from meters on busy systems.
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+)
+
+// Options for reading telegrams from a meter.  On a loaded system, eg. a
+// Raspberry Pi that does more than reading the meter, the reader may not
+// keep up, so that the buffer of the port overflows and telegrams fail
+// their checksum; see MeterStats.CRCErrors.  The zero value reads as
+// usual.
+type ReaderOptions struct {
+	// Size of the reads from the port in bytes, 4096 if zero.  Larger
+	// reads take fewer system calls per telegram.
+	ReadSize int
+
+	// Size of the receive buffer of the operating system in bytes, if
+	// non-zero.  Only applies to connections over TCP, as the kernel
+	// buffer of serial ports is fixed.
+	OSBuffer int
+
+	// Locks the reader to an operating system thread and raises its
+	// priority, which requires privileges (CAP_SYS_NICE on Linux).
+	// Has no effect on systems other than Linux.
+	Realtime bool
+
+	// Deliver telegrams of which some lines failed to parse on C as
+	// well, eg. those of which the reading of the gas meter timed out,
+	// rather than dropping them.  Their errors are in Telegram.Errors.
+	Partial bool
+}
+
+// Like OpenMeterWith, but reads the meter with the given options.
+func OpenMeterWithReader(source string, opts ParseOptions,
+	ropts ReaderOptions) (*Meter, error) {
+	return newMeter(opener(source, ropts), opts, ropts)
+}
+
+func (ropts ReaderOptions) newReader(r io.Reader) *bufio.Reader {
+	if ropts.ReadSize > 0 {
+		return bufio.NewReaderSize(r, ropts.ReadSize)
+	}
+	return bufio.NewReader(r)
+}
+
+func tcpOpener(addr string, osBuffer int) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if tc, ok := conn.(*net.TCPConn); ok && osBuffer > 0 {
+			if err = tc.SetReadBuffer(osBuffer); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
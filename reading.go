@@ -0,0 +1,199 @@
+package dsmrp1
+
+// Readings of a meter independent of the protocol and version it speaks.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"strconv"
+	"strings"
+)
+
+// The readings of a meter.  Values that the meter does not report are
+// nil or empty.
+type Reading struct {
+	ImportKWh []float64 // per tariff, starting at tariff 1
+	ExportKWh []float64
+	Tariff    Tariff // in effect; 0 if unknown
+
+	// The registers over all tariffs, if the meter has them.
+	ImportTotalKWh *float64
+	ExportTotalKWh *float64
+
+	W    *float64 // imported
+	WOut *float64 // exported
+
+	Phases []PhaseReading
+
+	GasM3   *float64
+	WaterM3 *float64
+}
+
+// The readings of a single phase.
+type PhaseReading struct {
+	Voltage *float64
+	Current *float64
+	W       *float64
+	WOut    *float64
+
+	// The current with sub-ampere resolution, which is derived from the
+	// power and voltage if CurrentDerived.  See Phase.PreciseCurrent.
+	PreciseCurrent *float64
+	CurrentDerived bool
+}
+
+// M-Bus device types.
+const (
+	mbusGas   = 3
+	mbusWater = 7
+)
+
+// Returns the readings of a telegram.
+func NewReading(t *Telegram) Reading {
+	var r Reading
+	if e := t.Electricity; e != nil {
+		for _, v := range e.ImportPerTariff() {
+			r.ImportKWh = append(r.ImportKWh, Float64(v))
+		}
+		for _, v := range e.ExportPerTariff() {
+			r.ExportKWh = append(r.ExportKWh, Float64(v))
+		}
+		r.Tariff = e.Tariff
+		if e.KWhTotal != nil {
+			r.ImportTotalKWh = f32Ptr(*e.KWhTotal)
+		}
+		if e.KWhOutTotal != nil {
+			r.ExportTotalKWh = f32Ptr(*e.KWhOutTotal)
+		}
+		r.W, r.WOut = f32Ptr(e.W), f32Ptr(e.WOut)
+		for _, p := range t.Phases() {
+			pr := PhaseReading{
+				Current: f32Ptr(p.Current),
+				W:       f32Ptr(p.Power),
+				WOut:    f32Ptr(p.PowerOut),
+			}
+			if p.Voltage != nil {
+				pr.Voltage = f32Ptr(*p.Voltage)
+			}
+			current, derived := p.PreciseCurrent()
+			pr.PreciseCurrent = f32Ptr(current)
+			pr.CurrentDerived = derived
+			r.Phases = append(r.Phases, pr)
+		}
+	} else {
+		// Lists of the power and totals only, as sent by some decoders
+		// and meters.
+		r.W = t.otherUnit(obis.Power)
+		r.WOut = t.otherUnit(obis.PowerOut)
+		r.ImportTotalKWh = t.otherUnit(obis.ImportTotal)
+		r.ExportTotalKWh = t.otherUnit(obis.ExportTotal)
+	}
+
+	if t.Gas != nil {
+		r.GasM3 = f32Ptr(t.Gas.LastRecord.Value)
+	}
+	for channel := 1; channel <= 4; channel++ {
+		args, ok := t.Get(obis.MBus(obis.MBusDeviceType, channel))
+		if !ok || len(args) != 1 {
+			continue
+		}
+		typ, err := strconv.Atoi(args[0])
+		if err != nil {
+			continue
+		}
+		var value *float64
+		for _, code := range []obis.Code{obis.MBusReading,
+			obis.MBusReadingEMUCS} {
+			args, ok := t.Get(obis.MBus(code, channel))
+			if ok && len(args) == 2 {
+				if v, err := parseUnit(args[1]); err == nil {
+					value = f32Ptr(v)
+				}
+			}
+		}
+		switch {
+		case typ == mbusGas && r.GasM3 == nil:
+			r.GasM3 = value
+		case typ == mbusWater && r.WaterM3 == nil:
+			r.WaterM3 = value
+		}
+	}
+	return r
+}
+
+// Returns the value of the line with the given code left in Other, if
+// it has a unit.
+func (t *Telegram) otherUnit(code obis.Code) *float64 {
+	args, ok := t.Get(code)
+	if !ok || len(args) != 1 {
+		return nil
+	}
+	v, err := parseUnit(args[0])
+	if err != nil {
+		return nil
+	}
+	return f32Ptr(v)
+}
+
+// Returns the energy imported over all tariffs: the total register if
+// there is one and otherwise the sum of the registers per tariff.
+func (r Reading) ImportTotal() float64 {
+	if r.ImportTotalKWh != nil {
+		return *r.ImportTotalKWh
+	}
+	return sum(r.ImportKWh)
+}
+
+// Returns the energy exported over all tariffs, like ImportTotal.
+func (r Reading) ExportTotal() float64 {
+	if r.ExportTotalKWh != nil {
+		return *r.ExportTotalKWh
+	}
+	return sum(r.ExportKWh)
+}
+
+func sum(vs []float64) float64 {
+	var ret float64
+	for _, v := range vs {
+		ret += v
+	}
+	// Round off the noise of the addition; registers have at most
+	// three decimals.
+	ret, _ = strconv.ParseFloat(strconv.FormatFloat(ret, 'f', 3, 64), 64)
+	return ret
+}
+
+func (r Reading) String() string {
+	var bits []string
+	if len(r.ImportKWh) > 0 {
+		bits = append(bits, fmt.Sprintf("import %v kWh", r.ImportKWh))
+	}
+	if len(r.ExportKWh) > 0 {
+		bits = append(bits, fmt.Sprintf("export %v kWh", r.ExportKWh))
+	}
+	if r.W != nil {
+		bits = append(bits, fmt.Sprintf("%v W", *r.W))
+	}
+	if r.WOut != nil {
+		bits = append(bits, fmt.Sprintf("%v W out", *r.WOut))
+	}
+	if r.GasM3 != nil {
+		bits = append(bits, fmt.Sprintf("gas %v m3", *r.GasM3))
+	}
+	if r.WaterM3 != nil {
+		bits = append(bits, fmt.Sprintf("water %v m3", *r.WaterM3))
+	}
+	return strings.Join(bits, ", ")
+}
+
+// Converts a float32 to the float64 with the same shortest decimal
+// representation, so that 123.4 is not rendered as 123.40000152587891.
+func Float64(v float32) float64 {
+	ret, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+	return ret
+}
+
+func f32Ptr(v float32) *float64 {
+	ret := Float64(v)
+	return &ret
+}
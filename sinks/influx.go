@@ -0,0 +1,121 @@
+package sinks
+
+// Writes telegrams to InfluxDB.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// Writes the readings of a meter in the line protocol to the write
+// endpoint of InfluxDB, eg.
+// http://localhost:8086/api/v2/write?org=home&bucket=energy, as the
+// dsmrp1 measurement with the name of the meter as the meter tag.
+type Influx struct {
+	// The client to write with; by default one with a timeout of 10s.
+	Client *http.Client
+
+	// Name of the measurement; dsmrp1 by default.
+	Measurement string
+
+	url   string
+	token string
+	meter string
+}
+
+// Creates a sink that writes to the given URL.  The token, if not
+// empty, is sent in the Authorization header.
+func NewInflux(writeURL, token string) *Influx {
+	return &Influx{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		Measurement: "dsmrp1",
+		url:         writeURL,
+		token:       token,
+	}
+}
+
+func (s *Influx) Start(meter string) error {
+	s.meter = meter
+	return nil
+}
+
+// Returns the line of the telegram, or the empty string if it has no
+// readings.
+func (s *Influx) line(t *dsmrp1.Telegram, at time.Time) string {
+	var fields []string
+	add := func(key string, v *float64) {
+		if v != nil {
+			fields = append(fields, key+"="+
+				strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+	}
+	r := dsmrp1.NewReading(t)
+	tariff := func(vs []float64, i int) *float64 {
+		if i >= len(vs) {
+			return nil
+		}
+		return &vs[i]
+	}
+	add("import_high_kwh", tariff(r.ImportKWh, 1))
+	add("import_low_kwh", tariff(r.ImportKWh, 0))
+	add("export_high_kwh", tariff(r.ExportKWh, 1))
+	add("export_low_kwh", tariff(r.ExportKWh, 0))
+	for i := 2; i < len(r.ImportKWh) || i < len(r.ExportKWh); i++ {
+		add(fmt.Sprintf("import_tariff%d_kwh", i+1), tariff(r.ImportKWh, i))
+		add(fmt.Sprintf("export_tariff%d_kwh", i+1), tariff(r.ExportKWh, i))
+	}
+	add("power_w", r.W)
+	add("power_out_w", r.WOut)
+	for i, p := range r.Phases {
+		add(fmt.Sprintf("l%d_voltage_v", i+1), p.Voltage)
+		add(fmt.Sprintf("l%d_current_a", i+1), p.Current)
+	}
+	add("gas_m3", r.GasM3)
+	add("water_m3", r.WaterM3)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s,meter=%s %s %d\n",
+		influxKeyEscaper.Replace(s.Measurement),
+		influxKeyEscaper.Replace(s.meter),
+		strings.Join(fields, ","), at.UnixNano())
+}
+
+func (s *Influx) HandleTelegram(t *dsmrp1.Telegram, at time.Time) error {
+	line := s.line(t, at)
+	if line == "" {
+		return nil
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewBufferString(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func (s *Influx) Close() error {
+	return nil
+}
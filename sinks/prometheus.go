@@ -0,0 +1,192 @@
+package sinks
+
+// Exposes the latest readings in the Prometheus text exposition format.
+
+import (
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keeps the latest telegram of a meter to be scraped by Prometheus.  Its
+// metrics are labelled with the name of the meter, unless that is empty;
+// use WritePrometheusMetrics to expose those of several meters together.
+type Prometheus struct {
+	// Tells the age of the telegram; the dsmrp1.SystemClock if nil.
+	Clock dsmrp1.Clock
+
+	lock  sync.Mutex
+	meter string
+	t     *dsmrp1.Telegram
+	at    time.Time
+}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{}
+}
+
+func (p *Prometheus) Start(meter string) error {
+	p.lock.Lock()
+	p.meter = meter
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *Prometheus) HandleTelegram(t *dsmrp1.Telegram, at time.Time) error {
+	p.lock.Lock()
+	p.t, p.at = t, at
+	p.lock.Unlock()
+	return nil
+}
+
+func (p *Prometheus) Close() error {
+	return nil
+}
+
+func (p *Prometheus) latest() (string, *dsmrp1.Telegram, time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.meter, p.t, p.at
+}
+
+// Serves the metrics of this meter.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WritePrometheusMetrics(w, p)
+}
+
+// Writes the metrics of the given meters.  Meters without a telegram
+// yet are left out.
+func WritePrometheusMetrics(w io.Writer, ps ...*Prometheus) {
+	age := &MetricFamily{Name: "dsmrp1_telegram_age_seconds", Type: "gauge",
+		Help: "Time since the latest telegram was received."}
+	energy := &MetricFamily{Name: "dsmrp1_electricity_energy_kwh",
+		Type: "counter", Help: "Electricity meter registers."}
+	power := &MetricFamily{Name: "dsmrp1_electricity_power_watts",
+		Type: "gauge", Help: "Actual electricity power."}
+	voltage := &MetricFamily{Name: "dsmrp1_phase_voltage_volts",
+		Type: "gauge", Help: "Instantaneous voltage per phase."}
+	current := &MetricFamily{Name: "dsmrp1_phase_current_amperes",
+		Type: "gauge", Help: "Instantaneous current per phase."}
+	phasePower := &MetricFamily{Name: "dsmrp1_phase_power_watts",
+		Type: "gauge", Help: "Instantaneous power per phase."}
+	sags := &MetricFamily{Name: "dsmrp1_phase_voltage_sags_total",
+		Type: "counter", Help: "Number of voltage sags per phase."}
+	swells := &MetricFamily{Name: "dsmrp1_phase_voltage_swells_total",
+		Type: "counter", Help: "Number of voltage swells per phase."}
+	failures := &MetricFamily{Name: "dsmrp1_power_failures_total",
+		Type: "counter", Help: "Number of power failures."}
+	gas := &MetricFamily{Name: "dsmrp1_gas_m3", Type: "counter",
+		Help: "Latest gas meter reading."}
+
+	for _, p := range ps {
+		meter, t, received := p.latest()
+		if t == nil {
+			continue
+		}
+		clock := p.Clock
+		if clock == nil {
+			clock = dsmrp1.SystemClock
+		}
+		age.Add(Labels("meter", meter), clock.Now().Sub(received).Seconds())
+
+		if e := t.Electricity; e != nil {
+			energy.Add(Labels("meter", meter, "direction", "import",
+				"tariff", "high"), dsmrp1.Float64(e.KWh))
+			energy.Add(Labels("meter", meter, "direction", "import",
+				"tariff", "low"), dsmrp1.Float64(e.KWhLow))
+			energy.Add(Labels("meter", meter, "direction", "export",
+				"tariff", "high"), dsmrp1.Float64(e.KWhOut))
+			energy.Add(Labels("meter", meter, "direction", "export",
+				"tariff", "low"), dsmrp1.Float64(e.KWhOutLow))
+			power.Add(Labels("meter", meter, "direction", "import"),
+				dsmrp1.Float64(e.W))
+			power.Add(Labels("meter", meter, "direction", "export"),
+				dsmrp1.Float64(e.WOut))
+			failures.Add(Labels("meter", meter, "type", "short"),
+				float64(e.PowerFailures))
+			failures.Add(Labels("meter", meter, "type", "long"),
+				float64(e.LongPowerFailures))
+		}
+
+		for i, ph := range t.Phases() {
+			phase := fmt.Sprintf("L%d", i+1)
+			if ph.Voltage != nil {
+				voltage.Add(Labels("meter", meter, "phase", phase),
+					dsmrp1.Float64(*ph.Voltage))
+			}
+			current.Add(Labels("meter", meter, "phase", phase),
+				dsmrp1.Float64(ph.Current))
+			phasePower.Add(Labels("meter", meter, "phase", phase,
+				"direction", "import"), dsmrp1.Float64(ph.Power))
+			phasePower.Add(Labels("meter", meter, "phase", phase,
+				"direction", "export"), dsmrp1.Float64(ph.PowerOut))
+			sags.Add(Labels("meter", meter, "phase", phase),
+				float64(ph.VoltageSags))
+			swells.Add(Labels("meter", meter, "phase", phase),
+				float64(ph.VoltageSwells))
+		}
+
+		if t.Gas != nil {
+			gas.Add(Labels("meter", meter),
+				dsmrp1.Float64(t.Gas.LastRecord.Value))
+		}
+	}
+
+	for _, f := range []*MetricFamily{age, energy, power, voltage, current,
+		phasePower, sags, swells, failures, gas} {
+		f.Write(w)
+	}
+}
+
+// Formats label name/value pairs as {name="value",...}.  Labels with an
+// empty value are left out, as Prometheus does not tell them apart from
+// missing ones, and so are the braces if no labels remain.
+func Labels(pairs ...string) string {
+	bits := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		bits = append(bits, fmt.Sprintf("%s=%s", pairs[i],
+			strconv.Quote(pairs[i+1])))
+	}
+	if len(bits) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(bits, ",") + "}"
+}
+
+// A metric with its samples in the Prometheus text exposition format,
+// for programs that expose metrics of their own next to those of
+// WritePrometheusMetrics.
+type MetricFamily struct {
+	Name string
+	Type string // eg. counter or gauge
+	Help string
+
+	samples []string
+}
+
+// Adds a sample with labels as formatted by Labels.
+func (f *MetricFamily) Add(labels string, value float64) {
+	f.samples = append(f.samples, fmt.Sprintf("%s%s %s", f.Name, labels,
+		strconv.FormatFloat(value, 'g', -1, 64)))
+}
+
+// Writes the family, unless it has no samples.
+func (f *MetricFamily) Write(w io.Writer) {
+	if len(f.samples) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.Name, f.Help, f.Name,
+		f.Type)
+	for _, sample := range f.samples {
+		fmt.Fprintln(w, sample)
+	}
+}
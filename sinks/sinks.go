@@ -0,0 +1,21 @@
+// Package sinks delivers telegrams to MQTT brokers, InfluxDB, Prometheus
+// and webhooks.  These are the destinations of dsmrp1d, for use by
+// other programs.
+package sinks
+
+import (
+	"github.com/bwesterb/go-dsmrp1"
+	"time"
+)
+
+// A destination for the telegrams of a meter.
+type Sink interface {
+	// Prepares the sink to receive the telegrams of the given meter.
+	Start(meter string) error
+
+	// Delivers a telegram received at the given time.  A delivery that
+	// fails may be retried by the caller.
+	HandleTelegram(t *dsmrp1.Telegram, at time.Time) error
+
+	Close() error
+}
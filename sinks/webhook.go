@@ -0,0 +1,70 @@
+package sinks
+
+// Posts telegrams to webhooks.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Posts the telegrams of a meter as JSON to a URL, with the name of the
+// meter and the time of receipt in the X-Dsmrp1-Meter and
+// X-Dsmrp1-Received headers.
+type Webhook struct {
+	// The client to post with; by default one with a timeout of 10s.
+	Client *http.Client
+
+	url   string
+	meter string
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		url:    url,
+	}
+}
+
+func (wh *Webhook) Start(meter string) error {
+	wh.meter = meter
+	return nil
+}
+
+func (wh *Webhook) HandleTelegram(t *dsmrp1.Telegram, at time.Time) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return wh.Post(body, at)
+}
+
+// Posts a telegram that is already encoded as JSON.
+func (wh *Webhook) Post(body []byte, at time.Time) error {
+	req, err := http.NewRequest("POST", wh.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Dsmrp1-Meter", wh.meter)
+	req.Header.Set("X-Dsmrp1-Received", at.Format(time.RFC3339Nano))
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+func (wh *Webhook) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package sml
+
+// SML values start with a type-length field: the type in bits 4-6 and
+// the length in bits 0-3, continued in the next byte while bit 7 is
+// set.  The length of octet strings and numbers includes the field
+// itself; that of lists is the number of elements.
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Types of SML values.
+const (
+	typeOctetString = 0x0
+	typeBoolean     = 0x4
+	typeInteger     = 0x5
+	typeUnsigned    = 0x6
+	typeList        = 0x7
+)
+
+// Message body tags.
+const (
+	tagOpenResponse    = 0x0101
+	tagCloseResponse   = 0x0201
+	tagGetListResponse = 0x0701
+)
+
+type value struct {
+	typ     byte
+	absent  bool // an optional value that was left out
+	bytes   []byte
+	number  int64
+	members []value
+}
+
+// Parses a value and returns it with what follows it.
+func parseValue(buf []byte) (value, []byte, error) {
+	var v value
+	if len(buf) == 0 {
+		return v, nil, errors.New("data is truncated")
+	}
+	switch buf[0] {
+	case 0x00:
+		// The end of a message.
+		return v, buf[1:], nil
+	case 0x01:
+		v.absent = true
+		return v, buf[1:], nil
+	}
+	v.typ = buf[0] >> 4 & 0x07
+	length := int(buf[0] & 0x0f)
+	tl := 1
+	for buf[tl-1]&0x80 != 0 {
+		if tl == len(buf) || tl == 4 {
+			return v, nil, errors.New("malformed type-length field")
+		}
+		length = length<<4 | int(buf[tl]&0x0f)
+		tl++
+	}
+	if v.typ == typeList {
+		buf = buf[tl:]
+		for i := 0; i < length; i++ {
+			var m value
+			var err error
+			if m, buf, err = parseValue(buf); err != nil {
+				return v, nil, err
+			}
+			v.members = append(v.members, m)
+		}
+		return v, buf, nil
+	}
+	if length < tl || length > len(buf) {
+		return v, nil, errors.New("data is truncated")
+	}
+	data := buf[tl:length]
+	buf = buf[length:]
+	switch v.typ {
+	case typeOctetString:
+		v.bytes = data
+	case typeBoolean, typeInteger, typeUnsigned:
+		if len(data) == 0 || len(data) > 8 {
+			return v, nil, errors.New(fmt.Sprintf(
+				"integer of %d bytes", len(data)))
+		}
+		var n uint64
+		for _, b := range data {
+			n = n<<8 | uint64(b)
+		}
+		if v.typ == typeInteger && data[0]&0x80 != 0 {
+			// Sign extend.
+			n |= ^uint64(0) << (8 * uint(len(data)))
+		}
+		v.number = int64(n)
+	default:
+		return v, nil, errors.New(fmt.Sprintf("unknown type %#x", v.typ))
+	}
+	return v, buf, nil
+}
+
+// A message of a file: a list of the transaction ID, group number,
+// abort-on-error, the body as a list of its tag and contents, a CRC,
+// and the end of message marker 00.
+type message struct {
+	tag  int64
+	body value
+}
+
+// Parses the messages of a file.
+func parseMessages(buf []byte) ([]message, error) {
+	var ret []message
+	for len(buf) > 0 {
+		v, rest, err := parseValue(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = rest
+		if v.typ != typeList || len(v.members) != 6 ||
+			len(v.members[3].members) != 2 {
+			return nil, errors.New("malformed message")
+		}
+		ret = append(ret, message{
+			tag:  v.members[3].members[0].number,
+			body: v.members[3].members[1],
+		})
+	}
+	return ret, nil
+}
@@ -0,0 +1,133 @@
+package sml
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func unhex(s string) []byte {
+	ret, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+func TestParseValue(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		raw    string
+		typ    byte
+		bytes  string
+		number int64
+	}{
+		{"octet string", "03abcd", typeOctetString, "abcd", 0},
+		{"long octet string", "8102" + "000102030405060708090a0b0c0d0e0f",
+			typeOctetString, "000102030405060708090a0b0c0d0e0f", 0},
+		{"boolean", "4201", typeBoolean, "", 1},
+		{"unsigned8", "62ff", typeUnsigned, "", 255},
+		{"unsigned32", "6500bc614e", typeUnsigned, "", 12345678},
+		{"integer8", "52ff", typeInteger, "", -1},
+		{"integer16", "53fe0c", typeInteger, "", -500},
+		{"positive integer", "5301f4", typeInteger, "", 500},
+		{"integer64", "59ffffffffffffffff", typeInteger, "", -1},
+	} {
+		v, rest, err := parseValue(append(unhex(tc.raw), 0xaa))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if v.typ != tc.typ || hex.EncodeToString(v.bytes) != tc.bytes ||
+			v.number != tc.number || v.absent {
+			t.Errorf("%s: got %+v", tc.name, v)
+		}
+		if !bytes.Equal(rest, []byte{0xaa}) {
+			t.Errorf("%s: left %x", tc.name, rest)
+		}
+	}
+}
+
+func TestParseList(t *testing.T) {
+	// A list of an octet string, a list with an unsigned and an absent
+	// value, and the end of message marker.
+	v, rest, err := parseValue(unhex("7303616272620501" + "00"))
+	if err != nil {
+		t.Fatalf("parseValue: %v", err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("parseValue: left %x", rest)
+	}
+	if v.typ != typeList || len(v.members) != 3 {
+		t.Fatalf("parseValue: got %+v", v)
+	}
+	if string(v.members[0].bytes) != "ab" {
+		t.Fatalf("parseValue: got first member %+v", v.members[0])
+	}
+	inner := v.members[1]
+	if inner.typ != typeList || len(inner.members) != 2 ||
+		inner.members[0].number != 5 || !inner.members[1].absent {
+		t.Fatalf("parseValue: got second member %+v", inner)
+	}
+	if end := v.members[2]; end.typ != typeOctetString || end.absent ||
+		end.bytes != nil {
+		t.Fatalf("parseValue: got end of message %+v", end)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{"empty", "", "data is truncated"},
+		{"length beyond data", "05abcd", "data is truncated"},
+		{"length within field", "8001", "data is truncated"},
+		{"unterminated length", "81", "malformed type-length field"},
+		{"overlong length", "8181818101", "malformed type-length field"},
+		{"unknown type", "3200", "unknown type 0x3"},
+		{"empty integer", "51", "integer of 0 bytes"},
+		{"long integer", "5a000000000000000001", "integer of 9 bytes"},
+		{"short list", "73620501", "data is truncated"},
+		{"bad member", "7162", "data is truncated"},
+	} {
+		_, _, err := parseValue(unhex(tc.raw))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got %v; expected %s", tc.name, err, tc.err)
+		}
+	}
+}
+
+// Returns a message with the given tag and body, with a zero CRC as
+// that is not checked.
+func testMessage(tag int, body string) []byte {
+	return unhex("76" + "0500000001" + "6200" + "6200" +
+		fmt.Sprintf("7263%04x", tag) + body + "630000" + "00")
+}
+
+func TestParseMessages(t *testing.T) {
+	open := testMessage(tagOpenResponse, "7101")
+	list := testMessage(tagGetListResponse, "72026101")
+	ms, err := parseMessages(append(open, list...))
+	if err != nil {
+		t.Fatalf("parseMessages: %v", err)
+	}
+	if len(ms) != 2 || ms[0].tag != tagOpenResponse ||
+		ms[1].tag != tagGetListResponse ||
+		string(ms[1].body.members[0].bytes) != "a" ||
+		!ms[1].body.members[1].absent {
+		t.Fatalf("parseMessages: got %+v", ms)
+	}
+
+	// A message is a list of six values.
+	if _, err = parseMessages(unhex("7262006200")); err == nil ||
+		err.Error() != "malformed message" {
+		t.Fatalf("parseMessages of a short list: got %v", err)
+	}
+	if _, err = parseMessages(append(open, 0x76)); err == nil ||
+		err.Error() != "data is truncated" {
+		t.Fatalf("parseMessages of a truncated message: got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package sml
+
+// Maps the entries of a GetListResponse to a telegram.  An entry is a
+// list of its OBIS code, status, time, unit, scaler, value and
+// signature.
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/bwesterb/go-dsmrp1"
+	"io"
+	"math"
+	"strconv"
+)
+
+// DLMS units, as used by SML.
+const (
+	unitW  = 27
+	unitWh = 30
+	unitA  = 33
+	unitV  = 35
+)
+
+// Formats an OBIS code given as 6 bytes, leaving out the last group.
+func formatCode(b []byte) string {
+	return fmt.Sprintf("%d-%d:%d.%d.%d", b[0], b[1], b[2], b[3], b[4])
+}
+
+// Decodes the messages of an SML file into a telegram.
+//
+// Meters with a single register for import and one for export report
+// those as tariff 1.  The signed power of 1-0:16.7.0 and its phases is
+// split into import and export.  Files without energy registers do not
+// have Electricity set: their values are in Other as they would appear
+// in a P1 telegram, eg. 1-0:16.7.0 as 1234*W.
+func Decode(msgs []byte) (*dsmrp1.Telegram, error) {
+	ms, err := parseMessages(msgs)
+	if err != nil {
+		return nil, err
+	}
+	var list *value
+	for i, m := range ms {
+		if m.tag == tagGetListResponse {
+			list = &ms[i].body
+			break
+		}
+	}
+	// The list has the client ID, server ID, list name, sensor time,
+	// entries, signature and gateway time.
+	if list == nil || len(list.members) < 5 {
+		return nil, errors.New("no GetListResponse")
+	}
+
+	t := &dsmrp1.Telegram{Other: make(map[string][]string)}
+	t.ID = hex.EncodeToString(list.members[1].bytes)
+	numbers := make(map[string]float64)
+	for _, entry := range list.members[4].members {
+		if len(entry.members) < 6 || len(entry.members[0].bytes) != 6 {
+			continue
+		}
+		code := formatCode(entry.members[0].bytes)
+		unit := entry.members[3].number
+		scaler := entry.members[4].number
+		v := entry.members[5]
+		switch {
+		case v.absent:
+		case code == "129-129:199.130.3":
+			// The manufacturer.
+			t.HeaderId = string(v.bytes)
+		case code == "1-0:96.1.0" || code == "1-0:0.0.9":
+			t.ID = hex.EncodeToString(v.bytes)
+		case v.typ == typeOctetString:
+			t.Other[code] = []string{hex.EncodeToString(v.bytes)}
+		case v.typ == typeInteger || v.typ == typeUnsigned:
+			n := float64(v.number) * math.Pow10(int(scaler))
+			suffix := ""
+			switch unit {
+			case unitWh:
+				n /= 1000
+				suffix = "*kWh"
+			case unitW:
+				suffix = "*W"
+			case unitA:
+				suffix = "*A"
+			case unitV:
+				suffix = "*V"
+			}
+			numbers[code] = n
+			t.Other[code] = []string{strconv.FormatFloat(n, 'f', -1, 64) + suffix}
+		}
+	}
+
+	_, tariff1 := numbers["1-0:1.8.1"]
+	_, total := numbers["1-0:1.8.0"]
+	if !tariff1 && !total {
+		return t, nil
+	}
+	used := make(map[string]bool)
+	get := func(codes ...string) float32 {
+		for _, code := range codes {
+			if n, ok := numbers[code]; ok {
+				used[code] = true
+				return float32(n)
+			}
+		}
+		return 0
+	}
+	getPtr := func(code string) *float32 {
+		if _, ok := numbers[code]; !ok {
+			return nil
+		}
+		v := get(code)
+		return &v
+	}
+	// Returns the import and export of a signed power, or else of the
+	// separate codes.
+	power := func(signed, in, out string) (float32, float32) {
+		if _, ok := numbers[signed]; !ok {
+			return get(in), get(out)
+		}
+		p := get(signed)
+		if p < 0 {
+			return 0, -p
+		}
+		return p, 0
+	}
+
+	e := &dsmrp1.ElectricityData{
+		KWh:         get("1-0:1.8.2"),
+		KWhLow:      get("1-0:1.8.1", "1-0:1.8.0"),
+		KWhOut:      get("1-0:2.8.2"),
+		KWhOutLow:   get("1-0:2.8.1", "1-0:2.8.0"),
+		KWhTotal:    getPtr("1-0:1.8.0"),
+		KWhOutTotal: getPtr("1-0:2.8.0"),
+		Tariff:      1, // the tariff of KWhLow
+		L1Current:   get("1-0:31.7.0"),
+		L1Voltage:   getPtr("1-0:32.7.0"),
+	}
+	e.W, e.WOut = power("1-0:16.7.0", "1-0:1.7.0", "1-0:2.7.0")
+	e.L1Power, e.L1PowerOut = power("1-0:36.7.0", "1-0:21.7.0", "1-0:22.7.0")
+	t.Electricity = e
+	for _, code := range []string{"1-0:51.7.0", "1-0:52.7.0", "1-0:56.7.0",
+		"1-0:41.7.0"} {
+		if _, ok := numbers[code]; ok {
+			m := &dsmrp1.MultiphaseElectricityData{
+				L2Current: get("1-0:51.7.0"),
+				L2Voltage: getPtr("1-0:52.7.0"),
+				L3Current: get("1-0:71.7.0"),
+				L3Voltage: getPtr("1-0:72.7.0"),
+			}
+			m.L2Power, m.L2PowerOut = power("1-0:56.7.0", "1-0:41.7.0", "1-0:42.7.0")
+			m.L3Power, m.L3PowerOut = power("1-0:76.7.0", "1-0:61.7.0", "1-0:62.7.0")
+			t.MultiphaseElectricity = m
+			break
+		}
+	}
+	for code := range used {
+		delete(t.Other, code)
+	}
+	return t, nil
+}
+
+// Reads telegrams from the optical interface of a meter or a capture of
+// it.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Returns the next telegram.  After an error about a malformed file,
+// reading can continue with the next one.  Errors from the underlying
+// reader, like io.EOF, are returned as is.
+func (r *Reader) Next() (*dsmrp1.Telegram, error) {
+	msgs, err := readFile(r.r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(msgs)
+}
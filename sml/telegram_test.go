@@ -0,0 +1,114 @@
+package sml
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A GetListResponse with the manufacturer, the server ID, the imported
+// energy of 12345.6789 kWh, the signed power of -500 W, which is an
+// export, and the voltage of 230 V.
+var testList = "77" + "01" + "0b0a014953460000000001" + "01" + "01" +
+	"74" +
+	"77" + "078181c78203ff" + "01" + "01" + "01" + "01" + "04495346" +
+	"01" +
+	"77" + "070100010800ff" + "01" + "01" + "621e" + "52ff" +
+	"65075bcd15" + "01" +
+	"77" + "070100100700ff" + "01" + "01" + "621b" + "5200" + "53fe0c" +
+	"01" +
+	"77" + "070100200700ff" + "01" + "01" + "6223" + "52ff" + "6308fc" +
+	"01" +
+	"01" + "01"
+
+func testMessages() []byte {
+	return append(testMessage(tagOpenResponse, "7101"),
+		testMessage(tagGetListResponse, testList)...)
+}
+
+func TestDecode(t *testing.T) {
+	tg, err := Decode(testMessages())
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if tg.HeaderId != "ISF" || tg.ID != "0a014953460000000001" {
+		t.Fatalf("Decode: got header %q, ID %q", tg.HeaderId, tg.ID)
+	}
+	e := tg.Electricity
+	if e == nil {
+		t.Fatalf("Decode: no electricity in %+v", tg)
+	}
+	if e.KWhLow != 12345.6789 || e.KWh != 0 || e.Tariff != 1 ||
+		e.KWhTotal == nil || *e.KWhTotal != 12345.6789 {
+		t.Fatalf("Decode: got energy %+v", e)
+	}
+	if e.W != 0 || e.WOut != 500 {
+		t.Fatalf("Decode: got power %v and %v out", e.W, e.WOut)
+	}
+	if e.L1Voltage == nil || *e.L1Voltage != 230 {
+		t.Fatalf("Decode: got voltage %v", e.L1Voltage)
+	}
+	if tg.MultiphaseElectricity != nil || len(tg.Other) != 0 {
+		t.Fatalf("Decode: got %+v and other lines %v",
+			tg.MultiphaseElectricity, tg.Other)
+	}
+}
+
+func TestDecodeWithoutEnergy(t *testing.T) {
+	list := "77" + "01" + "0b0a014953460000000001" + "01" + "01" +
+		"71" +
+		"77" + "070100100700ff" + "01" + "01" + "621b" + "5200" +
+		"5304d2" + "01" +
+		"01" + "01"
+	tg, err := Decode(testMessage(tagGetListResponse, list))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if tg.Electricity != nil {
+		t.Fatalf("Decode: got electricity %+v", tg.Electricity)
+	}
+	if v := tg.Other["1-0:16.7.0"]; len(v) != 1 || v[0] != "1234*W" {
+		t.Fatalf("Decode: got 1-0:16.7.0 %v", v)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msgs []byte
+		err  string
+	}{
+		{"no list", testMessage(tagOpenResponse, "7101"), "no GetListResponse"},
+		{"short list", testMessage(tagGetListResponse, "720101"),
+			"no GetListResponse"},
+		{"not a message", unhex("620500"), "malformed message"},
+		{"unknown type", testMessage(tagGetListResponse, "7132"),
+			"unknown type 0x3"},
+	} {
+		_, err := Decode(tc.msgs)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got %v; expected %s", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	bad := testFile(testMessages())
+	bad[len(bad)-1] ^= 0x01
+	raw := append(bad, testFile(testMessages())...)
+
+	// Reading continues after a file with a bad checksum.
+	r := NewReader(bytes.NewReader(raw))
+	if _, err := r.Next(); err == nil || err.Error() != "checksum mismatch" {
+		t.Fatalf("Next: got %v; expected a checksum mismatch", err)
+	}
+	tg, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if tg.Electricity == nil || tg.Electricity.WOut != 500 {
+		t.Fatalf("Next: got %+v", tg)
+	}
+	if _, err = r.Next(); err == nil {
+		t.Fatalf("Next at the end: no error")
+	}
+}
@@ -0,0 +1,118 @@
+// Package sml decodes the telegrams of German meters: SML files as sent
+// on their optical interface, mapped to the same dsmrp1.Telegram as the
+// telegrams of P1 ports.
+package sml
+
+// The SML transport protocol version 1 frames a file as
+//
+//	1B1B1B1B 01010101     start
+//	<messages>            padded to a multiple of 4 bytes
+//	1B1B1B1B 1A <p> <crc> end, with the number of padding bytes and
+//	                      the CRC-16/X-25 of everything before the CRC
+//
+// Within the messages, 1B1B1B1B is escaped by repeating it.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	escape = []byte{0x1b, 0x1b, 0x1b, 0x1b}
+	start  = []byte{0x01, 0x01, 0x01, 0x01}
+)
+
+const endMarker = 0x1a
+
+// Reads the next file, skipping anything before its start, and returns
+// its messages.
+func readFile(r *bufio.Reader) ([]byte, error) {
+	if err := skipToStart(r); err != nil {
+		return nil, err
+	}
+	raw := append(append([]byte{}, escape...), start...)
+	var msgs []byte
+	word := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, word); err != nil {
+			return nil, err
+		}
+		raw = append(raw, word...)
+		if !bytes.Equal(word, escape) {
+			msgs = append(msgs, word...)
+			continue
+		}
+		if _, err := io.ReadFull(r, word); err != nil {
+			return nil, err
+		}
+		switch {
+		case bytes.Equal(word, escape):
+			raw = append(raw, word...)
+			msgs = append(msgs, word...)
+		case bytes.Equal(word, start):
+			// The previous file was cut off.
+			raw = append(append([]byte{}, escape...), start...)
+			msgs = nil
+		case word[0] == endMarker:
+			raw = append(raw, word[:2]...)
+			if crcX25(raw) != uint16(word[2])|uint16(word[3])<<8 {
+				return nil, errors.New("checksum mismatch")
+			}
+			padding := int(word[1])
+			if padding > 3 || padding > len(msgs) {
+				return nil, errors.New(fmt.Sprintf(
+					"invalid padding of %d bytes", padding))
+			}
+			return msgs[:len(msgs)-padding], nil
+		default:
+			return nil, errors.New(fmt.Sprintf(
+				"unknown escape sequence %x", word))
+		}
+	}
+}
+
+// Consumes the input up to and including the next start sequence.
+func skipToStart(r *bufio.Reader) error {
+	seq := append(append([]byte{}, escape...), start...)
+	matched := 0
+	for matched < len(seq) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == seq[matched] {
+			matched++
+		} else if b == 0x1b {
+			// Within a run of 1B, the last four may start the sequence.
+			if matched > 4 {
+				matched = 0
+			}
+			if matched < 4 {
+				matched++
+			}
+		} else {
+			matched = 0
+		}
+	}
+	return nil
+}
+
+// CRC-16/X-25: the reflected CCITT polynomial with initial value and
+// final xor of 0xFFFF.
+func crcX25(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return ^crc
+}
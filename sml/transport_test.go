@@ -0,0 +1,99 @@
+package sml
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Frames messages as a file, escaping and padding them.
+func testFile(msgs []byte) []byte {
+	padding := (4 - len(msgs)%4) % 4
+	msgs = append(append([]byte{}, msgs...), make([]byte, padding)...)
+	buf := append(append([]byte{}, escape...), start...)
+	for i := 0; i < len(msgs); i += 4 {
+		if bytes.Equal(msgs[i:i+4], escape) {
+			buf = append(buf, escape...)
+		}
+		buf = append(buf, msgs[i:i+4]...)
+	}
+	buf = append(buf, escape...)
+	buf = append(buf, endMarker, byte(padding))
+	crc := crcX25(buf)
+	return append(buf, byte(crc), byte(crc>>8))
+}
+
+func reader(b []byte) *bufio.Reader {
+	return bufio.NewReader(bytes.NewReader(b))
+}
+
+func TestCRC(t *testing.T) {
+	// The check value of CRC-16/X-25.
+	if crc := crcX25([]byte("123456789")); crc != 0x906e {
+		t.Fatalf("crcX25: got %#x; expected 0x906e", crc)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	msgs := append(append([]byte("messages"), escape...), "escaped"...)
+
+	// Anything before the start is skipped, including a run of 1B.
+	raw := append([]byte{0x00, 0x1b, 0x1b, 0x1b, 0x1b, 0x1b}, testFile(msgs)...)
+	r := reader(raw)
+	got, err := readFile(r)
+	if err != nil {
+		t.Fatalf("readFile: %v", err)
+	}
+	if !bytes.Equal(got, msgs) {
+		t.Fatalf("readFile: got %q; expected %q", got, msgs)
+	}
+	if _, err = readFile(r); err != io.EOF {
+		t.Fatalf("readFile at the end: got %v; expected %v", err, io.EOF)
+	}
+
+	// A file that was cut off by the start of the next.
+	cut := testFile([]byte("cut off file"))
+	raw = append(cut[:len(cut)-8], testFile([]byte("next"))...)
+	if got, err = readFile(reader(raw)); err != nil ||
+		string(got) != "next" {
+		t.Fatalf("readFile after a cut off file: got %q, %v", got, err)
+	}
+}
+
+func TestReadBadFile(t *testing.T) {
+	good := testFile([]byte("messages"))
+
+	badCRC := append([]byte{}, good...)
+	badCRC[len(badCRC)-1] ^= 0x01
+
+	badMsgs := append([]byte{}, good...)
+	badMsgs[9] ^= 0x01
+
+	// The padding is covered by the checksum.
+	badPadding := append([]byte{}, good[:len(good)-3]...)
+	badPadding = append(badPadding, 5)
+	crc := crcX25(badPadding)
+	badPadding = append(badPadding, byte(crc), byte(crc>>8))
+
+	badEscape := append(append([]byte{}, good[:16]...), escape...)
+	badEscape = append(badEscape, 0x02, 0x02, 0x02, 0x02)
+
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		err  string
+	}{
+		{"bad CRC", badCRC, "checksum mismatch"},
+		{"bad messages", badMsgs, "checksum mismatch"},
+		{"bad padding", badPadding, "invalid padding of 5 bytes"},
+		{"unknown escape", badEscape, "unknown escape sequence 02020202"},
+		{"truncated", good[:len(good)-2], io.ErrUnexpectedEOF.Error()},
+		{"truncated start", good[:6], io.EOF.Error()},
+	} {
+		_, err := readFile(reader(tc.raw))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got %v; expected %s", tc.name, err, tc.err)
+		}
+	}
+}
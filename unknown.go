@@ -0,0 +1,105 @@
+package dsmrp1
+
+// Guesses at the values of the lines the parser does not know.
+
+import (
+	"encoding/hex"
+	"github.com/bwesterb/go-dsmrp1/obis"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A line of a telegram left in Other with a guess at its value.
+type UnknownValue struct {
+	OBIS obis.Code
+	Args []string
+
+	// What the last argument looks like: "quantity" (a float64 with a
+	// unit, normalized like the parsed fields if the unit is known),
+	// "timestamp" (a time.Time), "int" (an int64), "float" (a float64),
+	// "text" (a hex encoded string, decoded) or "string".  Empty, with
+	// a nil value, if the line has no arguments.
+	GuessedType  string
+	GuessedValue interface{}
+	Unit         string // of a quantity
+
+	// Why the guess is uncertain, eg. an unknown unit.
+	Warning string
+}
+
+// Returns the lines left in Other, sorted by OBIS code, with guesses at
+// their values, so that values of new meters can be used before the
+// parser knows them.
+func (t *Telegram) UnknownValues() []UnknownValue {
+	var codes []string
+	for code := range t.Other {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	ret := []UnknownValue{}
+	for _, code := range codes {
+		v := UnknownValue{OBIS: obis.Code(code), Args: t.Other[code]}
+		if len(v.Args) != 0 {
+			v.guess(v.Args[len(v.Args)-1])
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func (v *UnknownValue) guess(arg string) {
+	if bits := strings.SplitN(arg, "*", 2); len(bits) == 2 {
+		amount, err := parseAmount(bits[0], 64)
+		unit := strings.TrimSpace(bits[1])
+		if err == nil {
+			v.GuessedType, v.Unit = "quantity", unit
+			if factor, ok := normalizedUnits[unit]; ok {
+				amount *= float64(factor)
+				if unit == "kW" {
+					v.Unit = "W"
+				}
+			} else {
+				v.Warning = "unknown unit: " + unit
+			}
+			v.GuessedValue = amount
+			return
+		}
+		v.Warning = "not a quantity: " + err.Error()
+	}
+	if ts, err := ParseTimestamp(arg); err == nil {
+		v.GuessedType, v.GuessedValue = "timestamp", ts
+		return
+	}
+	if i, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		v.GuessedType, v.GuessedValue = "int", i
+		return
+	}
+	if f, err := strconv.ParseFloat(arg, 64); err == nil {
+		v.GuessedType, v.GuessedValue = "float", f
+		return
+	}
+	if s, ok := decodeText(arg); ok {
+		v.GuessedType, v.GuessedValue = "text", s
+		return
+	}
+	v.GuessedType, v.GuessedValue = "string", arg
+}
+
+// Decodes a hex encoded text, like the messages of the meter, if it is
+// one of printable ASCII.
+func decodeText(s string) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return "", false
+	}
+	for _, c := range buf {
+		if c < 0x20 || c > 0x7e {
+			return "", false
+		}
+	}
+	return string(buf), true
+}